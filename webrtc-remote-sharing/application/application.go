@@ -5,182 +5,346 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/remygo/application/session"
 	"github.com/remygo/conn/wrtc"
-	"github.com/remygo/conn/ws"
 	"github.com/remygo/display"
+	"github.com/remygo/pkg/approval"
+	"github.com/remygo/pkg/auth"
+	"github.com/remygo/pkg/config"
+	"github.com/remygo/pkg/knownhosts"
 	"github.com/remygo/pkg/logger"
 	"github.com/remygo/pkg/message"
+	"github.com/remygo/pkg/policy"
 
 	"github.com/pion/webrtc/v3"
 )
 
+// Files stored alongside the config
+const (
+	certFile       = "device.pem"
+	knownHostsFile = "known_hosts.json"
+)
+
+// Known hosts seen only once are forgotten after this long
+const knownHostsPrune = 30 * 24 * time.Hour
+
 type Resolution struct {
 	Width, Height int
 }
 
 type Args struct {
 	URL, TurnCreds, Codec, Addr, ConfigPath, UserCreds string
+	Headless                                           bool // Host only without creating any windows
 }
 
 func NewArgs() *Args {
 	return &Args{}
 }
 
-type Request struct {
-	Token  string // Session token
-	Status string // pending, complete
-	Next   string // Which call should come next
+// Writes messages to the signaling server. Implemented by ws.Socket
+type Sender interface {
+	Write(msg message.Message) error
 }
 
 // Main application which bundles all the necessary components
 type App struct {
-	*ws.Socket
+	Socket Sender
 	*Args
 	*wrtc.PeerConn
 	*webrtc.DataChannel
 	Resolution
 	*MediaComponents
-	candidatesTXQueue chan *webrtc.ICECandidate
-	candidatesRXQueue chan webrtc.ICECandidateInit
-	ticker            *time.Ticker
-	HostTrack         *webrtc.TrackLocalStaticSample
-	mode              Mode
-	UserID, DeviceID  string
-	SessionToken      string
-	registerRequest   *Request
-	callRequest       *Request
-	renewRequest      *Request
-	From              <-chan message.Message
-	done              chan struct{} // Signal to close the application when signaling server rejects any client request
-	sessionEvents     chan SessionEvent
-	reset             bool
-	Ctx               context.Context // Context to cancel the ICE service
-	CtxCancel         context.CancelFunc
+	mediaMu          sync.Mutex       // Guards replacing the media components against readers outside the main loop
+	trickle          *wrtc.Trickle    // Candidate exchange of the current peer connection
+	negotiator       *wrtc.Negotiator // Renegotiates the current peer connection once connected
+	reconnecting     int32            // Set while a lost connection is being recovered. Accessed atomically
+	iceRestart       chan struct{}    // Asks the main loop to send a restart offer
+	ticker           *time.Ticker
+	HostTrack        *webrtc.TrackLocalStaticSample
+	audioTrack       *webrtc.TrackLocalStaticSample // Sound of the host if it is streamed
+	muted            int32                          // Mutes the sound of the session on either side. Accessed atomically
+	micAlwaysOn      int32                          // Microphone on without the talk button. Accessed atomically
+	talking          int32                          // Talk button held. Accessed atomically
+	voice            voiceState                     // Microphone of the current session
+	voiceVolume      int32                          // Percent of the volume of the voice of the other user. Accessed atomically
+	voiceMuted       int32                          // Mutes the voice of the other user. Accessed atomically
+	codecs           []string                       // Video codecs of the current peer connection in the order of preference
+	stream           streamState                    // Size, frame rate and quality of the video of the current session
+	recording        recordingState                 // Recording of the current session by either user
+	uploads          uploadState                    // Uploads of the recordings and received files into the blob provider
+	clipboard        clipboardState                 // Clipboard shared with the other user
+	transfers        transferState                  // Files the remote sends to the host
+	UserID, DeviceID string
+	OrgID            string // Organization of the logged in user. Used by hosts for permission profiles
+	permissions      int32  // Permissions granted to the remote in the current session. Accessed atomically
+	SessionToken     string
+	joinToken        string           // Token of the session joined as remote
+	joinUnattended   bool             // The joined token is the access id of an unattended host
+	session          *session.Machine // Current state in the session flow
+	From             <-chan message.Message
+	done             chan struct{} // Signal to close the application when signaling server rejects any client request
+	sessionEvents    chan SessionEvent
+	events           eventQueue      // Session events on their way to sessionEvents
+	Ctx              context.Context // Context of the application which the session contexts derive from
+	CtxCancel        context.CancelFunc
+	sessionCancel    context.CancelFunc  // Cancels goroutines which live as long as the current session
+	tracker          *policy.Tracker     // Tracks activity of the hosted session against its limits
+	Config           *config.Config      // Persistent client configuration loaded from Args.ConfigPath
+	sessionPassword  string              // Password of the current session token shown to the host user
+	sessionVerifier  *auth.Verifier      // Verifier of the session password
+	pendingJoin      *pendingJoin        // Join request waiting for the confirmation of the password exchange
+	joinProver       *auth.Prover        // Password exchange of the join request sent as remote
+	signalKey        []byte              // Key sealing the offer and answer of the current session
+	cert             *webrtc.Certificate // Long-term DTLS certificate of the device
+	knownHosts       *knownhosts.Store   // Fingerprints of hosts this device connected to as remote
+	Approver         approval.Approver   // Decides join requests in place of the host user if set
+	peerUserID       string              // User id of the remote in the current hosted session
+	peerDevice       string              // Fingerprint the remote has to present if it joined as an allowed device
 }
 
 // Returns a new instance of the application
-func New(socket *ws.Socket, fromSocket <-chan message.Message, events chan SessionEvent,
+func New(socket Sender, fromSocket <-chan message.Message, events chan SessionEvent,
 	cfg *Args, userID, deviceID string) *App {
 	width, height := display.Dimensions()
 
+	conf, err := config.Load(cfg.ConfigPath)
+	if err != nil {
+		log.Printf("[WARN] Using default configuration. %v", err)
+	}
+
+	// Without the device certificate pion generates one per connection which works
+	// but remotes will not be able to recognize this device across sessions
+	cert, err := wrtc.LoadCertificate(filepath.Join(conf.Dir(), certFile))
+	if err != nil {
+		log.Printf("[WARN] Using a per connection certificate. %v", err)
+	}
+	checkMedia(&conf.Media)
+	hosts, err := knownhosts.Load(filepath.Join(conf.Dir(), knownHostsFile))
+	if err != nil {
+		log.Printf("[WARN] Starting with empty known hosts. %v", err)
+	}
+	if n, err := hosts.Prune(time.Now().Add(-knownHostsPrune)); err != nil {
+		log.Printf("[WARN] Pruning known hosts: %v", err)
+	} else if n > 0 {
+		log.Printf("[INFO] Pruned %d known hosts which were seen once", n)
+	}
+
 	// Configure the application with the provided configuration
-	return &App{
-		Socket:            socket,
-		Args:              cfg,
-		Resolution:        Resolution{width, height},
-		UserID:            userID,
-		DeviceID:          deviceID,
-		ticker:            time.NewTicker(time.Millisecond * 100),
-		MediaComponents:   newMediaComponents(),
-		candidatesTXQueue: make(chan *webrtc.ICECandidate, 32),
-		candidatesRXQueue: make(chan webrtc.ICECandidateInit, 32),
-		done:              make(chan struct{}),
-		From:              fromSocket,
-		sessionEvents:     events,
+	app := &App{
+		Socket:        socket,
+		Args:          cfg,
+		Resolution:    Resolution{width, height},
+		UserID:        userID,
+		DeviceID:      deviceID,
+		ticker:        time.NewTicker(time.Millisecond * 100),
+		done:          make(chan struct{}, 1),
+		iceRestart:    make(chan struct{}, 1),
+		From:          fromSocket,
+		sessionEvents: events,
+		Config:        conf,
+		cert:          cert,
+		knownHosts:    hosts,
+		session:       session.New(),
 	}
+	app.muted = boolFlag(conf.Media.Audio.Muted)
+	app.micAlwaysOn = boolFlag(conf.Media.Voice.AlwaysOn)
+	app.voiceVolume = int32(conf.Media.Voice.VolumePercent())
+	app.voiceMuted = boolFlag(conf.Media.Voice.Muted)
+	app.session.Subscribe(app.onTransition)
+	app.resetMedia()
+
+	return app
 }
 
-func (app *App) ICEService(ctx context.Context) {
-	// TODO: Handle proper context cancel propagation from the app main loop
-	// defer ctx.Done()
+// Starts the candidate exchange of the peer connection. It lasts as long as the session context
+func (app *App) startTrickle(ctx context.Context) {
+	app.trickle = wrtc.NewTrickle(app.PeerConn, app.sendCandidate)
+	go app.trickle.Run(ctx)
+}
 
-	// <-app.ticker.C
+// Sends a local ICE candidate to the other peer. An empty candidate marks the end of candidates
+func (app *App) sendCandidate(c webrtc.ICECandidateInit) error {
+	cs, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("creating candidate string: %w", err)
+	}
+	log.Println("[ICE] Sending ICE candidate")
+	return app.send(message.NewSignal(message.ICE, cs))
+}
 
-	defer func() {
-		close(app.candidatesRXQueue)
-		close(app.candidatesTXQueue)
-		log.Println("[INFO] Closing ICE service")
-	}()
-outer:
-	for {
-		select {
-		case c, ok := <-app.candidatesTXQueue:
-			if !ok {
-				continue
-			}
-			cs, err := json.Marshal(c.ToJSON())
-			if err != nil {
-				log.Panicf("[ERR] Creating candidate string: %v", err)
-			}
-			msgICE := message.NewSignal(message.ICE, cs)
+// Creates the initial offer and sends it to the host. Local candidates are held back until
+// the offer was sent since creating it starts gathering
+func (app *App) sendOffer() error {
+	app.trickle.Hold()
+	defer app.trickle.Release()
 
-			log.Println("[ICE] Sending ICE candidate")
+	offerString, err := app.PeerConn.NewOffer()
+	if err != nil {
+		return err
+	}
+	return app.sendSDP(message.SignalMessage{Type: message.Offer, Data: offerString})
+}
 
-			if err = app.Socket.Write(*msgICE); err != nil {
-				log.Panicf("[ERR] Cannot send ICE signal message. %v", err)
-			}
-			<-app.ticker.C
-		case c, ok := <-app.candidatesRXQueue:
-			if !ok {
-				continue
-			}
-			log.Println("[ICE] Received ICE candidate")
-			if app.PeerConn.RemoteDescription() != nil {
-				if err := app.PeerConn.AddICECandidate(c); err != nil {
-					log.Panicf("[ERR] Adding ICE candidate: %v", err)
-				}
-			}
-		case <-app.ticker.C:
-			if app.PeerConn.ICEGatheringState() == webrtc.ICEGatheringStateComplete {
-				log.Println("[ICE] Local gathering complete")
-				break outer
-			}
-			// case <-ctx.Done():
-			// 	break outer
-		}
+// Sends a description of a renegotiation over the signaling server. Used by the negotiator
+// when the control channel is not open
+func (app *App) sendDescription(desc webrtc.SessionDescription) error {
+	data, err := json.Marshal(desc)
+	if err != nil {
+		return fmt.Errorf("marshaling %s: %w", desc.Type, err)
+	}
+
+	msgType := message.Offer
+	if desc.Type == webrtc.SDPTypeAnswer {
+		msgType = message.Answer
+	}
+	return app.sendSDP(message.SignalMessage{Type: msgType, Data: data})
+}
+
+// Returns the supported codecs in the configured order of preference. The codec flag moves
+// its codec to the front
+func (app *App) preferredCodecs(supported []string) []string {
+	order := app.Config.Media.Codecs
+	if len(order) == 0 {
+		order = wrtc.DefaultCodecs
+	}
+	if app.Args.Codec != "" {
+		order = append([]string{app.Args.Codec}, order...)
+	}
+	return wrtc.Codecs(order, supported)
+}
+
+// Returns the recovery settings of the peer connections for the configured ones
+func recovery(r config.Recovery) wrtc.Recovery {
+	return wrtc.Recovery{
+		DisableNACK:  r.DisableNACK,
+		NACKBuffer:   r.NACKBuffer,
+		NACKInterval: time.Duration(r.NACKInterval) * time.Millisecond,
+		RTX:          r.RTX,
+		FECGroup:     r.FECGroup,
 	}
 }
 
-func (app *App) configureAsHost() {
-	peerConnection, videoTrack, err := wrtc.NewHost(app.Args.URL, app.Args.TurnCreds, app.Args.Codec)
+func (app *App) configureAsHost() error {
+	if app.Capture == nil {
+		return errNoCapture
+	}
+	app.codecs = app.preferredCodecs(app.Capture.Codecs())
+	peerConnection, videoTrack, err := wrtc.NewHost(app.Args.URL, app.Args.TurnCreds, app.codecs, app.cert,
+		recovery(app.Config.Media.Recovery))
 	if err != nil {
-		log.Panicf("[ERR] Creating peer connection: %v", err)
+		return fmt.Errorf("creating peer connection: %w", err)
 	}
 	app.PeerConn, app.HostTrack = peerConnection, videoTrack
+	// Added before the offer is applied so that the answer sends it
+	if app.AudioCapture != nil {
+		if app.audioTrack, err = app.PeerConn.AddAudioTrack(); err != nil {
+			log.Printf("[WARN] Streaming without sound. %v", err)
+		}
+	}
+	capture := app.Capture
+	app.PeerConn.OnKeyFrameRequest(func() {
+		if err := capture.RequestKeyFrame(); err != nil {
+			log.Printf("[WARN] Forcing key frame: %v", err)
+		}
+	})
+
+	// The host is the polite peer since the remote already offers the initial negotiation
+	if app.negotiator, err = wrtc.NewNegotiator(app.PeerConn, true, app.sendDescription); err != nil {
+		return err
+	}
 
-	if err := app.ConnectCallbacks(app.Ctx, Host); err != nil {
-		log.Panicf("[ERR] Connecting callbacks: %v", err)
+	var ctx context.Context
+	ctx, app.sessionCancel = context.WithCancel(app.Ctx)
+	app.startTrickle(ctx)
+
+	app.tracker = policy.NewTracker(app.Config.Limits, time.Now())
+	capture.OnActivity(func() {
+		app.tracker.Touch(time.Now())
+	})
+	go app.enforceLimits(ctx, app.tracker)
+	go app.adaptToBandwidth(ctx, app.PeerConn, capture)
+
+	if err := app.ConnectCallbacks(ctx, Host); err != nil {
+		return fmt.Errorf("connecting callbacks: %w", err)
 	}
 	log.Println("[INFO] Host callbacks connected")
+	app.startVoiceCapture()
+	return nil
+}
 
-	if err := app.Capture.Start(app.Resolution.Height, app.Resolution.Height,
-		app.Args.Codec, app.HostTrack); err != nil {
-		log.Panicf("[ERR] Starting capture: %v", err)
+// Picks the codec of the offer of the remote and starts capturing with it. Called by the host
+// between applying the offer and creating the answer
+func (app *App) startCapture() error {
+	track, err := app.PeerConn.SelectCodec(app.codecs, app.HostTrack)
+	if err != nil {
+		return err
 	}
-	app.mode = Host
+	app.HostTrack = track
 
-	go app.ICEService(context.TODO())
+	if err := app.Capture.Start(app.initialStream(), track.Codec().MimeType, app.HostTrack); err != nil {
+		return fmt.Errorf("starting capture: %w", err)
+	}
+	app.startAudioCapture()
+	return nil
 }
 
-func (app *App) configureAsRemote() {
+func (app *App) configureAsRemote() error {
 	// On clicking the join button, we need to start the remote application mode
-	peerConnection, err := wrtc.NewRemote(app.Args.URL, app.Args.TurnCreds)
+	if app.Playback == nil {
+		return errNoPlayback
+	}
+	app.codecs = app.preferredCodecs(app.Playback.Codecs())
+	peerConnection, err := wrtc.NewRemote(app.Args.URL, app.Args.TurnCreds, app.codecs, app.cert,
+		recovery(app.Config.Media.Recovery))
 	if err != nil {
-		log.Panicf("[ERR] Creating peer connection: %v", err)
+		return fmt.Errorf("creating peer connection: %w", err)
 	}
 	dataChannel, err := peerConnection.CreateDataChannel("data", nil)
 	if err != nil {
-		log.Panicf("[ERR] Creating data channel: %v", err)
+		peerConnection.Close()
+		return fmt.Errorf("creating data channel: %w", err)
 	}
 	app.PeerConn, app.DataChannel = peerConnection, dataChannel
-	if err := app.ConnectCallbacks(app.Ctx, Remote); err != nil {
-		log.Panicf("[ERR] Connecting callbacks: %v", err)
+
+	if app.negotiator, err = wrtc.NewNegotiator(app.PeerConn, false, app.sendDescription); err != nil {
+		return err
 	}
-	log.Println("[INFO] Remote callbacks connected")
-	app.mode = Remote
 
-	go app.ICEService(context.TODO())
+	var ctx context.Context
+	ctx, app.sessionCancel = context.WithCancel(app.Ctx)
+	app.startTrickle(ctx)
+
+	if err := app.ConnectCallbacks(ctx, Remote); err != nil {
+		return fmt.Errorf("connecting callbacks: %w", err)
+	}
+	log.Println("[INFO] Remote callbacks connected")
+	app.startVoiceCapture()
+	return nil
 }
 
 // If app.debugToken is non-empty, send it to create a session of the same name
 // to make debugging easier otherwise the signaling server will generate and assign a uuid to the session
 func (app *App) RegisterSession() error {
 	log.Println("[INFO] Registering session")
-	app.registerRequest = &Request{Status: "pending", Next: message.Token.String()}
+	if _, err := app.session.Fire(session.Register); err != nil {
+		return fmt.Errorf("registering session: %v", err)
+	}
+
+	if app.Config.Unattended.Enabled {
+		accessID, key, signature, err := app.Config.ClaimAccessID()
+		if err != nil {
+			return fmt.Errorf("claiming access id: %v", err)
+		}
+		log.Printf("[INFO] Unattended access enabled. Registering with access id %s", accessID)
+
+		return app.send(message.NewUnattendedRegister(accessID, key, signature, app.UserID, app.DeviceID, app.OrgID))
+	}
 
-	return app.Socket.Write(*message.NewInfo(message.Register, "", app.UserID, app.DeviceID))
+	return app.send(message.NewInfo(message.Register, "", app.UserID, app.DeviceID, app.OrgID))
 }
 
 func (app *App) GetSessionToken() string {
@@ -193,78 +357,218 @@ func (app *App) GetSessionToken() string {
 	return app.SessionToken
 }
 
-func (app *App) JoinSession(token string) error {
-	app.callRequest = &Request{Token: token, Status: "pending", Next: message.Ack.String()}
+// Requests to join the session with the given token. The password is never sent, the join
+// request starts an exchange which proves it to the host. It may be empty for users on the allowlist of an unattended host
+func (app *App) JoinSession(token, password string) error {
+	if app.Args.Headless {
+		return fmt.Errorf("cannot join a session without a display")
+	}
+	if !app.session.Can(session.Join) {
+		return fmt.Errorf("cannot join a session in state %s", app.session.State())
+	}
+
+	// The password is bound to the token so the same password yields different shares per host
+	var share string
+	app.signalKey, app.joinProver = nil, nil
+	if password != "" {
+		cred, err := auth.DeriveCredential(password, token)
+		if err != nil {
+			return fmt.Errorf("deriving password secrets: %v", err)
+		}
+		prover, err := auth.NewProver(cred, token, app.UserID)
+		if err != nil {
+			return fmt.Errorf("starting password exchange: %v", err)
+		}
+		app.joinProver, share = prover, prover.Share()
+	}
+
+	// Unattended hosts let allowed devices in by the fingerprint of their certificate
+	var device string
+	if app.cert != nil {
+		var err error
+		if device, err = wrtc.Fingerprint(app.cert); err != nil {
+			log.Printf("[WARN] Joining without the device fingerprint. %v", err)
+		}
+	}
+
+	if _, err := app.session.Fire(session.Join); err != nil {
+		return err
+	}
+	app.joinToken, app.joinUnattended = token, false
+	return app.send(message.NewJoinRequest(token, app.UserID, app.OrgID, share, device))
+}
 
-	return app.Socket.Write(*message.NewSession(message.JoinRequest, token, nil))
+// Writes a message built by one of the message constructors to the signaling server
+func (app *App) send(msg *message.Message, err error) error {
+	if err != nil {
+		return err
+	}
+	return app.Socket.Write(*msg)
 }
 
 func (app *App) Close() error {
-	// Stop the ICE service if it's running
+	// Stop the candidate exchange and the limits of the session if it is running
 	app.CtxCancel()
-	switch app.mode {
+	if app.sessionCancel != nil {
+		app.sessionCancel()
+	}
+	app.endRecording()
+	switch app.session.Role() {
 	case Host:
-		if err := app.Capture.Stop(); err != nil {
-			return fmt.Errorf("stopping capture: %q", err)
+		if app.Capture != nil {
+			if err := app.Capture.Stop(); err != nil {
+				return fmt.Errorf("stopping capture: %q", err)
+			}
+		}
+		if app.AudioCapture != nil {
+			if err := app.AudioCapture.Stop(); err != nil {
+				log.Printf("[ERR] Stopping audio capture: %v", err)
+			}
 		}
 	case Remote:
-		if err := app.Playback.Stop(); err != nil {
-			return fmt.Errorf("stopping playback: %q", err)
+		if app.Playback != nil {
+			if err := app.Playback.Stop(); err != nil {
+				return fmt.Errorf("stopping playback: %q", err)
+			}
+		}
+		if app.AudioPlayer != nil {
+			if err := app.AudioPlayer.Stop(); err != nil {
+				log.Printf("[ERR] Stopping audio playback: %v", err)
+			}
 		}
 	}
+	app.stopVoice()
 
-	if err := app.PeerConn.Close(); err != nil {
-		log.Panicf("[ERR] Closing peer connection: %v", err)
+	// There is no peer connection if the application exits outside of a session
+	if app.PeerConn != nil {
+		if err := app.PeerConn.Close(); err != nil {
+			log.Printf("[ERR] Closing peer connection: %v", err)
+		}
 	}
 	app.Socket = nil
 	app.PeerConn = nil
 	app.DataChannel = nil
-	app.MediaComponents = nil
-	app.callRequest = nil
-	app.registerRequest = nil
-	app.HostTrack = nil
+	app.setMedia(nil)
+	app.HostTrack, app.audioTrack = nil, nil
 	app.Ctx, app.CtxCancel = nil, nil
 	app.ticker.Stop()
 
 	return nil
 }
 
+// Closes the media and the peer connection of the session that is being torn down
 func (app *App) Reset() {
-	app.renewRequest = &Request{Status: "pending", Next: message.Renew.String()}
-	switch app.mode {
+	app.endRecording()
+	switch app.session.Role() {
 	case Remote:
-		if err := app.Playback.Stop(); err != nil {
-			log.Panicf("stopping playback: %q", err)
+		if app.Playback != nil {
+			if err := app.Playback.Stop(); err != nil {
+				log.Printf("[ERR] Stopping playback: %v", err)
+			}
+		}
+		if app.AudioPlayer != nil {
+			if err := app.AudioPlayer.Stop(); err != nil {
+				log.Printf("[ERR] Stopping audio playback: %v", err)
+			}
 		}
 
 		log.Println("[APP] Remote mode. Client will leave the current session and renew the token")
-		app.Socket.Write(*message.NewSession(message.Leave, "", nil))
+		if err := app.send(message.NewSession(message.Leave, "", nil)); err != nil {
+			log.Printf("[ERR] Leaving session: %v", err)
+		}
 	case Host:
-		if err := app.Capture.Stop(); err != nil {
-			log.Panicf("stopping capture: %q", err)
+		if app.Capture != nil {
+			if err := app.Capture.Stop(); err != nil {
+				log.Printf("[ERR] Stopping capture: %v", err)
+			}
+		}
+		if app.AudioCapture != nil {
+			if err := app.AudioCapture.Stop(); err != nil {
+				log.Printf("[ERR] Stopping audio capture: %v", err)
+			}
 		}
 
 		log.Println("[APP] Host mode. Current session terminated and token will be renewed automatically")
 	}
-	app.reset = false
+	app.stopVoice()
+	app.releasePeer()
+	app.done = make(chan struct{}, 1)
+	app.SessionToken = ""
+
+	if _, err := app.session.Fire(session.Reset); err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	app.emit(SessionEvent{Type: SessionEnded})
+}
 
-	if err := app.PeerConn.Close(); err != nil {
-		log.Panicf("[ERR] Closing peer connection: %v", err)
+// Closes the peer connection and forgets the peer of the session. The media components are
+// replaced since the pipelines cannot be started again once they were stopped
+func (app *App) releasePeer() {
+	if app.sessionCancel != nil {
+		app.sessionCancel()
+		app.sessionCancel = nil
 	}
 	if app.DataChannel != nil {
 		if err := app.DataChannel.Close(); err != nil {
-			log.Panicf("[ERR] Closing data channel: %v", err)
+			log.Printf("[ERR] Closing data channel: %v", err)
 		}
 		app.DataChannel = nil
 	}
-	app.PeerConn = nil
-	app.callRequest = nil
-	app.MediaComponents = newMediaComponents()
-	app.candidatesTXQueue = make(chan *webrtc.ICECandidate, 32)
-	app.candidatesRXQueue = make(chan webrtc.ICECandidateInit, 32)
-	app.done = make(chan struct{})
-	app.SessionToken = ""
-	app.mode = 0
+	if app.PeerConn != nil {
+		if err := app.PeerConn.Close(); err != nil {
+			log.Printf("[ERR] Closing peer connection: %v", err)
+		}
+		app.PeerConn = nil
+	}
+	app.grant(0)
+	app.signalKey = nil
+	app.pendingJoin = nil
+	app.joinProver = nil
+	app.peerUserID = ""
+	app.peerDevice = ""
+	app.trickle = nil
+	app.negotiator = nil
+	app.codecs = nil
+	app.audioTrack = nil
+	app.stream.reset()
+	app.clipboard.reset()
+	app.transfers.reset()
+	app.resetMedia()
+}
+
+// Ends the current session. The media and the peer connection are closed by the main loop
+// once it receives the done signal of the media components. Does nothing if the session
+// is already being torn down
+func (app *App) endSession() {
+	if _, err := app.session.Fire(session.Terminate); err != nil {
+		log.Printf("[APP] Not ending session: %v", err)
+		return
+	}
+	select {
+	case app.MediaComponents.Done <- struct{}{}:
+	default:
+	}
+}
+
+// Passes state changes on to the frontend
+func (app *App) onTransition(t session.Transition) {
+	log.Printf("[APP] Session state: %s", t)
+	app.emit(SessionEvent{Type: StateChanged, State: t.To})
+}
+
+// Passes the decoded message to the handler of its type
+func (app *App) handle(payload message.Payload) error {
+	switch msg := payload.(type) {
+	case *message.SignalMessage:
+		return app.handleSignaling(msg)
+	case *message.SessionMessage:
+		return app.handleSession(msg)
+	case *message.CommandMessage:
+		return app.handleCommand(msg)
+	case *message.InfoMessage:
+		return app.handleInfo(msg)
+	}
+	return message.ErrUnsupported
 }
 
 // Message loop that blocks on receiver channel of the websocket type and handles the messages
@@ -277,6 +581,7 @@ func (app *App) Start(ctx context.Context) {
 	if app.CtxCancel == nil {
 		app.Ctx, app.CtxCancel = context.WithCancel(ctx)
 	}
+	go app.resumeUploads()
 	// // TODO: Interrupt handling should happen inside the main package since this loop is non-blocking
 loop:
 	for {
@@ -289,46 +594,28 @@ loop:
 
 			logger.LogMessage(&m, "[WS] Received message. ")
 
-			switch m.Type {
-			case message.Signal:
-				var msg message.SignalMessage
-
-				if err := json.Unmarshal([]byte(m.Data), &msg); err != nil {
-					log.Panicf("[ERR] Unmarshalling session message. %v", err)
-				}
-
-				app.handleSignaling(&msg)
-			case message.Session:
-				var msg message.SessionMessage
-
-				if err := json.Unmarshal([]byte(m.Data), &msg); err != nil {
-					log.Panicf("[ERR] Unmarshalling session message. %v", err)
-				}
-				app.handleSession(&msg)
-			case message.Command:
-				var msg message.CommandMessage
-
-				if err := json.Unmarshal([]byte(m.Data), &msg); err != nil {
-					log.Panicf("[ERR] Unmarshalling session message. %v", err)
-				}
-
-				app.handleCommand(&msg)
-			case message.Info:
-				var msg message.InfoMessage
-
-				if err := json.Unmarshal([]byte(m.Data), &msg); err != nil {
-					log.Panicf("[ERR] Unmarshalling info message. %v", err)
-				}
-
-				app.handleInfo(&msg)
+			// Malformed messages are skipped so that a misbehaving peer cannot crash the client
+			payload, err := message.Decode(&m)
+			if err != nil {
+				log.Printf("[ERR] Skipping message: %v", err)
+				app.emit(SessionEvent{Type: MessageError, Message: err.Error()})
+				continue
+			}
+			if err := app.handle(payload); err != nil {
+				log.Printf("[ERR] Handling %s message: %v", m.Type, err)
+			}
+		case <-app.iceRestart:
+			if err := app.restartICE(); err != nil {
+				log.Printf("[ERR] Restarting ICE: %v", err)
 			}
 		case <-app.done:
 			log.Println("[INFO] Done received, closing")
 			break loop
 		case <-app.MediaComponents.Done:
 			log.Println("[APP] Media component close signal received. Restarting")
-			// mode := app.mode
-			if app.reset {
+			// The media components also signal done when the playback window is closed
+			// after the session has already been reset
+			if app.session.State() == session.TearingDown {
 				app.Reset()
 			}
 
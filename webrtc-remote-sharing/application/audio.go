@@ -0,0 +1,114 @@
+package application
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/remygo/conn/jitter"
+	"github.com/remygo/display/backend"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Audio is not retransmitted, so a missing packet is only waited for as long as packets are
+// reordered. The player conceals the lost frames
+const audioMaxDelay = 60 * time.Millisecond
+
+// Mutes or unmutes the sound of the host. The host stops sending its sound, the remote stops
+// playing it. The setting is kept for later sessions
+func (app *App) SetMuted(muted bool) error {
+	atomic.StoreInt32(&app.muted, boolFlag(muted))
+	if err := app.applyMuted(); err != nil {
+		return err
+	}
+
+	app.Config.Media.Audio.Muted = muted
+	if err := app.Config.Save(); err != nil {
+		return fmt.Errorf("saving config: %v", err)
+	}
+	return nil
+}
+
+func (app *App) isMuted() bool {
+	return atomic.LoadInt32(&app.muted) == 1
+}
+
+// Passes the mute setting on to the audio of the current media components. They keep it if
+// they are not started yet
+func (app *App) applyMuted() error {
+	media := app.media()
+	if media == nil {
+		return nil
+	}
+	if media.AudioCapture != nil {
+		if err := media.AudioCapture.SetMuted(app.isMuted()); err != nil {
+			return fmt.Errorf("muting capture: %w", err)
+		}
+	}
+	if media.AudioPlayer != nil {
+		if err := media.AudioPlayer.SetMuted(app.isMuted()); err != nil {
+			return fmt.Errorf("muting playback: %w", err)
+		}
+	}
+	return nil
+}
+
+// Starts sending the sound of the host if the audio track was negotiated. The session goes on
+// without sound if it cannot be captured
+func (app *App) startAudioCapture() {
+	if app.AudioCapture == nil || app.audioTrack == nil {
+		return
+	}
+	if err := app.AudioCapture.SetMuted(app.isMuted()); err != nil {
+		log.Printf("[WARN] Muting capture: %v", err)
+	}
+	if err := app.AudioCapture.Start(app.audioTrack); err != nil {
+		log.Printf("[WARN] Capturing sound: %v", err)
+	}
+}
+
+// Plays the sound of the host until the track ends with the peer connection
+func (app *App) playAudio(tr *webrtc.TrackRemote) {
+	log.Printf("[PC] Host audio track received: %s", tr.Codec().MimeType)
+
+	media := app.media()
+	if media == nil || media.AudioPlayer == nil {
+		return
+	}
+	player := media.AudioPlayer
+	if err := player.SetMuted(app.isMuted()); err != nil {
+		log.Printf("[WARN] Muting playback: %v", err)
+	}
+	app.setRecordedAudio()
+	app.playTrack(tr, player, app.recordAudio)
+}
+
+// Passes the frames of the audio track on to the player until the track ends. The frames are
+// passed to record too if it is set
+func (app *App) playTrack(tr *webrtc.TrackRemote, player backend.AudioPlayer, record func(frame []byte, pts time.Duration)) {
+	buffer, err := jitter.New(tr.Codec().MimeType, tr.Codec().ClockRate, audioMaxDelay)
+	if err != nil {
+		log.Printf("[ERR] Creating audio jitter buffer: %v", err)
+		return
+	}
+	if err := player.Start(); err != nil {
+		log.Printf("[WARN] Playing %s: %v", tr.ID(), err)
+		return
+	}
+
+	for {
+		pkt, _, err := tr.ReadRTP()
+		if err != nil {
+			log.Printf("[PC] Track %s ended: %v", tr.ID(), err)
+			return
+		}
+		for _, f := range buffer.Push(pkt) {
+			player.Push(f.Data, f.PTS)
+			if record != nil {
+				record(f.Data, f.PTS)
+			}
+		}
+	}
+}
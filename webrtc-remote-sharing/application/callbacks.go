@@ -6,52 +6,67 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/remygo/application/session"
+	"github.com/remygo/conn/jitter"
+	"github.com/remygo/conn/wrtc"
 	"github.com/remygo/display"
+	"github.com/remygo/display/backend"
 	"github.com/remygo/internal/events"
+	"github.com/remygo/internal/types"
 
 	"github.com/pion/webrtc/v3"
 )
 
-type Mode uint8
+// Interval of the log of the jitter buffer of the remote while packets are lost or late
+const jitterReportInterval = 10 * time.Second
+
+// Role of the application in the current session
+type Mode = session.Role
 
 const (
-	Host Mode = iota + 1
-	Remote
+	Host   = session.Host
+	Remote = session.Remote
 )
 
+// Connects the callbacks of the peer connection. The context is the one of the session which
+// ends a running recovery of the connection
 func (app *App) ConnectCallbacks(ctx context.Context, mode Mode) error {
-	// Connect common callbacks between host and remote operating modes
-	app.PeerConn.OnICECandidate(func(i *webrtc.ICECandidate) {
-		log.Println("[ICE] Found candidate")
-		if i == nil {
-			return
-		}
-
-		if app.PeerConn.ICEGatheringState() != webrtc.ICEGatheringStateComplete {
-			log.Println("[ICE] Queueing ICE candidate")
-			app.candidatesTXQueue <- i
-			return
-		}
-	})
+	// Connect common callbacks between host and remote operating modes. Local candidates
+	// are passed on by the trickle exchange which owns the OnICECandidate callback
+	pc := app.PeerConn
 
 	// Log ICE connection state
 	app.PeerConn.OnICEConnectionStateChange(func(is webrtc.ICEConnectionState) {
 		log.Printf("[ICE] Connection state changed: %s", is.String())
 	})
 
-	// Log peer connection state and exit if failed
+	// Log peer connection state and try to recover a lost connection with ICE restarts
 	app.PeerConn.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
 		switch p {
-		case webrtc.PeerConnectionStateFailed:
-			if err := app.PeerConn.Close(); err != nil {
-				log.Panicf("[ERR] Connecting to remote: %v", err)
+		case webrtc.PeerConnectionStateDisconnected, webrtc.PeerConnectionStateFailed:
+			log.Printf("[PC] Connection %s", p)
+			// A connection which never came up is not recovered
+			if app.session.State() == session.Connected {
+				go app.reconnect(ctx, pc, mode)
 			}
 		case webrtc.PeerConnectionStateClosed:
 			log.Println("[PC] Connection closed")
 			return
 		case webrtc.PeerConnectionStateConnected:
 			log.Println("[PC] Connection established")
+			// The session is already connected if an ICE restart brought the connection back
+			if app.session.State() == session.Connected {
+				return
+			}
+			if _, err := app.session.Fire(session.Connect); err != nil {
+				log.Printf("[WARN] %v", err)
+				return
+			}
+			app.negotiator.Start()
+			app.emit(SessionEvent{Type: InSession, UserID: app.peerUserID})
+			app.verifyConnection()
 			return
 		case webrtc.PeerConnectionStateConnecting:
 			log.Println("[PC] Connecting to remote")
@@ -63,31 +78,88 @@ func (app *App) ConnectCallbacks(ctx context.Context, mode Mode) error {
 	// Connect mode specific callbacks
 	switch mode {
 	case Host:
-		return app.connectHostCallbacks()
+		return app.connectHostCallbacks(ctx)
 	case Remote:
-		return app.connectRemoteCallbacks()
+		return app.connectRemoteCallbacks(ctx)
 	}
 	return nil
 }
 
-func (app *App) connectHostCallbacks() error {
+func (app *App) connectHostCallbacks(ctx context.Context) error {
+	// The remote only sends its voice
+	app.PeerConn.OnTrack(func(tr *webrtc.TrackRemote, r *webrtc.RTPReceiver) {
+		if tr.ID() == wrtc.VoiceTrackID {
+			app.playVoice(tr)
+			return
+		}
+		log.Printf("[WARN] Ignoring track %s of the remote", tr.ID())
+	})
+
 	app.PeerConn.OnDataChannel(func(dc *webrtc.DataChannel) {
 		log.Println("[PC] Remote peer opened DataChannel")
+		app.DataChannel = dc
 
+		dc.OnOpen(func() {
+			if err := app.sendIdentity(dc); err != nil {
+				log.Printf("[ERR] Sending identity: %v", err)
+			}
+			if err := app.sendPermissions(dc); err != nil {
+				log.Printf("[ERR] Sending permission profile: %v", err)
+			}
+			go app.watchClipboard(ctx, dc)
+		})
+		// Input is checked against the granted profile before it reaches robotgo
 		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
-			events.ParseEvent(msg.Data)
+			app.tracker.Touch(time.Now())
+			// Asking for another size of the video or for consent to record reconfigures the
+			// host like the input does, so they need the same permission
+			event := types.RemoteEvent{}
+			if err := json.Unmarshal(msg.Data, &event); err == nil {
+				switch event.Type {
+				case "stream":
+					if perm := app.granted(); !perm.Allows(event.Type) {
+						log.Printf("[WARN] Dropping %s event. Not allowed by permission profile %s", event.Type, perm)
+						return
+					}
+					app.handleStreamRequest(dc, event.Event)
+					return
+				case "record":
+					app.handleRecording(event.Event)
+					return
+				case "clipboard":
+					app.handleClipboard(event.Event)
+					return
+				case "file":
+					app.handleFile(dc, event.Event)
+					return
+				}
+			}
+			events.ParseEvent(msg.Data, app.granted())
 		})
 	})
 
 	return nil
 }
 
-func (app *App) connectRemoteCallbacks() error {
-	// On receiving a track, write to the created output track
+func (app *App) connectRemoteCallbacks(ctx context.Context) error {
+	// The callbacks keep the playback of this session. The main loop replaces the media
+	// components while they may still run
+	playback := app.Playback
+
+	// On receiving a track, play the voice or the sound or show the video of the host
 	app.PeerConn.OnTrack(func(tr *webrtc.TrackRemote, r *webrtc.RTPReceiver) {
+		if tr.Kind() == webrtc.RTPCodecTypeAudio {
+			if tr.ID() == wrtc.VoiceTrackID {
+				app.playVoice(tr)
+			} else {
+				app.playAudio(tr)
+			}
+			return
+		}
 		log.Println("[PC] Host video track received")
 
-		cancelRead := make(chan struct{})
+		// Buffered so that the playback loop can end even if the read loop never started
+		cancelRead := make(chan struct{}, 1)
 
 		log.Printf("[PC] Track has started, of type %d: %s \n", tr.PayloadType(), tr.Codec().MimeType)
 		wg := &sync.WaitGroup{}
@@ -95,9 +167,9 @@ func (app *App) connectRemoteCallbacks() error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := app.Playback.Loop()
+			err := playback.Loop()
 			if err != nil {
-				log.Panicf("[ERR] Stopping window provider: %v", err)
+				log.Printf("[ERR] Stopping window provider: %v", err)
 			}
 			// Stop the RTP read loop
 			log.Println("[DEBUG] Canceling RTP read loop")
@@ -107,41 +179,44 @@ func (app *App) connectRemoteCallbacks() error {
 			// app.CtxCancel()
 			log.Println("[DEBUG] Canceling app media component")
 
-			app.reset = true
-			app.MediaComponents.Done <- struct{}{}
+			app.endSession()
 		}()
 
-		// go func() {
-		// 	ticker := time.NewTicker(time.Second * 3)
-		// 	for {
-		// 		select {
-		// 		case <-ticker.C:
-		// 			rtcpSendErr := app.PeerConn.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(tr.SSRC())}})
-		// 			if rtcpSendErr != nil {
-		// 				log.Printf("[ERR] Writing RTCP: %v", rtcpSendErr)
-		// 				return
-		// 			}
-		// 		case <-rtpReadCtx.Done():
-		// 			// Stop if the playback window is closed
-		// 			return
-		// 		}
-		// 	}
-		// }()
+		// Ask for a key frame whenever the decoder lost track of the video and right away since
+		// the stream joined may have started with delta frames
+		pc := app.PeerConn
+		requestKeyFrame := func() {
+			if err := pc.RequestKeyFrame(uint32(tr.SSRC())); err != nil {
+				log.Printf("[ERR] Requesting key frame: %v", err)
+			}
+		}
+		playback.OnKeyFrameNeeded(requestKeyFrame)
+		app.setRecordedVideo(tr.Codec().MimeType, requestKeyFrame)
 
+		// The host may have announced the size of the video before the track arrived
 		width, height := display.Dimensions()
+		if settings := app.stream.get(); settings.Width > 0 {
+			width, height = settings.Width, settings.Height
+		}
 		codecName := strings.Split(tr.Codec().RTPCodecCapability.MimeType, "/")[1]
 
-		if err := app.Playback.Start(width, height, int(tr.PayloadType()), strings.ToLower(codecName)); err != nil {
-			log.Panicf("[ERR] Initiating display pipeline: %v", err)
+		// Packets are reordered and assembled into frames before they reach the decoder
+		buffer, err := jitter.New(tr.Codec().MimeType, tr.Codec().ClockRate, 0)
+		if err != nil {
+			log.Printf("[ERR] Creating jitter buffer: %v", err)
+			app.leaveSession("The screen of the host could not be displayed")
+			return
+		}
+		if err := playback.Start(width, height, strings.ToLower(codecName)); err != nil {
+			log.Printf("[ERR] Initiating display pipeline: %v", err)
+			app.leaveSession("The screen of the host could not be displayed")
+			return
 		}
-		// for {
-		// _, _, readErr := tr.ReadRTP()
-		// if readErr != nil {
-		// 	log.Println("[ERR] Reading received track: ", readErr)
-		// 	return
-		// }
-
-		buf := make([]byte, 1500)
+		requestKeyFrame()
+
+		var reported jitter.Stats
+		defer func() { reportJitter(buffer.Stats(), jitter.Stats{}) }()
+		lastReport := time.Now()
 	loop:
 		for {
 			select {
@@ -149,21 +224,44 @@ func (app *App) connectRemoteCallbacks() error {
 				log.Println("[APP] Stopping RTP track read loop")
 				break loop
 			default:
-				i, _, readErr := tr.Read(buf)
+				pkt, _, readErr := tr.ReadRTP()
 				if readErr != nil {
 					log.Printf("[ERR] Reading remote track: %v", readErr)
 					return
 				}
-				app.Playback.HandleFrameBuffer(buf[:i])
+				for _, f := range buffer.Push(pkt) {
+					// The decoder cannot catch up before the next key frame
+					if f.AfterLoss {
+						requestKeyFrame()
+					}
+					playback.HandleFrame(f.Data, f.PTS)
+					app.recordVideo(f.Data, f.PTS)
+				}
+				if time.Since(lastReport) >= jitterReportInterval {
+					reported, lastReport = reportJitter(buffer.Stats(), reported), time.Now()
+				}
 			}
 		}
 
 		wg.Wait()
 	})
 
+	app.DataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		app.handleHostEvent(msg.Data)
+	})
+
+	if window, ok := playback.UI.Window.(backend.Resizable); ok {
+		window.OnResize(app.windowResized)
+	}
+
 	app.DataChannel.OnOpen(func() {
 		log.Println("[PC] Data channel open")
-		for msg := range app.Playback.UI.ReceiveInputEvents() {
+		app.requestStream()
+		go app.sendFiles(ctx, app.DataChannel)
+		for msg := range playback.UI.ReceiveInputEvents() {
+			if !app.shareInput(msg) {
+				continue
+			}
 			msgToSend, dcErr := json.Marshal(msg)
 			if dcErr != nil {
 				log.Println("[ERR] Marshalling mouse event:", dcErr)
@@ -178,3 +276,13 @@ func (app *App) connectRemoteCallbacks() error {
 	})
 	return nil
 }
+
+// Logs the counters of the jitter buffer if packets were lost or late since the last report
+// and returns the counters for the next one
+func reportJitter(stats, last jitter.Stats) jitter.Stats {
+	if stats.Lost != last.Lost || stats.Late != last.Late || last == (jitter.Stats{}) {
+		log.Printf("[PC] Jitter buffer: %d packets, %d lost, %d late, %d frames, %d dropped",
+			stats.Packets, stats.Lost, stats.Late, stats.Frames, stats.Dropped)
+	}
+	return stats
+}
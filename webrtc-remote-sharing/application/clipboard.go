@@ -0,0 +1,133 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/remygo/internal/robot"
+	"github.com/remygo/internal/types"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Interval at which the host looks for text copied on its side
+const clipboardInterval = time.Second
+
+// Longest text passed between the clipboards. It keeps the message below the size which every
+// datachannel implementation accepts
+const maxClipboardLen = 60 << 10
+
+// Clipboard shared by the users of the current session if the host granted it
+type clipboardState struct {
+	mu   sync.Mutex
+	text string // Last text received from or sent to the other user
+}
+
+// Records the text as shared and reports whether it differs from the last shared one
+func (c *clipboardState) swap(text string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if text == c.text {
+		return false
+	}
+	c.text = text
+	return true
+}
+
+func (c *clipboardState) reset() {
+	c.mu.Lock()
+	c.text = ""
+	c.mu.Unlock()
+}
+
+// Writes the text the other user copied into the local clipboard. Text from a remote without
+// the clipboard permission is dropped, as is text for a remote the host did not grant it
+func (app *App) handleClipboard(payload json.RawMessage) {
+	if perm := app.granted(); !perm.Allows("clipboard") {
+		log.Printf("[WARN] Dropping clipboard event. Not allowed by permission profile %s", perm)
+		return
+	}
+	clip := types.Clipboard{}
+	if err := json.Unmarshal(payload, &clip); err != nil {
+		log.Println("[ERR] Parsing clipboard:", err)
+		return
+	}
+	if len(clip.Text) > maxClipboardLen || !app.clipboard.swap(clip.Text) {
+		return
+	}
+
+	if app.session.Role() == Remote {
+		if ui := app.playbackUI(); ui != nil {
+			ui.WriteClipboard(clip.Text)
+		}
+		return
+	}
+	if err := robot.SetClipboard(clip.Text); err != nil {
+		log.Printf("[ERR] Writing clipboard: %v", err)
+	}
+}
+
+// Reports whether the event the remote window produced may be sent to the host. The clipboard
+// of the remote only leaves the device if the host shares its own
+func (app *App) shareInput(ev *types.RemoteEvent) bool {
+	if ev.Type != "clipboard" {
+		return true
+	}
+	if !app.granted().Allows(ev.Type) {
+		return false
+	}
+	clip := types.Clipboard{}
+	if err := json.Unmarshal(ev.Event, &clip); err != nil || len(clip.Text) > maxClipboardLen {
+		return false
+	}
+	return app.clipboard.swap(clip.Text)
+}
+
+// Runs on the host for the lifetime of the session and sends text copied on the host to the
+// remote while the remote is granted the clipboard
+func (app *App) watchClipboard(ctx context.Context, dc *webrtc.DataChannel) {
+	ticker := time.NewTicker(clipboardInterval)
+	defer ticker.Stop()
+
+	var lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !app.granted().Allows("clipboard") {
+			continue
+		}
+
+		text, err := robot.Clipboard()
+		if err != nil {
+			// The clipboard may stay unavailable e.g. without a display, which is logged once
+			if err.Error() != lastErr {
+				log.Printf("[ERR] Reading clipboard: %v", err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		if text == "" || len(text) > maxClipboardLen || !app.clipboard.swap(text) {
+			continue
+		}
+
+		clip, err := json.Marshal(&types.Clipboard{Text: text})
+		if err != nil {
+			log.Printf("[ERR] Encoding clipboard: %v", err)
+			continue
+		}
+		msg, err := json.Marshal(&types.RemoteEvent{Type: "clipboard", Event: clip})
+		if err != nil {
+			log.Printf("[ERR] Encoding clipboard: %v", err)
+			continue
+		}
+		if err := dc.Send(msg); err != nil {
+			log.Printf("[ERR] Sending clipboard: %v", err)
+		}
+	}
+}
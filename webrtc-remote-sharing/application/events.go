@@ -1,13 +1,74 @@
 package application
 
+import (
+	"sync"
+
+	"github.com/remygo/application/session"
+)
+
 type EventType uint8
 
 const (
 	Renew EventType = iota
 	InSession
 	SessionEnded
+	Registered
+	Warning
+	Verify
+	StateChanged
+	MessageError       // A message from the signaling server could not be decoded and was skipped
+	RecordingRequested // The other user asks for consent to record the session. Answered with AnswerRecording
+	Recording          // The recording of this user started or stopped
+	PeerRecording      // The recording of the other user started or stopped
+	FileTransfer       // A file was sent to the host. Message is the stored file on the host and its name on the remote
 )
 
 type SessionEvent struct {
-	Type EventType
+	Type     EventType
+	Token    string        // Current session token for Registered and Renew events
+	Password string        // Session password for Registered and Renew events. Empty in unattended mode
+	Message  string        // Text of Warning and MessageError events or the short authentication string of Verify events
+	UserID   string        // User id of the remote for InSession events on the host
+	State    session.State // New state of StateChanged events
+
+	// Whether the recording runs for Recording and PeerRecording events. Message is the file of
+	// the recording of Recording events
+	Recording bool
+}
+
+// Events waiting for the reader of the session events. The queue keeps their order without
+// blocking the sender, e.g. the state machine whose handlers run on the goroutine of the GUI
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []SessionEvent
+	sending bool // Set while a goroutine delivers the pending events
+}
+
+// Queues the event for the reader of the session events and returns at once
+func (app *App) emit(ev SessionEvent) {
+	q := &app.events
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, ev)
+	if !q.sending {
+		q.sending = true
+		go app.deliverEvents()
+	}
+}
+
+// Sends the queued events in order until the queue is empty
+func (app *App) deliverEvents() {
+	q := &app.events
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.sending = false
+			q.mu.Unlock()
+			return
+		}
+		ev := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		app.sessionEvents <- ev
+	}
 }
@@ -1,160 +1,305 @@
 package application
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/pion/webrtc/v3"
+	"github.com/remygo/application/session"
+	"github.com/remygo/conn/wrtc"
+	"github.com/remygo/internal/types"
+	"github.com/remygo/pkg/approval"
 	"github.com/remygo/pkg/message"
 )
 
-func (app *App) handleSession(msg *message.SessionMessage) {
-	fmt.Printf("[TYPE]: %s\n\n", msg.String())
+func (app *App) handleSession(msg *message.SessionMessage) error {
+	log.Printf("[SESSION] Received %s", msg)
 	switch msg.Type {
 	case message.JoinRequest:
-		// For the host, there is no explicit flow. However, the host is the controller of the transaction
-		// i.e. it can either allow or deny the call request. Before the host allows the call however,
-		// it must first setup the correct state with appropriate paramenters which is what's happening here
+		if !app.session.Can(session.Accept) {
+			log.Printf("[SESSION] Join request from user %q in state %s. Denying request", msg.UserID, app.session.State())
+			return app.denyJoin(msg.Token)
+		}
 
-		// // TODO: This state initiation should be refactored. I'm being lazy right now
-		if app.callRequest == nil {
-			log.Println("[INFO] Call request received. Configuring as host")
-			app.configureAsHost()
+		// Unattended hosts accept devices on their allowlist without a password since nobody is
+		// in front of them to confirm. Other requests start the exchange of the password
+		if msg.Share == "" {
+			device, ok := app.authorizeDevice(msg)
+			if !ok {
+				log.Printf("[SESSION] Join request from user %q without password is not from an allowed device. Denying request", msg.UserID)
+				return app.denyJoin(msg.Token)
+			}
+			return app.acceptJoin(msg, nil, device)
+		}
+		if err := app.challengeJoin(msg); err != nil {
+			log.Printf("[SESSION] Join request from user %q cannot be challenged: %v. Denying request", msg.UserID, err)
+			return app.denyJoin(msg.Token)
+		}
+	case message.JoinConfirm:
+		// Requests without a valid proof of the session password are denied
+		request, key, err := app.confirmJoin(msg)
+		if err != nil {
+			log.Printf("[SESSION] %v. Denying request", err)
+			return app.denyJoin(msg.Token)
+		}
+		if !app.session.Can(session.Accept) {
+			log.Printf("[SESSION] Join request from user %q confirmed in state %s. Denying request", request.UserID, app.session.State())
+			return app.denyJoin(msg.Token)
 		}
+		return app.acceptJoin(request, key, "")
+	case message.JoinChallenge:
+		return app.answerChallenge(msg)
+	}
+	return nil
+}
+
+// Accepts a join request which proved the password or came from an allowed device once the
+// approver agreed. The key of the password exchange seals the signaling of the session
+func (app *App) acceptJoin(msg *message.SessionMessage, key []byte, device string) error {
+	log.Printf("[SESSION] Join request from user %q authorized", msg.UserID)
 
-		// TODO: There should be a popup/dialog on the GUI to confirm the request
-		// TODO: The host should be able to accept/reject the request
-		// For now, allow the host to accept the session directly
+	// The approver blocks the main loop while it runs which is fine since
+	// nothing else happens on the host until the request is answered
+	perm := app.resolvePermissions(msg)
+	if app.Approver != nil {
+		decision, err := app.Approver.Approve(app.Ctx, approval.Request{Token: msg.Token,
+			UserID: msg.UserID, OrgID: msg.OrgID, Unattended: app.Config.Unattended.Enabled})
+		if err != nil {
+			log.Printf("[ERR] Approving join request: %v", err)
+		}
+		if !decision.Approved || err != nil {
+			log.Printf("[SESSION] Join request from user %q not approved. Denying request", msg.UserID)
+			return app.denyJoin(msg.Token)
+		}
+		// The approver may grant another profile than the config, but a profile it names
+		// wrongly denies the request rather than guessing what it meant
+		if decision.Profile != "" {
+			if perm, err = types.ParseProfile(decision.Profile); err != nil {
+				log.Printf("[ERR] Approving join request: %v. Denying request", err)
+				return app.denyJoin(msg.Token)
+			}
+		}
+	}
+	app.signalKey = key
 
-		// FIXME: This is a really bad way to do this. I shouldn't be passing a pointer to a string
-		// FIXME: just so it can be an optional parameter.
-		allow := "allow"
-		log.Println("[SESSION] Join request received. Accepting request")
+	app.peerUserID, app.peerDevice = msg.UserID, device
+	app.grant(perm)
+	log.Printf("[SESSION] Granting permission profile %s to user %q", perm, msg.UserID)
 
-		app.Socket.Write(*message.NewSession(message.JoinResponse, app.SessionToken, &allow))
+	// The host is the controller of the transaction i.e. it can either allow or deny the call
+	// request. Before the host allows the call however, it must first setup the peer connection
+	log.Println("[INFO] Call request received. Configuring as host")
+	if err := app.configureAsHost(); err != nil {
+		app.releasePeer()
+		if denyErr := app.denyJoin(msg.Token); denyErr != nil {
+			log.Printf("[ERR] %v", denyErr)
+		}
+		return fmt.Errorf("configuring as host: %w", err)
+	}
+	if _, err := app.session.Fire(session.Accept); err != nil {
+		app.releasePeer()
+		return err
 	}
+
+	// FIXME: This is a really bad way to do this. I shouldn't be passing a pointer to a string
+	// FIXME: just so it can be an optional parameter.
+	allow := "allow"
+	log.Println("[SESSION] Join request received. Accepting request")
+
+	return app.send(message.NewSession(message.JoinResponse, app.SessionToken, &allow))
 }
 
-func (app *App) handleCommand(msg *message.CommandMessage) {
-	fmt.Printf("\n[MESSAGE TYPE]: %s\n", msg.String())
+func (app *App) handleCommand(msg *message.CommandMessage) error {
+	log.Printf("[WS] Received %s", msg)
 	switch msg.Type {
 	case message.InitiateSession:
+		if app.session.State() != session.Negotiating || app.session.Role() != Remote {
+			return fmt.Errorf("initiate session received in state %s as %s", app.session.State(), app.session.Role())
+		}
 		// Create an offer to send to the host peer
-		offerString, err := app.PeerConn.NewOffer()
-		if err != nil {
-			log.Panicf("[ERR] Creating offer: %v", err)
+		if err := app.sendOffer(); err != nil {
+			app.leaveSession("Connection could not be set up")
+			return fmt.Errorf("sending offer: %w", err)
 		}
-		offer := message.NewSignal(message.Offer, offerString)
-
-		app.Socket.Write(*offer)
 
 	case message.TerminateSession:
-		fmt.Println("[WS] Session terminated")
+		log.Println("[WS] Session terminated")
+		app.endSession()
+	}
+	return nil
+}
 
-		// Set the reset flag
-		app.reset = true
-		app.MediaComponents.Done <- struct{}{}
+// Sends a deny response to the signaling server which informs the requesting remote
+func (app *App) denyJoin(token string) error {
+	deny := "deny"
+	if err := app.send(message.NewSession(message.JoinResponse, token, &deny)); err != nil {
+		return fmt.Errorf("denying join request: %w", err)
 	}
+	return nil
 }
 
 // Handles the signaling messages
-func (app *App) handleSignaling(msg *message.SignalMessage) {
-	fmt.Printf("[TYPE]: %s\n\n", msg.String())
+func (app *App) handleSignaling(msg *message.SignalMessage) error {
+	log.Printf("[WS] Received %s", msg)
+
+	// Signaling is only valid once the join has been accepted and the peer connection exists
+	if state := app.session.State(); (state != session.Negotiating && state != session.Connected) || app.PeerConn == nil {
+		return fmt.Errorf("%s received in state %s", msg, state)
+	}
 	switch msg.Type {
 	case message.ICE:
 		log.Println("[ICE] Candidate received")
 		candidate := webrtc.ICECandidateInit{}
 		if err := msg.IntoICE(&candidate); err != nil {
-			log.Panicf("[ERR] Deserializing into ICE Candidate %v", err)
+			return fmt.Errorf("deserializing into ICE candidate: %w", err)
 		}
 
-		if app.PeerConn.RemoteDescription() == nil {
-			log.Println("[ICE] Remote description is nil. Queueing received candidate")
-			app.candidatesRXQueue <- candidate
-			return
-		}
-		log.Println("[ICE] Adding candidate")
-		if err := app.PeerConn.AddICECandidate(candidate); err != nil {
-			log.Println("[ERR] Adding ICE candidate:", err)
+		if err := app.trickle.AddRemote(candidate); err != nil {
+			return fmt.Errorf("adding ICE candidate: %w", err)
 		}
 
 	case message.Offer:
 		log.Println("[SDP] Offer received")
 		offer := webrtc.SessionDescription{}
-		if err := msg.IntoSDP(&offer); err != nil {
-			log.Panicf("[ERR] Deserializing into SDP Offer: %v", err)
+		if err := app.openSDP(msg, &offer); err != nil {
+			app.abortSession(err)
+			return nil
+		}
+		if err := app.checkPeerDevice(offer); err != nil {
+			app.abortSession(err)
+			return nil
+		}
+
+		// Applying an offer with new ICE credentials starts gathering, so local candidates
+		// are held back until the answer was sent
+		app.trickle.Hold()
+		defer app.trickle.Release()
+
+		// Once connected offers renegotiate the session, e.g. restart ICE
+		if app.session.State() == session.Connected {
+			return app.renegotiate(offer)
 		}
 
 		log.Println("[PC] Setting remote description")
 		if err := app.PeerConn.SetRemoteDescription(offer); err != nil {
-			log.Println("[ERR] Setting remote description: ", err)
-			return
+			return fmt.Errorf("setting remote description: %w", err)
+		}
+		if err := app.trickle.Ready(); err != nil {
+			log.Printf("[ERR] Adding buffered ICE candidates: %v", err)
+		}
+		if err := app.startCapture(); err != nil {
+			reason := "The screen could not be captured"
+			if errors.Is(err, wrtc.ErrNoCommonCodec) {
+				reason = "No video codec is supported by both peers"
+			}
+			app.leaveSession(reason)
+			return err
 		}
 		// Create an answer to send to the remote peer
 		answerString, err := app.PeerConn.NewAnswer()
 		if err != nil {
-			log.Panicf("[ERR] Creating answer: %v", err)
+			app.leaveSession("Connection could not be set up")
+			return fmt.Errorf("creating answer: %w", err)
 		}
-		msg := message.NewSignal(message.Answer, answerString)
-
-		if err = app.Socket.Write(*msg); err != nil {
-			log.Panicf("[ERR] Cannot send Answer signal message. %v", err)
+		if err = app.sendSDP(message.SignalMessage{Type: message.Answer, Data: answerString}); err != nil {
+			app.leaveSession("Connection could not be set up")
+			return fmt.Errorf("sending answer: %w", err)
 		}
 
 	case message.Answer:
 		log.Println("[SDP] Answer received")
 		answer := webrtc.SessionDescription{}
-		if err := msg.IntoSDP(&answer); err != nil {
-			log.Panicf("[ERR] Deserializing into SDP Answer: %v", err)
+		if err := app.openSDP(msg, &answer); err != nil {
+			app.abortSession(err)
+			return nil
+		}
+		if err := app.checkPeerDevice(answer); err != nil {
+			app.abortSession(err)
+			return nil
+		}
+		if app.session.State() == session.Connected {
+			return app.renegotiate(answer)
 		}
 
 		log.Println("[PC] Setting remote description")
 		if err := app.PeerConn.SetRemoteDescription(answer); err != nil {
-			log.Println("[ERR] Setting remote description: ", err)
-			return
+			return fmt.Errorf("setting remote description: %w", err)
+		}
+		if err := app.trickle.Ready(); err != nil {
+			log.Printf("[ERR] Adding buffered ICE candidates: %v", err)
 		}
 	}
+	return nil
 }
 
-func (app *App) handleInfo(msg *message.InfoMessage) {
-	fmt.Printf("\n[MESSAGE TYPE]: %s\n", msg.String())
+// Passes a description received over the signaling server during the session to the negotiator
+func (app *App) renegotiate(desc webrtc.SessionDescription) error {
+	if err := app.negotiator.HandleDescription(desc); err != nil {
+		return fmt.Errorf("renegotiating: %w", err)
+	}
+	if err := app.trickle.Ready(); err != nil {
+		log.Printf("[ERR] Adding buffered ICE candidates: %v", err)
+	}
+	return nil
+}
+
+func (app *App) handleInfo(msg *message.InfoMessage) error {
+	log.Printf("[INFO] Received %s", msg)
 	switch msg.Type {
 	case message.Token:
-		if app.registerRequest.Status == "pending" && app.registerRequest.Next == message.Token.String() {
-			log.Printf("[INFO] Received register response: %+#v\n", msg)
-			app.SessionToken = msg.Data
-			log.Println("[INFO] Session token:", app.SessionToken)
-			app.registerRequest = nil
-			return
-		}
-		log.Panic("[WARN] Token received but no pending register call")
+		if _, err := app.session.Fire(session.Token); err != nil {
+			return fmt.Errorf("token received but no pending register call: %w", err)
+		}
+		log.Printf("[INFO] Received register response: %+#v\n", msg)
+		app.SessionToken = msg.Data
+		log.Println("[INFO] Session token:", app.SessionToken)
+
+		if err := app.newSessionPassword(app.SessionToken); err != nil {
+			log.Printf("[ERR] %v", err)
+		}
+		app.emit(SessionEvent{Type: Registered, Token: app.SessionToken, Password: app.sessionPassword})
 	case message.Error:
-		fmt.Printf("\n(ERROR): %s\n\n", msg.Data)
+		log.Printf("[INFO] Signaling server error: %s", msg.Data)
 
+		// A denied join request only ends the attempt, other errors end the application
+		if _, err := app.session.Fire(session.Deny); err == nil {
+			app.emit(SessionEvent{Type: Warning, Message: msg.Data})
+			return nil
+		}
 		app.done <- struct{}{}
+	case message.Warning:
+		log.Printf("[APP] Signaling server: %s", msg.Data)
+		if ui := app.playbackUI(); ui != nil && app.session.Role() == Remote {
+			ui.ShowNotice(msg.Data)
+		}
+		app.emit(SessionEvent{Type: Warning, Message: msg.Data})
 	case message.Ack:
-		if app.callRequest != nil && app.callRequest.Status == "pending" {
-			log.Println("[INFO] Call approval received. Configuring as remote")
-			app.callRequest.Status = "active"
-			app.callRequest.Next = ""
-
-			fmt.Printf("\n(ACK): %s\n\n", msg.Data)
-			app.configureAsRemote()
-			return
+		if _, err := app.session.Fire(session.Approve); err != nil {
+			return fmt.Errorf("acknowledge received but no pending join call: %w", err)
+		}
+		app.joinUnattended = msg.Unattended
+		log.Println("[INFO] Call approval received. Configuring as remote")
+		if err := app.configureAsRemote(); err != nil {
+			app.leaveSession("Connection could not be set up")
+			return fmt.Errorf("configuring as remote: %w", err)
 		}
-		log.Panic("[WARN] Acknowledge received but no pending join call")
 	case message.Renew:
-		if app.reset {
+		// The new token may arrive before the main loop got to reset the ended session
+		if app.session.State() == session.TearingDown {
 			app.Reset()
 		}
-		if app.renewRequest != nil && app.renewRequest.Status == "pending" {
-			app.SessionToken = msg.Data
-			log.Printf("[APP] Session token renewed. New token %s\n", app.SessionToken)
-		} else if app.renewRequest == nil {
-			log.Panic("[WARN] Renew request received but no pending renew call")
+		if _, err := app.session.Fire(session.Renew); err != nil {
+			return fmt.Errorf("renew received but no session ended: %w", err)
 		}
-		app.renewRequest = nil
+		app.SessionToken = msg.Data
+		log.Printf("[APP] Session token renewed. New token %s\n", app.SessionToken)
 
-		app.sessionEvents <- SessionEvent{Type: Renew}
+		// A new password goes with every new token so that a previous remote cannot join again
+		if err := app.newSessionPassword(app.SessionToken); err != nil {
+			log.Printf("[ERR] %v", err)
+		}
+		app.emit(SessionEvent{Type: Renew, Token: app.SessionToken, Password: app.sessionPassword})
 	}
+	return nil
 }
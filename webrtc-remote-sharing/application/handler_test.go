@@ -0,0 +1,258 @@
+package application
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/remygo/application/session"
+	"github.com/remygo/pkg/approval"
+	"github.com/remygo/pkg/auth"
+	"github.com/remygo/pkg/config"
+	"github.com/remygo/pkg/message"
+)
+
+// Remembers the messages written to the signaling server
+type fakeSocket struct {
+	mu   sync.Mutex
+	sent []message.Message
+}
+
+func (s *fakeSocket) Write(msg message.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func (s *fakeSocket) last(t *testing.T) message.Payload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sent) == 0 {
+		t.Fatal("nothing sent")
+	}
+	payload, err := message.Decode(&s.sent[len(s.sent)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// Returns an application without media whose session events nobody reads until the test does
+func newTestApp() (*App, *fakeSocket) {
+	socket := &fakeSocket{}
+	app := &App{
+		Socket:        socket,
+		Args:          &Args{Headless: true},
+		Config:        &config.Config{},
+		session:       session.New(),
+		sessionEvents: make(chan SessionEvent),
+		done:          make(chan struct{}, 1),
+	}
+	app.session.Subscribe(app.onTransition)
+	return app, socket
+}
+
+func nextEvent(t *testing.T, app *App) SessionEvent {
+	select {
+	case ev := <-app.sessionEvents:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no session event")
+		return SessionEvent{}
+	}
+}
+
+// Registers the app and drops the events of the registration
+func register(t *testing.T, app *App) {
+	if err := app.RegisterSession(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.handleInfo(&message.InfoMessage{Type: message.Token, Data: "token-1"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		nextEvent(t, app)
+	}
+}
+
+func TestHandleToken(t *testing.T) {
+	app, socket := newTestApp()
+
+	// The handlers return although nobody reads the session events yet
+	handled := make(chan error, 1)
+	go func() {
+		if err := app.RegisterSession(); err != nil {
+			handled <- err
+			return
+		}
+		handled <- app.handleInfo(&message.InfoMessage{Type: message.Token, Data: "token-1"})
+	}()
+	select {
+	case err := <-handled:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handlers blocked on the session events")
+	}
+
+	if info, ok := socket.last(t).(*message.InfoMessage); !ok || info.Type != message.Register {
+		t.Fatalf("sent %+v, want register", socket.last(t))
+	}
+	if state := app.session.State(); state != session.Registered {
+		t.Fatalf("state %s", state)
+	}
+	if ev := nextEvent(t, app); ev.Type != StateChanged || ev.State != session.Registering {
+		t.Fatalf("first event %+v", ev)
+	}
+	if ev := nextEvent(t, app); ev.Type != StateChanged || ev.State != session.Registered {
+		t.Fatalf("second event %+v", ev)
+	}
+	ev := nextEvent(t, app)
+	if ev.Type != Registered || ev.Token != "token-1" || ev.Password == "" || ev.Password != app.sessionPassword {
+		t.Fatalf("third event %+v", ev)
+	}
+
+	// A token nobody asked for is an error
+	if err := app.handleInfo(&message.InfoMessage{Type: message.Token, Data: "token-2"}); err == nil {
+		t.Fatal("accepted unexpected token")
+	}
+	if app.SessionToken != "token-1" {
+		t.Fatalf("token %q", app.SessionToken)
+	}
+}
+
+func TestHandleJoinRequestDenied(t *testing.T) {
+	app, socket := newTestApp()
+	request := &message.SessionMessage{Type: message.JoinRequest, Token: "token-1", UserID: "u1", Share: "invalid"}
+
+	// Nothing to join before the token arrived
+	if err := app.handleSession(request); err != nil {
+		t.Fatal(err)
+	}
+	response, ok := socket.last(t).(*message.SessionMessage)
+	if !ok || response.Type != message.JoinResponse || response.Response != message.Deny {
+		t.Fatalf("sent %+v, want deny", socket.last(t))
+	}
+
+	register(t, app)
+	if err := app.handleSession(request); err != nil {
+		t.Fatal(err)
+	}
+	response, ok = socket.last(t).(*message.SessionMessage)
+	if !ok || response.Type != message.JoinResponse || response.Response != message.Deny || response.Token != "token-1" {
+		t.Fatalf("sent %+v, want deny", socket.last(t))
+	}
+	if state := app.session.State(); state != session.Registered {
+		t.Fatalf("state %s after denied request", state)
+	}
+}
+
+func TestHandleJoinWrongPassword(t *testing.T) {
+	app, socket := newTestApp()
+	register(t, app)
+
+	cred, err := auth.DeriveCredential("wrong", "token-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prover, err := auth.NewProver(cred, "token-1", "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &message.SessionMessage{Type: message.JoinRequest, Token: "token-1", UserID: "u1", Share: prover.Share()}
+	if err := app.handleSession(request); err != nil {
+		t.Fatal(err)
+	}
+
+	// The host answers with its share, which the remote cannot confirm with another password
+	challenge, ok := socket.last(t).(*message.SessionMessage)
+	if !ok || challenge.Type != message.JoinChallenge || challenge.Token != "token-1" {
+		t.Fatalf("sent %+v, want challenge", socket.last(t))
+	}
+	if _, _, err := prover.Finish(challenge.Share, challenge.Proof); err != auth.ErrPassword {
+		t.Fatalf("finished exchange with wrong password: %v", err)
+	}
+
+	// A guessed confirmation is denied and the request cannot be confirmed again
+	for i := 0; i < 2; i++ {
+		if err := app.handleSession(&message.SessionMessage{Type: message.JoinConfirm, Token: "token-1", Proof: "guess"}); err != nil {
+			t.Fatal(err)
+		}
+		response, ok := socket.last(t).(*message.SessionMessage)
+		if !ok || response.Type != message.JoinResponse || response.Response != message.Deny {
+			t.Fatalf("sent %+v, want deny", socket.last(t))
+		}
+	}
+	if state := app.session.State(); state != session.Registered {
+		t.Fatalf("state %s after denied request", state)
+	}
+}
+
+func TestHandleJoinDenied(t *testing.T) {
+	app, _ := newTestApp()
+	register(t, app)
+	if _, err := app.session.Fire(session.Join); err != nil {
+		t.Fatal(err)
+	}
+
+	// The host denied the request, which only ends the attempt
+	if err := app.handleInfo(&message.InfoMessage{Type: message.Error, Data: "denied"}); err != nil {
+		t.Fatal(err)
+	}
+	if state := app.session.State(); state != session.Registered {
+		t.Fatalf("state %s", state)
+	}
+	nextEvent(t, app) // AwaitingApproval
+	nextEvent(t, app) // Registered
+	if ev := nextEvent(t, app); ev.Type != Warning || ev.Message != "denied" {
+		t.Fatalf("event %+v", ev)
+	}
+	select {
+	case <-app.done:
+		t.Fatal("denied join closed the application")
+	default:
+	}
+}
+
+// Decides join requests with the given decision and remembers the request
+type fakeApprover struct {
+	decision approval.Decision
+	request  approval.Request
+}
+
+func (a *fakeApprover) Approve(_ context.Context, req approval.Request) (approval.Decision, error) {
+	a.request = req
+	return a.decision, nil
+}
+
+func TestAcceptJoinNotApproved(t *testing.T) {
+	for _, decision := range []approval.Decision{
+		{},
+		{Approved: true, Profile: "admin"}, // Unknown profiles deny rather than grant anything
+	} {
+		app, socket := newTestApp()
+		approver := &fakeApprover{decision: decision}
+		app.Approver = approver
+		register(t, app)
+		app.Config.Unattended.Enabled = true
+
+		request := &message.SessionMessage{Type: message.JoinRequest, Token: "token-1", UserID: "u1", OrgID: "o1"}
+		if err := app.acceptJoin(request, []byte("key"), ""); err != nil {
+			t.Fatal(err)
+		}
+		want := approval.Request{Token: "token-1", UserID: "u1", OrgID: "o1", Unattended: true}
+		if approver.request != want {
+			t.Fatalf("approver asked %+v, want %+v", approver.request, want)
+		}
+		response, ok := socket.last(t).(*message.SessionMessage)
+		if !ok || response.Type != message.JoinResponse || response.Response != message.Deny {
+			t.Fatalf("%+v: sent %+v, want deny", decision, socket.last(t))
+		}
+		if app.granted() != 0 || app.signalKey != nil {
+			t.Fatalf("%+v: denied request granted %s", decision, app.granted())
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/remygo/internal/types"
+	"github.com/remygo/pkg/message"
+	"github.com/remygo/pkg/policy"
+)
+
+// How often the host reports activity to the signaling server at most
+const activityReportInterval = 10 * time.Second
+
+// Runs for the duration of a hosted session. Reports input and frame changes to the signaling
+// server for its idle timeout and enforces the limits configured on the host itself
+func (app *App) enforceLimits(ctx context.Context, tracker *policy.Tracker) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	reported := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if tracker.LastActivity().After(reported) && now.Sub(reported) >= activityReportInterval {
+				reported = now
+				if err := app.send(message.NewInfo(message.Activity, "")); err != nil {
+					log.Printf("[ERR] Reporting activity: %v", err)
+				}
+			}
+
+			ev, ok := tracker.Check(now)
+			if !ok {
+				continue
+			}
+			if ev.Expired {
+				// Leaving as the host makes the signaling server terminate the session for both peers
+				log.Printf("[APP] %s. Leaving session", ev)
+				if err := app.send(message.NewSession(message.Leave, "", nil)); err != nil {
+					log.Printf("[ERR] Leaving session: %v", err)
+				}
+				return
+			}
+
+			log.Printf("[APP] %s", ev)
+			app.emit(SessionEvent{Type: Warning, Message: ev.String()})
+			if err := app.sendNotice(ev.String()); err != nil {
+				log.Printf("[ERR] Sending notice to remote: %v", err)
+			}
+		}
+	}
+}
+
+// Sends a warning to the remote over the datachannel
+func (app *App) sendNotice(text string) error {
+	if app.DataChannel == nil {
+		return nil
+	}
+	notice, err := json.Marshal(&types.Notice{Text: text})
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(&types.RemoteEvent{Type: "notice", Event: notice})
+	if err != nil {
+		return err
+	}
+	return app.DataChannel.Send(msg)
+}
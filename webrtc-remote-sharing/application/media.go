@@ -1,18 +1,120 @@
 package application
 
-import "github.com/remygo/display"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/remygo/display"
+	"github.com/remygo/display/backend"
+	"github.com/remygo/display/capture/source"
+	"github.com/remygo/pkg/config"
+)
+
+var (
+	errNoCapture  = errors.New("the screen cannot be captured")
+	errNoPlayback = errors.New("the video cannot be played")
+)
 
 type MediaComponents struct {
-	Playback *PlaybackComponent
-	Capture  *CaptureComponent
-	Done     chan struct{}
+	Playback     *PlaybackComponent
+	Capture      *CaptureComponent
+	AudioCapture backend.AudioCapturer // Nil unless the sound of the host is streamed
+	AudioPlayer  backend.AudioPlayer   // Nil without a display like the playback
+	VoiceCapture backend.AudioCapturer // Microphone of the voice chat
+	VoicePlayer  backend.AudioPlayer   // Voice of the other user
+	Done         chan struct{}
+}
+
+// Returns the current media components. Used by the setters of the frontend which run beside
+// the main loop that replaces the components
+func (app *App) media() *MediaComponents {
+	app.mediaMu.Lock()
+	defer app.mediaMu.Unlock()
+	return app.MediaComponents
+}
+
+// Returns the window of the current playback or nil without one. Used by the callbacks of the
+// session which run beside the main loop
+func (app *App) playbackUI() *display.UI {
+	media := app.media()
+	if media == nil || media.Playback == nil {
+		return nil
+	}
+	return media.Playback.UI
+}
+
+func (app *App) setMedia(media *MediaComponents) {
+	app.mediaMu.Lock()
+	app.MediaComponents = media
+	app.mediaMu.Unlock()
+}
+
+// Falls back to the defaults of the settings which name unknown backends or are out of range.
+// The other settings are kept since the config may be saved again
+func checkMedia(m *config.Media) {
+	if err := backend.Check(m.Capture, m.Decoder, m.Window); err != nil {
+		log.Printf("[WARN] Using the default media backends. %v", err)
+		m.Capture, m.Decoder, m.Window = "", "", ""
+	}
+	if err := backend.CheckAudio(m.Audio.Backend); err != nil {
+		log.Printf("[WARN] Using the default audio backend. %v", err)
+		m.Audio.Backend = ""
+	}
+	if err := source.Check(m.Source); err != nil {
+		log.Printf("[WARN] Detecting the screen source. %v", err)
+		m.Source = ""
+	}
+	if err := recovery(m.Recovery).Check(); err != nil {
+		log.Printf("[WARN] Using the default recovery of lost packets. %v", err)
+		m.Recovery = config.Recovery{}
+	}
 }
 
-func newMediaComponents() *MediaComponents {
-	return &MediaComponents{
-		getPlaybackComponent(),
-		getCaptureComponent(),
-		make(chan struct{}, 1),
+// Playback creates a window so it is left out if the application only hosts without a display.
+// A backend which cannot be created, e.g. without its GStreamer plugin or sound device, is left
+// out as well. The session goes on without it and the error is returned for a warning
+func newMediaComponents(backends config.Media, headless bool) (*MediaComponents, error) {
+	var errs []string
+	check := func(err error) {
+		if err != nil {
+			log.Printf("[ERR] %v", err)
+			errs = append(errs, err.Error())
+		}
+	}
+
+	media := &MediaComponents{Done: make(chan struct{}, 1)}
+	var err error
+	media.Capture, err = getCaptureComponent(backends)
+	check(err)
+	media.VoiceCapture, err = getAudioCapture(backends.Audio.Backend, backend.AudioOptions{Source: backends.Voice.Source, Microphone: true})
+	check(err)
+	media.VoicePlayer, err = getAudioPlayer(backends.Audio.Backend)
+	check(err)
+	if !headless {
+		media.Playback, err = getPlaybackComponent(backends)
+		check(err)
+		media.AudioPlayer, err = getAudioPlayer(backends.Audio.Backend)
+		check(err)
+	}
+	if backends.Audio.Enabled {
+		media.AudioCapture, err = getAudioCapture(backends.Audio.Backend, backend.AudioOptions{Source: backends.Audio.Source})
+		check(err)
+	}
+	if len(errs) > 0 {
+		return media, errors.New(strings.Join(errs, "; "))
+	}
+	return media, nil
+}
+
+// Replaces the media components with new ones and warns the user of the backends which are
+// missing from them
+func (app *App) resetMedia() {
+	media, err := newMediaComponents(app.Config.Media, app.Args.Headless)
+	app.setMedia(media)
+	if err != nil {
+		app.emit(SessionEvent{Type: Warning, Message: err.Error()})
 	}
 }
 
@@ -25,16 +127,39 @@ type CaptureComponent struct {
 	*display.Capture
 }
 
-func getPlaybackComponent() *PlaybackComponent {
-	playback, window := display.NewPlaybackProvider()
+func getPlaybackComponent(backends config.Media) (*PlaybackComponent, error) {
+	playback, window, err := display.NewPlaybackProvider(backends.Decoder, backends.Window)
+	if err != nil {
+		return nil, fmt.Errorf("creating playback: %w", err)
+	}
 	return &PlaybackComponent{
 		playback,
 		window,
-	}
+	}, nil
 }
 
-func getCaptureComponent() *CaptureComponent {
+func getCaptureComponent(backends config.Media) (*CaptureComponent, error) {
+	capture, err := display.NewCaptureProvider(backends.Capture, backend.CaptureOptions{Source: backends.Source})
+	if err != nil {
+		return nil, fmt.Errorf("creating capture: %w", err)
+	}
 	return &CaptureComponent{
-		display.NewCaptureProvider(),
+		capture,
+	}, nil
+}
+
+func getAudioCapture(name string, opts backend.AudioOptions) (backend.AudioCapturer, error) {
+	capture, err := backend.NewAudioCapturer(name, opts)
+	if err != nil {
+		return nil, fmt.Errorf("creating audio capture: %w", err)
+	}
+	return capture, nil
+}
+
+func getAudioPlayer(name string) (backend.AudioPlayer, error) {
+	player, err := backend.NewAudioPlayer(name)
+	if err != nil {
+		return nil, fmt.Errorf("creating audio playback: %w", err)
 	}
+	return player, nil
 }
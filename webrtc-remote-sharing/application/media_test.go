@@ -0,0 +1,32 @@
+package application
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/remygo/pkg/config"
+)
+
+func TestCheckMediaUnknownBackend(t *testing.T) {
+	m := config.Media{
+		Capture:    "vnc",
+		Decoder:    "null",
+		Window:     "null",
+		Source:     "test",
+		Codecs:     []string{"video/VP9"},
+		FrameRate:  25,
+		Quality:    "high",
+		MinBitrate: 300,
+		MaxBitrate: 4000,
+		Recovery:   config.Recovery{NACKBuffer: 512, NACKInterval: 50, RTX: true, FECGroup: 10},
+		Audio:      config.Audio{Enabled: true, Backend: "null", Source: "test", Muted: true},
+		Voice:      config.Voice{AlwaysOn: true, Volume: 80},
+	}
+	want := m
+	want.Capture, want.Decoder, want.Window = "", "", ""
+
+	checkMedia(&m)
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("checked media\n%+v\nwant\n%+v", m, want)
+	}
+}
@@ -0,0 +1,134 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/remygo/internal/types"
+	"github.com/remygo/pkg/message"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Profile granted when neither the host nor the config picked one
+const defaultProfile = types.ProfileControl
+
+// Returns the permissions for the user requesting to join based on the configured profiles
+func (app *App) resolvePermissions(msg *message.SessionMessage) types.Permission {
+	perm, err := types.ParseProfile(profileOrDefault(app.Config.Permissions.Profile(msg.UserID, msg.OrgID)))
+	if err != nil {
+		// Fail closed on a broken config rather than handing out control
+		log.Printf("[WARN] %v. Granting %s", err, types.ProfileViewOnly)
+		return 0
+	}
+	return perm
+}
+
+// Returns the profile granted to remotes which have no user or organization rule. A
+// configured profile which is unknown grants view only
+func (app *App) PermissionProfile() string {
+	name := profileOrDefault(app.Config.Permissions.Default)
+	if _, err := types.ParseProfile(name); err != nil {
+		return types.ProfileViewOnly
+	}
+	return name
+}
+
+func profileOrDefault(name string) string {
+	if name == "" {
+		return defaultProfile
+	}
+	return name
+}
+
+// Returns the permissions granted to the remote of the current session. Input arrives on the
+// datachannel beside the main loop which grants them
+func (app *App) granted() types.Permission {
+	return types.Permission(atomic.LoadInt32(&app.permissions))
+}
+
+func (app *App) grant(perm types.Permission) {
+	atomic.StoreInt32(&app.permissions, int32(perm))
+}
+
+// Sets the profile granted to remotes which have no user or organization rule
+func (app *App) SetPermissionProfile(name string) error {
+	if _, err := types.ParseProfile(name); err != nil {
+		return err
+	}
+	app.Config.Permissions.Default = name
+
+	if err := app.Config.Save(); err != nil {
+		return fmt.Errorf("saving config: %v", err)
+	}
+	return nil
+}
+
+// Tells the remote which profile it was granted so that it can be shown in the playback window
+func (app *App) sendPermissions(dc *webrtc.DataChannel) error {
+	grant, err := json.Marshal(&types.PermissionGrant{Profile: app.granted().String()})
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(&types.RemoteEvent{Type: "permissions", Event: grant})
+	if err != nil {
+		return err
+	}
+	return dc.Send(msg)
+}
+
+// Handles messages sent by the host on the remote datachannel
+func (app *App) handleHostEvent(payload []byte) {
+	event := types.RemoteEvent{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.Println("[ERR] Parsing host event:", err)
+		return
+	}
+
+	switch event.Type {
+	case "permissions":
+		grant := types.PermissionGrant{}
+		if err := json.Unmarshal(event.Event, &grant); err != nil {
+			log.Println("[ERR] Parsing permission grant:", err)
+			return
+		}
+		log.Printf("[APP] Host granted permission profile %s", grant.Profile)
+		// The remote keeps the grant to know whether it may share its clipboard
+		perm, err := types.ParseProfile(grant.Profile)
+		if err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+		app.grant(perm)
+		if ui := app.playbackUI(); ui != nil {
+			ui.ShowPermissions(grant.Profile)
+		}
+	case "notice":
+		notice := types.Notice{}
+		if err := json.Unmarshal(event.Event, &notice); err != nil {
+			log.Println("[ERR] Parsing host notice:", err)
+			return
+		}
+		log.Printf("[APP] Host notice: %s", notice.Text)
+		if ui := app.playbackUI(); ui != nil {
+			ui.ShowNotice(notice.Text)
+		}
+		app.emit(SessionEvent{Type: Warning, Message: notice.Text})
+	case "identity":
+		identity := types.Identity{}
+		if err := json.Unmarshal(event.Event, &identity); err != nil {
+			log.Println("[ERR] Parsing host identity:", err)
+			return
+		}
+		log.Printf("[PC] Host claims device id %s", identity.DeviceID)
+	case "stream":
+		app.handleStreamSettings(event.Event)
+	case "record":
+		app.handleRecording(event.Event)
+	case "clipboard":
+		app.handleClipboard(event.Event)
+	case "file":
+		app.handleFileResult(event.Event)
+	}
+}
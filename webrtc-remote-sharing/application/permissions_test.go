@@ -0,0 +1,64 @@
+package application
+
+import (
+	"testing"
+
+	"github.com/remygo/internal/types"
+	"github.com/remygo/pkg/message"
+)
+
+func TestPermissionProfile(t *testing.T) {
+	app, _ := newTestApp()
+	if profile := app.PermissionProfile(); profile != defaultProfile {
+		t.Fatalf("unconfigured default %s", profile)
+	}
+	app.Config.Permissions.Default = types.ProfileViewOnly
+	app.Config.Permissions.Users = map[string]string{"u1": types.ProfileControl}
+	if profile := app.PermissionProfile(); profile != types.ProfileViewOnly {
+		t.Fatalf("configured default %s", profile)
+	}
+	if perm := app.resolvePermissions(&message.SessionMessage{UserID: "u2"}); perm != 0 {
+		t.Fatalf("default grants %s", perm)
+	}
+	if perm := app.resolvePermissions(&message.SessionMessage{UserID: "u1"}); perm != types.PermControl {
+		t.Fatalf("user rule grants %s", perm)
+	}
+
+	// Unknown profiles e.g. of older configs grant view only
+	app.Config.Permissions.Default = "admin"
+	if profile := app.PermissionProfile(); profile != types.ProfileViewOnly {
+		t.Fatalf("unknown default shown as %s", profile)
+	}
+	if perm := app.resolvePermissions(&message.SessionMessage{UserID: "u2"}); perm != 0 {
+		t.Fatalf("unknown default grants %s", perm)
+	}
+}
+
+func TestShareClipboard(t *testing.T) {
+	app, _ := newTestApp()
+	clip := &types.RemoteEvent{Type: "clipboard", Event: []byte(`{"text":"copied"}`)}
+	mouse := &types.RemoteEvent{Type: "mouse", Event: []byte(`{}`)}
+
+	app.grant(types.PermControl)
+	if app.shareInput(clip) {
+		t.Fatal("clipboard shared without permission")
+	}
+	if !app.shareInput(mouse) {
+		t.Fatal("input dropped")
+	}
+
+	app.grant(types.PermControl | types.PermClipboard)
+	if !app.shareInput(clip) {
+		t.Fatal("clipboard not shared")
+	}
+	if app.shareInput(clip) {
+		t.Fatal("unchanged clipboard shared again")
+	}
+
+	// Text received from the host is not sent back
+	app.clipboard.reset()
+	app.clipboard.swap("copied")
+	if app.shareInput(clip) {
+		t.Fatal("received clipboard sent back")
+	}
+}
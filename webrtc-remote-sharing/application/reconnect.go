@@ -0,0 +1,69 @@
+package application
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/remygo/application/session"
+	"github.com/remygo/conn/wrtc"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Tries to bring a disconnected or failed connection back with ICE restarts until it is
+// connected again or the restart budget is used up, in which case the session is left.
+// Only the remote restarts since it is the offerer, the host answers the restart offers
+// and waits with the same budget. Does nothing if a recovery is already running
+func (app *App) reconnect(ctx context.Context, pc *wrtc.PeerConn, mode Mode) {
+	if !atomic.CompareAndSwapInt32(&app.reconnecting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&app.reconnecting, 0)
+
+	log.Println("[PC] Connection lost. Trying to reconnect")
+	if ui := app.playbackUI(); ui != nil && mode == Remote {
+		ui.ShowReconnecting(true)
+		defer ui.ShowReconnecting(false)
+	}
+
+	restarts := wrtc.NewRestarts()
+	for {
+		delay, ok := restarts.Next()
+		if !ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if pc.ConnectionState() == webrtc.PeerConnectionStateConnected {
+			log.Println("[PC] Connection recovered")
+			return
+		}
+		if mode == Remote {
+			// The restart offer is created by the main loop which owns the peer connection
+			select {
+			case app.iceRestart <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	log.Println("[PC] Restart budget used up. Leaving session")
+	app.leaveSession("Connection to the other peer was lost")
+}
+
+// Sends an offer with new ICE credentials to the host
+func (app *App) restartICE() error {
+	if app.session.State() != session.Connected || app.session.Role() != Remote || app.PeerConn == nil {
+		return nil
+	}
+	log.Println("[ICE] Restarting ICE")
+	app.trickle.Hold()
+	defer app.trickle.Release()
+	return app.negotiator.Restart()
+}
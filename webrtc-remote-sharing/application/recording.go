@@ -0,0 +1,407 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/remygo/application/session"
+	"github.com/remygo/display/backend"
+	"github.com/remygo/internal/types"
+	"github.com/remygo/pkg/record"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Directory next to the config the recordings are stored in unless the config names another
+const recordingsDir = "recordings"
+
+var (
+	errNotConnected       = errors.New("not in a session")
+	errRecording          = errors.New("already recording or waiting for consent")
+	errNoRecordingRequest = errors.New("no recording request to answer")
+	errNoSampleTap        = errors.New("the capture cannot be recorded")
+)
+
+// Recording of the current session and the consent exchanged with the other user. The host
+// records the encoded samples of its capture, the remote the frames of the jitter buffers
+type recordingState struct {
+	mu        sync.Mutex
+	recorder  *record.Recorder
+	path      string
+	session   string // Session the recording is linked to when it is uploaded
+	codec     string // Video received by the remote
+	keyFrame  func() // Asks the host for a key frame which the recording of the remote starts with
+	audio     bool   // The remote receives the sound of the host
+	requested bool   // Waiting for the other user to consent
+	asked     bool   // The other user waits for an answer to its request
+}
+
+// Asks the other user for consent to record the session. The recording starts once the other
+// user accepted and the other user is shown that the session is recorded until it stops
+func (app *App) StartRecording() error {
+	if app.session.State() != session.Connected {
+		return errNotConnected
+	}
+	r := &app.recording
+	r.mu.Lock()
+	if r.recorder != nil || r.requested {
+		r.mu.Unlock()
+		return errRecording
+	}
+	r.requested = true
+	r.mu.Unlock()
+
+	log.Println("[REC] Asking the other user for consent to record the session")
+	if err := app.sendRecording(types.RecordingRequest); err != nil {
+		r.mu.Lock()
+		r.requested = false
+		r.mu.Unlock()
+		return fmt.Errorf("asking for consent: %w", err)
+	}
+	return nil
+}
+
+// Stops the recording or withdraws the request to record
+func (app *App) StopRecording() error {
+	r := &app.recording
+	r.mu.Lock()
+	withdrawn := r.requested && r.recorder == nil
+	r.mu.Unlock()
+
+	path, err := app.closeRecorder()
+	if withdrawn {
+		log.Println("[REC] Withdrawing the request to record")
+		app.emit(SessionEvent{Type: Recording})
+		return app.sendRecording(types.RecordingStopped)
+	}
+	if err != nil {
+		err = fmt.Errorf("closing recording %s: %w", path, err)
+	}
+	app.finishRecording(path)
+	return err
+}
+
+// Answers the request of the other user to record the session
+func (app *App) AnswerRecording(allow bool) error {
+	r := &app.recording
+	r.mu.Lock()
+	asked := r.asked
+	r.asked = false
+	r.mu.Unlock()
+	if !asked {
+		return errNoRecordingRequest
+	}
+
+	action := types.RecordingDecline
+	if allow {
+		action = types.RecordingAccept
+	}
+	log.Printf("[REC] Answering the request to record with %s", action)
+	return app.sendRecording(action)
+}
+
+// Handles the recording messages of the other user
+func (app *App) handleRecording(payload json.RawMessage) {
+	rec := types.Recording{}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		log.Println("[ERR] Parsing recording message:", err)
+		return
+	}
+	r := &app.recording
+
+	switch rec.Action {
+	case types.RecordingRequest:
+		// Remotes which may only watch cannot have the host asked
+		if perm := app.granted(); app.session.Role() == Host && !perm.Allows("record") {
+			log.Printf("[REC] Declining the recording. Not allowed by permission profile %s", perm)
+			if err := app.sendRecording(types.RecordingDecline); err != nil {
+				log.Printf("[ERR] Declining the recording: %v", err)
+			}
+			return
+		}
+		// Nobody may be in front of an unattended host to answer
+		if app.Config.Recording.AutoConsent {
+			log.Println("[REC] The other user records the session. Consented by the config")
+			if err := app.sendRecording(types.RecordingAccept); err != nil {
+				log.Printf("[ERR] Consenting to the recording: %v", err)
+			}
+			return
+		}
+		log.Println("[REC] The other user asks for consent to record the session")
+		r.mu.Lock()
+		r.asked = true
+		r.mu.Unlock()
+		app.emit(SessionEvent{Type: RecordingRequested})
+	case types.RecordingAccept:
+		r.mu.Lock()
+		requested := r.requested
+		r.requested = false
+		r.mu.Unlock()
+		if !requested {
+			return
+		}
+		path, err := app.startRecorder()
+		if err != nil {
+			log.Printf("[ERR] Starting recording: %v", err)
+			app.emit(SessionEvent{Type: Warning, Message: "The session could not be recorded"})
+			app.emit(SessionEvent{Type: Recording})
+			return
+		}
+		if err := app.sendRecording(types.RecordingStarted); err != nil {
+			log.Printf("[ERR] Telling the other user about the recording: %v", err)
+		}
+		app.emit(SessionEvent{Type: Recording, Recording: true, Message: path})
+	case types.RecordingDecline:
+		r.mu.Lock()
+		r.requested = false
+		r.mu.Unlock()
+		log.Println("[REC] The other user declined the recording")
+		app.emit(SessionEvent{Type: Warning, Message: "The other user declined the recording"})
+		app.emit(SessionEvent{Type: Recording})
+	case types.RecordingStarted:
+		log.Println("[REC] The other user started recording the session")
+		app.showPeerRecording(true)
+	case types.RecordingStopped:
+		// Withdraws a request which was not answered yet too
+		r.mu.Lock()
+		r.asked = false
+		r.mu.Unlock()
+		log.Println("[REC] The other user stopped recording the session")
+		app.showPeerRecording(false)
+	}
+}
+
+// Shows whether the other user records the session
+func (app *App) showPeerRecording(recording bool) {
+	if ui := app.playbackUI(); ui != nil && app.session.Role() == Remote {
+		ui.ShowRecording(recording)
+	}
+	app.emit(SessionEvent{Type: PeerRecording, Recording: recording})
+}
+
+// Creates the file of the recording and starts passing the video and sound to it. The host
+// taps the samples of its capture which carry no presentation time, so they are timed by
+// their arrival
+func (app *App) startRecorder() (string, error) {
+	r := &app.recording
+	opts := record.Options{Width: app.Resolution.Width, Height: app.Resolution.Height}
+	if settings := app.stream.get(); settings.Width > 0 {
+		opts.Width, opts.Height = settings.Width, settings.Height
+	}
+
+	var videoTap, audioTap backend.SampleTap
+	sessionID := app.SessionToken
+	switch app.session.Role() {
+	case Host:
+		media := app.media()
+		if app.HostTrack == nil || media == nil {
+			return "", errNotConnected
+		}
+		var ok bool
+		if videoTap, ok = media.Capture.Provider.(backend.SampleTap); !ok {
+			return "", errNoSampleTap
+		}
+		opts.Codec = app.HostTrack.Codec().MimeType
+		if app.audioTrack != nil && media.AudioCapture != nil {
+			audioTap, opts.Audio = media.AudioCapture.(backend.SampleTap)
+		}
+	case Remote:
+		r.mu.Lock()
+		opts.Codec, opts.Audio = r.codec, r.audio
+		r.mu.Unlock()
+		sessionID = app.joinToken
+	}
+
+	dir := app.Config.Recording.Dir
+	if dir == "" {
+		dir = filepath.Join(app.Config.Dir(), recordingsDir)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "session-"+time.Now().Format("20060102-150405")+record.Extension(opts.Codec))
+	rec, err := record.Create(path, opts)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	r.recorder, r.path, r.session = rec, path, sessionID
+	r.mu.Unlock()
+
+	start := time.Now()
+	if videoTap != nil {
+		videoTap.OnSample(func(sample []byte) { app.recordVideo(sample, time.Since(start)) })
+	}
+	if audioTap != nil {
+		audioTap.OnSample(func(sample []byte) { app.recordAudio(sample, time.Since(start)) })
+	}
+	app.recordingKeyFrame()
+	log.Printf("[REC] Recording the session to %s", path)
+	return path, nil
+}
+
+// The recorder drops the video until a key frame arrives, so one is asked for instead of
+// waiting for the next one of the encoder
+func (app *App) recordingKeyFrame() {
+	switch app.session.Role() {
+	case Host:
+		if media := app.media(); media != nil {
+			if err := media.Capture.RequestKeyFrame(); err != nil {
+				log.Printf("[WARN] Forcing key frame for the recording: %v", err)
+			}
+		}
+	case Remote:
+		app.recording.mu.Lock()
+		keyFrame := app.recording.keyFrame
+		app.recording.mu.Unlock()
+		if keyFrame != nil {
+			keyFrame()
+		}
+	}
+}
+
+// Stops the recording if one runs and returns its file. A request waiting for consent is
+// dropped
+func (app *App) closeRecorder() (string, error) {
+	r := &app.recording
+	r.mu.Lock()
+	rec, path := r.recorder, r.path
+	r.recorder, r.path, r.requested = nil, "", false
+	r.mu.Unlock()
+
+	if rec == nil {
+		return "", nil
+	}
+	if media := app.media(); media != nil && app.session.Role() == Host {
+		if tap, ok := media.Capture.Provider.(backend.SampleTap); ok {
+			tap.OnSample(nil)
+		}
+		if tap, ok := media.AudioCapture.(backend.SampleTap); ok {
+			tap.OnSample(nil)
+		}
+	}
+	duration := rec.Duration()
+	if err := rec.Close(); err != nil {
+		return path, err
+	}
+	log.Printf("[REC] Recorded %s of the session to %s", duration.Round(time.Second), path)
+	return path, nil
+}
+
+// Tells the other user and the frontend that the recording stopped and uploads it
+func (app *App) finishRecording(path string) {
+	if path == "" {
+		return
+	}
+	if err := app.sendRecording(types.RecordingStopped); err != nil {
+		log.Printf("[ERR] Telling the other user about the recording: %v", err)
+	}
+	app.emit(SessionEvent{Type: Recording, Message: path})
+	app.uploadRecording(path)
+}
+
+// Uploads the recording into the blob provider if one is configured
+func (app *App) uploadRecording(path string) {
+	app.recording.mu.Lock()
+	sessionID := app.recording.session
+	app.recording.mu.Unlock()
+	app.uploadFile(path, sessionID)
+}
+
+// Stops the recording when the session ends. The other user and the frontend learn that the
+// session ended instead
+func (app *App) endRecording() {
+	r := &app.recording
+	r.mu.Lock()
+	r.requested = false
+	r.mu.Unlock()
+	path, err := app.closeRecorder()
+	if err != nil {
+		log.Printf("[ERR] Closing recording: %v", err)
+	}
+	if path != "" {
+		app.uploadRecording(path)
+	}
+	r.mu.Lock()
+	r.asked, r.codec, r.keyFrame, r.audio, r.session = false, "", nil, false, ""
+	r.mu.Unlock()
+}
+
+// Remembers the video the remote receives for recordings and how to ask for its key frames
+func (app *App) setRecordedVideo(mimeType string, keyFrame func()) {
+	app.recording.mu.Lock()
+	app.recording.codec, app.recording.keyFrame = mimeType, keyFrame
+	app.recording.mu.Unlock()
+}
+
+// Remembers that the remote receives the sound of the host for recordings
+func (app *App) setRecordedAudio() {
+	app.recording.mu.Lock()
+	app.recording.audio = true
+	app.recording.mu.Unlock()
+}
+
+// Passes a video frame to the recording if one runs
+func (app *App) recordVideo(frame []byte, pts time.Duration) {
+	app.recording.mu.Lock()
+	rec := app.recording.recorder
+	app.recording.mu.Unlock()
+	if rec == nil {
+		return
+	}
+	if err := rec.WriteVideo(frame, pts); err != nil {
+		app.recordingFailed(err)
+	}
+}
+
+// Passes an Opus frame of the sound of the host to the recording if one runs
+func (app *App) recordAudio(frame []byte, pts time.Duration) {
+	app.recording.mu.Lock()
+	rec := app.recording.recorder
+	app.recording.mu.Unlock()
+	if rec == nil {
+		return
+	}
+	if err := rec.WriteAudio(frame, pts); err != nil {
+		app.recordingFailed(err)
+	}
+}
+
+// Stops a recording which cannot be written any longer e.g. since the disk is full. Frames
+// written concurrently fail too, only the first one stops the recording
+func (app *App) recordingFailed(err error) {
+	path, closeErr := app.closeRecorder()
+	if path == "" {
+		return
+	}
+	log.Printf("[ERR] Writing recording %s: %v", path, err)
+	if closeErr != nil {
+		log.Printf("[ERR] Closing recording %s: %v", path, closeErr)
+	}
+	app.emit(SessionEvent{Type: Warning, Message: "The recording stopped since it could not be written"})
+	app.finishRecording(path)
+}
+
+// Sends a recording message to the other user over the datachannel
+func (app *App) sendRecording(action string) error {
+	dc := app.DataChannel
+	if dc == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return errNotConnected
+	}
+	data, err := json.Marshal(&types.Recording{Action: action})
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(&types.RemoteEvent{Type: "record", Event: data})
+	if err != nil {
+		return err
+	}
+	return dc.Send(msg)
+}
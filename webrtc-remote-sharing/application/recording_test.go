@@ -0,0 +1,218 @@
+package application
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/remygo/application/session"
+	"github.com/remygo/internal/types"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Connects a data channel of the app to another peer and returns the recording actions the
+// other peer receives
+func connectRecording(t *testing.T, app *App) <-chan string {
+	local, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		local.Close()
+		peer.Close()
+	})
+
+	actions := make(chan string, 8)
+	peer.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			ev, rec := types.RemoteEvent{}, types.Recording{}
+			if json.Unmarshal(msg.Data, &ev) == nil && ev.Type == "record" && json.Unmarshal(ev.Event, &rec) == nil {
+				actions <- rec.Action
+			}
+		})
+	})
+	dc, err := local.CreateDataChannel("control", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan struct{})
+	dc.OnOpen(func() { close(opened) })
+
+	// Candidates are gathered up front since the trickle exchange is not under test
+	offer, err := local.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(local)
+	if err := local.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	if err := peer.SetRemoteDescription(*local.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := peer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered = webrtc.GatheringCompletePromise(peer)
+	if err := peer.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	if err := local.SetRemoteDescription(*peer.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatal("data channel did not open")
+	}
+	app.DataChannel = dc
+	return actions
+}
+
+func nextAction(t *testing.T, actions <-chan string) string {
+	select {
+	case action := <-actions:
+		return action
+	case <-time.After(5 * time.Second):
+		t.Fatal("no recording message")
+		return ""
+	}
+}
+
+// Returns a remote which is connected to a host and records into a temporary directory
+func newRecordingApp(t *testing.T) (*App, <-chan string, chan struct{}) {
+	app, _ := newTestApp()
+	app.Resolution = Resolution{1280, 720}
+	app.Config.Recording.Dir = t.TempDir()
+	register(t, app)
+	for _, ev := range []session.Event{session.Join, session.Approve, session.Connect} {
+		if _, err := app.session.Fire(ev); err != nil {
+			t.Fatal(err)
+		}
+		nextEvent(t, app)
+	}
+
+	keyFrames := make(chan struct{}, 1)
+	app.setRecordedVideo(webrtc.MimeTypeVP8, func() { keyFrames <- struct{}{} })
+	return app, connectRecording(t, app), keyFrames
+}
+
+func TestRecordingConsent(t *testing.T) {
+	app, actions, keyFrames := newRecordingApp(t)
+
+	if err := app.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if action := nextAction(t, actions); action != types.RecordingRequest {
+		t.Fatalf("sent %s, want request", action)
+	}
+	if err := app.StartRecording(); err != errRecording {
+		t.Fatalf("second request: %v", err)
+	}
+
+	// Nothing is recorded before the other user consented
+	app.handleRecording(json.RawMessage(`{"action":"accept"}`))
+	if action := nextAction(t, actions); action != types.RecordingStarted {
+		t.Fatalf("sent %s, want started", action)
+	}
+	ev := nextEvent(t, app)
+	if ev.Type != Recording || !ev.Recording || ev.Message == "" {
+		t.Fatalf("event %+v", ev)
+	}
+	select {
+	case <-keyFrames:
+	default:
+		t.Fatal("recording started without asking for a key frame")
+	}
+
+	if err := app.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	if action := nextAction(t, actions); action != types.RecordingStopped {
+		t.Fatalf("sent %s, want stopped", action)
+	}
+	if stopped := nextEvent(t, app); stopped.Type != Recording || stopped.Recording || stopped.Message != ev.Message {
+		t.Fatalf("event %+v", stopped)
+	}
+	if _, err := os.Stat(ev.Message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordingDeclined(t *testing.T) {
+	app, actions, keyFrames := newRecordingApp(t)
+
+	if err := app.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+	nextAction(t, actions)
+	app.handleRecording(json.RawMessage(`{"action":"decline"}`))
+	if ev := nextEvent(t, app); ev.Type != Warning {
+		t.Fatalf("event %+v", ev)
+	}
+	if ev := nextEvent(t, app); ev.Type != Recording || ev.Recording {
+		t.Fatalf("event %+v", ev)
+	}
+
+	// A late consent starts nothing
+	app.handleRecording(json.RawMessage(`{"action":"accept"}`))
+	select {
+	case <-keyFrames:
+		t.Fatal("recording started after it was declined")
+	case action := <-actions:
+		t.Fatalf("sent %s after the decline", action)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRecordingRequested(t *testing.T) {
+	app, actions, _ := newRecordingApp(t)
+
+	if err := app.AnswerRecording(true); err != errNoRecordingRequest {
+		t.Fatalf("answer without request: %v", err)
+	}
+	app.handleRecording(json.RawMessage(`{"action":"request"}`))
+	if ev := nextEvent(t, app); ev.Type != RecordingRequested {
+		t.Fatalf("event %+v", ev)
+	}
+	if err := app.AnswerRecording(false); err != nil {
+		t.Fatal(err)
+	}
+	if action := nextAction(t, actions); action != types.RecordingDecline {
+		t.Fatalf("sent %s, want decline", action)
+	}
+}
+
+func TestRecordingRequestNotPermitted(t *testing.T) {
+	app, _ := newTestApp()
+	register(t, app)
+	for _, ev := range []session.Event{session.Accept, session.Connect} {
+		if _, err := app.session.Fire(ev); err != nil {
+			t.Fatal(err)
+		}
+		nextEvent(t, app)
+	}
+	actions := connectRecording(t, app)
+
+	// A remote which may only watch is declined without asking the host user
+	app.grant(0)
+	app.handleRecording(json.RawMessage(`{"action":"request"}`))
+	if action := nextAction(t, actions); action != types.RecordingDecline {
+		t.Fatalf("sent %s, want decline", action)
+	}
+
+	app.grant(types.PermControl)
+	app.handleRecording(json.RawMessage(`{"action":"request"}`))
+	if ev := nextEvent(t, app); ev.Type != RecordingRequested {
+		t.Fatalf("event %+v", ev)
+	}
+}
@@ -0,0 +1,228 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+)
+
+// State of the client in the session flow
+type State uint8
+
+const (
+	Unregistered     State = iota // Not registered with the signaling server yet
+	Registering                   // Waiting for the session token
+	Registered                    // Holding a token. Can host or join a session
+	AwaitingApproval              // Sent a join request and waiting for the host to answer
+	Negotiating                   // Join accepted. Exchanging descriptions and candidates
+	Connected                     // Peer connection established
+	TearingDown                   // Session ended. Media and the peer connection are being closed
+	Renewing                      // Waiting for the new token after a session
+)
+
+func (s State) String() string {
+	switch s {
+	case Unregistered:
+		return "Unregistered"
+	case Registering:
+		return "Registering"
+	case Registered:
+		return "Registered"
+	case AwaitingApproval:
+		return "Awaiting Approval"
+	case Negotiating:
+		return "Negotiating"
+	case Connected:
+		return "Connected"
+	case TearingDown:
+		return "Tearing Down"
+	case Renewing:
+		return "Renewing"
+	default:
+		return "Unsupported"
+	}
+}
+
+// Inputs of the state machine
+type Event uint8
+
+const (
+	Register  Event = iota // Register message sent
+	Token                  // Session token received
+	Join                   // Join request sent to a host
+	Deny                   // Join request denied by the host or the signaling server
+	Approve                // Join request approved by the host
+	Accept                 // Join request of a remote accepted as the host
+	Connect                // Peer connection established
+	Terminate              // Session ended by either peer, the signaling server or the user
+	Reset                  // Media and the peer connection closed
+	Renew                  // New session token received after a session
+)
+
+func (e Event) String() string {
+	switch e {
+	case Register:
+		return "Register"
+	case Token:
+		return "Token"
+	case Join:
+		return "Join"
+	case Deny:
+		return "Deny"
+	case Approve:
+		return "Approve"
+	case Accept:
+		return "Accept"
+	case Connect:
+		return "Connect"
+	case Terminate:
+		return "Terminate"
+	case Reset:
+		return "Reset"
+	case Renew:
+		return "Renew"
+	default:
+		return "Unsupported"
+	}
+}
+
+// Role of the client in the current session
+type Role uint8
+
+const (
+	None Role = iota
+	Host
+	Remote
+)
+
+func (r Role) String() string {
+	switch r {
+	case None:
+		return "None"
+	case Host:
+		return "Host"
+	case Remote:
+		return "Remote"
+	default:
+		return "Unsupported"
+	}
+}
+
+type edge struct {
+	from  State
+	event Event
+}
+
+// Valid transitions. Any input not listed here is rejected
+var transitions = map[edge]State{
+	{Unregistered, Register}:    Registering,
+	{Registered, Register}:      Registering, // Registering again e.g. to switch to unattended access
+	{Registering, Token}:        Registered,
+	{Registered, Join}:          AwaitingApproval,
+	{AwaitingApproval, Deny}:    Registered,
+	{AwaitingApproval, Approve}: Negotiating,
+	{Registered, Accept}:        Negotiating,
+	{Negotiating, Connect}:      Connected,
+	{Negotiating, Terminate}:    TearingDown,
+	{Connected, Terminate}:      TearingDown,
+	{TearingDown, Reset}:        Renewing,
+	{Renewing, Renew}:           Registered,
+}
+
+// Events which determine the role of the client in the session
+var roles = map[Event]Role{
+	Join:   Remote,
+	Accept: Host,
+}
+
+// Passed to subscribers on every state change
+type Transition struct {
+	From, To State
+	Event    Event
+	Role     Role // Role in the session. Still set on the transition which ends it
+}
+
+func (t Transition) String() string {
+	return fmt.Sprintf("%s -(%s)-> %s", t.From, t.Event, t.To)
+}
+
+// Returned for inputs which are not valid in the current state
+type ErrInvalidTransition struct {
+	State State
+	Event Event
+}
+
+func (e *ErrInvalidTransition) Error() string {
+	return fmt.Sprintf("invalid event %s in state %s", e.Event, e.State)
+}
+
+// Table driven state machine of the session flow. It is safe for concurrent use since
+// inputs come from the websocket loop as well as from peer connection callbacks
+type Machine struct {
+	state       State
+	role        Role
+	subscribers []func(Transition)
+	mu          sync.Mutex
+}
+
+func New() *Machine {
+	return &Machine{}
+}
+
+// Registers a function which is called after every transition. Subscribers are called
+// in order without holding the lock, so they may query the machine but should not block
+func (m *Machine) Subscribe(f func(Transition)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subscribers = append(m.subscribers, f)
+}
+
+// Applies the event to the current state. The state is left unchanged if the transition is invalid
+func (m *Machine) Fire(ev Event) (Transition, error) {
+	m.mu.Lock()
+	next, ok := transitions[edge{m.state, ev}]
+	if !ok {
+		state := m.state
+		m.mu.Unlock()
+		return Transition{}, &ErrInvalidTransition{State: state, Event: ev}
+	}
+
+	t := Transition{From: m.state, To: next, Event: ev, Role: m.role}
+	if role, ok := roles[ev]; ok {
+		m.role, t.Role = role, role
+	}
+	if next == Registered {
+		m.role = None
+	}
+	m.state = next
+	subscribers := m.subscribers
+	m.mu.Unlock()
+
+	for _, f := range subscribers {
+		f(t)
+	}
+	return t, nil
+}
+
+// Reports whether the event is valid in the current state without applying it
+func (m *Machine) Can(ev Event) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := transitions[edge{m.state, ev}]
+	return ok
+}
+
+func (m *Machine) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+func (m *Machine) Role() Role {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.role
+}
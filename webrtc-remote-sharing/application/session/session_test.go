@@ -0,0 +1,99 @@
+package session
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMachine(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		state  State
+		role   Role
+	}{
+		{"register", []Event{Register, Token}, Registered, None},
+		{"host session", []Event{Register, Token, Accept, Connect}, Connected, Host},
+		{"remote session", []Event{Register, Token, Join, Approve, Connect}, Connected, Remote},
+		{"denied join", []Event{Register, Token, Join, Deny}, Registered, None},
+		{"renew after session", []Event{Register, Token, Accept, Connect, Terminate, Reset, Renew}, Registered, None},
+		{"terminated while negotiating", []Event{Register, Token, Join, Approve, Terminate, Reset}, Renewing, Remote},
+		{"register again", []Event{Register, Token, Register, Token}, Registered, None},
+	}
+	for _, tt := range tests {
+		m := New()
+		for _, ev := range tt.events {
+			if _, err := m.Fire(ev); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		if m.State() != tt.state || m.Role() != tt.role {
+			t.Errorf("%s: got %s as %s, want %s as %s", tt.name, m.State(), m.Role(), tt.state, tt.role)
+		}
+	}
+}
+
+func TestInvalidTransition(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		bad    Event
+	}{
+		{"token without register", nil, Token},
+		{"approve without join", []Event{Register, Token}, Approve},
+		{"accept while joining", []Event{Register, Token, Join}, Accept},
+		{"join during session", []Event{Register, Token, Accept, Connect}, Join},
+		{"terminate twice", []Event{Register, Token, Accept, Connect, Terminate}, Terminate},
+		{"renew while tearing down", []Event{Register, Token, Accept, Connect, Terminate}, Renew},
+	}
+	for _, tt := range tests {
+		m := New()
+		for _, ev := range tt.events {
+			if _, err := m.Fire(ev); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		before := m.State()
+
+		_, err := m.Fire(tt.bad)
+		var invalid *ErrInvalidTransition
+		if !errors.As(err, &invalid) {
+			t.Errorf("%s: Fire(%s) = %v, want ErrInvalidTransition", tt.name, tt.bad, err)
+		}
+		if m.State() != before {
+			t.Errorf("%s: state changed to %s on invalid event", tt.name, m.State())
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	m := New()
+
+	var got []Transition
+	m.Subscribe(func(tr Transition) {
+		// Subscribers may query the machine
+		if m.State() != tr.To {
+			t.Errorf("state %s in subscriber, want %s", m.State(), tr.To)
+		}
+		got = append(got, tr)
+	})
+
+	m.Fire(Register)
+	m.Fire(Token)
+	m.Fire(Approve)
+	m.Fire(Accept)
+
+	want := []Transition{
+		{From: Unregistered, To: Registering, Event: Register},
+		{From: Registering, To: Registered, Event: Token},
+		{From: Registered, To: Negotiating, Event: Accept, Role: Host},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d transitions, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("transition %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/remygo/conn/wrtc"
+	"github.com/remygo/display/backend"
+	"github.com/remygo/internal/types"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Delay before a new size of the window is requested from the host. Dragging the border of
+// the window produces many sizes of which only the last one matters
+const resizeDelay = 500 * time.Millisecond
+
+// Settings of the video of the current session. On the host these are the settings the
+// capture runs with, on the remote the ones the host announced last
+type streamState struct {
+	mu            sync.Mutex
+	settings      types.StreamSettings
+	width, height int         // Size of the window of the remote
+	resize        *time.Timer // Sends the request once the window stopped changing
+}
+
+func (s *streamState) get() types.StreamSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settings
+}
+
+func (s *streamState) set(settings types.StreamSettings) {
+	s.mu.Lock()
+	s.settings = settings
+	s.mu.Unlock()
+}
+
+// Forgets the settings and the window of the session
+func (s *streamState) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resize != nil {
+		s.resize.Stop()
+	}
+	s.settings, s.width, s.height, s.resize = types.StreamSettings{}, 0, 0, nil
+}
+
+// How often the host applies the estimated bandwidth to the capture at most. The estimate
+// changes with every feedback of the remote
+const adaptInterval = time.Second
+
+// Runs for the duration of a hosted session. Follows the bandwidth estimated for the peer
+// connection with the bitrate and frame rate of the capture within the configured bounds
+func (app *App) adaptToBandwidth(ctx context.Context, pc *wrtc.PeerConn, capture *CaptureComponent) {
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+
+	var (
+		applied            types.StreamSettings // Reconfiguring resets the bitrate and frame rate
+		bitrate, frameRate int
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			estimate := pc.TargetBitrate()
+			settings := app.stream.get()
+			// Nothing is estimated without a congestion controller or captured before the offer
+			if estimate == 0 || settings.FrameRate == 0 {
+				continue
+			}
+			b, f := backend.Adapt(settings, estimate,
+				app.Config.Media.MinBitrate*1000, app.Config.Media.MaxBitrate*1000)
+			if settings == applied && b == bitrate && f == frameRate {
+				continue
+			}
+			if err := capture.Adapt(b, f); err != nil {
+				log.Printf("[WARN] Adapting capture: %v", err)
+				continue
+			}
+			if settings != applied || f != frameRate {
+				log.Printf("[APP] Estimated %d kbit/s. Capturing with %d kbit/s at %d fps", estimate/1000, b/1000, f)
+			}
+			applied, bitrate, frameRate = settings, b, f
+		}
+	}
+}
+
+// Returns and records the settings the host captures with before the remote asked for any
+func (app *App) initialStream() types.StreamSettings {
+	settings := backend.Fit(types.StreamSettings{}, app.Resolution.Width, app.Resolution.Height)
+	app.stream.set(settings)
+	return settings
+}
+
+// Applies the settings the remote asked for as far as the screen of the host allows and
+// tells the remote which settings are used
+func (app *App) handleStreamRequest(dc *webrtc.DataChannel, payload json.RawMessage) {
+	req := types.StreamSettings{}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		log.Println("[ERR] Parsing stream request:", err)
+		return
+	}
+	settings := backend.Fit(req, app.Resolution.Width, app.Resolution.Height)
+	log.Printf("[APP] Remote asked for %+v. Using %+v", req, settings)
+
+	media := app.media()
+	if media == nil || media.Capture == nil {
+		return
+	}
+	if err := media.Capture.Reconfigure(settings); err != nil {
+		log.Printf("[ERR] Reconfiguring capture: %v", err)
+		settings = app.stream.get()
+	} else {
+		app.stream.set(settings)
+	}
+	if err := sendStreamSettings(dc, settings); err != nil {
+		log.Printf("[ERR] Sending stream settings: %v", err)
+	}
+}
+
+// Follows the settings announced by the host with the size of the decoded frames so that
+// the video is not scaled twice
+func (app *App) handleStreamSettings(payload json.RawMessage) {
+	settings := types.StreamSettings{}
+	if err := json.Unmarshal(payload, &settings); err != nil {
+		log.Println("[ERR] Parsing stream settings:", err)
+		return
+	}
+	log.Printf("[APP] Host streams %+v", settings)
+	app.stream.set(settings)
+
+	// The playback picks up the settings when it starts if the track has not arrived yet
+	media := app.media()
+	if media == nil || media.Playback == nil {
+		return
+	}
+	if err := media.Playback.Resize(settings.Width, settings.Height); err != nil {
+		log.Printf("[WARN] Resizing playback: %v", err)
+	}
+}
+
+// Records the new size of the window and asks the host for it once the size settled
+func (app *App) windowResized(width, height int) {
+	app.stream.mu.Lock()
+	defer app.stream.mu.Unlock()
+	app.stream.width, app.stream.height = width, height
+	if app.stream.resize != nil {
+		app.stream.resize.Stop()
+	}
+	app.stream.resize = time.AfterFunc(resizeDelay, app.requestStream)
+}
+
+// Asks the host for video of the size of the window with the configured frame rate and
+// quality. Before the window reported its size the host picks the size of its screen
+func (app *App) requestStream() {
+	dc := app.DataChannel
+	if dc == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+	app.stream.mu.Lock()
+	req := types.StreamSettings{
+		Width:     app.stream.width,
+		Height:    app.stream.height,
+		FrameRate: app.Config.Media.FrameRate,
+		Quality:   app.Config.Media.Quality,
+	}
+	app.stream.mu.Unlock()
+
+	if err := sendStreamSettings(dc, req); err != nil {
+		log.Printf("[ERR] Sending stream request: %v", err)
+	}
+}
+
+// Sends a request of the remote or the settings applied by the host over the datachannel
+func sendStreamSettings(dc *webrtc.DataChannel, settings types.StreamSettings) error {
+	data, err := json.Marshal(&settings)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(&types.RemoteEvent{Type: "stream", Event: data})
+	if err != nil {
+		return err
+	}
+	return dc.Send(msg)
+}
@@ -0,0 +1,426 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/remygo/application/session"
+	"github.com/remygo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// Directory next to the config the received files are stored in
+	transfersDir = "transfers"
+
+	// MiB the host accepts per file unless configured otherwise
+	defaultMaxTransfer = 100
+
+	// Files the host receives at the same time
+	maxTransfers = 4
+
+	// Files the remote queues for sending
+	transferQueue = 16
+
+	// Bytes of a file sent in one message. Encoded they stay below the size which every
+	// datachannel implementation accepts
+	fileChunkSize = 16 << 10
+
+	// Bytes the remote lets the datachannel buffer before it waits for the buffer to drain
+	fileBufferHigh = 1 << 20
+	fileBufferLow  = 256 << 10
+)
+
+var (
+	errTransferNotStarted = errors.New("file transfer was not started")
+	errTransferOffset     = errors.New("file transfer is out of order")
+	errTransferSize       = errors.New("file does not match its size")
+	errTransferBusy       = errors.New("too many files at once")
+	errTransferQueue      = errors.New("too many files queued")
+	errTransferName       = errors.New("invalid file name")
+	errTransferNotRemote  = errors.New("only the remote sends files")
+	errTransferSkipped    = errors.New("file transfer failed before")
+)
+
+// Files sent to the host in the current session
+type transferState struct {
+	mu       sync.Mutex
+	received map[string]*receivedFile // Files the host is receiving by id
+	failed   map[string]bool          // Files the host gave up on. Their remaining parts are dropped
+	queue    chan string              // Paths of the files the remote sends in this order
+	sending  string                   // Id of the file the remote is sending
+	cancel   chan struct{}            // Closed if the host gave up on the file being sent
+}
+
+type receivedFile struct {
+	file          *os.File
+	path          string
+	size, written int64
+}
+
+// Returns the queue of the files to send
+func (t *transferState) files() chan string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.queue == nil {
+		t.queue = make(chan string, transferQueue)
+	}
+	return t.queue
+}
+
+// Writes the part of a file into the directory and returns the path of the file once it is
+// complete. The file is removed if any part of it is invalid
+func (t *transferState) receive(dir string, maxSize int64, chunk *types.FileChunk) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failed[chunk.ID] {
+		return "", errTransferSkipped
+	}
+
+	f := t.received[chunk.ID]
+	if f == nil {
+		if chunk.Offset != 0 || chunk.Name == "" {
+			return "", t.fail(chunk.ID, errTransferNotStarted)
+		}
+		if len(t.received) >= maxTransfers {
+			return "", t.fail(chunk.ID, errTransferBusy)
+		}
+		if chunk.Size < 0 || chunk.Size > maxSize {
+			return "", t.fail(chunk.ID, fmt.Errorf("file is larger than %d MiB", maxSize>>20))
+		}
+		file, err := createReceived(dir, chunk.Name)
+		if err != nil {
+			return "", t.fail(chunk.ID, err)
+		}
+		if t.received == nil {
+			t.received = make(map[string]*receivedFile)
+		}
+		f = &receivedFile{file: file, path: file.Name(), size: chunk.Size}
+		t.received[chunk.ID] = f
+	}
+
+	if chunk.Offset != f.written {
+		return "", t.fail(chunk.ID, errTransferOffset)
+	}
+	if f.written+int64(len(chunk.Data)) > f.size {
+		return "", t.fail(chunk.ID, errTransferSize)
+	}
+	n, err := f.file.Write(chunk.Data)
+	f.written += int64(n)
+	if err != nil {
+		return "", t.fail(chunk.ID, err)
+	}
+	if !chunk.Done {
+		return "", nil
+	}
+
+	if f.written != f.size {
+		return "", t.fail(chunk.ID, errTransferSize)
+	}
+	delete(t.received, chunk.ID)
+	if err := f.file.Close(); err != nil {
+		os.Remove(f.path)
+		return "", err
+	}
+	return f.path, nil
+}
+
+// Removes the incomplete file and drops its remaining parts. Called with the lock held
+func (t *transferState) fail(id string, err error) error {
+	if f := t.received[id]; f != nil {
+		f.file.Close()
+		os.Remove(f.path)
+		delete(t.received, id)
+	}
+	if t.failed == nil {
+		t.failed = make(map[string]bool)
+	}
+	t.failed[id] = true
+	return err
+}
+
+// Marks the file as being sent and returns the channel closed if the host gives up on it
+func (t *transferState) start(id string) <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sending, t.cancel = id, make(chan struct{})
+	return t.cancel
+}
+
+func (t *transferState) finish() {
+	t.mu.Lock()
+	t.sending, t.cancel = "", nil
+	t.mu.Unlock()
+}
+
+// Stops sending the file if the host gave up on it
+func (t *transferState) abort(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sending == id && t.cancel != nil {
+		close(t.cancel)
+		t.cancel = nil
+	}
+}
+
+// Removes incomplete files and forgets the queued ones when the session ends
+func (t *transferState) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id := range t.received {
+		t.fail(id, nil)
+	}
+	t.failed = nil
+	if t.cancel != nil {
+		close(t.cancel)
+		t.cancel = nil
+	}
+	for t.queue != nil && len(t.queue) > 0 {
+		<-t.queue
+	}
+}
+
+// Creates the file of the name in the directory without replacing an existing one. Only the
+// base of the name is used, so the remote cannot write outside of the directory
+func createReceived(dir, name string) (*os.File, error) {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return nil, errTransferName
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 100; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("%s exists too often", name)
+}
+
+// Returns the directory of the received files
+func (app *App) transferDir() string {
+	if dir := app.Config.Transfer.Dir; dir != "" {
+		return dir
+	}
+	return filepath.Join(app.Config.Dir(), transfersDir)
+}
+
+// Stores a part of a file the remote sends. The remote is told about the stored file or why
+// the host gave up on it
+func (app *App) handleFile(dc *webrtc.DataChannel, payload json.RawMessage) {
+	chunk := types.FileChunk{}
+	if err := json.Unmarshal(payload, &chunk); err != nil {
+		log.Println("[ERR] Parsing file chunk:", err)
+		return
+	}
+
+	var path string
+	var err error
+	if perm := app.granted(); !perm.Allows("file") {
+		app.transfers.mu.Lock()
+		err = app.transfers.fail(chunk.ID, fmt.Errorf("not allowed by permission profile %s", perm))
+		app.transfers.mu.Unlock()
+	} else {
+		maxSize := int64(defaultMaxTransfer) << 20
+		if app.Config.Transfer.MaxSize > 0 {
+			maxSize = int64(app.Config.Transfer.MaxSize) << 20
+		}
+		path, err = app.transfers.receive(app.transferDir(), maxSize, &chunk)
+	}
+
+	switch {
+	case errors.Is(err, errTransferSkipped):
+		return
+	case err != nil:
+		log.Printf("[WARN] Dropping file %q of the remote: %v", chunk.Name, err)
+		app.emit(SessionEvent{Type: Warning, Message: fmt.Sprintf("File of the remote dropped: %v", err)})
+	case path == "":
+		return
+	default:
+		log.Printf("[FILE] Received %s", path)
+		app.emit(SessionEvent{Type: FileTransfer, Message: path})
+		app.uploadFile(path, app.SessionToken)
+	}
+
+	result := types.FileResult{ID: chunk.ID, Name: filepath.Base(path)}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if err := sendFileResult(dc, &result); err != nil {
+		log.Printf("[ERR] Sending file result: %v", err)
+	}
+}
+
+func sendFileResult(dc *webrtc.DataChannel, result *types.FileResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(&types.RemoteEvent{Type: "file", Event: data})
+	if err != nil {
+		return err
+	}
+	return dc.Send(msg)
+}
+
+// Shows the answer of the host to a file the remote sent
+func (app *App) handleFileResult(payload json.RawMessage) {
+	result := types.FileResult{}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		log.Println("[ERR] Parsing file result:", err)
+		return
+	}
+	if result.Error != "" {
+		app.transfers.abort(result.ID)
+		log.Printf("[WARN] Host dropped the file: %s", result.Error)
+		app.emit(SessionEvent{Type: Warning, Message: "Host dropped the file: " + result.Error})
+		return
+	}
+	log.Printf("[FILE] Host stored the file as %s", result.Name)
+	app.emit(SessionEvent{Type: FileTransfer, Message: result.Name})
+}
+
+// Queues the file for sending to the host of the current session. The host decides whether
+// the granted profile allows it
+func (app *App) SendFile(path string) error {
+	if app.session.State() != session.Connected {
+		return errNotConnected
+	}
+	if app.session.Role() != Remote {
+		return errTransferNotRemote
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a file", path)
+	}
+
+	select {
+	case app.transfers.files() <- path:
+		return nil
+	default:
+		return errTransferQueue
+	}
+}
+
+// Runs on the remote for the lifetime of the session and sends the queued files one after
+// the other
+func (app *App) sendFiles(ctx context.Context, dc *webrtc.DataChannel) {
+	// The datachannel is drained before more of a file is buffered
+	low := make(chan struct{}, 1)
+	dc.SetBufferedAmountLowThreshold(fileBufferLow)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case low <- struct{}{}:
+		default:
+		}
+	})
+
+	queue := app.transfers.files()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case path := <-queue:
+			if err := app.sendFile(ctx, dc, low, path); err != nil {
+				log.Printf("[ERR] Sending %s: %v", path, err)
+				app.emit(SessionEvent{Type: Warning, Message: fmt.Sprintf("Sending %s failed: %v", filepath.Base(path), err)})
+			}
+		}
+	}
+}
+
+func (app *App) sendFile(ctx context.Context, dc *webrtc.DataChannel, low <-chan struct{}, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	chunk := types.FileChunk{ID: uuid.New().String(), Name: filepath.Base(path), Size: info.Size()}
+	cancel := app.transfers.start(chunk.ID)
+	defer app.transfers.finish()
+
+	// A file which grows while it is sent is cut at the size announced to the host
+	r := io.LimitReader(f, info.Size())
+	buf := make([]byte, fileChunkSize)
+	log.Printf("[FILE] Sending %s", path)
+	for {
+		n, err := io.ReadFull(r, buf)
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			chunk.Done = true
+		case err != nil:
+			return err
+		}
+		chunk.Data = buf[:n]
+		if chunk.Done && chunk.Offset+int64(n) != info.Size() {
+			return errTransferSize
+		}
+
+		data, err := json.Marshal(&chunk)
+		if err != nil {
+			return err
+		}
+		msg, err := json.Marshal(&types.RemoteEvent{Type: "file", Event: data})
+		if err != nil {
+			return err
+		}
+		for dc.BufferedAmount() > fileBufferHigh {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-cancel:
+				return nil
+			case <-low:
+			}
+		}
+		select {
+		case <-cancel:
+			// The host told why it gave up on the file
+			return nil
+		default:
+		}
+		if err := dc.Send(msg); err != nil {
+			return err
+		}
+		if chunk.Done {
+			return nil
+		}
+		chunk.Offset += int64(n)
+		chunk.Name, chunk.Size = "", 0
+	}
+}
@@ -0,0 +1,148 @@
+package application
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/remygo/internal/types"
+)
+
+func TestReceiveFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []types.FileChunk
+		file   string // Name of the stored file. Empty if the file is dropped
+		data   string
+		err    error
+	}{
+		{
+			name: "parts",
+			chunks: []types.FileChunk{
+				{ID: "1", Name: "notes.txt", Size: 11, Data: []byte("hello ")},
+				{ID: "1", Offset: 6, Data: []byte("world"), Done: true},
+			},
+			file: "notes.txt",
+			data: "hello world",
+		},
+		{
+			name:   "empty",
+			chunks: []types.FileChunk{{ID: "1", Name: "empty", Done: true}},
+			file:   "empty",
+		},
+		{
+			name:   "path stripped",
+			chunks: []types.FileChunk{{ID: "1", Name: `..\..\evil/../run.sh`, Size: 2, Data: []byte("ok"), Done: true}},
+			file:   "run.sh",
+			data:   "ok",
+		},
+		{
+			name:   "invalid name",
+			chunks: []types.FileChunk{{ID: "1", Name: "..", Size: 2, Data: []byte("ok"), Done: true}},
+			err:    errTransferName,
+		},
+		{
+			name:   "not started",
+			chunks: []types.FileChunk{{ID: "1", Offset: 6, Data: []byte("world"), Done: true}},
+			err:    errTransferNotStarted,
+		},
+		{
+			name: "out of order",
+			chunks: []types.FileChunk{
+				{ID: "1", Name: "notes.txt", Size: 11, Data: []byte("hello ")},
+				{ID: "1", Offset: 8, Data: []byte("rld"), Done: true},
+			},
+			err: errTransferOffset,
+		},
+		{
+			name:   "larger than announced",
+			chunks: []types.FileChunk{{ID: "1", Name: "notes.txt", Size: 2, Data: []byte("hello"), Done: true}},
+			err:    errTransferSize,
+		},
+		{
+			name:   "smaller than announced",
+			chunks: []types.FileChunk{{ID: "1", Name: "notes.txt", Size: 20, Data: []byte("hello"), Done: true}},
+			err:    errTransferSize,
+		},
+		{
+			name: "parts after failure",
+			chunks: []types.FileChunk{
+				{ID: "1", Name: "notes.txt", Size: 11, Data: []byte("hello ")},
+				{ID: "1", Offset: 8, Data: []byte("rl")},
+				{ID: "1", Offset: 10, Data: []byte("d"), Done: true},
+			},
+			err: errTransferSkipped,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			state := transferState{}
+			var path string
+			var err error
+			for i := range tt.chunks {
+				path, err = state.receive(dir, 1<<20, &tt.chunks[i])
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+
+			entries, _ := os.ReadDir(dir)
+			if tt.file == "" {
+				if len(entries) != 0 {
+					t.Fatalf("dropped file left %s", entries[0].Name())
+				}
+				return
+			}
+			if path != filepath.Join(dir, tt.file) {
+				t.Fatalf("stored as %s, want %s", path, tt.file)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.data {
+				t.Fatalf("stored %q, want %q", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestReceiveFileLimits(t *testing.T) {
+	dir := t.TempDir()
+	state := transferState{}
+
+	if _, err := state.receive(dir, 4, &types.FileChunk{ID: "big", Name: "big", Size: 5}); err == nil {
+		t.Fatal("file larger than the limit accepted")
+	}
+
+	// Existing files are kept
+	for i, want := range []string{"a.txt", "a (1).txt", "a (2).txt"} {
+		chunk := types.FileChunk{ID: string(rune('0' + i)), Name: "a.txt", Done: true}
+		path, err := state.receive(dir, 4, &chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(path) != want {
+			t.Fatalf("stored as %s, want %s", filepath.Base(path), want)
+		}
+	}
+
+	for i := 0; i < maxTransfers; i++ {
+		chunk := types.FileChunk{ID: string(rune('a' + i)), Name: "part", Size: 4, Data: []byte("p")}
+		if _, err := state.receive(dir, 4, &chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := state.receive(dir, 4, &types.FileChunk{ID: "z", Name: "part", Size: 4}); !errors.Is(err, errTransferBusy) {
+		t.Fatalf("error %v, want %v", err, errTransferBusy)
+	}
+
+	// Incomplete files are removed when the session ends
+	state.reset()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Fatalf("%d files left, want the 3 complete ones", len(entries))
+	}
+}
@@ -0,0 +1,78 @@
+package application
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/remygo/application/session"
+	"github.com/remygo/conn/wrtc"
+	"github.com/remygo/pkg/config"
+	"github.com/remygo/pkg/message"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Reports whether a join request without a password may be accepted by an unattended host.
+// Allowed devices are only claimed by the request, so the remote has to present the certificate
+// of the device in the DTLS handshake, which the host checks against the offer
+func (app *App) authorizeDevice(msg *message.SessionMessage) (device string, ok bool) {
+	u := &app.Config.Unattended
+	if !u.Enabled || !u.Allowed(msg.Device, msg.UserID) {
+		return "", false
+	}
+	return strings.ToLower(msg.Device), true
+}
+
+// Checks that the offers and answers of a remote which joined as an allowed device carry the
+// certificate of the device. The DTLS handshake then proves that the remote holds its key
+func (app *App) checkPeerDevice(desc webrtc.SessionDescription) error {
+	if app.peerDevice == "" {
+		return nil
+	}
+	fingerprint, err := wrtc.DescriptionFingerprint(desc.SDP)
+	if err != nil {
+		return err
+	}
+	if fingerprint != app.peerDevice {
+		return fmt.Errorf("remote presented fingerprint %s but joined as device %s", fingerprint, app.peerDevice)
+	}
+	return nil
+}
+
+// Returns the DTLS fingerprint of the certificate of this device, which hosts allow it by
+func DeviceFingerprint(configPath string) (string, error) {
+	conf, err := config.Load(configPath)
+	if err != nil {
+		return "", err
+	}
+	cert, err := wrtc.LoadCertificate(filepath.Join(conf.Dir(), certFile))
+	if err != nil {
+		return "", err
+	}
+	return wrtc.Fingerprint(cert)
+}
+
+// Switches unattended access on or off and registers again with the signaling server
+// so that the session token changes between the access id and a one-off token
+func (app *App) SetUnattended(enabled bool) error {
+	if !app.session.Can(session.Register) {
+		return fmt.Errorf("cannot change unattended access in state %s", app.session.State())
+	}
+
+	app.Config.Unattended.Enabled = enabled
+	if enabled {
+		if _, err := app.Config.EnsureAccessID(); err != nil {
+			return fmt.Errorf("creating access id: %v", err)
+		}
+		if app.Config.Unattended.AccessVerifier == "" && len(app.Config.Unattended.AllowedDevices) == 0 {
+			log.Println("[WARN] Unattended access has no password and no allowed devices. All join requests will be denied")
+		}
+	}
+	if err := app.Config.Save(); err != nil {
+		return fmt.Errorf("saving config: %v", err)
+	}
+
+	return app.RegisterSession()
+}
@@ -0,0 +1,100 @@
+package application
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/remygo/pkg/upload"
+	"github.com/remygo/swagger"
+)
+
+// Directory next to the config the journals of interrupted uploads are kept in
+const uploadsDir = "uploads"
+
+// Uploader of the configured blob provider. It is set up on the first upload since the
+// provider is fetched from the API
+type uploadState struct {
+	mu       sync.Mutex
+	uploader *upload.Uploader
+}
+
+// Returns the uploader of the blob provider of the config or nil if none is configured
+func (app *App) uploader() (*upload.Uploader, error) {
+	conf := app.Config.Upload
+	if conf.Provider == "" {
+		return nil, nil
+	}
+	app.uploads.mu.Lock()
+	defer app.uploads.mu.Unlock()
+	if app.uploads.uploader != nil {
+		return app.uploads.uploader, nil
+	}
+
+	api := swagger.NewAPIClient(swagger.NewConfiguration())
+	provider, _, err := api.BlobProviderApi.GetBlobProviderById(app.Ctx, conf.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("fetching blob provider %s: %w", conf.Provider, err)
+	}
+	store, err := upload.NewS3(provider.Url, provider.AccessKey, provider.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	registry := &upload.APIRegistry{Files: api.UserFileApi, UserID: app.UserID, ProviderID: conf.Provider}
+	u := upload.New(store, registry, filepath.Join(app.Config.Dir(), uploadsDir))
+	if conf.PartSize > 0 {
+		u.PartSize = int64(conf.PartSize) << 20
+		if u.PartSize < upload.MinPartSize {
+			log.Printf("[WARN] Uploading parts of %d MiB, the blob store takes no smaller ones", upload.MinPartSize>>20)
+			u.PartSize = upload.MinPartSize
+		}
+	}
+	app.uploads.uploader = u
+	return u, nil
+}
+
+// Uploads a file of the session into the blob provider in the background. The upload is
+// journaled first if the provider is known already, so it continues when the application
+// starts again even if it closes right away
+func (app *App) uploadFile(path, sessionID string) {
+	if app.Config.Upload.Provider == "" {
+		return
+	}
+	app.uploads.mu.Lock()
+	u := app.uploads.uploader
+	app.uploads.mu.Unlock()
+	if u != nil {
+		if err := u.Queue(path, sessionID); err != nil {
+			log.Printf("[ERR] Queueing upload of %s: %v", path, err)
+		}
+	}
+
+	go func() {
+		u, err := app.uploader()
+		if err != nil {
+			log.Printf("[ERR] Uploading %s: %v", path, err)
+			return
+		}
+		log.Printf("[UPLOAD] Uploading %s to the blob provider", path)
+		if err = u.Upload(app.Ctx, path, sessionID); err != nil {
+			log.Printf("[ERR] Uploading %s: %v", path, err)
+			return
+		}
+		log.Printf("[UPLOAD] Uploaded %s", path)
+	}()
+}
+
+// Continues the uploads which were interrupted when the application closed
+func (app *App) resumeUploads() {
+	u, err := app.uploader()
+	if err != nil {
+		log.Printf("[ERR] Resuming uploads: %v", err)
+	}
+	if u == nil {
+		return
+	}
+	if err = u.Resume(app.Ctx); err != nil {
+		log.Printf("[ERR] Resuming uploads: %v", err)
+	}
+}
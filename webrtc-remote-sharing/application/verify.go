@@ -0,0 +1,232 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/remygo/application/session"
+	"github.com/remygo/internal/types"
+	"github.com/remygo/pkg/auth"
+	"github.com/remygo/pkg/knownhosts"
+	"github.com/remygo/pkg/message"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Offers and answers carry the DTLS fingerprints which is why the peers seal them with the key
+// of the password exchange. A signaling server that swaps the fingerprints to sit in the middle
+// cannot produce a valid sealed message without the session password. Devices on the allowlist of an unattended host share no
+// password; the host checks that the remote offers the certificate of the device instead and
+// the short authentication string lets the users compare the connection
+
+var errUnsealed = errors.New("unsealed session description although a session password was used")
+
+// Generates the password for the session token of an attended host. The remote has to
+// enter it to join. The host keeps only its verifier for the password exchange
+func (app *App) newSessionPassword(token string) error {
+	app.sessionPassword, app.sessionVerifier = "", nil
+	if app.Config.Unattended.Enabled {
+		return nil
+	}
+
+	password, err := auth.NewPassword()
+	if err != nil {
+		return fmt.Errorf("generating session password: %v", err)
+	}
+	cred, err := auth.DeriveCredential(password, token)
+	if err != nil {
+		return fmt.Errorf("deriving session password verifier: %v", err)
+	}
+	app.sessionPassword, app.sessionVerifier = password, cred.Verifier()
+
+	return nil
+}
+
+// Join request waiting for the remote to confirm the password exchange
+type pendingJoin struct {
+	request   *message.SessionMessage
+	challenge *auth.Challenge
+}
+
+// Answers the share of a join request with the host's share of the password exchange. The
+// request is accepted once the remote confirms that it used the same password
+func (app *App) challengeJoin(msg *message.SessionMessage) error {
+	verifier := app.sessionVerifier
+	if app.Config.Unattended.Enabled {
+		verifier = app.Config.Unattended.Verifier()
+	}
+	if verifier == nil {
+		return errors.New("no password is set")
+	}
+
+	challenge, err := auth.NewChallenge(verifier, msg.Share, msg.Token, msg.UserID)
+	if err != nil {
+		return err
+	}
+	app.pendingJoin = &pendingJoin{request: msg, challenge: challenge}
+
+	return app.send(message.NewJoinChallenge(msg.Token, challenge.Share(), challenge.Confirm()))
+}
+
+// Checks the remote's confirmation of the password exchange. Returns the pending join request
+// and the key both peers derived from the exchange. Each request gets one attempt
+func (app *App) confirmJoin(msg *message.SessionMessage) (*message.SessionMessage, []byte, error) {
+	pending := app.pendingJoin
+	app.pendingJoin = nil
+	if pending == nil || pending.request.Token != msg.Token {
+		return nil, nil, errors.New("join confirmation without a pending join request")
+	}
+
+	key, err := pending.challenge.Check(msg.Proof)
+	if err != nil {
+		return nil, nil, fmt.Errorf("join request from user %q: %v", pending.request.UserID, err)
+	}
+	return pending.request, key, nil
+}
+
+// Finishes the password exchange as the remote. A host which does not know the password is
+// still sent an empty confirmation so that it denies the request and the hub drops it
+func (app *App) answerChallenge(msg *message.SessionMessage) error {
+	prover := app.joinProver
+	app.joinProver = nil
+	if prover == nil || msg.Token != app.joinToken || app.session.State() != session.AwaitingApproval {
+		return fmt.Errorf("unexpected join challenge for %s in state %s", msg.Token, app.session.State())
+	}
+
+	confirm, key, err := prover.Finish(msg.Share, msg.Proof)
+	if err != nil {
+		log.Printf("[SECURITY] Password exchange with host %s failed: %v", msg.Token, err)
+		app.emit(SessionEvent{Type: Warning, Message: "The host could not prove that it knows the password"})
+		return app.send(message.NewJoinConfirm(msg.Token, ""))
+	}
+	app.signalKey = key
+
+	return app.send(message.NewJoinConfirm(msg.Token, confirm))
+}
+
+// Sends an offer or answer, sealed if the peers share a key
+func (app *App) sendSDP(msg message.SignalMessage) error {
+	if app.signalKey == nil {
+		return app.send(message.NewSignal(msg.Type, msg.Data))
+	}
+
+	sealed, err := auth.Seal(app.signalKey, msg.Data, msg.String())
+	if err != nil {
+		return fmt.Errorf("sealing %s: %v", msg, err)
+	}
+	return app.send(message.NewSealedSignal(msg.Type, sealed))
+}
+
+// Opens a received offer or answer. Unsealed descriptions are rejected if the peers share a key
+// since the signaling server could have stripped the seal to replace the fingerprints
+func (app *App) openSDP(msg *message.SignalMessage, sdp *webrtc.SessionDescription) error {
+	switch {
+	case app.signalKey != nil && !msg.Sealed:
+		return errUnsealed
+	case app.signalKey == nil && msg.Sealed:
+		return fmt.Errorf("sealed %s received but no session password was used", msg)
+	case msg.Sealed:
+		data, err := auth.Open(app.signalKey, msg.Data, msg.String())
+		if err != nil {
+			return fmt.Errorf("opening %s: %v", msg, err)
+		}
+		msg.Data = data
+	}
+	return msg.IntoSDP(sdp)
+}
+
+// Leaves the session if the signaling could not be authenticated
+func (app *App) abortSession(err error) {
+	log.Printf("[SECURITY] %v. Leaving session", err)
+	app.leaveSession("Connection could not be verified. The session password may be wrong")
+}
+
+// Leaves the session and shows the reason to the user. As the host this makes the signaling
+// server terminate the session for both peers. As the remote the session is ended locally
+// and the reset leaves it on the signaling server
+func (app *App) leaveSession(reason string) {
+	app.emit(SessionEvent{Type: Warning, Message: reason})
+
+	if app.session.Role() == Remote {
+		app.endSession()
+		return
+	}
+	if err := app.send(message.NewSession(message.Leave, "", nil)); err != nil {
+		log.Printf("[ERR] Leaving session: %v", err)
+	}
+}
+
+// Derives the short authentication string from the DTLS fingerprints of the established
+// connection and shows it to the user for comparison with the other peer
+func (app *App) verifyConnection() {
+	local, remote, err := app.PeerConn.Fingerprints()
+	if err != nil {
+		log.Printf("[ERR] Reading DTLS fingerprints: %v", err)
+		return
+	}
+	sas := auth.SAS(local, remote)
+	log.Printf("[PC] Short authentication string: %s", sas)
+
+	if app.signalKey == nil {
+		log.Println("[WARN] Signaling is not sealed with a session password. Compare the short authentication string with the other user")
+	}
+	if app.session.Role() == Remote {
+		if ui := app.playbackUI(); ui != nil {
+			ui.ShowSAS(sas)
+		}
+		app.verifyHost(remote)
+	}
+	app.emit(SessionEvent{Type: Verify, Message: sas})
+}
+
+// Tells the remote the device id of the host. It is only logged since the host could claim
+// any id, the remote pins the fingerprint under the access id it dialed
+func (app *App) sendIdentity(dc *webrtc.DataChannel) error {
+	identity, err := json.Marshal(&types.Identity{DeviceID: app.DeviceID})
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(&types.RemoteEvent{Type: "identity", Event: identity})
+	if err != nil {
+		return err
+	}
+	return dc.Send(msg)
+}
+
+// Checks the host's DTLS fingerprint against the one pinned for the access id the remote dialed
+// on the first connection. A changed fingerprint means the host was reinstalled or someone is
+// in the middle. One-off tokens are not pinned unless the token is known, so that the signaling
+// server cannot skip the check by claiming that a pinned access id is a one-off token
+func (app *App) verifyHost(fingerprint string) {
+	token := app.joinToken
+	if token == "" || app.knownHosts == nil {
+		return
+	}
+	if !app.joinUnattended && !app.knownHosts.Known(token) {
+		log.Printf("[PC] Host %s joined by a one-off token. Not pinning fingerprint %s", token, fingerprint)
+		return
+	}
+
+	result, pinned, err := app.knownHosts.Verify(token, fingerprint, time.Now())
+	if err != nil {
+		log.Printf("[ERR] Saving known hosts: %v", err)
+	}
+	switch result {
+	case knownhosts.New:
+		log.Printf("[PC] First connection to host %s. Pinned fingerprint %s", token, fingerprint)
+	case knownhosts.Match:
+		log.Printf("[PC] Host %s matches the fingerprint pinned on %s", token, pinned.FirstSeen.Format(time.RFC1123))
+	case knownhosts.Changed:
+		log.Printf("[SECURITY] Fingerprint of host %s changed. Pinned %s on %s, got %s", token,
+			pinned.Fingerprint, pinned.FirstSeen.Format(time.RFC1123), fingerprint)
+
+		warning := "WARNING: HOST IDENTITY CHANGED. Compare the verification code before continuing"
+		if ui := app.playbackUI(); ui != nil {
+			ui.ShowNotice(warning)
+		}
+		app.emit(SessionEvent{Type: Warning, Message: warning})
+	}
+}
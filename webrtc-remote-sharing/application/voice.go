@@ -0,0 +1,189 @@
+package application
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/remygo/display/backend"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Microphone of the current session. The device is only open while the microphone is on, so
+// nothing is captured while it is muted
+type voiceState struct {
+	mu      sync.Mutex
+	capture backend.AudioCapturer
+	track   *webrtc.TrackLocalStaticSample // Voice track of the current session. Nil while the microphone may not be used
+	open    bool                           // The capture is running
+}
+
+// Keeps the microphone on without holding the talk button. The setting is kept for later
+// sessions
+func (app *App) SetMicrophone(alwaysOn bool) error {
+	atomic.StoreInt32(&app.micAlwaysOn, boolFlag(alwaysOn))
+	if err := app.applyMicrophone(); err != nil {
+		return err
+	}
+
+	app.Config.Media.Voice.AlwaysOn = alwaysOn
+	if err := app.Config.Save(); err != nil {
+		return fmt.Errorf("saving config: %v", err)
+	}
+	return nil
+}
+
+// Turns the microphone on while the talk button is held
+func (app *App) SetTalking(talking bool) error {
+	atomic.StoreInt32(&app.talking, boolFlag(talking))
+	return app.applyMicrophone()
+}
+
+// Sets the volume of the voice of the other user in percent. The setting is kept for later
+// sessions
+func (app *App) SetVoiceVolume(percent int) error {
+	if percent < 1 || percent > 100 {
+		return fmt.Errorf("volume %d%% out of range", percent)
+	}
+	atomic.StoreInt32(&app.voiceVolume, int32(percent))
+	if err := app.applyVoicePlayback(); err != nil {
+		return err
+	}
+
+	app.Config.Media.Voice.Volume = percent
+	if err := app.Config.Save(); err != nil {
+		return fmt.Errorf("saving config: %v", err)
+	}
+	return nil
+}
+
+// Mutes or unmutes the voice of the other user. The setting is kept for later sessions
+func (app *App) SetVoiceMuted(muted bool) error {
+	atomic.StoreInt32(&app.voiceMuted, boolFlag(muted))
+	if err := app.applyVoicePlayback(); err != nil {
+		return err
+	}
+
+	app.Config.Media.Voice.Muted = muted
+	if err := app.Config.Save(); err != nil {
+		return fmt.Errorf("saving config: %v", err)
+	}
+	return nil
+}
+
+func boolFlag(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (app *App) microphoneOn() bool {
+	return atomic.LoadInt32(&app.micAlwaysOn) == 1 || atomic.LoadInt32(&app.talking) == 1
+}
+
+// Opens the microphone of the current session while it is on and closes it otherwise. The
+// remote hears nothing in between, its player waits for the next frame
+func (app *App) applyMicrophone() error {
+	v := &app.voice
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.capture == nil || v.track == nil {
+		return nil
+	}
+
+	on := app.microphoneOn()
+	switch {
+	case on && !v.open:
+		if err := v.capture.Start(v.track); err != nil {
+			return fmt.Errorf("capturing microphone: %w", err)
+		}
+		v.open = true
+	case !on && v.open:
+		v.open = false
+		if err := v.capture.Stop(); err != nil {
+			return fmt.Errorf("stopping microphone: %w", err)
+		}
+	}
+	return nil
+}
+
+// Reports whether the microphone may be opened in the current session. Nobody may be in front
+// of headless and unattended hosts, so they only send the sound of the room if the config
+// allows it explicitly
+func (app *App) microphoneAllowed() bool {
+	unattended := app.Args.Headless || app.session.Role() == Host && app.Config.Unattended.Enabled
+	return !unattended || app.Config.Media.Voice.Unattended
+}
+
+// Passes the volume and the mute setting on to the voice player of the current media
+// components
+func (app *App) applyVoicePlayback() error {
+	media := app.media()
+	if media == nil || media.VoicePlayer == nil {
+		return nil
+	}
+	volume := float64(atomic.LoadInt32(&app.voiceVolume)) / 100
+	if err := media.VoicePlayer.SetVolume(volume); err != nil {
+		return fmt.Errorf("setting voice volume: %w", err)
+	}
+	if err := media.VoicePlayer.SetMuted(atomic.LoadInt32(&app.voiceMuted) == 1); err != nil {
+		return fmt.Errorf("muting voice: %w", err)
+	}
+	return nil
+}
+
+// Lets the microphone send on the voice track of the peer connection. It is opened once the
+// user talks. The session goes on without voice if there is no microphone
+func (app *App) startVoiceCapture() {
+	if app.VoiceCapture == nil || app.PeerConn.Voice == nil {
+		return
+	}
+	if !app.microphoneAllowed() {
+		log.Println("[INFO] Microphone stays closed on this unattended host")
+		return
+	}
+
+	app.voice.mu.Lock()
+	app.voice.capture, app.voice.track = app.VoiceCapture, app.PeerConn.Voice
+	app.voice.mu.Unlock()
+	if err := app.applyMicrophone(); err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+}
+
+// Plays the voice of the other user until the track ends with the peer connection
+func (app *App) playVoice(tr *webrtc.TrackRemote) {
+	log.Printf("[PC] Voice track received: %s", tr.Codec().MimeType)
+
+	media := app.media()
+	if media == nil || media.VoicePlayer == nil {
+		return
+	}
+	player := media.VoicePlayer
+	if err := app.applyVoicePlayback(); err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	app.playTrack(tr, player, nil)
+}
+
+// Stops the microphone and the voice of the other user
+func (app *App) stopVoice() {
+	v := &app.voice
+	v.mu.Lock()
+	if v.open {
+		if err := v.capture.Stop(); err != nil {
+			log.Printf("[ERR] Stopping microphone: %v", err)
+		}
+	}
+	v.capture, v.track, v.open = nil, nil, false
+	v.mu.Unlock()
+
+	if app.VoicePlayer != nil {
+		if err := app.VoicePlayer.Stop(); err != nil {
+			log.Printf("[ERR] Stopping voice playback: %v", err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/remygo/application"
+)
+
+// Registers with the signaling server and prints the token and password for the remote.
+// Join requests are handled by the application according to the config
+func runHost(ctx context.Context, c *client, opts *options, fs *flag.FlagSet) error {
+	if err := c.RegisterSession(); err != nil {
+		return fmt.Errorf("registering session: %v", err)
+	}
+
+	for {
+		select {
+		case ev := <-c.events:
+			switch ev.Type {
+			case application.Registered, application.Renew:
+				printToken(ev)
+			case application.RecordingRequested:
+				declineRecording(c)
+			default:
+				printEvent(ev)
+			}
+		case <-c.done:
+			return nil
+		case <-ctx.Done():
+			log.Println("[APP] Interrupted")
+			return nil
+		}
+	}
+}
+
+// Registers with the signaling server and joins the session with the given token
+func runJoin(ctx context.Context, c *client, opts *options, fs *flag.FlagSet) error {
+	if fs.NArg() != 1 {
+		return errors.New("provide the token of the session to join i.e. 'remygo join [flags] TOKEN'")
+	}
+	token := fs.Arg(0)
+
+	if err := c.RegisterSession(); err != nil {
+		return fmt.Errorf("registering session: %v", err)
+	}
+
+	for {
+		select {
+		case ev := <-c.events:
+			switch ev.Type {
+			case application.Registered:
+				// Joining requires a registered peer
+				log.Printf("[APP] Requesting to join session %s", token)
+				if err := c.JoinSession(token, opts.password); err != nil {
+					return fmt.Errorf("joining session: %v", err)
+				}
+			case application.Renew:
+				// Renewed after the session ended
+				log.Println("[APP] Session ended")
+				return nil
+			case application.InSession:
+				printEvent(ev)
+				if opts.send != "" {
+					if err := c.SendFile(opts.send); err != nil {
+						log.Printf("[ERR] Sending %s: %v", opts.send, err)
+					}
+				}
+			case application.RecordingRequested:
+				declineRecording(c)
+			default:
+				printEvent(ev)
+			}
+		case <-c.done:
+			return nil
+		case <-ctx.Done():
+			log.Println("[APP] Interrupted")
+			return nil
+		}
+	}
+}
+
+// Declines recordings of the other user since there is no prompt to consent without the GUI.
+// Setting recording.autoConsent in the config consents to them
+func declineRecording(c *client) {
+	fmt.Print("\nThe other user asked to record the session. Declined since recordings need consent\n\n")
+	if err := c.AnswerRecording(false); err != nil {
+		log.Printf("[ERR] Declining recording: %v", err)
+	}
+}
+
+func printToken(ev application.SessionEvent) {
+	fmt.Printf("\nSession token:    %s\n", ev.Token)
+	if ev.Password != "" {
+		fmt.Printf("Session password: %s\n\n", ev.Password)
+	} else {
+		fmt.Print("Unattended access enabled. The access password or an allowed device is required to join\n\n")
+	}
+}
+
+func printEvent(ev application.SessionEvent) {
+	switch ev.Type {
+	case application.InSession:
+		fmt.Println("\nConnected")
+	case application.Verify:
+		fmt.Printf("\nVerification code: %s\nIt must match the code shown to the other user\n\n", ev.Message)
+	case application.Warning, application.MessageError:
+		fmt.Printf("\nWarning: %s\n\n", ev.Message)
+	case application.PeerRecording:
+		if ev.Recording {
+			fmt.Print("\nThe other user is recording the session\n\n")
+		} else {
+			fmt.Print("\nThe other user stopped recording the session\n\n")
+		}
+	case application.FileTransfer:
+		fmt.Printf("\nFile transferred: %s\n\n", ev.Message)
+	case application.SessionEnded:
+		fmt.Println("\nSession ended")
+	}
+}
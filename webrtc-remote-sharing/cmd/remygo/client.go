@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/remygo/application"
+	"github.com/remygo/conn/ws"
+	"github.com/remygo/pkg/config"
+	"github.com/remygo/pkg/message"
+
+	"nhooyr.io/websocket"
+)
+
+// Size of the channel buffers between the websocket, the application and the frontend
+const eventBuffer = 16
+
+// Bundles the application with the websocket connection it runs on
+type client struct {
+	*application.App
+	socket *ws.Socket
+	events chan application.SessionEvent
+	done   chan struct{} // Closed when the application main loop exits
+}
+
+// Connects to the signaling server and starts the application main loop. The loop
+// runs until the context is canceled or the signaling server closes the connection
+func dial(ctx context.Context, opts *options) (*client, error) {
+	conn, _, err := websocket.Dial(ctx, opts.args.Addr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dialing %s: %v", opts.args.Addr, err)
+	}
+
+	from := make(chan message.Message, eventBuffer)
+	socket := ws.NewPeer(conn, from)
+
+	cfg, err := config.Load(opts.args.ConfigPath)
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	deviceID, err := cfg.EnsureDeviceID()
+	if err != nil {
+		log.Printf("[WARN] Persisting device id: %v", err)
+	}
+
+	c := &client{
+		socket: socket,
+		events: make(chan application.SessionEvent, eventBuffer),
+		done:   make(chan struct{}),
+	}
+	c.App = application.New(socket, from, c.events, opts.args, opts.userID, deviceID)
+	c.App.OrgID = opts.orgID
+
+	go func() {
+		// The application main loop exits once the channel is closed
+		defer close(from)
+		if err := socket.ReadPump(ctx); err != nil {
+			log.Printf("[WS] Read pump stopped: %v", err)
+		}
+	}()
+	go func() {
+		defer close(c.done)
+		c.App.Start(ctx)
+	}()
+
+	return c, nil
+}
+
+// Waits for the application main loop to exit and closes the websocket connection
+func (c *client) shutdown() {
+	<-c.done
+	if err := c.socket.Conn.Close(websocket.StatusNormalClosure, ""); err != nil {
+		log.Printf("[WS] Closing connection: %v", err)
+	}
+}
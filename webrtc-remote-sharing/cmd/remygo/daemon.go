@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/remygo/application"
+	"github.com/remygo/pkg/approval"
+)
+
+// Contents of the status file written by the daemon
+type status struct {
+	State    string    `json:"state"` // registered, in session, stopped
+	Token    string    `json:"token,omitempty"`
+	Password string    `json:"password,omitempty"` // Empty in unattended mode
+	UserID   string    `json:"userID,omitempty"`   // Remote of the current session
+	Code     string    `json:"verificationCode,omitempty"`
+	Warning  string    `json:"warning,omitempty"`  // Latest warning of the current session
+	Recorded bool      `json:"recorded,omitempty"` // The remote records the current session
+	PID      int       `json:"pid"`
+	Since    time.Time `json:"since"` // When the state last changed
+	Updated  time.Time `json:"updated"`
+}
+
+// Hosts without any window. Join requests are decided by the approval flags in place of a user
+// and the current token and session state are kept up to date in the status file
+func runDaemon(ctx context.Context, c *client, opts *options, fs *flag.FlagSet) error {
+	var approvers approval.All
+	if opts.allow != "" {
+		approvers = append(approvers, approval.AllowList(strings.Split(opts.allow, ",")))
+	}
+	if opts.approveCmd != "" {
+		approvers = append(approvers, approval.Command{Path: opts.approveCmd})
+	}
+	if len(approvers) == 0 {
+		log.Println("[WARN] No approval policy set. Every join request with a valid password is accepted")
+	}
+	c.Approver = approvers
+
+	path := opts.statusPath
+	if path == "" {
+		path = filepath.Join(c.Config.Dir(), "status.json")
+	}
+	st := &status{PID: os.Getpid()}
+	defer func() {
+		st.setState("stopped")
+		st.Password = ""
+		if err := st.write(path); err != nil {
+			log.Printf("[ERR] Writing status: %v", err)
+		}
+	}()
+
+	if err := c.RegisterSession(); err != nil {
+		return fmt.Errorf("registering session: %v", err)
+	}
+	log.Printf("[APP] Running headless. Writing status to %s", path)
+
+	for {
+		select {
+		case ev := <-c.events:
+			if ev.Type == application.RecordingRequested {
+				declineRecording(c)
+			}
+			st.update(ev)
+			if err := st.write(path); err != nil {
+				log.Printf("[ERR] Writing status: %v", err)
+			}
+		case <-c.done:
+			return nil
+		case <-ctx.Done():
+			log.Println("[APP] Interrupted")
+			return nil
+		}
+	}
+}
+
+func (st *status) update(ev application.SessionEvent) {
+	switch ev.Type {
+	case application.Registered, application.Renew:
+		st.setState("registered")
+		st.Token, st.Password = ev.Token, ev.Password
+		st.UserID, st.Code, st.Warning = "", "", ""
+		st.Recorded = false
+	case application.InSession:
+		st.setState("in session")
+		st.UserID = ev.UserID
+	case application.Verify:
+		st.Code = ev.Message
+	case application.Warning, application.MessageError:
+		st.Warning = ev.Message
+	case application.PeerRecording:
+		st.Recorded = ev.Recording
+	}
+}
+
+func (st *status) setState(state string) {
+	if st.State != state {
+		st.State, st.Since = state, time.Now()
+	}
+}
+
+// Replaces the status file so that readers never see a partially written one
+func (st *status) write(path string) error {
+	st.Updated = time.Now()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The file holds the session password
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/remygo/application"
+	"github.com/remygo/gui"
+	uievents "github.com/remygo/gui/events"
+	"github.com/remygo/pkg/approval"
+	"github.com/remygo/swagger"
+
+	"gioui.org/app"
+	"gioui.org/unit"
+)
+
+// Runs the desktop client. The session is registered once the user logged in and
+// events are passed between the GUI and the application until the window is closed
+func runGUI(ctx context.Context, c *client, opts *options, fs *flag.FlagSet) error {
+	w := app.NewWindow(app.Title("Remygo"), app.Size(unit.Dp(800), unit.Dp(600)))
+	g := gui.NewGUI(w, guiSettings(c))
+	c.Approver = &guiApprover{events: g.EventsRX, answers: make(chan string, 1)}
+
+	guiDone := make(chan error, 1)
+	go func() {
+		guiDone <- g.Loop()
+	}()
+
+	for {
+		select {
+		case ev := <-g.EventsTX:
+			handleUIEvent(c, ev)
+		case ev := <-c.events:
+			forwardSessionEvent(g, ev)
+		case err := <-guiDone:
+			log.Println("[GUI] Window closed")
+			return err
+		case <-c.done:
+			return nil
+		case <-ctx.Done():
+			log.Println("[APP] Interrupted")
+			return nil
+		}
+	}
+}
+
+// How long the host user has to answer a join request before it is denied
+const approvalTimeout = time.Minute
+
+// Asks the host user to approve join requests and to pick the permission profile of the
+// remote. Unattended hosts approve them since nobody may be in front of them
+type guiApprover struct {
+	events  chan<- uievents.Event
+	answers chan string // Profile picked by the host user. Empty denies the request
+}
+
+func (a *guiApprover) Approve(ctx context.Context, req approval.Request) (approval.Decision, error) {
+	if req.Unattended {
+		return approval.Decision{Approved: true}, nil
+	}
+	// An answer to an earlier request which came too late is not one to this request
+	select {
+	case <-a.answers:
+	default:
+	}
+
+	ask := uievents.Event{Type: uievents.AskApproval, Payload: uievents.ApprovalRequest{UserID: req.UserID, OrgID: req.OrgID}}
+	select {
+	case a.events <- ask:
+	case <-ctx.Done():
+		return approval.Decision{}, ctx.Err()
+	}
+
+	timer := time.NewTimer(approvalTimeout)
+	defer timer.Stop()
+	select {
+	case profile := <-a.answers:
+		return approval.Decision{Approved: profile != "", Profile: profile}, nil
+	case <-timer.C:
+		select {
+		case a.events <- uievents.Event{Type: uievents.CancelApproval}:
+		case <-ctx.Done():
+		}
+		return approval.Decision{}, fmt.Errorf("join request not answered within %s", approvalTimeout)
+	case <-ctx.Done():
+		return approval.Decision{}, ctx.Err()
+	}
+}
+
+// Passes the answer of the host user on to the waiting request
+func (a *guiApprover) answer(profile string) {
+	select {
+	case a.answers <- profile:
+	default:
+	}
+}
+
+// Returns the settings of the config the controls of the GUI start with
+func guiSettings(c *client) uievents.Settings {
+	conf := c.Config
+	voice := &conf.Media.Voice
+	return uievents.Settings{
+		Profile:     c.PermissionProfile(),
+		Muted:       conf.Media.Audio.Muted,
+		Microphone:  voice.AlwaysOn,
+		VoiceMuted:  voice.Muted,
+		VoiceVolume: voice.VolumePercent(),
+	}
+}
+
+// Calls into the application on behalf of the user
+func handleUIEvent(c *client, ev uievents.Event) {
+	switch ev.Type {
+	case uievents.LoginSuccess:
+		if resp, ok := ev.Payload.(swagger.InlineResponse200); ok && resp.User != nil {
+			c.UserID, c.OrgID = resp.User.Id, resp.User.OrganizationId
+		}
+		if err := c.RegisterSession(); err != nil {
+			log.Printf("[ERR] Registering session: %v", err)
+		}
+	case uievents.JoinSession:
+		if req, ok := ev.Payload.(uievents.JoinRequest); ok {
+			if err := c.JoinSession(req.Token, req.Password); err != nil {
+				log.Printf("[ERR] Joining session: %v", err)
+			}
+		}
+	case uievents.SetUnattended:
+		if enabled, ok := ev.Payload.(bool); ok {
+			if err := c.SetUnattended(enabled); err != nil {
+				log.Printf("[ERR] Setting unattended access: %v", err)
+			}
+		}
+	case uievents.SetMuted:
+		if muted, ok := ev.Payload.(bool); ok {
+			if err := c.SetMuted(muted); err != nil {
+				log.Printf("[ERR] Muting sound: %v", err)
+			}
+		}
+	case uievents.SetMicrophone:
+		if alwaysOn, ok := ev.Payload.(bool); ok {
+			if err := c.SetMicrophone(alwaysOn); err != nil {
+				log.Printf("[ERR] Setting microphone: %v", err)
+			}
+		}
+	case uievents.SetTalking:
+		if talking, ok := ev.Payload.(bool); ok {
+			if err := c.SetTalking(talking); err != nil {
+				log.Printf("[ERR] Setting microphone: %v", err)
+			}
+		}
+	case uievents.SetVoiceMuted:
+		if muted, ok := ev.Payload.(bool); ok {
+			if err := c.SetVoiceMuted(muted); err != nil {
+				log.Printf("[ERR] Muting voice: %v", err)
+			}
+		}
+	case uievents.SetVoiceVolume:
+		if percent, ok := ev.Payload.(int); ok {
+			if err := c.SetVoiceVolume(percent); err != nil {
+				log.Printf("[ERR] Setting voice volume: %v", err)
+			}
+		}
+	case uievents.StartRecording:
+		if err := c.StartRecording(); err != nil {
+			log.Printf("[ERR] Starting recording: %v", err)
+		}
+	case uievents.StopRecording:
+		if err := c.StopRecording(); err != nil {
+			log.Printf("[ERR] Stopping recording: %v", err)
+		}
+	case uievents.AnswerRecording:
+		if allow, ok := ev.Payload.(bool); ok {
+			if err := c.AnswerRecording(allow); err != nil {
+				log.Printf("[ERR] Answering recording request: %v", err)
+			}
+		}
+	case uievents.SendFile:
+		if path, ok := ev.Payload.(string); ok {
+			if err := c.SendFile(path); err != nil {
+				log.Printf("[ERR] Sending %s: %v", path, err)
+			}
+		}
+	case uievents.AnswerApproval:
+		if profile, ok := ev.Payload.(string); ok {
+			if a, ok := c.Approver.(*guiApprover); ok {
+				a.answer(profile)
+			}
+		}
+	case uievents.SetPermissions:
+		if name, ok := ev.Payload.(string); ok {
+			if err := c.SetPermissionProfile(name); err != nil {
+				log.Printf("[ERR] Setting permission profile: %v", err)
+			}
+		}
+	}
+}
+
+// Translates application events into GUI events
+func forwardSessionEvent(g *gui.GUI, ev application.SessionEvent) {
+	switch ev.Type {
+	case application.Registered:
+		g.EventsRX <- uievents.Event{Type: uievents.SetToken, Payload: uievents.TokenInfo{Token: ev.Token, Password: ev.Password}}
+	case application.Renew:
+		g.EventsRX <- uievents.Event{Type: uievents.RenewToken, Payload: uievents.TokenInfo{Token: ev.Token, Password: ev.Password}}
+	case application.InSession:
+		g.EventsRX <- uievents.Event{Type: uievents.SessionStarted}
+	case application.SessionEnded:
+		g.EventsRX <- uievents.Event{Type: uievents.SessionEnded}
+	case application.StateChanged:
+		g.EventsRX <- uievents.Event{Type: uievents.SessionState, Payload: ev.State.String()}
+	case application.Verify:
+		g.EventsRX <- uievents.Event{Type: uievents.ShowSAS, Payload: ev.Message}
+	case application.RecordingRequested:
+		g.EventsRX <- uievents.Event{Type: uievents.RecordingRequest}
+	case application.Recording:
+		g.EventsRX <- uievents.Event{Type: uievents.RecordingState, Payload: uievents.RecordingInfo{Recording: ev.Recording, File: ev.Message}}
+	case application.PeerRecording:
+		g.EventsRX <- uievents.Event{Type: uievents.PeerRecordingState, Payload: ev.Recording}
+	case application.FileTransfer:
+		g.EventsRX <- uievents.Event{Type: uievents.FileTransferred, Payload: ev.Message}
+	case application.Warning, application.MessageError:
+		log.Printf("[APP] %s", ev.Message)
+		g.EventsRX <- uievents.Event{Type: uievents.ShowWarning, Payload: ev.Message}
+	}
+}
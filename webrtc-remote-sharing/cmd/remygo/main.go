@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/remygo/application"
+	_ "github.com/remygo/display/provider" // Registers the GStreamer and Gio backends
+	"github.com/remygo/pkg/flags"
+
+	"gioui.org/app"
+)
+
+const usage = `Usage: remygo <command> [flags]
+
+Commands:
+  gui                 Start the desktop client with the login and landing pages
+  host                Host a session and print its token and password
+  join [flags] TOKEN  Join the session of a host
+  daemon              Host without a window, deciding join requests by policy
+  unattended          Set the access password and the devices allowed to join without it
+
+Run 'remygo <command> -h' for the flags of a command`
+
+// Flags shared by all commands
+type options struct {
+	args       *application.Args
+	userID     string
+	orgID      string
+	password   string
+	send       string
+	allow      string
+	approveCmd string
+	statusPath string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	opts := &options{args: application.NewArgs()}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&opts.args.Addr, "addr", "ws://localhost:8765/ws", "websocket address of the signaling server")
+	fs.StringVar(&opts.args.URL, "url", "stun:stun.l.google.com:19302", "STUN or TURN server url")
+	fs.StringVar(&opts.args.TurnCreds, "creds", "", "TURN credentials in the format 'username:password'")
+	fs.StringVar(&opts.args.Codec, "codec", "", "preferred video codec e.g. 'video/VP9'. The codec is negotiated with the other peer")
+	fs.StringVar(&opts.args.ConfigPath, "config", "", "path to the config file (default in the user config directory)")
+	if name != "gui" {
+		// The GUI takes the user from the login page
+		fs.StringVar(&opts.userID, "user", "", "user id sent to the signaling server")
+		fs.StringVar(&opts.orgID, "org", "", "organization id sent to the signaling server")
+	}
+	switch name {
+	case "join":
+		fs.StringVar(&opts.password, "password", "", "session password of the host")
+		fs.StringVar(&opts.send, "send", "", "file sent to the host once connected if the host grants the file transfer")
+	case "daemon":
+		fs.StringVar(&opts.allow, "allow", "", "comma separated user ids whose join requests are approved")
+		fs.StringVar(&opts.approveCmd, "approve-cmd", "",
+			"program deciding join requests by its exit status. The request is passed in REMYGO_* environment variables and the first line printed may name the granted permission profile")
+		fs.StringVar(&opts.statusPath, "status", "", "path of the status file (default in the config directory)")
+		opts.args.Headless = true
+	}
+	return fs, opts
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cmd := os.Args[1]
+	var run func(ctx context.Context, c *client, opts *options, fs *flag.FlagSet) error
+	switch cmd {
+	case "gui":
+		run = runGUI
+	case "host":
+		run = runHost
+	case "join":
+		run = runJoin
+	case "daemon":
+		run = runDaemon
+	case "unattended":
+		// Only changes the config, so there is nothing to connect to
+		if err := runUnattended(os.Args[2:]); err != nil {
+			log.Fatalf("[ERR] %v", err)
+		}
+		return
+	case "-h", "-help", "--help", "help":
+		fmt.Println(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", cmd, usage)
+		os.Exit(2)
+	}
+
+	fs, opts := newFlagSet(cmd)
+	fs.Parse(os.Args[2:])
+	if err := flags.Validate(opts.args); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	// Gio needs the main goroutine on some platforms, so the client runs
+	// in the background and exits the process once it's done
+	go func() {
+		defer stop()
+
+		c, err := dial(ctx, opts)
+		if err != nil {
+			log.Fatalf("[ERR] Connecting to signaling server: %v", err)
+		}
+		if err := run(ctx, c, opts, fs); err != nil {
+			log.Printf("[ERR] %v", err)
+			c.shutdown()
+			os.Exit(1)
+		}
+		c.shutdown()
+		os.Exit(0)
+	}()
+
+	app.Main()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/remygo/application"
+	"github.com/remygo/pkg/config"
+)
+
+// Changes the unattended access of this device in the config. It does not connect to the
+// signaling server, a running host picks the changes up when it starts again
+func runUnattended(args []string) error {
+	var configPath, allow, revoke string
+	var password, fingerprint bool
+
+	fs := flag.NewFlagSet("unattended", flag.ExitOnError)
+	fs.StringVar(&configPath, "config", "", "path to the config file (default in the user config directory)")
+	fs.BoolVar(&password, "password", false, "set the access password, read from the first line of the standard input")
+	fs.StringVar(&allow, "allow-device", "", "allow the remote device with the fingerprint e.g. 'sha-256 ab:cd:...' to join without a password")
+	fs.StringVar(&revoke, "revoke-device", "", "remove the remote device with the fingerprint from the allowed devices")
+	fs.BoolVar(&fingerprint, "fingerprint", false, "print the fingerprint of this device, which hosts allow it by")
+	fs.Parse(args)
+
+	if fingerprint {
+		fp, err := application.DeviceFingerprint(configPath)
+		if err != nil {
+			return fmt.Errorf("reading device certificate: %v", err)
+		}
+		fmt.Println(fp)
+		return nil
+	}
+
+	conf, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	if password {
+		fmt.Fprint(os.Stderr, "Access password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("reading access password: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return errors.New("the access password must not be empty")
+		}
+		if err := conf.SetAccessPassword(line); err != nil {
+			return fmt.Errorf("setting access password: %v", err)
+		}
+		fmt.Println("Access password set")
+	}
+	if allow != "" {
+		if err := conf.AllowDevice(allow); err != nil {
+			return fmt.Errorf("allowing device: %v", err)
+		}
+	}
+	if revoke != "" {
+		if err := conf.RevokeDevice(revoke); err != nil {
+			return fmt.Errorf("revoking device: %v", err)
+		}
+	}
+
+	u := conf.Unattended
+	fmt.Printf("Unattended access: %v\nAccess id:         %s\nAccess password:   %v\n",
+		u.Enabled, conf.AccessID, u.AccessVerifier != "")
+	fmt.Println("Allowed devices:")
+	for _, d := range u.AllowedDevices {
+		fmt.Printf("  %s\n", d)
+	}
+	if len(u.AllowedUsers) > 0 {
+		fmt.Printf("Allowed devices are restricted to the users %s\n", strings.Join(u.AllowedUsers, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package jitter
+
+import (
+	"errors"
+
+	"github.com/pion/rtp/codecs"
+)
+
+// Bits of the OBU header
+const (
+	obuTypeShift          = 3
+	obuTypeMask           = 0x0f
+	obuExtensionFlag      = 0x04
+	obuHasSizeField       = 0x02
+	obuTemporalDelimiter  = 2
+	av1ContinuationFlag   = 0x80 // Z of the aggregation header
+	leb128ContinuationBit = 0x80
+)
+
+// Temporal delimiter OBU with a size field of 0 which starts every temporal unit
+var temporalDelimiter = []byte{obuTemporalDelimiter<<obuTypeShift | obuHasSizeField, 0}
+
+var errMissingFragment = errors.New("continued AV1 OBU fragment without its start")
+
+// Depacketizes AV1 into temporal units of OBUs with size fields, the stream format av1parse
+// takes. OBUs fragmented across packets are joined, so each frame needs a new depacketizer.
+// Elements which already carry size fields are passed on as they are, which is how the pion
+// payloader sends whole temporal units
+type av1Depacketizer struct {
+	started  bool
+	fragment []byte // Start of the OBU continued in the next packet
+}
+
+func (d *av1Depacketizer) IsPartitionHead(payload []byte) bool {
+	return len(payload) > 0 && payload[0]&av1ContinuationFlag == 0
+}
+
+func (d *av1Depacketizer) IsPartitionTail(marker bool, _ []byte) bool {
+	return marker
+}
+
+func (d *av1Depacketizer) Unmarshal(payload []byte) ([]byte, error) {
+	var p codecs.AV1Packet
+	if _, err := p.Unmarshal(payload); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	for i, obu := range p.OBUElements {
+		if i == 0 && p.Z {
+			if d.fragment == nil {
+				return nil, errMissingFragment
+			}
+			obu = append(d.fragment, obu...)
+			d.fragment = nil
+		}
+		if i == len(p.OBUElements)-1 && p.Y {
+			d.fragment = append([]byte(nil), obu...)
+			break
+		}
+		out = d.appendOBU(out, obu)
+	}
+	return out, nil
+}
+
+// Appends the OBU with a size field. The first one is preceded by a temporal delimiter unless
+// it is one and later delimiters on their own are dropped
+func (d *av1Depacketizer) appendOBU(out, obu []byte) []byte {
+	if len(obu) == 0 {
+		return out
+	}
+	header := obu[0]
+	delimiter := header>>obuTypeShift&obuTypeMask == obuTemporalDelimiter
+	if !d.started {
+		d.started = true
+		if !delimiter {
+			out = append(out, temporalDelimiter...)
+		}
+	} else if delimiter && len(obu) <= len(temporalDelimiter) {
+		return out
+	}
+	if header&obuHasSizeField != 0 {
+		return append(out, obu...)
+	}
+
+	n := 1
+	if header&obuExtensionFlag != 0 {
+		n = 2
+	}
+	if len(obu) < n {
+		return out
+	}
+	out = append(out, header|obuHasSizeField)
+	out = append(out, obu[1:n]...)
+	out = appendLEB128(out, uint(len(obu)-n))
+	return append(out, obu[n:]...)
+}
+
+func appendLEB128(out []byte, v uint) []byte {
+	for v >= leb128ContinuationBit {
+		out = append(out, byte(v)|leb128ContinuationBit)
+		v >>= 7
+	}
+	return append(out, byte(v))
+}
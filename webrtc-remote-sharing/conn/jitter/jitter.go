@@ -0,0 +1,262 @@
+package jitter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+// Time a missing packet is waited for before the frame it belongs to is given up. A lost packet
+// is resent after the NACK interval of the receiver and a round trip
+const DefaultMaxDelay = 200 * time.Millisecond
+
+// Packets buffered behind a missing one. Missing packets are given up early if more arrive
+const bufferSize = 512
+
+var ErrUnsupportedCodec = errors.New("unsupported codec")
+
+// Frame assembled from the RTP packets of one timestamp
+type Frame struct {
+	Data      []byte        // Elementary stream of the codec, H.264 in Annex B byte-stream format
+	Timestamp uint32        // RTP timestamp of the packets
+	PTS       time.Duration // Time since the first frame following the RTP timestamps
+	AfterLoss bool          // Packets before the frame were lost, so it may not decode until the next key frame
+}
+
+// Counters of the buffer since it was created
+type Stats struct {
+	Packets int64 // Packets pushed
+	Late    int64 // Packets which arrived after their frame was released or given up, and duplicates
+	Lost    int64 // Packets which did not arrive in time
+	Frames  int64 // Frames released
+	Dropped int64 // Frames given up since packets were lost or could not be depacketized
+}
+
+type slot struct {
+	pkt     *rtp.Packet
+	arrival time.Time
+}
+
+// Reorders the RTP packets of a track and assembles them into frames. Missing packets are
+// waited for until the max delay passes so that retransmissions can fill the gaps
+type Buffer struct {
+	newDepacketizer func() rtp.Depacketizer
+	tail            rtp.Depacketizer // Finds the last packet of a frame, which is every packet of audio
+	clockRate       uint32
+	maxDelay        time.Duration
+	now             func() time.Time
+
+	mu       sync.Mutex
+	ring     [bufferSize]slot
+	started  bool
+	next     uint16    // Sequence number of the next packet to release
+	buffered int       // Packets in the ring
+	waiting  time.Time // Arrival of the oldest packet behind a missing one
+	lateRun  int       // Late packets in a row, which means the sender restarted its sequence numbers
+	frame    []*rtp.Packet
+	loss     bool
+	skipping bool   // The rest of the packets of the given up frame are dropped
+	skipTS   uint32 // Timestamp of the given up frame
+
+	timed   bool
+	lastTS  uint32
+	elapsed int64 // Ticks of the clock rate since the first frame
+
+	stats Stats
+}
+
+// Returns a buffer for the codec of the MIME type. A max delay of 0 is DefaultMaxDelay
+func New(mimeType string, clockRate uint32, maxDelay time.Duration) (*Buffer, error) {
+	var f func() rtp.Depacketizer
+	switch strings.ToLower(mimeType) {
+	case "video/h264":
+		f = func() rtp.Depacketizer { return &codecs.H264Packet{} }
+	case "video/vp8":
+		f = func() rtp.Depacketizer { return &codecs.VP8Packet{} }
+	case "video/vp9":
+		f = func() rtp.Depacketizer { return &codecs.VP9Packet{} }
+	case "video/av1":
+		f = func() rtp.Depacketizer { return &av1Depacketizer{} }
+	case "audio/opus":
+		f = func() rtp.Depacketizer { return &codecs.OpusPacket{} }
+	default:
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedCodec, mimeType)
+	}
+	if clockRate == 0 {
+		return nil, errors.New("clock rate must not be 0")
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+	return &Buffer{newDepacketizer: f, tail: f(), clockRate: clockRate, maxDelay: maxDelay, now: time.Now}, nil
+}
+
+// Adds a packet and returns the frames it completes in order. The buffer keeps the packet
+// until its frame is released, so it must not be reused by the caller
+func (b *Buffer) Push(pkt *rtp.Packet) []Frame {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stats.Packets++
+	now := b.now()
+	if !b.started {
+		b.started = true
+		b.next = pkt.SequenceNumber
+	}
+
+	var frames []Frame
+	if int16(pkt.SequenceNumber-b.next) < 0 {
+		b.stats.Late++
+		if b.lateRun++; b.lateRun < bufferSize {
+			return nil
+		}
+		// Start over at the new sequence numbers
+		for b.buffered > 0 {
+			b.advance(&frames)
+		}
+		b.giveUpFrame()
+		b.next = pkt.SequenceNumber
+	}
+	b.lateRun = 0
+
+	// The packets too far behind the new one are released or given up
+	for pkt.SequenceNumber-b.next >= bufferSize {
+		b.advance(&frames)
+	}
+	s := &b.ring[pkt.SequenceNumber%bufferSize]
+	if s.pkt != nil {
+		b.stats.Late++
+		return frames
+	}
+	s.pkt, s.arrival = pkt, now
+	b.buffered++
+
+	for {
+		for b.ring[b.next%bufferSize].pkt != nil {
+			b.advance(&frames)
+		}
+		if b.buffered == 0 {
+			b.waiting = time.Time{}
+			break
+		}
+		if b.waiting.IsZero() {
+			b.waiting = b.oldestArrival()
+		}
+		if now.Sub(b.waiting) < b.maxDelay {
+			break
+		}
+		for b.ring[b.next%bufferSize].pkt == nil {
+			b.advance(&frames)
+		}
+		b.waiting = time.Time{}
+	}
+	return frames
+}
+
+// Returns the counters of the buffer
+func (b *Buffer) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// Releases the next packet into the frame being assembled or gives it up if it is missing
+func (b *Buffer) advance(frames *[]Frame) {
+	s := &b.ring[b.next%bufferSize]
+	b.next++
+	if s.pkt == nil {
+		b.stats.Lost++
+		b.giveUpFrame()
+		return
+	}
+	pkt := s.pkt
+	s.pkt = nil
+	b.buffered--
+
+	end := b.tail.IsPartitionTail(pkt.Marker, pkt.Payload)
+	if b.skipping && pkt.Timestamp == b.skipTS {
+		b.skipping = !end
+		return
+	}
+	b.skipping = false
+	// The marker of the previous frame may have been missing
+	if len(b.frame) > 0 && pkt.Timestamp != b.frame[0].Timestamp {
+		b.assemble(frames)
+	}
+	b.frame = append(b.frame, pkt)
+	if end {
+		b.assemble(frames)
+	}
+}
+
+// Drops the packets of the frame being assembled after a missing packet
+func (b *Buffer) giveUpFrame() {
+	if len(b.frame) > 0 {
+		b.stats.Dropped++
+		b.skipping, b.skipTS = true, b.frame[0].Timestamp
+	}
+	b.frame = b.frame[:0]
+	b.loss = true
+}
+
+// Depacketizes the packets of the current frame. Frames which do not start at the beginning of
+// a partition lost their first packets
+func (b *Buffer) assemble(frames *[]Frame) {
+	pkts := b.frame
+	b.frame = b.frame[:0]
+
+	d := b.newDepacketizer()
+	if !d.IsPartitionHead(pkts[0].Payload) {
+		b.stats.Dropped++
+		b.loss = true
+		return
+	}
+	size := 0
+	for _, pkt := range pkts {
+		size += len(pkt.Payload)
+	}
+	data := make([]byte, 0, size)
+	for _, pkt := range pkts {
+		part, err := d.Unmarshal(pkt.Payload)
+		if err != nil {
+			b.stats.Dropped++
+			b.loss = true
+			return
+		}
+		data = append(data, part...)
+	}
+	if len(data) == 0 {
+		return
+	}
+
+	b.stats.Frames++
+	*frames = append(*frames, Frame{Data: data, Timestamp: pkts[0].Timestamp, PTS: b.pts(pkts[0].Timestamp), AfterLoss: b.loss})
+	b.loss = false
+}
+
+// Returns the arrival of the first packet after the next one to release
+func (b *Buffer) oldestArrival() time.Time {
+	for seq := b.next; seq != b.next+bufferSize; seq++ {
+		if s := b.ring[seq%bufferSize]; s.pkt != nil {
+			return s.arrival
+		}
+	}
+	return b.now()
+}
+
+// Returns the time since the first frame. The RTP timestamps wrap around after 13 hours at
+// the clock rate of video
+func (b *Buffer) pts(ts uint32) time.Duration {
+	if !b.timed {
+		b.timed, b.lastTS = true, ts
+	}
+	b.elapsed += int64(int32(ts - b.lastTS))
+	b.lastTS = ts
+	rate := int64(b.clockRate)
+	return time.Duration(b.elapsed/rate)*time.Second + time.Duration(b.elapsed%rate)*time.Second/time.Duration(rate)
+}
@@ -0,0 +1,257 @@
+package jitter
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+// Returns the packets of VP8 frames of the given number of packets each. The payload of every
+// packet is the index of its frame after the descriptor
+func vp8Frames(frames, packetsPerFrame int, size int) []*rtp.Packet {
+	var pkts []*rtp.Packet
+	seq := uint16(65530) // Wraps around within the test
+	for f := 0; f < frames; f++ {
+		for i := 0; i < packetsPerFrame; i++ {
+			payload := make([]byte, size)
+			if i == 0 {
+				payload[0] = 0x10 // Start of the partition
+			}
+			payload[1] = byte(f)
+			pkts = append(pkts, &rtp.Packet{
+				Header:  rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: uint32(f) * 3000, Marker: i == packetsPerFrame-1},
+				Payload: payload,
+			})
+			seq++
+		}
+	}
+	return pkts
+}
+
+func newTestBuffer(t testing.TB) (*Buffer, *time.Time) {
+	b, err := New("video/VP8", 90000, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestNew(t *testing.T) {
+	for _, mimeType := range []string{"video/H264", "video/vp8", "video/VP9", "video/AV1", "audio/opus"} {
+		if _, err := New(mimeType, 90000, 0); err != nil {
+			t.Errorf("%s: %v", mimeType, err)
+		}
+	}
+	if _, err := New("audio/PCMU", 8000, 0); !errors.Is(err, ErrUnsupportedCodec) {
+		t.Errorf("got %v, want %v", err, ErrUnsupportedCodec)
+	}
+}
+
+func TestInOrder(t *testing.T) {
+	b, _ := newTestBuffer(t)
+	var frames []Frame
+	for _, pkt := range vp8Frames(10, 3, 100) {
+		frames = append(frames, b.Push(pkt)...)
+	}
+	if len(frames) != 10 {
+		t.Fatalf("got %d frames, want 10", len(frames))
+	}
+	for i, f := range frames {
+		if len(f.Data) != 3*99 || f.Data[0] != byte(i) {
+			t.Errorf("frame %d: got %d bytes of frame %d", i, len(f.Data), f.Data[0])
+		}
+		if want := time.Duration(i) * time.Second / 30; f.PTS != want {
+			t.Errorf("frame %d: got PTS %s, want %s", i, f.PTS, want)
+		}
+		if f.AfterLoss {
+			t.Errorf("frame %d follows a loss", i)
+		}
+	}
+	if got, want := b.Stats(), (Stats{Packets: 30, Frames: 10}); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestReordering(t *testing.T) {
+	b, now := newTestBuffer(t)
+	pkts := vp8Frames(4, 3, 10)
+	// The second frame arrives backwards and the first packet of the third one last
+	order := []int{0, 1, 2, 5, 4, 3, 7, 8, 9, 10, 11, 6}
+	var frames []Frame
+	for _, i := range order {
+		*now = now.Add(10 * time.Millisecond)
+		frames = append(frames, b.Push(pkts[i])...)
+	}
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, want 4", len(frames))
+	}
+	for i, f := range frames {
+		if f.Data[0] != byte(i) || f.AfterLoss {
+			t.Errorf("frame %d: got frame %d, after loss %t", i, f.Data[0], f.AfterLoss)
+		}
+	}
+	if s := b.Stats(); s.Lost != 0 || s.Late != 0 || s.Dropped != 0 {
+		t.Errorf("got stats %+v, want no losses", s)
+	}
+}
+
+// A missing packet is waited for until the max delay passes. Its frame is dropped and the next
+// one marked. The packet arriving afterwards is late
+func TestLoss(t *testing.T) {
+	b, now := newTestBuffer(t)
+	pkts := vp8Frames(4, 3, 10)
+	var frames []Frame
+	for i, pkt := range pkts {
+		if i == 4 {
+			continue
+		}
+		*now = now.Add(10 * time.Millisecond)
+		frames = append(frames, b.Push(pkt)...)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("got %d frames before the max delay, want 1", len(frames))
+	}
+
+	*now = now.Add(100 * time.Millisecond)
+	frames = append(frames, b.Push(vp8Frames(5, 3, 10)[12])...)
+	frames = append(frames, b.Push(pkts[4])...)
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(frames))
+	}
+	if frames[1].Data[0] != 2 || !frames[1].AfterLoss || frames[2].AfterLoss {
+		t.Errorf("got frames %d and %d after the loss, marked %t and %t, want 2 marked and 3",
+			frames[1].Data[0], frames[2].Data[0], frames[1].AfterLoss, frames[2].AfterLoss)
+	}
+	if got, want := b.Stats(), (Stats{Packets: 13, Late: 1, Lost: 1, Frames: 3, Dropped: 1}); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
+// Packets far ahead of a missing one release the frames before them right away
+func TestBufferFull(t *testing.T) {
+	b, _ := newTestBuffer(t)
+	pkts := vp8Frames(bufferSize/2+2, 2, 10)
+	var frames []Frame
+	for i, pkt := range pkts {
+		if i != 2 {
+			frames = append(frames, b.Push(pkt)...)
+		}
+	}
+	s := b.Stats()
+	if s.Lost != 1 || s.Dropped != 1 || len(frames) < 2 || frames[1].Data[0] != 2 || !frames[1].AfterLoss {
+		t.Errorf("got %d frames and stats %+v, want the second frame lost", len(frames), s)
+	}
+}
+
+func TestH264(t *testing.T) {
+	b, err := New("video/H264", 90000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nalu := append([]byte{0x65}, bytes.Repeat([]byte{0xab}, 3000)...)
+	payloads := (&codecs.H264Payloader{}).Payload(1200, append([]byte{0, 0, 0, 1}, nalu...))
+	var frames []Frame
+	for i, p := range payloads {
+		frames = append(frames, b.Push(&rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: uint16(i), Timestamp: 90000, Marker: i == len(payloads)-1},
+			Payload: p,
+		})...)
+	}
+	if len(frames) != 1 || !bytes.Equal(frames[0].Data, append([]byte{0, 0, 0, 1}, nalu...)) {
+		t.Errorf("got %d frames, want the NAL unit in Annex B format", len(frames))
+	}
+}
+
+func TestAV1(t *testing.T) {
+	// A sequence header and a frame OBU without size fields, the second one fragmented
+	header := []byte{1 << obuTypeShift, 0x0a, 0x0b}
+	frame := append([]byte{6<<obuTypeShift | obuExtensionFlag, 0x08}, bytes.Repeat([]byte{0xcd}, 200)...)
+	payloads := [][]byte{
+		append(append([]byte{0x20 | 0x40, byte(len(header))}, header...), frame[:100]...), // W=2, Y
+		append([]byte{0x80 | 0x10}, frame[100:]...),                                       // Z, W=1
+	}
+	b, err := New("video/AV1", 90000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frames []Frame
+	for i, p := range payloads {
+		frames = append(frames, b.Push(&rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: uint16(i), Timestamp: 0, Marker: i == len(payloads)-1},
+			Payload: p,
+		})...)
+	}
+	want := append([]byte{}, temporalDelimiter...)
+	want = append(want, header[0]|obuHasSizeField, 2, 0x0a, 0x0b)
+	want = append(want, frame[0]|obuHasSizeField, frame[1])
+	want = appendLEB128(want, 200)
+	want = append(want, frame[2:]...)
+	if len(frames) != 1 || !bytes.Equal(frames[0].Data, want) {
+		t.Fatalf("got %d frames, want the temporal unit with size fields", len(frames))
+	}
+
+	// The pion payloader sends the temporal unit as it is
+	tu := append(append([]byte{}, want...), want[2:]...)
+	b, _ = New("video/AV1", 90000, 0)
+	payloads = (&codecs.AV1Payloader{}).Payload(100, tu)
+	frames = nil
+	for i, p := range payloads {
+		frames = append(frames, b.Push(&rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: uint16(i), Marker: i == len(payloads)-1},
+			Payload: p,
+		})...)
+	}
+	if len(frames) != 1 || !bytes.Equal(frames[0].Data, tu) {
+		t.Errorf("got %d frames, want the temporal unit of the payloader", len(frames))
+	}
+}
+
+// Every Opus packet is a frame of its own. The marker bit only flags the start of talkspurts
+func TestOpus(t *testing.T) {
+	b, err := New("audio/opus", 48000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frames []Frame
+	for i, seq := range []uint16{0, 2, 1, 3} {
+		frames = append(frames, b.Push(&rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: seq, Timestamp: uint32(seq) * 960, Marker: i == 0},
+			Payload: []byte{0xf8, 0xff, byte(seq)},
+		})...)
+	}
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, want 4", len(frames))
+	}
+	for i, f := range frames {
+		if f.Data[2] != byte(i) || f.PTS != time.Duration(i)*20*time.Millisecond {
+			t.Errorf("frame %d: got packet %d at %s", i, f.Data[2], f.PTS)
+		}
+	}
+}
+
+// Per packet cost of frames of 5 packets of 1200 bytes with every 10th pair of packets swapped
+func BenchmarkPush(b *testing.B) {
+	buf, _ := newTestBuffer(b)
+	pkts := vp8Frames(1000, 5, 1200)
+	for i := 0; i+1 < len(pkts); i += 20 {
+		pkts[i], pkts[i+1] = pkts[i+1], pkts[i]
+	}
+	b.ReportAllocs()
+	b.SetBytes(1200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := i % len(pkts)
+		pkt := *pkts[n]
+		// Later rounds continue the sequence numbers and timestamps
+		round := i / len(pkts)
+		pkt.SequenceNumber += uint16(round * len(pkts))
+		pkt.Timestamp += uint32(round * 1000 * 3000)
+		buf.Push(&pkt)
+	}
+}
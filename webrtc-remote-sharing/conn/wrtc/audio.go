@@ -0,0 +1,60 @@
+package wrtc
+
+import (
+	"log"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Track ids which tell the audio tracks apart in OnTrack
+const (
+	AudioTrackID = "audio" // Sound of the host
+	VoiceTrackID = "voice" // Microphone of either peer
+)
+
+// Parameters of the Opus audio of the host. In-band FEC lets the remote conceal a lost packet
+// with the next one
+var opusParameters = webrtc.RTPCodecParameters{
+	RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2,
+		SDPFmtpLine: "minptime=10;useinbandfec=1"},
+	PayloadType: 111,
+}
+
+// Registers Opus which both peers always support, whether the host sends audio or not
+func registerAudio(m *webrtc.MediaEngine) error {
+	return m.RegisterCodec(opusParameters, webrtc.RTPCodecTypeAudio)
+}
+
+// Adds a send only Opus track for the sound of the host. It has to be added before the offer
+// of the remote is applied so that the answer sends it in the audio section of the offer
+func (pc *PeerConn) AddAudioTrack() (*webrtc.TrackLocalStaticSample, error) {
+	return addOpusTrack(pc.PeerConnection, AudioTrackID, "host", webrtc.RTPTransceiverDirectionSendonly)
+}
+
+// Adds the send and receive Opus track of the microphone. The remote adds it before its
+// receive only audio so that the host, which matches the sections of the offer by direction,
+// never answers the sound of the host with the voice
+func addVoiceTrack(pc *webrtc.PeerConnection, streamID string) (*webrtc.TrackLocalStaticSample, error) {
+	return addOpusTrack(pc, VoiceTrackID, streamID, webrtc.RTPTransceiverDirectionSendrecv)
+}
+
+func addOpusTrack(pc *webrtc.PeerConnection, id, streamID string, direction webrtc.RTPTransceiverDirection) (*webrtc.TrackLocalStaticSample, error) {
+	track, err := webrtc.NewTrackLocalStaticSample(opusParameters.RTPCodecCapability, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	transceiver, err := pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{Direction: direction})
+	if err != nil {
+		log.Printf("[ERR] Adding %s track: %v", id, err)
+		return nil, err
+	}
+	// The RTCP has to be read for the interceptors to process it
+	go func() {
+		for {
+			if _, _, err := transceiver.Sender().ReadRTCP(); err != nil {
+				return
+			}
+		}
+	}()
+	return track, nil
+}
@@ -0,0 +1,137 @@
+package wrtc
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// Tracks of one peer which are written in turns until the other peer received them
+type sendingTracks []*webrtc.TrackLocalStaticSample
+
+// Connects a remote and a host with the transceivers of NewRemote and NewHost. The host adds
+// its sound if asked to. Returns the tracks of both peers and the ids of the tracks each one
+// receives
+func newAudioPair(t *testing.T, audio bool) (remote, host *webrtc.PeerConnection, remoteTracks, hostTracks sendingTracks,
+	remoteReceived, hostReceived chan string) {
+	remote, host, _ = newVNetPairWithAPI(t, func(_ int, m *webrtc.MediaEngine, _ *interceptor.Registry) error {
+		if err := registerCodecs(m, []string{webrtc.MimeTypeVP8}); err != nil {
+			return err
+		}
+		return registerAudio(m)
+	})
+
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	voice, err := addVoiceTrack(remote, "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteTracks = append(remoteTracks, voice)
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+
+	video, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := host.AddTransceiverFromTrack(video,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+		t.Fatal(err)
+	}
+	if voice, err = addVoiceTrack(host, "host"); err != nil {
+		t.Fatal(err)
+	}
+	hostTracks = sendingTracks{video, voice}
+	if audio {
+		sound, err := (&PeerConn{PeerConnection: host}).AddAudioTrack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hostTracks = append(hostTracks, sound)
+	}
+
+	remoteReceived, hostReceived = make(chan string, 3), make(chan string, 3)
+	remote.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) { remoteReceived <- tr.ID() })
+	host.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) { hostReceived <- tr.ID() })
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, remote, host, offer)
+	answer, err := host.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, host, remote, answer)
+	return remote, host, remoteTracks, hostTracks, remoteReceived, hostReceived
+}
+
+// Writes samples to the tracks until the given number of them was received and a moment
+// longer to catch tracks which should not arrive. Returns the sorted ids of the received tracks
+func receiveTracks(t *testing.T, tracks sendingTracks, received chan string, want int) []string {
+	t.Helper()
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+	var ids []string
+	var settled <-chan time.Time
+	for {
+		select {
+		case id := <-received:
+			if ids = append(ids, id); len(ids) == want {
+				settled = time.After(200 * time.Millisecond)
+			}
+		case <-ticker.C:
+			for _, track := range tracks {
+				data := []byte{0xf8, 0xff, 0xfe}
+				if track.Kind() == webrtc.RTPCodecTypeVideo {
+					data = []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}
+				}
+				if err := track.WriteSample(media.Sample{Data: data, Duration: 20 * time.Millisecond}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		case <-settled:
+			sort.Strings(ids)
+			return ids
+		case <-timeout:
+			t.Fatalf("received the tracks %v, want %d", ids, want)
+		}
+	}
+}
+
+func TestAudioTrack(t *testing.T) {
+	_, host, remoteTracks, hostTracks, remoteReceived, hostReceived := newAudioPair(t, true)
+	if sdp := host.LocalDescription().SDP; !strings.Contains(sdp, "opus/48000/2") || !strings.Contains(sdp, "msid:host audio") {
+		t.Errorf("answer does not send the sound:\n%s", sdp)
+	}
+	if got := strings.Join(receiveTracks(t, hostTracks, remoteReceived, 3), " "); got != "audio video voice" {
+		t.Errorf("remote received %s, want audio, video and voice", got)
+	}
+	if got := strings.Join(receiveTracks(t, remoteTracks, hostReceived, 1), " "); got != "voice" {
+		t.Errorf("host received %s, want voice", got)
+	}
+}
+
+// A host without sound only answers the voice although pion answers the audio section of the
+// remote too
+func TestNoAudioTrack(t *testing.T) {
+	_, _, remoteTracks, hostTracks, remoteReceived, hostReceived := newAudioPair(t, false)
+	if got := strings.Join(receiveTracks(t, hostTracks, remoteReceived, 2), " "); got != "video voice" {
+		t.Errorf("remote received %s, want video and voice", got)
+	}
+	if got := strings.Join(receiveTracks(t, remoteTracks, hostReceived, 1), " "); got != "voice" {
+		t.Errorf("host received %s, want voice", got)
+	}
+}
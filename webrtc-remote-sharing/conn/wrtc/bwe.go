@@ -0,0 +1,75 @@
+package wrtc
+
+import (
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Bitrate the estimate of the host starts from in bits per second. GCC probes upwards from
+// there, so starting low avoids flooding a slow link with the first key frames
+const initialBitrate = 1_000_000
+
+// Registers the congestion controller of the sending peer. Every RTP packet gets a transport
+// wide sequence number which the remote acknowledges in TWCC feedback, from which GCC
+// estimates the bandwidth from the delay and the loss of the packets. REMB is not read, pion
+// has no estimator for it. The estimator of the peer connection is passed to the callback
+// while the peer connection is created
+func registerEstimator(m *webrtc.MediaEngine, i *interceptor.Registry, onEstimator cc.NewPeerConnectionCallback) error {
+	// The encoder follows the estimate instead of a pacer holding back its frames which
+	// would only add latency
+	controller, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		bwe, err := gcc.NewSendSideBWE(gcc.SendSideBWEInitialBitrate(initialBitrate), gcc.SendSideBWEPacer(gcc.NewNoOpPacer()))
+		if err != nil {
+			return nil, err
+		}
+		return &estimator{BandwidthEstimator: bwe}, nil
+	})
+	if err != nil {
+		return err
+	}
+	controller.OnNewPeerConnection(onEstimator)
+	i.Add(controller)
+
+	return webrtc.ConfigureTWCCHeaderExtensionSender(m, i)
+}
+
+// Serializes the feedback with closing the estimator. GCC closes the channels the feedback is
+// written to, so feedback arriving while the peer connection is closed would panic
+type estimator struct {
+	cc.BandwidthEstimator
+	mu     sync.Mutex
+	closed bool
+}
+
+func (e *estimator) WriteRTCP(pkts []rtcp.Packet, attributes interceptor.Attributes) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+	return e.BandwidthEstimator.WriteRTCP(pkts, attributes)
+}
+
+func (e *estimator) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.BandwidthEstimator.Close()
+}
+
+// Returns the estimated bandwidth towards the remote in bits per second or 0 if the
+// peer connection does not estimate it i.e. it does not send video
+func (pc *PeerConn) TargetBitrate() int {
+	if pc.estimator == nil {
+		return 0
+	}
+	return pc.estimator.GetTargetBitrate()
+}
@@ -0,0 +1,93 @@
+package wrtc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// Sends video from a host with the congestion controller to a remote with the default
+// interceptors as in the application. The estimate moves once the TWCC feedback of the
+// remote arrives
+func TestEstimator(t *testing.T) {
+	codecs := []string{webrtc.MimeTypeVP8}
+	var estimator cc.BandwidthEstimator
+	remote, host, _ := newVNetPairWithAPI(t, func(i int, m *webrtc.MediaEngine, r *interceptor.Registry) error {
+		if i == 0 {
+			_, err := configureMedia(m, r, codecs, Recovery{}, nil)
+			return err
+		}
+		_, err := configureMedia(m, r, codecs, Recovery{}, func(_ string, e cc.BandwidthEstimator) { estimator = e })
+		return err
+	})
+	pc := &PeerConn{PeerConnection: host, estimator: estimator}
+	if got := pc.TargetBitrate(); got != initialBitrate {
+		t.Fatalf("initial estimate %d, want %d", got, initialBitrate)
+	}
+	if got := (&PeerConn{PeerConnection: remote}).TargetBitrate(); got != 0 {
+		t.Errorf("remote estimates %d", got)
+	}
+
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: codecs[0]}, "video", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transceiver, err := host.AddTransceiverFromTrack(track,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go pc.readRTCP(transceiver.Sender())
+	// The interceptors of the remote only see the packets which are read
+	remote.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			if _, _, err := tr.ReadRTP(); err != nil {
+				return
+			}
+		}
+	})
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, remote, host, offer)
+	answer, err := host.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, host, remote, answer)
+	if sdp := host.LocalDescription().SDP; !strings.Contains(sdp, "transport-wide-cc") {
+		t.Fatalf("answer does not negotiate transport wide sequence numbers:\n%s", sdp)
+	}
+	if sdp := host.LocalDescription().SDP; strings.Contains(sdp, "goog-remb") {
+		t.Fatalf("answer offers REMB feedback which is not read:\n%s", sdp)
+	}
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+	frame := make([]byte, 4000)
+	for {
+		select {
+		case <-ticker.C:
+			if err := track.WriteSample(media.Sample{Data: frame, Duration: 20 * time.Millisecond}); err != nil {
+				t.Fatal(err)
+			}
+			if pc.TargetBitrate() != initialBitrate {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("estimate stayed at %d: %v", pc.TargetBitrate(), estimator.GetStats())
+		}
+	}
+}
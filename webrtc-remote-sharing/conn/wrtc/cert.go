@@ -0,0 +1,94 @@
+package wrtc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Certificates generated by pion expire after a month. The device certificate is the identity
+// remotes pin so it is valid for much longer and only replaced once it has expired
+const certificateValidity = 10 * 365 * 24 * time.Hour
+
+// Returns the device certificate stored at path, generating and storing a new one if
+// the file does not exist or the certificate has expired
+func LoadCertificate(path string) (*webrtc.Certificate, error) {
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		log.Printf("[PC] No device certificate found. Generating one at %s", path)
+	case err != nil:
+		return nil, fmt.Errorf("reading certificate %s: %v", path, err)
+	default:
+		cert, err := webrtc.CertificateFromPEM(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate %s: %v", path, err)
+		}
+		if time.Now().Before(cert.Expires()) {
+			return cert, nil
+		}
+		log.Printf("[WARN] Device certificate expired on %s. Remotes will see a changed fingerprint", cert.Expires())
+	}
+
+	cert, err := newCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("generating certificate: %v", err)
+	}
+	pem, err := cert.PEM()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	// The file holds the private key of the device
+	if err := os.WriteFile(path, []byte(pem), 0o600); err != nil {
+		return nil, fmt.Errorf("writing certificate %s: %v", path, err)
+	}
+	return cert, nil
+}
+
+func newCertificate() (*webrtc.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return webrtc.NewCertificate(key, x509.Certificate{
+		Issuer:       pkix.Name{CommonName: "remygo"},
+		Subject:      pkix.Name{CommonName: "remygo"},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(certificateValidity),
+		SerialNumber: serial,
+		Version:      2,
+	})
+}
+
+// Returns the fingerprint of the certificate in the format of the SDP fingerprint attribute
+func Fingerprint(cert *webrtc.Certificate) (string, error) {
+	fingerprints, err := cert.GetFingerprints()
+	if err != nil {
+		return "", err
+	}
+	if len(fingerprints) == 0 {
+		return "", errors.New("certificate has no fingerprint")
+	}
+	return strings.ToLower(fingerprints[0].Algorithm + " " + fingerprints[0].Value), nil
+}
@@ -0,0 +1,42 @@
+package wrtc
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCertificate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device.pem")
+
+	first, err := LoadCertificate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoadCertificate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := Fingerprint(first)
+	got, _ := Fingerprint(second)
+	if got != want {
+		t.Errorf("fingerprint changed after reloading the certificate: got %s, want %s", got, want)
+	}
+
+	pc, _, err := newPeerConnection("stun:stun.l.google.com:19302", "", DefaultCodecs, second, Recovery{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := pc.CreateDataChannel("data", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp, _ := DescriptionFingerprint(offer.SDP); fp != want {
+		t.Errorf("offer fingerprint = %s, want device certificate %s", fp, want)
+	}
+}
@@ -0,0 +1,176 @@
+package wrtc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/webrtc/v3"
+)
+
+// Video codecs in the default order of preference. The software encoders of the older codecs
+// need less CPU for the same latency which matters more for screen sharing than the bitrate
+var DefaultCodecs = []string{webrtc.MimeTypeH264, webrtc.MimeTypeVP8, webrtc.MimeTypeVP9, webrtc.MimeTypeAV1}
+
+var ErrNoCommonCodec = errors.New("no video codec supported by both peers")
+
+// Feedback of every video codec. NACKs for retransmissions follow the recovery settings. REMB
+// is not offered since the estimator only reads TWCC feedback
+var videoRTCPFeedback = []webrtc.RTCPFeedback{{Type: "ccm", Parameter: "fir"},
+	{Type: "nack", Parameter: "pli"}}
+
+// Parameters the codecs are registered with. The payload types are the ones of the pion defaults
+var codecParameters = map[string]webrtc.RTPCodecParameters{
+	strings.ToLower(webrtc.MimeTypeH264): {
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: videoRTCPFeedback},
+		PayloadType: 125,
+	},
+	strings.ToLower(webrtc.MimeTypeVP8): {
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        96,
+	},
+	strings.ToLower(webrtc.MimeTypeVP9): {
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000,
+			SDPFmtpLine: "profile-id=0", RTCPFeedback: videoRTCPFeedback},
+		PayloadType: 98,
+	},
+	strings.ToLower(webrtc.MimeTypeAV1): {
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        45,
+	},
+}
+
+// Returns the codecs of the preferred list which are also supported, in the order of preference.
+// Names are compared case insensitively and codecs unknown to this package are dropped
+func Codecs(preferred, supported []string) []string {
+	var codecs []string
+	for _, p := range preferred {
+		if _, ok := codecParameters[strings.ToLower(p)]; !ok {
+			continue
+		}
+		for _, s := range supported {
+			if strings.EqualFold(p, s) && !containsCodec(codecs, p) {
+				codecs = append(codecs, codecParameters[strings.ToLower(p)].MimeType)
+				break
+			}
+		}
+	}
+	return codecs
+}
+
+func containsCodec(codecs []string, name string) bool {
+	for _, c := range codecs {
+		if strings.EqualFold(c, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registers the video codecs in the given order which is the order of the codecs in the
+// descriptions created by the peer
+func registerCodecs(m *webrtc.MediaEngine, codecs []string) error {
+	if len(codecs) == 0 {
+		return ErrNoCommonCodec
+	}
+	for _, name := range codecs {
+		params, ok := codecParameters[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("unsupported codec %s", name)
+		}
+		if err := m.RegisterCodec(params, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns an API with only the given video codecs and the interceptors of the recovery
+// settings. A callback for the bandwidth estimator makes the peer connections estimate the
+// bandwidth. The repair stream is nil unless RTX or FEC are configured and serves only the
+// first peer connection of the API
+func newAPI(codecs []string, recovery Recovery, onEstimator cc.NewPeerConnectionCallback) (*webrtc.API, *repair, error) {
+	m, i := &webrtc.MediaEngine{}, &interceptor.Registry{}
+	rp, err := configureMedia(m, i, codecs, recovery, onEstimator)
+	if err != nil {
+		return nil, nil, err
+	}
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i)), rp, nil
+}
+
+// Registers the codecs and the interceptors of a peer. The same interceptors as the pion
+// defaults are registered except for the NACKs and the repair stream which follow the recovery
+// settings
+func configureMedia(m *webrtc.MediaEngine, i *interceptor.Registry, codecs []string, recovery Recovery,
+	onEstimator cc.NewPeerConnectionCallback) (*repair, error) {
+	if err := registerCodecs(m, codecs); err != nil {
+		return nil, err
+	}
+	if err := registerAudio(m); err != nil {
+		return nil, err
+	}
+	if onEstimator != nil {
+		if err := registerEstimator(m, i, onEstimator); err != nil {
+			return nil, err
+		}
+	}
+	rp, err := registerRecovery(m, i, codecs, recovery)
+	if err != nil {
+		return nil, err
+	}
+	if err := webrtc.ConfigureRTCPReports(i); err != nil {
+		return nil, err
+	}
+	return rp, webrtc.ConfigureTWCCSender(m, i)
+}
+
+// Picks the video codec once the offer of the remote has been applied and replaces the track
+// of the host if it was created with another codec. The offer lists the codecs both peers
+// support, of which the first one in the order of the host is used. The answer then only
+// carries the picked codec
+func (pc *PeerConn) SelectCodec(codecs []string, track *webrtc.TrackLocalStaticSample) (*webrtc.TrackLocalStaticSample, error) {
+	for _, tr := range pc.GetTransceivers() {
+		if tr.Kind() != webrtc.RTPCodecTypeVideo || tr.Sender() == nil {
+			continue
+		}
+
+		params := tr.Sender().GetParameters().Codecs
+		var offered []string
+		for _, c := range params {
+			offered = append(offered, c.MimeType)
+		}
+		common := Codecs(codecs, offered)
+		if len(common) == 0 {
+			return nil, fmt.Errorf("%w: offered %v, supported %v", ErrNoCommonCodec, offered, codecs)
+		}
+		log.Printf("[PC] Using %s of the offered codecs %v", common[0], offered)
+
+		if !strings.EqualFold(track.Codec().MimeType, common[0]) {
+			replacement, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: common[0]}, track.ID(), track.StreamID())
+			if err != nil {
+				return nil, err
+			}
+			if err := tr.Sender().ReplaceTrack(replacement); err != nil {
+				return nil, fmt.Errorf("replacing video track: %w", err)
+			}
+			track = replacement
+		}
+
+		// The payload types are the ones of the offer. The repair codecs of the picked one follow it
+		var picked []webrtc.RTPCodecParameters
+		for _, c := range params {
+			if strings.EqualFold(c.MimeType, common[0]) {
+				picked = append(picked, c)
+			}
+		}
+		if err := tr.SetCodecPreferences(append(picked, repairCodecs(params, picked)...)); err != nil {
+			return nil, err
+		}
+		return track, nil
+	}
+	return nil, errors.New("no video sender")
+}
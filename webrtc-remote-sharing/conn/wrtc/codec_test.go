@@ -0,0 +1,177 @@
+package wrtc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+func TestCodecs(t *testing.T) {
+	for _, tc := range []struct {
+		name                 string
+		preferred, supported []string
+		want                 []string
+	}{
+		{"order of preference", DefaultCodecs, []string{"video/AV1", "video/vp8"}, []string{webrtc.MimeTypeVP8, webrtc.MimeTypeAV1}},
+		{"unknown codec", []string{"video/theora", "video/VP9"}, DefaultCodecs, []string{webrtc.MimeTypeVP9}},
+		{"duplicates", []string{"video/vp8", "video/VP8"}, DefaultCodecs, []string{webrtc.MimeTypeVP8}},
+		{"no common codec", []string{webrtc.MimeTypeH264}, []string{webrtc.MimeTypeVP8}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Codecs(tc.preferred, tc.supported); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Negotiates a host which prefers AV1 with a remote which cannot decode it. The remote offers
+// as in the application and the host picks its most preferred codec of the offer
+func TestSelectCodec(t *testing.T) {
+	hostCodecs := []string{webrtc.MimeTypeAV1, webrtc.MimeTypeVP8, webrtc.MimeTypeVP9}
+	remote, host := newVNetPairWithCodecs(t, []string{webrtc.MimeTypeVP9, webrtc.MimeTypeVP8, webrtc.MimeTypeH264}, hostCodecs)
+
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: hostCodecs[0]}, "video", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := host.AddTransceiverFromTrack(track,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	remote.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		received <- tr.Codec().MimeType
+	})
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, remote, host, offer)
+
+	track, err = (&PeerConn{PeerConnection: host}).SelectCodec(hostCodecs, track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := track.Codec().MimeType; got != webrtc.MimeTypeVP8 {
+		t.Fatalf("selected %s, want %s", got, webrtc.MimeTypeVP8)
+	}
+
+	answer, err := host.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, host, remote, answer)
+	if sdp := host.LocalDescription().SDP; !strings.Contains(sdp, "VP8/90000") || strings.Contains(sdp, "VP9/90000") {
+		t.Errorf("answer does not carry only the selected codec:\n%s", sdp)
+	}
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case got := <-received:
+			if got != webrtc.MimeTypeVP8 {
+				t.Errorf("remote received %s, want %s", got, webrtc.MimeTypeVP8)
+			}
+			return
+		case <-ticker.C:
+			if err := track.WriteSample(media.Sample{Data: []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}, Duration: 20 * time.Millisecond}); err != nil {
+				t.Fatal(err)
+			}
+		case <-timeout:
+			t.Fatal("remote received no track")
+		}
+	}
+}
+
+// The answer keeps the repair codecs of the selected codec
+func TestSelectCodecRepair(t *testing.T) {
+	codecs := []string{webrtc.MimeTypeVP9, webrtc.MimeTypeVP8}
+	remote, host, _ := newVNetPairWithAPI(t, func(i int, m *webrtc.MediaEngine, _ *interceptor.Registry) error {
+		if err := registerCodecs(m, codecs); err != nil {
+			return err
+		}
+		return registerRepairCodecs(m, codecs, Recovery{RTX: true, FECGroup: 5})
+	})
+
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := host.AddTransceiverFromTrack(track,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	if err := host.SetRemoteDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&PeerConn{PeerConnection: host}).SelectCodec([]string{webrtc.MimeTypeVP8}, track); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := host.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"a=rtpmap:96 VP8/90000", "a=fmtp:97 apt=96", "a=rtpmap:49 flexfec-03/90000"} {
+		if !strings.Contains(answer.SDP, want) {
+			t.Errorf("answer lacks %q:\n%s", want, answer.SDP)
+		}
+	}
+	if strings.Contains(answer.SDP, "apt=98") {
+		t.Errorf("answer carries the RTX of VP9:\n%s", answer.SDP)
+	}
+}
+
+func TestSelectCodecNoCommonCodec(t *testing.T) {
+	remote, host := newVNetPairWithCodecs(t, []string{webrtc.MimeTypeVP8}, []string{webrtc.MimeTypeAV1})
+
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1}, "video", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := host.AddTransceiverFromTrack(track,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	if err := host.SetRemoteDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&PeerConn{PeerConnection: host}).SelectCodec([]string{webrtc.MimeTypeAV1}, track); !errors.Is(err, ErrNoCommonCodec) {
+		t.Errorf("got %v, want %v", err, ErrNoCommonCodec)
+	}
+}
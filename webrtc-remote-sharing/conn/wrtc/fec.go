@@ -0,0 +1,165 @@
+package wrtc
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/pion/rtp"
+)
+
+// Largest group of media packets protected by one FEC packet, the bits of the mask
+const maxFECGroup = 15
+
+// Length of the FEC header in front of the payload recovery
+const fecHeaderLen = 20
+
+var errFECPacket = errors.New("malformed FEC packet")
+
+// Protects consecutive media packets with the XOR of their headers and payloads. The packet
+// follows the flexible FEC of draft-ietf-payload-flexible-fec-scheme-03 for a single SSRC
+// with a 15 bit mask:
+//
+//	|R|F|P|X|  CC   |M| PT recovery |        length recovery        |
+//	|                          TS recovery                          |
+//	|   SSRCCount   |                    reserved                   |
+//	|                             SSRC                              |
+//	|            SN base            |k|           mask              |
+//	|                       payload recovery                        |
+//
+// Only the padding bit of the first byte is protected. Recovered packets have neither CSRCs
+// nor header extensions
+type fecPacket struct {
+	ssrc    uint32
+	base    uint16 // Sequence number of the first protected packet
+	mask    uint16 // Bit 14 is the packet at base, bit 13 the one after it and so on
+	flags   byte   // P bit of the first byte
+	pt      byte   // M bit and payload type
+	length  uint16
+	ts      uint32
+	payload []byte
+}
+
+// XOR of the group of media packets sent since the last FEC packet
+type fecEncoder struct {
+	size  int // Packets per group
+	count int
+	next  uint16 // Sequence number the group continues with
+	fec   fecPacket
+}
+
+// Adds a media packet to the group and returns the FEC packet once the group is full. A gap
+// in the sequence numbers starts a new group
+func (e *fecEncoder) add(header *rtp.Header, payload []byte) ([]byte, bool) {
+	if e.count > 0 && header.SequenceNumber != e.next {
+		e.count = 0
+	}
+	if e.count == 0 {
+		e.fec = fecPacket{ssrc: header.SSRC, base: header.SequenceNumber}
+	}
+	e.fec.xor(header, payload)
+	e.fec.mask |= 1 << (maxFECGroup - 1 - e.count)
+	e.count++
+	e.next = header.SequenceNumber + 1
+	if e.count < e.size {
+		return nil, false
+	}
+	e.count = 0
+	return e.fec.marshal(), true
+}
+
+// Adds a protected packet to the recovery fields
+func (f *fecPacket) xor(header *rtp.Header, payload []byte) {
+	var p byte
+	if header.Padding {
+		p = 0x20
+	}
+	f.flags ^= p
+	f.pt ^= header.PayloadType
+	if header.Marker {
+		f.pt ^= 0x80
+	}
+	f.length ^= uint16(len(payload))
+	f.ts ^= header.Timestamp
+	if len(payload) > len(f.payload) {
+		f.payload = append(f.payload, make([]byte, len(payload)-len(f.payload))...)
+	}
+	for i, b := range payload {
+		f.payload[i] ^= b
+	}
+}
+
+func (f *fecPacket) marshal() []byte {
+	buf := make([]byte, fecHeaderLen+len(f.payload))
+	buf[0] = f.flags
+	buf[1] = f.pt
+	binary.BigEndian.PutUint16(buf[2:], f.length)
+	binary.BigEndian.PutUint32(buf[4:], f.ts)
+	buf[8] = 1
+	binary.BigEndian.PutUint32(buf[12:], f.ssrc)
+	binary.BigEndian.PutUint16(buf[16:], f.base)
+	binary.BigEndian.PutUint16(buf[18:], 0x8000|f.mask)
+	copy(buf[fecHeaderLen:], f.payload)
+	return buf
+}
+
+func parseFEC(buf []byte) (fecPacket, error) {
+	if len(buf) < fecHeaderLen || buf[8] != 1 || buf[18]&0x80 == 0 {
+		return fecPacket{}, errFECPacket
+	}
+	return fecPacket{
+		flags:   buf[0] & 0x20,
+		pt:      buf[1],
+		length:  binary.BigEndian.Uint16(buf[2:]),
+		ts:      binary.BigEndian.Uint32(buf[4:]),
+		ssrc:    binary.BigEndian.Uint32(buf[12:]),
+		base:    binary.BigEndian.Uint16(buf[16:]),
+		mask:    binary.BigEndian.Uint16(buf[18:]) & 0x7fff,
+		payload: append([]byte(nil), buf[fecHeaderLen:]...),
+	}, nil
+}
+
+// Returns the sequence numbers of the protected packets
+func (f *fecPacket) protected() []uint16 {
+	var seqs []uint16
+	for i := 0; i < maxFECGroup; i++ {
+		if f.mask&(1<<(maxFECGroup-1-i)) != 0 {
+			seqs = append(seqs, f.base+uint16(i))
+		}
+	}
+	return seqs
+}
+
+// Restores the only protected packet with the given sequence number from the others, which
+// the lookup returns. The result is the marshaled packet
+func (f *fecPacket) recover(missing uint16, lookup func(uint16) (*rtp.Header, []byte)) ([]byte, error) {
+	r := fecPacket{flags: f.flags, pt: f.pt, length: f.length, ts: f.ts,
+		payload: append([]byte(nil), f.payload...)}
+	for _, seq := range f.protected() {
+		if seq == missing {
+			continue
+		}
+		header, payload := lookup(seq)
+		if header == nil {
+			return nil, errFECPacket
+		}
+		r.xor(header, payload)
+	}
+	if int(r.length) > len(r.payload) {
+		return nil, errFECPacket
+	}
+	// The payload is kept as it is together with any padding
+	header := rtp.Header{
+		Version:        2,
+		Padding:        r.flags&0x20 != 0,
+		Marker:         r.pt&0x80 != 0,
+		PayloadType:    r.pt & 0x7f,
+		SequenceNumber: missing,
+		Timestamp:      r.ts,
+		SSRC:           f.ssrc,
+	}
+	buf, err := header.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, r.payload[:r.length]...), nil
+}
@@ -0,0 +1,86 @@
+package wrtc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+// Any one packet of a group is restored from the FEC packet and the others
+func TestFECRecover(t *testing.T) {
+	var packets []rtp.Packet
+	for i := 0; i < 4; i++ {
+		packets = append(packets, rtp.Packet{
+			Header: rtp.Header{Version: 2, Marker: i == 3, PayloadType: 96, SequenceNumber: uint16(65534 + i),
+				Timestamp: uint32(3000 * (i / 2)), SSRC: 1234},
+			Payload: bytes.Repeat([]byte{byte(i + 1)}, 10*(i+1)),
+		})
+	}
+	e := fecEncoder{size: len(packets)}
+	var buf []byte
+	for i := range packets {
+		fec, ok := e.add(&packets[i].Header, packets[i].Payload)
+		if ok != (i == len(packets)-1) {
+			t.Fatalf("packet %d completed the group: %t", i, ok)
+		}
+		buf = fec
+	}
+	fec, err := parseFEC(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fec.protected(); len(got) != len(packets) || got[0] != 65534 || got[3] != 1 {
+		t.Fatalf("protected %v", got)
+	}
+
+	for missing := range packets {
+		lookup := func(seq uint16) (*rtp.Header, []byte) {
+			for i := range packets {
+				if i != missing && packets[i].SequenceNumber == seq {
+					return &packets[i].Header, packets[i].Payload
+				}
+			}
+			return nil, nil
+		}
+		data, err := fec.recover(packets[missing].SequenceNumber, lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := packets[missing].Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("restored packet %d\n%x\nwant\n%x", missing, data, want)
+		}
+	}
+
+	// Two missing packets cannot be restored
+	if _, err := fec.recover(65534, func(uint16) (*rtp.Header, []byte) { return nil, nil }); err == nil {
+		t.Error("restored a packet without the others")
+	}
+	if _, err := parseFEC(buf[:fecHeaderLen-1]); err == nil {
+		t.Error("parsed a truncated FEC packet")
+	}
+}
+
+// A gap in the sequence numbers starts a new group
+func TestFECGap(t *testing.T) {
+	e := fecEncoder{size: 2}
+	e.add(&rtp.Header{SequenceNumber: 10}, []byte{1})
+	buf, ok := e.add(&rtp.Header{SequenceNumber: 12}, []byte{2})
+	if ok {
+		t.Fatal("group completed across a gap")
+	}
+	if buf, ok = e.add(&rtp.Header{SequenceNumber: 13}, []byte{3}); !ok {
+		t.Fatal("group not completed")
+	}
+	fec, err := parseFEC(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fec.protected(); len(got) != 2 || got[0] != 12 {
+		t.Errorf("protected %v, want 12 and 13", got)
+	}
+}
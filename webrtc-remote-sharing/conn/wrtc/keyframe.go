@@ -0,0 +1,82 @@
+package wrtc
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Smallest interval between two key frames requested by the remote or forced on the encoder
+// of the host. A key frame takes a round trip to arrive, so the requests in between are
+// answered by the one already on its way
+const keyFrameInterval = 500 * time.Millisecond
+
+// Drops the key frame requests which follow the previous one too closely
+type keyFrameLimiter struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (l *keyFrameLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() && now.Sub(l.last) < keyFrameInterval {
+		return false
+	}
+	l.last = now
+	return true
+}
+
+// Asks the host for a key frame of the track with the given SSRC with a picture loss
+// indication. Called by the remote when it joins or cannot decode the video
+func (pc *PeerConn) RequestKeyFrame(ssrc uint32) error {
+	if !pc.keyFrames.allow(time.Now()) {
+		return nil
+	}
+	log.Println("[RTCP] Requesting key frame")
+	return pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}})
+}
+
+// Sets a callback which is invoked when the remote asks for a key frame with a picture loss
+// indication or a full intra request. Requests which follow the previous one too closely
+// are dropped
+func (pc *PeerConn) OnKeyFrameRequest(f func()) {
+	pc.mu.Lock()
+	pc.onKeyFrame = f
+	pc.mu.Unlock()
+}
+
+// Reads the RTCP the remote sends about the video until the connection is closed. The
+// interceptors only see the packets which are read e.g. the NACKs and the TWCC feedback
+func (pc *PeerConn) readRTCP(sender *webrtc.RTPSender) {
+	for {
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		pc.keyFrameRequested(pkts)
+	}
+}
+
+// Passes a key frame request of the remote on to the callback
+func (pc *PeerConn) keyFrameRequested(pkts []rtcp.Packet) {
+	requested := false
+	for _, pkt := range pkts {
+		switch pkt.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			requested = true
+		}
+	}
+	if !requested || !pc.keyFrames.allow(time.Now()) {
+		return
+	}
+	pc.mu.Lock()
+	f := pc.onKeyFrame
+	pc.mu.Unlock()
+	if f != nil {
+		f()
+	}
+}
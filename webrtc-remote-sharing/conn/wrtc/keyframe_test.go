@@ -0,0 +1,92 @@
+package wrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+func TestKeyFrameLimiter(t *testing.T) {
+	var l keyFrameLimiter
+	start := time.Now()
+	for _, tc := range []struct {
+		after time.Duration
+		want  bool
+	}{
+		{0, true},
+		{keyFrameInterval / 2, false},
+		{keyFrameInterval, true},
+		{keyFrameInterval + time.Millisecond, false},
+		{3 * keyFrameInterval, true},
+	} {
+		if got := l.allow(start.Add(tc.after)); got != tc.want {
+			t.Errorf("request after %s allowed %t, want %t", tc.after, got, tc.want)
+		}
+	}
+}
+
+// The remote asks for a key frame as soon as the track arrives. The host hears of it once
+// even though the remote asks twice in a row
+func TestRequestKeyFrame(t *testing.T) {
+	remote, host := newVNetPairWithCodecs(t, []string{webrtc.MimeTypeVP8}, []string{webrtc.MimeTypeVP8})
+
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transceiver, err := host.AddTransceiverFromTrack(track,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPC, remotePC := &PeerConn{PeerConnection: host}, &PeerConn{PeerConnection: remote}
+	requests := make(chan struct{}, 10)
+	hostPC.OnKeyFrameRequest(func() { requests <- struct{}{} })
+	go hostPC.readRTCP(transceiver.Sender())
+
+	remote.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for i := 0; i < 2; i++ {
+			if err := remotePC.RequestKeyFrame(uint32(tr.SSRC())); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, remote, host, offer)
+	answer, err := host.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, host, remote, answer)
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case <-requests:
+			// The second request of the remote is dropped by its limiter
+			time.Sleep(keyFrameInterval / 2)
+			if n := len(requests); n != 0 {
+				t.Errorf("host heard of %d more key frame requests", n)
+			}
+			return
+		case <-ticker.C:
+			if err := track.WriteSample(media.Sample{Data: []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}, Duration: 20 * time.Millisecond}); err != nil {
+				t.Fatal(err)
+			}
+		case <-timeout:
+			t.Fatal("host heard of no key frame request")
+		}
+	}
+}
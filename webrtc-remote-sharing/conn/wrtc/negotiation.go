@@ -0,0 +1,252 @@
+package wrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Label of the DataChannel which carries renegotiation once the peers are connected
+const ControlLabel = "control"
+
+// Stream id of the control channel. It is negotiated out of band so that both peers create
+// it with the peer connection and neither has to wait for the other to open it
+const controlID uint16 = 0
+
+var errOfferCollision = errors.New("offer collided with the local description")
+
+// Message on the control channel
+type controlMessage struct {
+	Description *webrtc.SessionDescription `json:"description,omitempty"`
+	Request     bool                       `json:"request,omitempty"` // Polite peer asks to send an offer
+	Grant       bool                       `json:"grant,omitempty"`   // Impolite peer waits for the offer of the polite peer
+}
+
+// Renegotiates the session with the perfect negotiation pattern. Offers and answers go over
+// the control DataChannel and only over the signaling server if it is not open. The impolite
+// peer ignores offers colliding with its own and the polite peer drops its offer to answer the
+// colliding one. pion rejects rolling back a local offer, so the polite peer only applies its
+// offers together with their answers and stays stable in between. Over the control channel the
+// polite peer also avoids collisions by asking the impolite peer before it offers, which holds
+// back its offers until the polite offer is answered. Offers over the signaling server, like
+// ICE restarts, may still collide. Only the impolite peer restarts ICE since its offers are
+// applied right away
+type Negotiator struct {
+	pc       *PeerConn
+	polite   bool
+	control  *webrtc.DataChannel
+	fallback func(webrtc.SessionDescription) error
+
+	mu        sync.Mutex                 // Serializes applying descriptions so that collisions are seen by the signaling state
+	started   bool                       // Whether the initial negotiation over the signaling server is done
+	requested bool                       // Polite peer asked to offer and waits for the grant
+	granted   bool                       // Polite peer may offer or the impolite peer waits for its offer
+	grantDue  bool                       // Impolite peer grants the request once its own offer is answered
+	pending   *webrtc.SessionDescription // Offer of the polite peer which is applied with its answer
+}
+
+// Returns a negotiator for the peer connection. The fallback sends a description over the
+// signaling server. It takes over the OnNegotiationNeeded callback and must be created on
+// both peers before the initial offer
+func NewNegotiator(pc *PeerConn, polite bool, fallback func(webrtc.SessionDescription) error) (*Negotiator, error) {
+	negotiated, id := true, controlID
+	control, err := pc.CreateDataChannel(ControlLabel, &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &id})
+	if err != nil {
+		return nil, fmt.Errorf("creating control channel: %w", err)
+	}
+
+	n := &Negotiator{pc: pc, polite: polite, control: control}
+	n.fallback = func(desc webrtc.SessionDescription) error {
+		return fallback(pc.declareRepair(desc))
+	}
+	control.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if err := n.onControl(msg.Data); err != nil {
+			log.Printf("[ERR] Renegotiating: %v", err)
+		}
+	})
+	// pion runs the callback in its operation queue which the offer must not block
+	pc.OnNegotiationNeeded(func() {
+		go func() {
+			if err := n.offer(); err != nil {
+				log.Printf("[ERR] Renegotiating: %v", err)
+			}
+		}()
+	})
+	return n, nil
+}
+
+// Lets changes of the peer connection trigger offers. Must be called once the initial
+// offer and answer are applied since those are exchanged by the application
+func (n *Negotiator) Start() {
+	n.mu.Lock()
+	n.started = true
+	n.mu.Unlock()
+}
+
+// Applies a description received over the signaling server. Offers are answered the same way
+func (n *Negotiator) HandleDescription(desc webrtc.SessionDescription) error {
+	return n.handle(desc, n.fallback)
+}
+
+// Sends an offer with new ICE credentials. It always goes over the signaling server since
+// the control channel runs over the connection which is being restored
+func (n *Negotiator) Restart() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	log.Println("[SDP] Creating restart offer")
+	offer, err := n.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return err
+	}
+	if err := n.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	return n.fallback(offer)
+}
+
+func (n *Negotiator) onControl(data []byte) error {
+	var msg controlMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return fmt.Errorf("reading control message: %w", err)
+	}
+
+	switch {
+	case msg.Description != nil:
+		log.Printf("[SDP] Received %s over the control channel", msg.Description.Type)
+		return n.handle(*msg.Description, n.send)
+	case msg.Request && !n.polite:
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.pc.SignalingState() != webrtc.SignalingStateStable {
+			n.grantDue = true
+			return nil
+		}
+		return n.grant()
+	case msg.Grant && n.polite:
+		n.mu.Lock()
+		n.granted = true
+		n.mu.Unlock()
+		return n.offer()
+	}
+	return nil
+}
+
+// Lets the polite peer offer. Must be called with the lock held
+func (n *Negotiator) grant() error {
+	log.Println("[SDP] Letting the other peer offer")
+	n.granted, n.grantDue = true, false
+	return n.sendControl(controlMessage{Grant: true})
+}
+
+func (n *Negotiator) offer() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// pion asks again once the signaling state is stable if changes are left
+	if !n.started || n.pc.SignalingState() != webrtc.SignalingStateStable || n.pending != nil {
+		return nil
+	}
+	switch {
+	case !n.polite && n.granted:
+		return nil
+	case n.polite && !n.granted && n.control.ReadyState() == webrtc.DataChannelStateOpen:
+		if n.requested {
+			return nil
+		}
+		log.Println("[SDP] Negotiation needed. Asking the other peer to offer")
+		n.requested = true
+		return n.sendControl(controlMessage{Request: true})
+	}
+
+	log.Println("[SDP] Negotiation needed. Creating offer")
+	offer, err := n.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if n.polite {
+		n.pending = &offer
+	} else if err := n.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	return n.send(offer)
+}
+
+func (n *Negotiator) handle(desc webrtc.SessionDescription, reply func(webrtc.SessionDescription) error) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch {
+	case desc.Type == webrtc.SDPTypeOffer && n.pc.SignalingState() != webrtc.SignalingStateStable:
+		if !n.polite {
+			log.Println("[SDP] Ignoring colliding offer")
+			return nil
+		}
+		return errOfferCollision
+	case desc.Type == webrtc.SDPTypeOffer && n.pending != nil:
+		// The impolite peer ignores the dropped offer. Its changes are offered again once the
+		// colliding offer is answered
+		log.Println("[SDP] Dropping the local offer for the colliding offer")
+		n.pending = nil
+	case desc.Type != webrtc.SDPTypeOffer && n.pending != nil:
+		log.Println("[PC] Setting local description")
+		err := n.pc.SetLocalDescription(*n.pending)
+		n.pending = nil
+		if err != nil {
+			return fmt.Errorf("setting local offer: %w", err)
+		}
+	case desc.Type != webrtc.SDPTypeOffer && n.pc.SignalingState() == webrtc.SignalingStateStable:
+		// The answer overtook the colliding offer of the impolite peer, which was created after
+		// applying the dropped offer and carries its changes
+		log.Println("[SDP] Ignoring answer to a dropped offer")
+		return nil
+	}
+
+	log.Println("[PC] Setting remote description")
+	if err := n.pc.SetRemoteDescription(desc); err != nil {
+		return fmt.Errorf("setting remote description: %w", err)
+	}
+	if desc.Type != webrtc.SDPTypeOffer {
+		if n.polite {
+			n.requested, n.granted = false, false
+		} else if n.grantDue {
+			return n.grant()
+		}
+		return nil
+	}
+
+	log.Println("[SDP] Creating Answer")
+	answer, err := n.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := n.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	if !n.polite {
+		n.granted = false
+	}
+	return reply(answer)
+}
+
+// Sends a description over the control channel or the signaling server if it is not open
+func (n *Negotiator) send(desc webrtc.SessionDescription) error {
+	desc = n.pc.declareRepair(desc)
+	if n.control.ReadyState() != webrtc.DataChannelStateOpen {
+		log.Printf("[SDP] Control channel is %s. Sending %s over the signaling server", n.control.ReadyState(), desc.Type)
+		return n.fallback(desc)
+	}
+	return n.sendControl(controlMessage{Description: &desc})
+}
+
+func (n *Negotiator) sendControl(msg controlMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return n.control.Send(data)
+}
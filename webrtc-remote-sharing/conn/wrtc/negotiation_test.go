@@ -0,0 +1,192 @@
+package wrtc
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Polls the condition until it holds or fails the test after a while
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Applies a description with all candidates since the initial negotiation is not under test
+func applyGathered(t *testing.T, from, to *webrtc.PeerConnection, desc webrtc.SessionDescription) {
+	t.Helper()
+	gathered := webrtc.GatheringCompletePromise(from)
+	if err := from.SetLocalDescription(desc); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	if err := to.SetRemoteDescription(*from.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Connects a remote and a polite host with negotiators. The fallback delivers descriptions
+// like the signaling server and counts them. A gate holds them back until it is closed
+func newNegotiatorPair(t *testing.T, gate <-chan struct{}) (remote, host *webrtc.PeerConnection, ns [2]*Negotiator, fallbacks *int32) {
+	remote, host = newVNetPair(t)
+	fallbacks = new(int32)
+
+	for i, pc := range []*webrtc.PeerConnection{remote, host} {
+		other := 1 - i
+		n, err := NewNegotiator(&PeerConn{PeerConnection: pc}, i == 1, func(desc webrtc.SessionDescription) error {
+			atomic.AddInt32(fallbacks, 1)
+			go func() {
+				if gate != nil {
+					<-gate
+				}
+				if err := ns[other].HandleDescription(desc); err != nil {
+					t.Error(err)
+				}
+			}()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ns[i] = n
+	}
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, remote, host, offer)
+	answer, err := host.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, host, remote, answer)
+
+	for _, n := range ns {
+		n := n
+		eventually(t, "the control channel", func() bool {
+			return n.control.ReadyState() == webrtc.DataChannelStateOpen
+		})
+		n.Start()
+	}
+	return remote, host, ns, fallbacks
+}
+
+func addTrack(t *testing.T, pc *webrtc.PeerConnection, id string) {
+	t.Helper()
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, id, "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Reports whether the other peer applied a description with the track and both are stable
+func negotiated(from, to *webrtc.PeerConnection, id string) func() bool {
+	return func() bool {
+		desc := to.CurrentRemoteDescription()
+		return desc != nil && strings.Contains(desc.SDP, "stream "+id) &&
+			from.SignalingState() == webrtc.SignalingStateStable &&
+			to.SignalingState() == webrtc.SignalingStateStable
+	}
+}
+
+func TestNegotiator(t *testing.T) {
+	t.Run("in band", func(t *testing.T) {
+		remote, host, _, fallbacks := newNegotiatorPair(t, nil)
+
+		addTrack(t, host, "audio")
+		eventually(t, "the track to be negotiated", negotiated(host, remote, "audio"))
+		if n := atomic.LoadInt32(fallbacks); n != 0 {
+			t.Errorf("%d descriptions went over the signaling server, want 0", n)
+		}
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		remote, host, _, _ := newNegotiatorPair(t, nil)
+
+		addTrack(t, remote, "remote-audio")
+		addTrack(t, host, "host-audio")
+		eventually(t, "the remote track to be negotiated", negotiated(remote, host, "remote-audio"))
+		eventually(t, "the host track to be negotiated", negotiated(host, remote, "host-audio"))
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		remote, host, ns, fallbacks := newNegotiatorPair(t, nil)
+
+		if err := ns[1].control.Close(); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, "the control channel to close", func() bool {
+			return ns[1].control.ReadyState() == webrtc.DataChannelStateClosed
+		})
+
+		addTrack(t, host, "audio")
+		eventually(t, "the track to be negotiated", negotiated(host, remote, "audio"))
+		if atomic.LoadInt32(fallbacks) == 0 {
+			t.Error("no description went over the signaling server")
+		}
+	})
+
+	// Both peers offer over the signaling server before either offer arrives
+	t.Run("collision over the signaling server", func(t *testing.T) {
+		gate := make(chan struct{})
+		remote, host, _, fallbacks := newCollidingPair(t, gate)
+
+		addTrack(t, remote, "remote-audio")
+		addTrack(t, host, "host-audio")
+		eventually(t, "both offers", func() bool { return atomic.LoadInt32(fallbacks) == 2 })
+		close(gate)
+
+		eventually(t, "the remote track to be negotiated", negotiated(remote, host, "remote-audio"))
+		eventually(t, "the host track to be negotiated", negotiated(host, remote, "host-audio"))
+	})
+
+	// The remote restarts ICE while the host renegotiates after the control channel closed
+	t.Run("restart collision", func(t *testing.T) {
+		gate := make(chan struct{})
+		remote, host, ns, fallbacks := newCollidingPair(t, gate)
+		before := iceUfrag(remote.CurrentLocalDescription().SDP)
+
+		addTrack(t, host, "host-audio")
+		eventually(t, "the host offer", func() bool { return atomic.LoadInt32(fallbacks) == 1 })
+		if err := ns[0].Restart(); err != nil {
+			t.Fatal(err)
+		}
+		close(gate)
+
+		eventually(t, "the host track to be negotiated", negotiated(host, remote, "host-audio"))
+		ufrag := iceUfrag(remote.CurrentLocalDescription().SDP)
+		if ufrag == before || iceUfrag(host.CurrentRemoteDescription().SDP) != ufrag {
+			t.Errorf("restart offer not applied: ufrag %s before, %s after", before, ufrag)
+		}
+	})
+}
+
+// Connects a pair whose descriptions only go over the signaling server
+func newCollidingPair(t *testing.T, gate <-chan struct{}) (remote, host *webrtc.PeerConnection, ns [2]*Negotiator, fallbacks *int32) {
+	remote, host, ns, fallbacks = newNegotiatorPair(t, gate)
+	for _, n := range ns {
+		if err := n.control.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range ns {
+		n := n
+		eventually(t, "the control channel to close", func() bool {
+			return n.control.ReadyState() == webrtc.DataChannelStateClosed
+		})
+	}
+	return remote, host, ns, fallbacks
+}
@@ -0,0 +1,89 @@
+package wrtc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/webrtc/v3"
+)
+
+// Settings of the recovery of lost video packets. The zero value retransmits lost packets on
+// NACKs of the receiver with the defaults of pion, which resends them on the stream of the
+// codec. With RTX or FEC the packets are repaired on a separate repair stream instead
+type Recovery struct {
+	DisableNACK  bool
+	NACKBuffer   int           // Packets the sender keeps for retransmission. A power of two, 0 is 1024
+	NACKInterval time.Duration // Interval of the NACKs of the receiver for missing packets, 0 is 100ms
+	RTX          bool          // Resends the packets in RTX packets of the repair stream
+	FECGroup     int           // Packets protected by one FEC packet, up to 15. 0 sends no FEC
+}
+
+var (
+	ErrNACKBuffer = errors.New("NACK buffer must be a power of two up to 32768")
+	ErrFECGroup   = fmt.Errorf("FEC group must have up to %d packets", maxFECGroup)
+)
+
+// Checks the settings before the first peer connection is created with them
+func (r Recovery) Check() error {
+	if r.NACKBuffer < 0 || r.NACKBuffer > 1<<15 || r.NACKBuffer&(r.NACKBuffer-1) != 0 {
+		return fmt.Errorf("%w: %d", ErrNACKBuffer, r.NACKBuffer)
+	}
+	if r.NACKInterval < 0 {
+		return fmt.Errorf("negative NACK interval %s", r.NACKInterval)
+	}
+	if r.RTX && r.DisableNACK {
+		return errors.New("RTX needs NACKs")
+	}
+	if r.FECGroup < 0 || r.FECGroup > maxFECGroup {
+		return fmt.Errorf("%w: %d", ErrFECGroup, r.FECGroup)
+	}
+	return nil
+}
+
+// Registers the generator of the NACKs of the receiver and what resends the packets of the
+// sender: the responder of pion or, with RTX or FEC, the repair stream which is returned. Both
+// peers register both but only use their side
+func registerRecovery(m *webrtc.MediaEngine, i *interceptor.Registry, codecs []string, r Recovery) (*repair, error) {
+	if err := r.Check(); err != nil {
+		return nil, err
+	}
+	if err := registerRepairCodecs(m, codecs, r); err != nil {
+		return nil, err
+	}
+	// The generator reads the video after the restored packets were added
+	var rp *repair
+	if r.RTX || r.FECGroup > 0 {
+		rp = newRepair(r)
+		i.Add(rp)
+	}
+	if r.DisableNACK {
+		return rp, nil
+	}
+
+	var generatorOpts []nack.GeneratorOption
+	if r.NACKInterval > 0 {
+		generatorOpts = append(generatorOpts, nack.GeneratorInterval(r.NACKInterval))
+	}
+	generator, err := nack.NewGeneratorInterceptor(generatorOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if rp == nil {
+		var responderOpts []nack.ResponderOption
+		if r.NACKBuffer > 0 {
+			responderOpts = append(responderOpts, nack.ResponderSize(uint16(r.NACKBuffer)))
+		}
+		responder, err := nack.NewResponderInterceptor(responderOpts...)
+		if err != nil {
+			return nil, err
+		}
+		i.Add(responder)
+	}
+
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	i.Add(generator)
+	return rp, nil
+}
@@ -0,0 +1,179 @@
+package wrtc
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/transport/vnet"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+func TestRecoveryCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		recovery Recovery
+		wantErr  bool
+	}{
+		{"defaults", Recovery{}, false},
+		{"power of two", Recovery{NACKBuffer: 4096, NACKInterval: 50 * time.Millisecond}, false},
+		{"not a power of two", Recovery{NACKBuffer: 1000}, true},
+		{"largest buffer", Recovery{NACKBuffer: 1 << 15}, false},
+		{"too large", Recovery{NACKBuffer: 1 << 16}, true},
+		{"negative interval", Recovery{NACKInterval: -time.Second}, true},
+		{"rtx", Recovery{RTX: true}, false},
+		{"rtx without nack", Recovery{RTX: true, DisableNACK: true}, true},
+		{"largest fec group", Recovery{FECGroup: 15}, false},
+		{"fec group too large", Recovery{FECGroup: 16}, true},
+		{"negative fec group", Recovery{FECGroup: -1}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.recovery.Check(); (err != nil) != tc.wantErr {
+				t.Errorf("got %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+	if err := (Recovery{NACKBuffer: 3}).Check(); !errors.Is(err, ErrNACKBuffer) {
+		t.Errorf("got %v, want %v", err, ErrNACKBuffer)
+	}
+	if err := (Recovery{FECGroup: 20}).Check(); !errors.Is(err, ErrFECGroup) {
+		t.Errorf("got %v, want %v", err, ErrFECGroup)
+	}
+}
+
+// Drops 2% of the packets from the host to the remote at random once the connection is up and
+// counts the packets of the track which reach the remote. The seed is fixed but the packets
+// in between depend on the timing, so a few may still be lost with recovery
+func TestRecovery(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		recovery Recovery
+		minRate  float64
+		maxRate  float64
+	}{
+		{"nack", Recovery{NACKInterval: 50 * time.Millisecond}, 0.95, 1},
+		{"rtx", Recovery{NACKInterval: 50 * time.Millisecond, RTX: true}, 0.95, 1},
+		{"fec", Recovery{DisableNACK: true, FECGroup: 5}, 0.95, 1},
+		{"disabled", Recovery{DisableNACK: true}, 0.9, 0.995},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sent, received := sendLossy(t, tc.recovery, 300)
+			rate := float64(received) / float64(sent)
+			t.Logf("%d of %d packets received, recovery rate %.3f", received, sent, rate)
+			if rate < tc.minRate || rate > tc.maxRate {
+				t.Errorf("received %.3f of the packets, want between %.3f and %.3f", rate, tc.minRate, tc.maxRate)
+			}
+		})
+	}
+}
+
+// Returns the number of packets the host sent and the number of distinct ones the remote read
+func sendLossy(t *testing.T, recovery Recovery, packets int) (sent, received int) {
+	codecs := []string{webrtc.MimeTypeVP8}
+	var repairs [2]*repair
+	remote, host, wan := newVNetPairWithAPI(t, func(i int, m *webrtc.MediaEngine, r *interceptor.Registry) error {
+		var err error
+		repairs[i], err = configureMedia(m, r, codecs, recovery, nil)
+		return err
+	})
+
+	var lossy int32
+	var lossMu sync.Mutex
+	loss := rand.New(rand.NewSource(1))
+	wan.AddChunkFilter(func(c vnet.Chunk) bool {
+		addr, ok := c.SourceAddr().(*net.UDPAddr)
+		if !ok || atomic.LoadInt32(&lossy) == 0 || !addr.IP.Equal(net.ParseIP("1.2.3.5")) {
+			return true
+		}
+		lossMu.Lock()
+		defer lossMu.Unlock()
+		return loss.Float64() >= 0.02
+	})
+
+	if _, err := remote.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: codecs[0]}, "video", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transceiver, err := host.AddTransceiverFromTrack(track,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go (&PeerConn{PeerConnection: host}).readRTCP(transceiver.Sender())
+
+	var mu sync.Mutex
+	seen := map[uint16]bool{}
+	remote.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			pkt, _, err := tr.ReadRTP()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			seen[pkt.SequenceNumber] = true
+			mu.Unlock()
+		}
+	})
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen)
+	}
+
+	offer, err := remote.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyGathered(t, remote, host, offer)
+	answer, err := host.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(host)
+	if err := host.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	pc := &PeerConn{PeerConnection: host, repair: repairs[1]}
+	if err := remote.SetRemoteDescription(pc.declareRepair(*host.LocalDescription())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first packet also signals the track to the remote so it is sent without loss
+	frame := []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}
+	deadline := time.Now().Add(10 * time.Second)
+	for count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("remote received no packet")
+		}
+		if err := track.WriteSample(media.Sample{Data: frame, Duration: 10 * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	before := count()
+	atomic.StoreInt32(&lossy, 1)
+
+	for i := 0; i < packets; i++ {
+		if err := track.WriteSample(media.Sample{Data: frame, Duration: 10 * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Retransmissions arrive within a few NACK intervals and the last FEC group is complete
+	deadline = time.Now().Add(2 * time.Second)
+	for count()-before < packets && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return packets, count() - before
+}
@@ -0,0 +1,581 @@
+package wrtc
+
+import (
+	"encoding/binary"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Codecs of the repair stream of the video
+const (
+	mimeTypeRTX     = "video/rtx"
+	mimeTypeFlexFEC = "video/flexfec-03"
+	fecPayloadType  = 49
+)
+
+// Payload types of the RTX of each video codec by the payload type of the codec. The remote
+// reads its repair stream with them, the host uses the ones of the description it sent
+var rtxPayloadTypes = map[webrtc.PayloadType]webrtc.PayloadType{125: 107, 96: 97, 98: 99, 45: 46}
+
+// The repair stream of a video stream has the SSRC of the video stream with these bits flipped
+const repairSSRCMask = 0x5a5a5a5a
+
+const (
+	receiveMTU    = 1460 // Size of the packets read, the one of pion
+	fecWindow     = 64   // Video packets the remote keeps for the recovery by FEC
+	seenWindow    = 1024 // Sequence numbers the remote remembers to drop duplicates
+	maxPendingFEC = 16   // FEC packets waiting for the other packets of their group
+)
+
+// Sends and reads the repair stream of the video. The host keeps the packets it sent, resends
+// the ones the remote reports with NACKs in RTX packets and protects groups of them with FEC
+// packets. The remote restores the lost packets from both and passes them on with the video.
+// pion only reads repair streams it knows from an FID group of the remote description, so FEC
+// travels in the repair stream too and the host declares it with declare. The same instance
+// serves as the factory of the only peer connection of an API
+type repair struct {
+	interceptor.NoOp
+	recovery Recovery
+
+	mu        sync.Mutex
+	rtxTypes  map[uint8]uint8 // Payload types of RTX by the ones of the codecs, as negotiated
+	fecType   uint8           // Payload type of FEC, 0 if it was not negotiated
+	senders   map[uint32]*repairSender
+	receivers map[uint32]*repairReceiver
+}
+
+func newRepair(r Recovery) *repair {
+	return &repair{recovery: r, senders: map[uint32]*repairSender{}, receivers: map[uint32]*repairReceiver{}}
+}
+
+func (rp *repair) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return rp, nil
+}
+
+// Registers the RTX of the given video codecs and FEC as configured. They follow the codecs
+// since pion matches an RTX codec with the codec of its apt when reading a description
+func registerRepairCodecs(m *webrtc.MediaEngine, codecs []string, r Recovery) error {
+	if r.RTX {
+		for _, name := range codecs {
+			params := codecParameters[strings.ToLower(name)]
+			rtx := webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeRTX, ClockRate: 90000,
+					SDPFmtpLine: "apt=" + strconv.Itoa(int(params.PayloadType))},
+				PayloadType: rtxPayloadTypes[params.PayloadType],
+			}
+			if err := m.RegisterCodec(rtx, webrtc.RTPCodecTypeVideo); err != nil {
+				return err
+			}
+		}
+	}
+	if r.FECGroup > 0 {
+		fec := webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeFlexFEC, ClockRate: 90000,
+				SDPFmtpLine: "repair-window=10000000"},
+			PayloadType: fecPayloadType,
+		}
+		if err := m.RegisterCodec(fec, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the repair codecs of the picked codecs among the given ones: FEC and the RTX whose
+// apt is one of the picked payload types
+func repairCodecs(params, picked []webrtc.RTPCodecParameters) []webrtc.RTPCodecParameters {
+	var codecs []webrtc.RTPCodecParameters
+	for _, c := range params {
+		switch {
+		case strings.EqualFold(c.MimeType, mimeTypeFlexFEC):
+			codecs = append(codecs, c)
+		case strings.EqualFold(c.MimeType, mimeTypeRTX):
+			for _, p := range picked {
+				if apt, ok := fmtpParameter(c.SDPFmtpLine, "apt"); ok && apt == strconv.Itoa(int(p.PayloadType)) {
+					codecs = append(codecs, c)
+				}
+			}
+		}
+	}
+	return codecs
+}
+
+func fmtpParameter(line, key string) (string, bool) {
+	for _, p := range strings.Split(line, ";") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], key) {
+			return kv[1], true
+		}
+	}
+	return "", false
+}
+
+// Returns the description to send with the repair stream of the video declared in an FID
+// group. The repair codecs it lists are the ones the host sends. Descriptions without a video
+// stream or repair codecs are returned as they are
+func (rp *repair) declare(desc webrtc.SessionDescription) webrtc.SessionDescription {
+	lines := strings.Split(desc.SDP, "\r\n")
+	video, grouped := false, false
+	first, last := -1, -1
+	var ssrc, cname string
+	rtx, apt := map[string]bool{}, map[string]string{}
+	var fecType uint8
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "m="):
+			video = strings.HasPrefix(line, "m=video ")
+		case !video:
+		case strings.HasPrefix(line, "a=rtpmap:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "a=rtpmap:"))
+			if len(fields) < 2 {
+				continue
+			}
+			name := strings.SplitN(fields[1], "/", 2)[0]
+			if strings.EqualFold("video/"+name, mimeTypeRTX) {
+				rtx[fields[0]] = true
+			} else if pt, err := strconv.ParseUint(fields[0], 10, 7); err == nil && strings.EqualFold("video/"+name, mimeTypeFlexFEC) {
+				fecType = uint8(pt)
+			}
+		case strings.HasPrefix(line, "a=fmtp:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "a=fmtp:"))
+			if len(fields) < 2 {
+				continue
+			}
+			if p, ok := fmtpParameter(fields[1], "apt"); ok {
+				apt[fields[0]] = p
+			}
+		case strings.HasPrefix(line, "a=ssrc-group:FID "):
+			grouped = true
+		case strings.HasPrefix(line, "a=ssrc:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "a=ssrc:"))
+			if first < 0 {
+				first, ssrc = i, fields[0]
+			}
+			if fields[0] == ssrc {
+				last = i
+				if len(fields) > 1 && strings.HasPrefix(fields[1], "cname:") {
+					cname = strings.TrimPrefix(fields[1], "cname:")
+				}
+			}
+		}
+	}
+
+	rtxTypes := map[uint8]uint8{}
+	for pt := range rtx {
+		media, err1 := strconv.ParseUint(apt[pt], 10, 7)
+		repair, err2 := strconv.ParseUint(pt, 10, 7)
+		if err1 == nil && err2 == nil {
+			rtxTypes[uint8(media)] = uint8(repair)
+		}
+	}
+	rp.mu.Lock()
+	rp.rtxTypes, rp.fecType = rtxTypes, fecType
+	rp.mu.Unlock()
+
+	media, err := strconv.ParseUint(ssrc, 10, 32)
+	if err != nil || grouped || (len(rtxTypes) == 0 && fecType == 0) {
+		return desc
+	}
+	repair := strconv.FormatUint(media^repairSSRCMask, 10)
+	declared := append(lines[:first:first], "a=ssrc-group:FID "+ssrc+" "+repair)
+	declared = append(declared, lines[first:last+1]...)
+	if cname != "" {
+		declared = append(declared, "a=ssrc:"+repair+" cname:"+cname)
+	}
+	declared = append(declared, lines[last+1:]...)
+	log.Printf("[SDP] Declaring repair stream %s of video stream %s", repair, ssrc)
+	return webrtc.SessionDescription{Type: desc.Type, SDP: strings.Join(declared, "\r\n")}
+}
+
+func (rp *repair) negotiated() (map[uint8]uint8, uint8) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.rtxTypes, rp.fecType
+}
+
+func isVideo(info *interceptor.StreamInfo) bool {
+	return strings.HasPrefix(strings.ToLower(info.MimeType), "video/")
+}
+
+// Video stream of the host with the packets kept for retransmissions
+type repairSender struct {
+	writer interceptor.RTPWriter
+	ssrc   uint32 // Of the repair stream
+
+	mu   sync.Mutex
+	seq  uint16 // Next sequence number of the repair stream
+	sent []sentPacket
+	fec  fecEncoder
+}
+
+type sentPacket struct {
+	header  rtp.Header
+	payload []byte
+}
+
+func (rp *repair) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if !isVideo(info) {
+		return writer
+	}
+	size := rp.recovery.NACKBuffer
+	if size == 0 {
+		size = 1024
+	}
+	s := &repairSender{writer: writer, ssrc: info.SSRC ^ repairSSRCMask, sent: make([]sentPacket, size),
+		fec: fecEncoder{size: rp.recovery.FECGroup}}
+	rp.mu.Lock()
+	rp.senders[info.SSRC] = s
+	rp.mu.Unlock()
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		n, err := writer.Write(header, payload, attributes)
+		if err != nil {
+			return n, err
+		}
+		_, fecType := rp.negotiated()
+		if fec := s.add(header, payload, fecType); fec != nil {
+			if _, err := writer.Write(&fec.Header, fec.Payload, interceptor.Attributes{}); err != nil {
+				log.Printf("[ERR] Sending FEC: %v", err)
+			}
+		}
+		return n, nil
+	})
+}
+
+func (rp *repair) UnbindLocalStream(info *interceptor.StreamInfo) {
+	rp.mu.Lock()
+	delete(rp.senders, info.SSRC)
+	rp.mu.Unlock()
+}
+
+// Keeps a sent packet and returns the FEC packet of its group once it is complete
+func (s *repairSender) add(header *rtp.Header, payload []byte, fecType uint8) *rtp.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent[int(header.SequenceNumber)%len(s.sent)] = sentPacket{
+		header: rtp.Header{Version: 2, Padding: header.Padding, Marker: header.Marker, PayloadType: header.PayloadType,
+			SequenceNumber: header.SequenceNumber, Timestamp: header.Timestamp, SSRC: header.SSRC},
+		payload: append([]byte(nil), payload...),
+	}
+	if fecType == 0 || s.fec.size == 0 {
+		return nil
+	}
+	fec, ok := s.fec.add(header, payload)
+	if !ok {
+		return nil
+	}
+	return s.repairPacket(fecType, header.Timestamp, fec)
+}
+
+// Returns the packet to resend for the given sequence number, an RTX packet if RTX was
+// negotiated for its codec or else the packet itself. Nil if it is no longer kept
+func (s *repairSender) retransmission(seq uint16, rtxTypes map[uint8]uint8) *rtp.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.sent[int(seq)%len(s.sent)]
+	if p.payload == nil || p.header.SequenceNumber != seq {
+		return nil
+	}
+	rtx, ok := rtxTypes[p.header.PayloadType]
+	if !ok {
+		return &rtp.Packet{Header: p.header, Payload: p.payload}
+	}
+	// RTX payloads start with the original sequence number
+	payload := make([]byte, 2+len(p.payload))
+	binary.BigEndian.PutUint16(payload, seq)
+	copy(payload[2:], p.payload)
+	pkt := s.repairPacket(rtx, p.header.Timestamp, payload)
+	pkt.Marker, pkt.Padding = p.header.Marker, p.header.Padding
+	return pkt
+}
+
+// Must be called with the lock held
+func (s *repairSender) repairPacket(pt uint8, ts uint32, payload []byte) *rtp.Packet {
+	pkt := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: pt, SequenceNumber: s.seq, Timestamp: ts, SSRC: s.ssrc},
+		Payload: payload,
+	}
+	s.seq++
+	return pkt
+}
+
+// Resends the packets of the NACKs of the remote
+func (rp *repair) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return 0, nil, err
+		}
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+		pkts, err := attr.GetRTCPPackets(b[:n])
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, pkt := range pkts {
+			if nack, ok := pkt.(*rtcp.TransportLayerNack); ok {
+				go rp.resend(nack)
+			}
+		}
+		return n, attr, nil
+	})
+}
+
+func (rp *repair) resend(nack *rtcp.TransportLayerNack) {
+	rp.mu.Lock()
+	s, rtxTypes := rp.senders[nack.MediaSSRC], rp.rtxTypes
+	rp.mu.Unlock()
+	if s == nil {
+		return
+	}
+	for _, pair := range nack.Nacks {
+		pair.Range(func(seq uint16) bool {
+			if pkt := s.retransmission(seq, rtxTypes); pkt != nil {
+				if _, err := s.writer.Write(&pkt.Header, pkt.Payload, interceptor.Attributes{}); err != nil {
+					log.Printf("[ERR] Resending packet: %v", err)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// Video stream of the remote which passes on the packets restored from its repair stream
+// together with the ones received. Duplicates are dropped
+type repairReceiver struct {
+	ssrc      uint32
+	incoming  chan receivedPacket
+	recovered chan receivedPacket
+	done      chan struct{} // Closed once the stream is unbound
+	start     sync.Once
+	err       error // Error of the stream, set before incoming is closed
+
+	mu      sync.Mutex
+	seen    [seenWindow]uint32 // Sequence numbers plus one of the packets passed on
+	packets [fecWindow]receivedPacket
+	newest  uint16      // Highest sequence number kept
+	fec     []fecPacket // Waiting for the packets of their group
+}
+
+type receivedPacket struct {
+	data    []byte
+	attrs   interceptor.Attributes
+	header  rtp.Header
+	payload []byte
+	valid   bool // The header was read
+}
+
+func (rp *repair) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	if !isVideo(info) {
+		return reader
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	// pion binds the repair stream after its video stream. It reads the repair stream only to
+	// discard the packets, so they are never passed on
+	if r, ok := rp.receivers[info.SSRC^repairSSRCMask]; ok {
+		return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+			for {
+				n, _, err := reader.Read(b, a)
+				if err != nil {
+					return 0, nil, err
+				}
+				r.repair(b[:n])
+			}
+		})
+	}
+
+	r := &repairReceiver{ssrc: info.SSRC, incoming: make(chan receivedPacket), recovered: make(chan receivedPacket, fecWindow),
+		done: make(chan struct{})}
+	rp.receivers[info.SSRC] = r
+	return interceptor.RTPReaderFunc(func(b []byte, _ interceptor.Attributes) (int, interceptor.Attributes, error) {
+		return r.read(reader, b)
+	})
+}
+
+func (rp *repair) UnbindRemoteStream(info *interceptor.StreamInfo) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if r, ok := rp.receivers[info.SSRC]; ok {
+		close(r.done)
+		delete(rp.receivers, info.SSRC)
+	}
+}
+
+// Returns the next received or restored packet. The packets are read in another goroutine
+// so that restored packets are passed on without waiting for the next one of the stream
+func (r *repairReceiver) read(reader interceptor.RTPReader, b []byte) (int, interceptor.Attributes, error) {
+	r.start.Do(func() { go r.readStream(reader) })
+	for {
+		var pkt receivedPacket
+		select {
+		case pkt = <-r.recovered:
+		case in, ok := <-r.incoming:
+			if !ok {
+				return 0, nil, r.err
+			}
+			pkt = in
+		}
+		if r.firstSeen(&pkt) {
+			return copy(b, pkt.data), pkt.attrs, nil
+		}
+	}
+}
+
+// Reads the stream and keeps the packets for the recovery of the others as they arrive
+func (r *repairReceiver) readStream(reader interceptor.RTPReader) {
+	defer close(r.incoming)
+	for {
+		buf := make([]byte, receiveMTU)
+		n, attrs, err := reader.Read(buf, nil)
+		if err != nil {
+			r.err = err
+			return
+		}
+		pkt := receivedPacket{data: buf[:n], attrs: attrs}
+		r.mu.Lock()
+		r.keep(&pkt)
+		r.recoverFEC()
+		r.mu.Unlock()
+		select {
+		case r.incoming <- pkt:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Reads the header of a packet and keeps it. Must be called with the lock held
+func (r *repairReceiver) keep(pkt *receivedPacket) {
+	n, err := pkt.header.Unmarshal(pkt.data)
+	if err != nil {
+		// The readers after this one report the malformed packet
+		return
+	}
+	pkt.payload, pkt.valid = pkt.data[n:], true
+	if int16(pkt.header.SequenceNumber-r.newest) > 0 {
+		r.newest = pkt.header.SequenceNumber
+	}
+	r.packets[pkt.header.SequenceNumber%fecWindow] = receivedPacket{header: pkt.header, payload: pkt.payload, valid: true}
+}
+
+// Reports whether a packet is passed on for the first time
+func (r *repairReceiver) firstSeen(pkt *receivedPacket) bool {
+	if !pkt.valid {
+		return true
+	}
+	seq := pkt.header.SequenceNumber
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seen[seq%seenWindow] == uint32(seq)+1 {
+		return false
+	}
+	r.seen[seq%seenWindow] = uint32(seq) + 1
+	return true
+}
+
+// Restores the packet of an RTX packet or keeps an FEC packet until it can restore one
+func (r *repairReceiver) repair(data []byte) {
+	var header rtp.Header
+	n, err := header.Unmarshal(data)
+	if err != nil {
+		return
+	}
+	payload := data[n:]
+
+	if header.PayloadType == fecPayloadType {
+		fec, err := parseFEC(payload)
+		if err != nil || fec.ssrc != r.ssrc {
+			return
+		}
+		r.mu.Lock()
+		r.fec = append(r.fec, fec)
+		if len(r.fec) > maxPendingFEC {
+			r.fec = r.fec[1:]
+		}
+		r.recoverFEC()
+		r.mu.Unlock()
+		return
+	}
+
+	for media, rtx := range rtxPayloadTypes {
+		if uint8(rtx) != header.PayloadType || len(payload) < 2 {
+			continue
+		}
+		restored := rtp.Header{Version: 2, Padding: header.Padding, Marker: header.Marker, PayloadType: uint8(media),
+			SequenceNumber: binary.BigEndian.Uint16(payload), Timestamp: header.Timestamp, SSRC: r.ssrc}
+		buf, err := restored.Marshal()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		r.restore(append(buf, payload[2:]...))
+		r.recoverFEC()
+		r.mu.Unlock()
+		return
+	}
+}
+
+// Keeps a restored packet and passes it on. Must be called with the lock held
+func (r *repairReceiver) restore(data []byte) {
+	pkt := receivedPacket{data: data}
+	if r.has(binary.BigEndian.Uint16(data[2:])) {
+		return
+	}
+	r.keep(&pkt)
+	select {
+	case r.recovered <- pkt:
+	default:
+		// The video is read too slowly to catch up with restored packets
+	}
+}
+
+// Restores the packets missing from the groups of the FEC packets. The repair stream is read
+// apart from the video, so a packet only counts as missing once a later one arrived. FEC
+// packets are dropped once their group is complete. Must be called with the lock held
+func (r *repairReceiver) recoverFEC() {
+	kept := r.fec[:0]
+	for _, f := range r.fec {
+		var missing uint16
+		count := 0
+		protected := f.protected()
+		for _, seq := range protected {
+			if !r.has(seq) {
+				missing = seq
+				count++
+			}
+		}
+		switch {
+		case count == 0:
+		case count == 1 && int16(r.newest-protected[len(protected)-1]) > 0:
+			if data, err := f.recover(missing, r.lookup); err == nil {
+				r.restore(data)
+			}
+		default:
+			kept = append(kept, f)
+		}
+	}
+	r.fec = kept
+}
+
+func (r *repairReceiver) has(seq uint16) bool {
+	p := r.packets[seq%fecWindow]
+	return p.valid && p.header.SequenceNumber == seq
+}
+
+func (r *repairReceiver) lookup(seq uint16) (*rtp.Header, []byte) {
+	if !r.has(seq) {
+		return nil, nil
+	}
+	p := &r.packets[seq%fecWindow]
+	return &p.header, p.payload
+}
@@ -0,0 +1,64 @@
+package wrtc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const repairSDP = "v=0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=ssrc:5 cname:host\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 49\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rtpmap:49 flexfec-03/90000\r\n" +
+	"a=fmtp:49 repair-window=10000000\r\n" +
+	"a=ssrc:1000 cname:host\r\n" +
+	"a=ssrc:1000 msid:host video\r\n" +
+	"a=sendonly\r\n"
+
+func TestDeclareRepair(t *testing.T) {
+	rp := newRepair(Recovery{RTX: true, FECGroup: 5})
+	desc := rp.declare(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: repairSDP})
+
+	repair := "1515870642" // 1000 ^ repairSSRCMask
+	lines := strings.Split(desc.SDP, "\r\n")
+	want := []string{"a=ssrc-group:FID 1000 " + repair, "a=ssrc:1000 cname:host", "a=ssrc:1000 msid:host video",
+		"a=ssrc:" + repair + " cname:host", "a=sendonly"}
+	if got := lines[10:15]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("declared\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if rtxTypes, fecType := rp.negotiated(); len(rtxTypes) != 1 || rtxTypes[96] != 97 || fecType != 49 {
+		t.Errorf("negotiated RTX %v and FEC %d", rtxTypes, fecType)
+	}
+
+	// Descriptions are declared once and only with repair codecs
+	if again := rp.declare(desc); again.SDP != desc.SDP {
+		t.Errorf("declared again\n%s", again.SDP)
+	}
+	plain := strings.Replace(repairSDP, "a=rtpmap:97 rtx/90000\r\n", "", 1)
+	plain = strings.Replace(plain, "a=rtpmap:49 flexfec-03/90000\r\n", "", 1)
+	if got := rp.declare(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: plain}); got.SDP != plain {
+		t.Errorf("declared without repair codecs\n%s", got.SDP)
+	}
+	if rtxTypes, fecType := rp.negotiated(); len(rtxTypes) != 0 || fecType != 0 {
+		t.Errorf("negotiated RTX %v and FEC %d without repair codecs", rtxTypes, fecType)
+	}
+}
+
+func TestRepairCodecs(t *testing.T) {
+	vp8 := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, PayloadType: 96}
+	vp9 := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9}, PayloadType: 98}
+	rtx8 := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeRTX, SDPFmtpLine: "apt=96"}, PayloadType: 97}
+	rtx9 := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeRTX, SDPFmtpLine: "apt=98"}, PayloadType: 99}
+	fec := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeFlexFEC}, PayloadType: 49}
+
+	got := repairCodecs([]webrtc.RTPCodecParameters{vp8, vp9, rtx8, rtx9, fec}, []webrtc.RTPCodecParameters{vp9})
+	if len(got) != 2 || got[0].PayloadType != 99 || got[1].PayloadType != 49 {
+		t.Errorf("repair codecs %v, want the RTX of VP9 and FEC", got)
+	}
+}
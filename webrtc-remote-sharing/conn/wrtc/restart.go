@@ -0,0 +1,38 @@
+package wrtc
+
+import (
+	"time"
+)
+
+// Default budget of ICE restarts per outage
+const (
+	DefaultRestarts     = 5
+	DefaultRestartDelay = time.Second
+)
+
+// Budget of ICE restarts after the connection was lost. The delay before a restart doubles
+// with every attempt so that a peer without network does not flood the signaling server
+type Restarts struct {
+	Max      int           // Restarts per outage
+	Delay    time.Duration // Delay before the first restart
+	attempts int
+}
+
+func NewRestarts() *Restarts {
+	return &Restarts{Max: DefaultRestarts, Delay: DefaultRestartDelay}
+}
+
+// Returns the delay before the next restart. False once the budget is used up
+func (r *Restarts) Next() (time.Duration, bool) {
+	if r.attempts >= r.Max {
+		return 0, false
+	}
+	delay := r.Delay << r.attempts
+	r.attempts++
+	return delay, true
+}
+
+// Refills the budget once the connection is back
+func (r *Restarts) Reset() {
+	r.attempts = 0
+}
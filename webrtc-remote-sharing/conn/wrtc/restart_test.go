@@ -0,0 +1,109 @@
+package wrtc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestRestarts(t *testing.T) {
+	r := &Restarts{Max: 3, Delay: time.Second}
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got, ok := r.Next(); !ok || got != want {
+			t.Errorf("Next() = %v, %v, want %v, true", got, ok, want)
+		}
+	}
+	if _, ok := r.Next(); ok {
+		t.Error("Next() succeeded after the budget was used up")
+	}
+
+	r.Reset()
+	if got, ok := r.Next(); !ok || got != time.Second {
+		t.Errorf("Next() after Reset = %v, %v, want %v, true", got, ok, time.Second)
+	}
+}
+
+func TestRestartOffer(t *testing.T) {
+	offerer, answerer := newVNetPair(t)
+	offererConnected := connected(offerer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Candidates are delivered as they arrive like over the signaling server
+	var trickles [2]*Trickle
+	for i, pc := range []*webrtc.PeerConnection{offerer, answerer} {
+		other := 1 - i
+		trickles[i] = NewTrickle(&PeerConn{PeerConnection: pc}, func(c webrtc.ICECandidateInit) error {
+			return trickles[other].AddRemote(c)
+		})
+		go trickles[i].Run(ctx)
+	}
+
+	// Like the application the offerer holds its candidates from creating the offer until it is sent
+	// and the answerer from applying the offer until the answer is sent
+	offerTo := func(create func() ([]byte, error)) {
+		trickles[0].Hold()
+		offerJSON, err := create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		trickles[0].Release()
+
+		var offer webrtc.SessionDescription
+		if err := json.Unmarshal(offerJSON, &offer); err != nil {
+			t.Fatal(err)
+		}
+		trickles[1].Hold()
+		if err := answerer.SetRemoteDescription(offer); err != nil {
+			t.Fatal(err)
+		}
+		if err := trickles[1].Ready(); err != nil {
+			t.Fatal(err)
+		}
+		answerJSON, err := (&PeerConn{PeerConnection: answerer}).NewAnswer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		trickles[1].Release()
+
+		var answer webrtc.SessionDescription
+		if err := json.Unmarshal(answerJSON, &answer); err != nil {
+			t.Fatal(err)
+		}
+		if err := offerer.SetRemoteDescription(answer); err != nil {
+			t.Fatal(err)
+		}
+		if err := trickles[0].Ready(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := offerer.CreateDataChannel("data", nil); err != nil {
+		t.Fatal(err)
+	}
+	offerTo((&PeerConn{PeerConnection: offerer}).NewOffer)
+	select {
+	case <-offererConnected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the connection")
+	}
+	before := iceUfrag(offerer.LocalDescription().SDP)
+
+	offerTo((&PeerConn{PeerConnection: offerer}).NewRestartOffer)
+	if after := iceUfrag(offerer.LocalDescription().SDP); after == before {
+		t.Errorf("restart offer kept the ICE credentials %q", before)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for offerer.ICEConnectionState() != webrtc.ICEConnectionStateConnected {
+		if time.Now().After(deadline) {
+			t.Fatalf("ICE state after restart = %s, want connected", offerer.ICEConnectionState())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
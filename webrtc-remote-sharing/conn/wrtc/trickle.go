@@ -0,0 +1,216 @@
+package wrtc
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Exchanges ICE candidates with the other peer for the lifetime of a session. Local candidates
+// are sent as they are gathered but held back while a description is negotiated so that they
+// reach the other peer after it. Remote candidates wait until the remote description they
+// belong to is applied since pion drops candidates of earlier ICE credentials on a restart.
+// Candidates carry the ufrag of their credentials, an empty candidate marks the end of candidates
+type Trickle struct {
+	pc   *webrtc.PeerConnection
+	send func(webrtc.ICECandidateInit) error
+
+	mu         sync.Mutex
+	local      []localCandidate          // Gathered candidates waiting to be sent
+	localUfrag string                    // Ufrag of the last released local description
+	holding    bool                      // Whether a local description is being negotiated
+	remote     []webrtc.ICECandidateInit // Received candidates waiting for their remote description
+	ufrag      string                    // Ufrag of the applied remote description
+	stale      map[string]bool           // Ufrags of remote descriptions replaced by a restart
+	pending    chan struct{}             // Signals the send loop that local candidates are queued
+}
+
+// Gathered candidate with the ufrag of its local description. The ufrag of candidates
+// gathered during a negotiation is only known once the description is released
+type localCandidate struct {
+	init  webrtc.ICECandidateInit
+	ufrag string
+}
+
+// Returns a trickle exchange for the peer connection. The send function passes local
+// candidates on to the signaling channel. It takes over the OnICECandidate callback
+func NewTrickle(pc *PeerConn, send func(webrtc.ICECandidateInit) error) *Trickle {
+	t := &Trickle{pc: pc.PeerConnection, send: send, stale: map[string]bool{},
+		pending: make(chan struct{}, 1)}
+
+	// The callback never blocks pion since candidates are only queued here
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		var init webrtc.ICECandidateInit
+		if c == nil {
+			log.Println("[ICE] Local gathering complete")
+		} else {
+			log.Println("[ICE] Found candidate")
+			init = c.ToJSON()
+		}
+
+		t.mu.Lock()
+		ufrag := t.localUfrag
+		if t.holding {
+			ufrag = ""
+		}
+		t.local = append(t.local, localCandidate{init: init, ufrag: ufrag})
+		t.mu.Unlock()
+		t.notify()
+	})
+	return t
+}
+
+func (t *Trickle) notify() {
+	select {
+	case t.pending <- struct{}{}:
+	default:
+	}
+}
+
+// Holds back local candidates until Release. Must be called before a local description is
+// created or a remote offer is applied since either can start gathering with new credentials
+func (t *Trickle) Hold() {
+	t.mu.Lock()
+	t.holding = true
+	t.mu.Unlock()
+}
+
+// Sends the held candidates once the local description was sent to the other peer
+func (t *Trickle) Release() {
+	var ufrag string
+	if desc := t.pc.LocalDescription(); desc != nil {
+		ufrag = iceUfrag(desc.SDP)
+	}
+
+	t.mu.Lock()
+	for i := range t.local {
+		if t.local[i].ufrag == "" {
+			t.local[i].ufrag = ufrag
+		}
+	}
+	t.localUfrag, t.holding = ufrag, false
+	t.mu.Unlock()
+	t.notify()
+}
+
+// Sends the local candidates until the context is canceled. Gathering starts again on an
+// ICE restart, so the loop keeps running after the end of candidates was sent
+func (t *Trickle) Run(ctx context.Context) {
+	log.Println("[ICE] Starting candidate exchange")
+	defer log.Println("[ICE] Stopping candidate exchange")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.pending:
+		}
+
+		t.mu.Lock()
+		if t.holding {
+			t.mu.Unlock()
+			continue
+		}
+		queued := t.local
+		t.local = nil
+		t.mu.Unlock()
+
+		for _, c := range queued {
+			if c.ufrag != "" {
+				ufrag := c.ufrag
+				c.init.UsernameFragment = &ufrag
+			}
+			if err := t.send(c.init); err != nil {
+				log.Printf("[ERR] Sending ICE candidate: %v", err)
+			}
+		}
+	}
+}
+
+// Adds a candidate received from the other peer. It is buffered if the remote description
+// it belongs to is not applied yet and dropped if it belongs to one replaced by a restart
+func (t *Trickle) AddRemote(c webrtc.ICECandidateInit) error {
+	t.mu.Lock()
+	switch {
+	case t.matches(c):
+		t.mu.Unlock()
+		return t.add(c)
+	case c.UsernameFragment != nil && t.stale[*c.UsernameFragment]:
+		t.mu.Unlock()
+		log.Println("[ICE] Dropping candidate of replaced ICE credentials")
+		return nil
+	}
+	log.Println("[ICE] Remote description is not set. Buffering received candidate")
+	t.remote = append(t.remote, c)
+	t.mu.Unlock()
+	return nil
+}
+
+// Adds the buffered remote candidates of the applied remote description. Must be called
+// whenever a remote description is applied
+func (t *Trickle) Ready() error {
+	desc := t.pc.RemoteDescription()
+	if desc == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	if ufrag := iceUfrag(desc.SDP); ufrag != t.ufrag {
+		if t.ufrag != "" {
+			log.Println("[ICE] Remote ICE credentials changed")
+			t.stale[t.ufrag] = true
+		}
+		t.ufrag = ufrag
+	}
+	var matching, waiting []webrtc.ICECandidateInit
+	for _, c := range t.remote {
+		switch {
+		case t.matches(c):
+			matching = append(matching, c)
+		case c.UsernameFragment == nil || !t.stale[*c.UsernameFragment]:
+			waiting = append(waiting, c)
+		}
+	}
+	t.remote = waiting
+	t.mu.Unlock()
+
+	var firstErr error
+	for _, c := range matching {
+		if err := t.add(c); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Reports whether the candidate belongs to the applied remote description. Candidates
+// without a ufrag are assumed to belong to it. Must be called with the lock held
+func (t *Trickle) matches(c webrtc.ICECandidateInit) bool {
+	if t.ufrag == "" {
+		return false
+	}
+	return c.UsernameFragment == nil || *c.UsernameFragment == t.ufrag
+}
+
+func (t *Trickle) add(c webrtc.ICECandidateInit) error {
+	if c.Candidate == "" {
+		log.Println("[ICE] Remote gathering complete")
+		return nil
+	}
+	log.Println("[ICE] Adding candidate")
+	return t.pc.AddICECandidate(c)
+}
+
+// Returns the ICE username fragment of the first media section of the SDP
+func iceUfrag(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "a=ice-ufrag:") {
+			return strings.TrimPrefix(line, "a=ice-ufrag:")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,196 @@
+package wrtc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/logging"
+	"github.com/pion/transport/vnet"
+	"github.com/pion/webrtc/v3"
+)
+
+// Returns two peer connections on a virtual network so that ICE runs without real interfaces
+func newVNetPair(t *testing.T) (*webrtc.PeerConnection, *webrtc.PeerConnection) {
+	return newVNetPairWithCodecs(t, nil, nil)
+}
+
+// Creates two peers on a virtual network with the given video codecs. Nil registers the pion defaults
+func newVNetPairWithCodecs(t *testing.T, first, second []string) (*webrtc.PeerConnection, *webrtc.PeerConnection) {
+	firstPC, secondPC, _ := newVNetPairWithAPI(t, func(i int, m *webrtc.MediaEngine, _ *interceptor.Registry) error {
+		if codecs := [][]string{first, second}[i]; codecs != nil {
+			return registerCodecs(m, codecs)
+		}
+		return m.RegisterDefaultCodecs()
+	})
+	return firstPC, secondPC
+}
+
+// Creates two peers on a virtual network whose media engine and interceptors are set up by
+// the given function which gets the index of the peer. The router lets tests drop packets
+func newVNetPairWithAPI(t *testing.T, configure func(i int, m *webrtc.MediaEngine, r *interceptor.Registry) error) (*webrtc.PeerConnection, *webrtc.PeerConnection, *vnet.Router) {
+	wan, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pcs []*webrtc.PeerConnection
+	for i, ip := range []string{"1.2.3.4", "1.2.3.5"} {
+		n := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{ip}})
+		if err := wan.AddNet(n); err != nil {
+			t.Fatal(err)
+		}
+
+		se := webrtc.SettingEngine{}
+		se.SetVNet(n)
+		se.SetICETimeouts(2*time.Second, 5*time.Second, 200*time.Millisecond)
+
+		m, r := &webrtc.MediaEngine{}, &interceptor.Registry{}
+		if err := configure(i, m, r); err != nil {
+			t.Fatal(err)
+		}
+
+		api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(r), webrtc.WithSettingEngine(se))
+		pc, err := api.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { pc.Close() })
+		pcs = append(pcs, pc)
+	}
+
+	if err := wan.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wan.Stop() })
+
+	return pcs[0], pcs[1], wan
+}
+
+// Reads the candidates sent by one peer up to and including the end of candidates
+func collect(t *testing.T, sent <-chan webrtc.ICECandidateInit) []webrtc.ICECandidateInit {
+	var cs []webrtc.ICECandidateInit
+	for {
+		select {
+		case c := <-sent:
+			cs = append(cs, c)
+			if c.Candidate == "" {
+				return cs
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the end of candidates")
+		}
+	}
+}
+
+func reverse(cs []webrtc.ICECandidateInit) []webrtc.ICECandidateInit {
+	for i, j := 0, len(cs)-1; i < j; i, j = i+1, j-1 {
+		cs[i], cs[j] = cs[j], cs[i]
+	}
+	return cs
+}
+
+// Returns a channel which is closed once the peer connection is connected
+func connected(pc *webrtc.PeerConnection) <-chan struct{} {
+	done := make(chan struct{})
+	var once sync.Once
+	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		if s == webrtc.PeerConnectionStateConnected {
+			once.Do(func() { close(done) })
+		}
+	})
+	return done
+}
+
+func TestTrickle(t *testing.T) {
+	tests := []struct {
+		name  string
+		early bool // Candidates arrive before the session description
+		order func([]webrtc.ICECandidateInit) []webrtc.ICECandidateInit
+	}{
+		{"delayed", false, func(cs []webrtc.ICECandidateInit) []webrtc.ICECandidateInit { return cs }},
+		{"before description", true, func(cs []webrtc.ICECandidateInit) []webrtc.ICECandidateInit { return cs }},
+		{"reordered", true, reverse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offerer, answerer := newVNetPair(t)
+			offererConnected, answererConnected := connected(offerer), connected(answerer)
+
+			toAnswerer := make(chan webrtc.ICECandidateInit, 16)
+			toOfferer := make(chan webrtc.ICECandidateInit, 16)
+			offererTrickle := NewTrickle(&PeerConn{PeerConnection: offerer}, func(c webrtc.ICECandidateInit) error {
+				toAnswerer <- c
+				return nil
+			})
+			answererTrickle := NewTrickle(&PeerConn{PeerConnection: answerer}, func(c webrtc.ICECandidateInit) error {
+				toOfferer <- c
+				return nil
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go offererTrickle.Run(ctx)
+			go answererTrickle.Run(ctx)
+
+			if _, err := offerer.CreateDataChannel("data", nil); err != nil {
+				t.Fatal(err)
+			}
+			offer, err := offerer.CreateOffer(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := offerer.SetLocalDescription(offer); err != nil {
+				t.Fatal(err)
+			}
+
+			// Applies a description and delivers the candidates of the other peer before or after it
+			apply := func(pc *webrtc.PeerConnection, tr *Trickle, sdp webrtc.SessionDescription, cs []webrtc.ICECandidateInit) {
+				if tt.early {
+					for _, c := range cs {
+						if err := tr.AddRemote(c); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				if err := pc.SetRemoteDescription(sdp); err != nil {
+					t.Fatal(err)
+				}
+				if err := tr.Ready(); err != nil {
+					t.Fatal(err)
+				}
+				if !tt.early {
+					for _, c := range cs {
+						if err := tr.AddRemote(c); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+			}
+
+			apply(answerer, answererTrickle, offer, tt.order(collect(t, toAnswerer)))
+			answer, err := answerer.CreateAnswer(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := answerer.SetLocalDescription(answer); err != nil {
+				t.Fatal(err)
+			}
+			apply(offerer, offererTrickle, answer, tt.order(collect(t, toOfferer)))
+
+			for _, done := range []<-chan struct{}{offererConnected, answererConnected} {
+				select {
+				case <-done:
+				case <-time.After(10 * time.Second):
+					t.Fatal("timed out waiting for the connection")
+				}
+			}
+		})
+	}
+}
@@ -2,23 +2,47 @@ package wrtc
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strings"
+	"sync"
 
+	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/webrtc/v3"
 )
 
 type PeerConn struct {
 	*webrtc.PeerConnection
+	estimator cc.BandwidthEstimator // Estimates the bandwidth of the video sent by the host
+	keyFrames keyFrameLimiter       // Limits the key frames requested by the remote or forced on the host
+	repair    *repair               // Repair stream of the video, nil without RTX and FEC
+
+	Voice *webrtc.TrackLocalStaticSample // Microphone of this peer, sent to the other one
+
+	mu         sync.Mutex
+	onKeyFrame func()
 }
 
-// Creates a new peerConnection with STUN config
-func newPeerConnection(url, creds string) (peerConnection *webrtc.PeerConnection, err error) {
+// Creates a new peerConnection with STUN config, the given video codecs and the recovery of lost
+// packets. The DTLS certificate
+// is generated by pion for this connection only if the device certificate is nil
+func newPeerConnection(url, creds string, codecs []string, cert *webrtc.Certificate, recovery Recovery,
+	onEstimator cc.NewPeerConnectionCallback) (peerConnection *webrtc.PeerConnection, rp *repair, err error) {
+	api, rp, err := newAPI(codecs, recovery, onEstimator)
+	if err != nil {
+		return nil, nil, err
+	}
+	var certificates []webrtc.Certificate
+	if cert != nil {
+		certificates = []webrtc.Certificate{*cert}
+	}
+
 	if creds != "" {
 		split := strings.Split(creds, ":")
 		user, pwd := split[0], split[1]
-		peerConnection, err = webrtc.NewPeerConnection(webrtc.Configuration{
+		peerConnection, err = api.NewPeerConnection(webrtc.Configuration{
 			ICEServers: []webrtc.ICEServer{
 				{
 					URLs:           []string{url},
@@ -27,25 +51,29 @@ func newPeerConnection(url, creds string) (peerConnection *webrtc.PeerConnection
 					CredentialType: webrtc.ICECredentialTypePassword,
 				},
 			},
+			Certificates: certificates,
 		})
 	} else {
-		peerConnection, err = webrtc.NewPeerConnection(webrtc.Configuration{
+		peerConnection, err = api.NewPeerConnection(webrtc.Configuration{
 			ICEServers: []webrtc.ICEServer{
 				{
 					URLs: []string{url},
 				},
 			},
+			Certificates: certificates,
 		})
 	}
 
 	return
 }
 
-// Returns a peerConnection with receive only transceiver
-func NewRemote(url, creds string) (*PeerConn, error) {
+// Returns a peerConnection with receive only transceivers for video and the sound of the host
+// and a voice track in both directions. The offer of the remote lists the codecs it can decode
+// in the given order
+func NewRemote(url, creds string, codecs []string, cert *webrtc.Certificate, recovery Recovery) (*PeerConn, error) {
 	log.Println("[PC] Creating remote connection")
 
-	peerConnection, err := newPeerConnection(url, creds)
+	peerConnection, rp, err := newPeerConnection(url, creds, codecs, cert, recovery, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -56,32 +84,55 @@ func NewRemote(url, creds string) (*PeerConn, error) {
 		log.Printf("[ERR] Adding transceiver: %v", err)
 		return nil, err
 	}
-	return &PeerConn{peerConnection}, err
+	voice, err := addVoiceTrack(peerConnection, "remote")
+	if err != nil {
+		return nil, err
+	}
+	// The host only sends audio if it captures its sound, otherwise the section stays inactive
+	if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio,
+		webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		log.Printf("[ERR] Adding audio transceiver: %v", err)
+		return nil, err
+	}
+	return &PeerConn{PeerConnection: peerConnection, repair: rp, Voice: voice}, err
 }
 
-// Returns a peerConnection and video track with the given codec
-func NewHost(url, creds, codecName string) (*PeerConn, *webrtc.TrackLocalStaticSample, error) {
+// Returns a peerConnection with a voice track and the video track with the first of the codecs
+// the host can encode. The video track is replaced by SelectCodec if the remote cannot decode
+// that codec. The device certificate lets remotes recognize the host across sessions
+func NewHost(url, creds string, codecs []string, cert *webrtc.Certificate, recovery Recovery) (*PeerConn, *webrtc.TrackLocalStaticSample, error) {
 	log.Println("[PC] Creating host connection")
 
-	peerConnection, err := newPeerConnection(url, creds)
+	var estimator cc.BandwidthEstimator
+	peerConnection, rp, err := newPeerConnection(url, creds, codecs, cert, recovery, func(_ string, e cc.BandwidthEstimator) {
+		estimator = e
+	})
 	if err != nil {
 		return nil, nil, err
 	}
 
-	log.Printf("[PC] Creating video track with %s", codecName)
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: codecName}, "video", "host")
+	log.Printf("[PC] Creating video track with %s", codecs[0])
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: codecs[0]}, "video", "host")
 	if err != nil {
 		log.Printf("[ERR] Creating video track: %v", err)
 		return nil, nil, err
 	}
 
-	_, err = peerConnection.AddTransceiverFromTrack(videoTrack,
+	transceiver, err := peerConnection.AddTransceiverFromTrack(videoTrack,
 		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
 	if err != nil {
-		log.Panicf("[ERR] Adding video track: %v", err)
+		log.Printf("[ERR] Adding video track: %v", err)
+		return nil, nil, err
 	}
+	voice, err := addVoiceTrack(peerConnection, "host")
+	if err != nil {
+		return nil, nil, err
+	}
+	pc := &PeerConn{PeerConnection: peerConnection, estimator: estimator, repair: rp, Voice: voice}
+	go pc.readRTCP(transceiver.Sender())
 
-	return &PeerConn{peerConnection}, videoTrack, err
+	return pc, videoTrack, err
 }
 
 // Connects the OnConnectionStateChange callback to the PeerConnection
@@ -100,10 +151,47 @@ func (pc *PeerConn) ConnectionStateCallback() {
 	})
 }
 
+// Returns the DTLS fingerprints of the local and the remote description. Both are set once
+// the offer and answer are applied, so this is valid after the connection is established
+func (pc *PeerConn) Fingerprints() (local, remote string, err error) {
+	if pc.CurrentLocalDescription() == nil || pc.CurrentRemoteDescription() == nil {
+		return "", "", errors.New("session descriptions not applied")
+	}
+	if local, err = DescriptionFingerprint(pc.CurrentLocalDescription().SDP); err != nil {
+		return "", "", fmt.Errorf("local description: %v", err)
+	}
+	if remote, err = DescriptionFingerprint(pc.CurrentRemoteDescription().SDP); err != nil {
+		return "", "", fmt.Errorf("remote description: %v", err)
+	}
+	return local, remote, nil
+}
+
+// Returns the value of the first fingerprint attribute of the SDP e.g. "sha-256 ab:cd:...".
+// Fingerprints are lower cased since implementations differ in the case of the hex digits
+func DescriptionFingerprint(sdp string) (string, error) {
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "a=fingerprint:") {
+			return strings.ToLower(strings.TrimPrefix(line, "a=fingerprint:")), nil
+		}
+	}
+	return "", errors.New("no fingerprint attribute")
+}
+
 // Creates an offer, sets it as the local description and returns the json marshaled bytes
 func (pc *PeerConn) NewOffer() ([]byte, error) {
+	return pc.newOffer(nil)
+}
+
+// Creates an offer with new ICE credentials which makes both peers gather and check candidates
+// again. The DTLS session and the tracks are kept so media resumes once a pair is found
+func (pc *PeerConn) NewRestartOffer() ([]byte, error) {
+	return pc.newOffer(&webrtc.OfferOptions{ICERestart: true})
+}
+
+func (pc *PeerConn) newOffer(options *webrtc.OfferOptions) ([]byte, error) {
 	log.Println("[SDP] Creating offer")
-	offer, err := pc.CreateOffer(nil)
+	offer, err := pc.CreateOffer(options)
 	if err != nil {
 		return nil, err
 	}
@@ -111,7 +199,7 @@ func (pc *PeerConn) NewOffer() ([]byte, error) {
 	if err = pc.SetLocalDescription(offer); err != nil {
 		return nil, err
 	}
-	offerString, err := json.Marshal(offer)
+	offerString, err := json.Marshal(pc.declareRepair(offer))
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +220,7 @@ func (pc *PeerConn) NewAnswer() ([]byte, error) {
 		return nil, err
 	}
 	// Marshal the answer and send it back to SFU
-	answerString, err := json.Marshal(answer)
+	answerString, err := json.Marshal(pc.declareRepair(answer))
 	if err != nil {
 		return nil, err
 	}
@@ -140,6 +228,15 @@ func (pc *PeerConn) NewAnswer() ([]byte, error) {
 	return answerString, nil
 }
 
+// Returns the description to send with the repair stream of the video declared if the peer
+// sends one
+func (pc *PeerConn) declareRepair(desc webrtc.SessionDescription) webrtc.SessionDescription {
+	if pc.repair == nil {
+		return desc
+	}
+	return pc.repair.declare(desc)
+}
+
 // func (pc *PeerConn) HandleICE(data string) {
 // 	log.Println("[ICE] Candidate received")
 // 	candidate := webrtc.ICECandidateInit{}
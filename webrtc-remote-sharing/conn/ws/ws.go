@@ -50,8 +50,9 @@ loop:
 	for {
 		select {
 		default:
+			// The context is canceled on shutdown
 			if err = s.Limiter.Wait(ctx); err != nil {
-				log.Panicf("[ERR] Waiting for read: %v", err)
+				return err
 			}
 			var msg message.Message
 
@@ -0,0 +1,200 @@
+package backend
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// Name of the audio backend used if the config does not select one. It is registered by the
+// provider package
+const DefaultAudio = "gstreamer"
+
+// Captures the sound of the host or a microphone and writes the encoded Opus frames to the track
+type AudioCapturer interface {
+	Start(track *webrtc.TrackLocalStaticSample) error
+	Stop() error
+	SetMuted(muted bool) error // Sends silence instead of the sound while muted
+}
+
+// Plays the Opus frames of a received audio track
+type AudioPlayer interface {
+	Start() error
+	Stop() error
+	Push(frame []byte, pts time.Duration)
+	SetMuted(muted bool) error
+	SetVolume(volume float64) error // 1 plays the frames as they are
+}
+
+// Settings passed to a new audio capturer
+type AudioOptions struct {
+	Source     string // Sound source of the GStreamer capture. Empty is the default output or input
+	Microphone bool   // Captures an input for the voice chat instead of the output
+}
+
+type audioBackend struct {
+	capturer func(AudioOptions) AudioCapturer
+	player   func() AudioPlayer
+}
+
+var audioBackends = map[string]audioBackend{}
+
+func init() {
+	RegisterAudio("null", func(AudioOptions) AudioCapturer { return &NullAudioCapturer{} },
+		func() AudioPlayer { return &NullAudioPlayer{} })
+}
+
+// Makes an audio capturer and player available by name. Panics if the name is taken
+func RegisterAudio(name string, capturer func(AudioOptions) AudioCapturer, player func() AudioPlayer) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := audioBackends[name]; ok {
+		panic(fmt.Sprintf("audio %q registered twice", name))
+	}
+	audioBackends[name] = audioBackend{capturer, player}
+}
+
+func audio(name string) (audioBackend, error) {
+	if name == "" {
+		name = DefaultAudio
+	}
+	mu.RLock()
+	b, ok := audioBackends[name]
+	mu.RUnlock()
+	if !ok {
+		return audioBackend{}, fmt.Errorf("audio %q: %w", name, ErrUnknown)
+	}
+	return b, nil
+}
+
+// Returns a new audio capturer of the given name or the default one if the name is empty
+func NewAudioCapturer(name string, opts AudioOptions) (AudioCapturer, error) {
+	b, err := audio(name)
+	if err != nil {
+		return nil, err
+	}
+	return b.capturer(opts), nil
+}
+
+// Returns a new audio player of the given name or the default one if the name is empty
+func NewAudioPlayer(name string) (AudioPlayer, error) {
+	b, err := audio(name)
+	if err != nil {
+		return nil, err
+	}
+	return b.player(), nil
+}
+
+// Checks that the audio backend of the given name is registered. An empty name stands for
+// the default
+func CheckAudio(name string) error {
+	_, err := audio(name)
+	return err
+}
+
+// Opus frame of 20ms of silence
+var opusSilence = []byte{0xf8, 0xff, 0xfe}
+
+const opusFrameDuration = 20 * time.Millisecond
+
+// Captures no sound and writes frames of silence to the track
+type NullAudioCapturer struct {
+	muted int32 // Accessed atomically
+	stop  chan struct{}
+	once  sync.Once
+}
+
+func boolFlag(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *NullAudioCapturer) Start(track *webrtc.TrackLocalStaticSample) error {
+	c.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(opusFrameDuration)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				if err := track.WriteSample(media.Sample{Data: opusSilence, Duration: opusFrameDuration}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *NullAudioCapturer) Stop() error {
+	if c.stop != nil {
+		c.once.Do(func() { close(c.stop) })
+	}
+	return nil
+}
+
+func (c *NullAudioCapturer) SetMuted(muted bool) error {
+	atomic.StoreInt32(&c.muted, boolFlag(muted))
+	return nil
+}
+
+func (c *NullAudioCapturer) Muted() bool {
+	return atomic.LoadInt32(&c.muted) == 1
+}
+
+// Counts and drops the received frames instead of playing them
+type NullAudioPlayer struct {
+	frames int64 // Accessed atomically
+	muted  int32 // Accessed atomically
+
+	mu     sync.Mutex
+	volume float64
+}
+
+func (p *NullAudioPlayer) Start() error {
+	return nil
+}
+
+func (p *NullAudioPlayer) Stop() error {
+	return nil
+}
+
+func (p *NullAudioPlayer) Push(frame []byte, pts time.Duration) {
+	atomic.AddInt64(&p.frames, 1)
+}
+
+// Returns the number of frames pushed so far
+func (p *NullAudioPlayer) Frames() int64 {
+	return atomic.LoadInt64(&p.frames)
+}
+
+func (p *NullAudioPlayer) SetMuted(muted bool) error {
+	atomic.StoreInt32(&p.muted, boolFlag(muted))
+	return nil
+}
+
+func (p *NullAudioPlayer) Muted() bool {
+	return atomic.LoadInt32(&p.muted) == 1
+}
+
+func (p *NullAudioPlayer) SetVolume(volume float64) error {
+	p.mu.Lock()
+	p.volume = volume
+	p.mu.Unlock()
+	return nil
+}
+
+// Returns the volume of the last SetVolume
+func (p *NullAudioPlayer) Volume() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.volume
+}
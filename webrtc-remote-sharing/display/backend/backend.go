@@ -0,0 +1,209 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/remygo/internal/types"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Names of the backends used if the config does not select one. They are registered by
+// the provider package
+const (
+	DefaultCapturer = "gstreamer"
+	DefaultDecoder  = "gstreamer"
+	DefaultWindow   = "gio"
+)
+
+var ErrUnknown = errors.New("unknown backend")
+
+// Codecs of the pure Go backends which do not look at the encoded frames
+var anyCodec = []string{webrtc.MimeTypeH264, webrtc.MimeTypeVP8, webrtc.MimeTypeVP9, webrtc.MimeTypeAV1}
+
+// Captures the screen of the host and writes the encoded frames to the track
+type Capturer interface {
+	Start(settings types.StreamSettings, codecName string, track *webrtc.TrackLocalStaticSample) error
+	Stop() error
+	Reconfigure(settings types.StreamSettings) error // Changes the size, frame rate and quality without stopping
+	Adapt(bitrate, frameRate int) error              // Follows the estimated bandwidth keeping the size
+	RequestKeyFrame() error                          // Makes the next frame a key frame e.g. after the remote lost packets
+	OnActivity(f func())                             // Sets a callback which is invoked whenever the captured screen changes
+	Codecs() []string                                // MIME types of the codecs the capturer can encode
+}
+
+// Decodes the encoded frames assembled from the received track into frames for the window
+type Decoder interface {
+	Start(width, height int, codecName string, frames *FrameRing) error
+	Stop() error
+	Push(frame []byte, pts time.Duration) // Takes a frame of the elementary stream of the codec
+	Resize(width, height int) error       // Changes the size of the decoded frames without stopping
+	Codecs() []string                     // MIME types of the codecs the decoder can decode
+}
+
+// Shows the decoded frames and passes on the input of the user on the remote
+type Window interface {
+	Loop(frames *FrameRing) error // Blocks until the window is closed
+	SetTitle(title string)
+	SetOverlay(text string) // Draws the text over the video. An empty text removes the overlay
+	GetEventQueue() chan *types.RemoteEvent
+}
+
+// Implemented by windows which report their size so that the remote can ask for video
+// which fits the window
+type Resizable interface {
+	OnResize(f func(width, height int))
+}
+
+// Implemented by windows which share the clipboard with the host. They send the text in the
+// clipboard of the remote as a "clipboard" event whenever the window gets the focus
+type Clipboard interface {
+	WriteClipboard(text string)
+}
+
+// Implemented by decoders which notice that they cannot decode the video until the next key
+// frame e.g. after lost packets
+type KeyFrameRequester interface {
+	OnKeyFrameNeeded(f func())
+}
+
+// Implemented by capturers which pass the encoded samples they write to the track on to a
+// callback e.g. to record the session. A nil callback removes it
+type SampleTap interface {
+	OnSample(f func(sample []byte))
+}
+
+// Callback of a SampleTap which is set while the streaming thread writes the samples
+type Tap struct {
+	f atomic.Value
+}
+
+func (t *Tap) Set(f func(sample []byte)) {
+	t.f.Store(f)
+}
+
+// Passes the sample to the callback if one is set
+func (t *Tap) Write(sample []byte) {
+	if f, _ := t.f.Load().(func([]byte)); f != nil {
+		f(sample)
+	}
+}
+
+// Settings passed to a new capturer. Backends ignore the ones they do not support
+type CaptureOptions struct {
+	Source string // Screen source of the GStreamer capture. Empty detects it from the environment
+}
+
+var (
+	mu        sync.RWMutex
+	capturers = map[string]func(CaptureOptions) Capturer{}
+	decoders  = map[string]func() Decoder{}
+	windows   = map[string]func() Window{}
+)
+
+func init() {
+	RegisterCapturer("testpattern", func(CaptureOptions) Capturer { return &TestPattern{} })
+	RegisterDecoder("null", func() Decoder { return &NullDecoder{} })
+	RegisterWindow("null", func() Window { return NewNullWindow() })
+}
+
+// Makes a capturer available by name. Panics if the name is taken
+func RegisterCapturer(name string, f func(CaptureOptions) Capturer) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := capturers[name]; ok {
+		panic(fmt.Sprintf("capturer %q registered twice", name))
+	}
+	capturers[name] = f
+}
+
+// Makes a decoder available by name. Panics if the name is taken
+func RegisterDecoder(name string, f func() Decoder) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := decoders[name]; ok {
+		panic(fmt.Sprintf("decoder %q registered twice", name))
+	}
+	decoders[name] = f
+}
+
+// Makes a window available by name. Panics if the name is taken
+func RegisterWindow(name string, f func() Window) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := windows[name]; ok {
+		panic(fmt.Sprintf("window %q registered twice", name))
+	}
+	windows[name] = f
+}
+
+// Returns a new capturer of the given name or the default one if the name is empty
+func NewCapturer(name string, opts CaptureOptions) (Capturer, error) {
+	if name == "" {
+		name = DefaultCapturer
+	}
+	mu.RLock()
+	f, ok := capturers[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("capturer %q: %w", name, ErrUnknown)
+	}
+	return f(opts), nil
+}
+
+// Returns a new decoder of the given name or the default one if the name is empty
+func NewDecoder(name string) (Decoder, error) {
+	if name == "" {
+		name = DefaultDecoder
+	}
+	mu.RLock()
+	f, ok := decoders[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("decoder %q: %w", name, ErrUnknown)
+	}
+	return f(), nil
+}
+
+// Returns a new window of the given name or the default one if the name is empty
+func NewWindow(name string) (Window, error) {
+	if name == "" {
+		name = DefaultWindow
+	}
+	mu.RLock()
+	f, ok := windows[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("window %q: %w", name, ErrUnknown)
+	}
+	return f(), nil
+}
+
+// Checks that the backends of the given names are registered without creating them since
+// creating a window opens it. Empty names stand for the defaults
+func Check(capturer, decoder, window string) error {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, b := range []struct {
+		kind, name, fallback string
+		ok                   func(string) bool
+	}{
+		{"capturer", capturer, DefaultCapturer, func(n string) bool { _, ok := capturers[n]; return ok }},
+		{"decoder", decoder, DefaultDecoder, func(n string) bool { _, ok := decoders[n]; return ok }},
+		{"window", window, DefaultWindow, func(n string) bool { _, ok := windows[n]; return ok }},
+	} {
+		name := b.name
+		if name == "" {
+			name = b.fallback
+		}
+		if !b.ok(name) {
+			return fmt.Errorf("%s %q: %w", b.kind, name, ErrUnknown)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/remygo/conn/jitter"
+	"github.com/remygo/internal/types"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/vnet"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestRegistry(t *testing.T) {
+	if err := Check("testpattern", "null", "null"); err != nil {
+		t.Errorf("Check of the pure Go backends: %v", err)
+	}
+	// The GStreamer and Gio backends are only registered by the provider package
+	if err := Check("", "null", "null"); !errors.Is(err, ErrUnknown) {
+		t.Errorf("Check of the default capturer: got %v, want %v", err, ErrUnknown)
+	}
+	if _, err := NewDecoder("missing"); !errors.Is(err, ErrUnknown) {
+		t.Errorf("NewDecoder of a missing backend: got %v, want %v", err, ErrUnknown)
+	}
+	if w, err := NewWindow("null"); err != nil {
+		t.Error(err)
+	} else if _, ok := w.(*NullWindow); !ok {
+		t.Errorf("got window %T, want *NullWindow", w)
+	}
+	if err := CheckAudio(""); !errors.Is(err, ErrUnknown) {
+		t.Errorf("CheckAudio of the default backend: got %v, want %v", err, ErrUnknown)
+	}
+	if p, err := NewAudioPlayer("null"); err != nil {
+		t.Error(err)
+	} else if _, ok := p.(*NullAudioPlayer); !ok {
+		t.Errorf("got audio player %T, want *NullAudioPlayer", p)
+	}
+}
+
+// Streams the test pattern from a host to a remote on a virtual network and checks that
+// the frames reassembled by the jitter buffer reach the null decoder
+func TestTestPattern(t *testing.T) {
+	wan, err := vnet.NewRouter(&vnet.RouterConfig{CIDR: "1.2.3.0/24", LoggerFactory: logging.NewDefaultLoggerFactory()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pcs []*webrtc.PeerConnection
+	for _, ip := range []string{"1.2.3.4", "1.2.3.5"} {
+		n := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{ip}})
+		if err := wan.AddNet(n); err != nil {
+			t.Fatal(err)
+		}
+		se := webrtc.SettingEngine{}
+		se.SetVNet(n)
+		m := &webrtc.MediaEngine{}
+		if err := m.RegisterDefaultCodecs(); err != nil {
+			t.Fatal(err)
+		}
+		pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(se)).NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+		pcs = append(pcs, pc)
+	}
+	if err := wan.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer wan.Stop()
+	host, remote := pcs[0], pcs[1]
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := host.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := &NullDecoder{}
+	remote.OnTrack(func(tr *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		buf, err := jitter.New(tr.Codec().MimeType, tr.Codec().ClockRate, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for {
+			pkt, _, err := tr.ReadRTP()
+			if err != nil {
+				return
+			}
+			for _, f := range buf.Push(pkt) {
+				decoder.Push(f.Data, f.PTS)
+			}
+		}
+	})
+
+	// Candidates are gathered up front since the trickle exchange is not under test
+	offer, err := host.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(host)
+	if err := host.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	if err := remote.SetRemoteDescription(*host.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := remote.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered = webrtc.GatheringCompletePromise(remote)
+	if err := remote.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	if err := host.SetRemoteDescription(*remote.LocalDescription()); err != nil {
+		t.Fatal(err)
+	}
+
+	var capturer TestPattern
+	activity := make(chan struct{}, 1)
+	capturer.OnActivity(func() {
+		select {
+		case activity <- struct{}{}:
+		default:
+		}
+	})
+	settings := Fit(types.StreamSettings{Width: 640, Height: 480}, 1920, 1080)
+	if err := capturer.Start(settings, webrtc.MimeTypeVP8, track); err != nil {
+		t.Fatal(err)
+	}
+	defer capturer.Stop()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for decoder.Frames() < DefaultFrameRate {
+		if time.Now().After(deadline) {
+			t.Fatalf("decoder received %d frames, want at least %d", decoder.Frames(), DefaultFrameRate)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-activity:
+	default:
+		t.Error("test pattern reported no activity")
+	}
+
+	// Tripling the frame rate has to show within a second
+	settings.FrameRate = MaxFrameRate
+	if err := capturer.Reconfigure(settings); err != nil {
+		t.Fatal(err)
+	}
+	if got := capturer.Settings(); got != settings {
+		t.Errorf("settings after reconfiguration: got %+v, want %+v", got, settings)
+	}
+	time.Sleep(100 * time.Millisecond)
+	before := decoder.Frames()
+	time.Sleep(time.Second)
+	if got, min := decoder.Frames()-before, int64(2*DefaultFrameRate); got <= min {
+		t.Errorf("decoder received %d frames per second after raising the frame rate, want more than %d", got, min)
+	}
+}
+
+func TestFit(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		req  types.StreamSettings
+		want types.StreamSettings
+	}{
+		{"defaults", types.StreamSettings{}, types.StreamSettings{Width: 1920, Height: 1080, FrameRate: DefaultFrameRate, Quality: types.QualityMedium}},
+		{"window", types.StreamSettings{Width: 1281, Height: 721, FrameRate: 15, Quality: types.QualityHigh},
+			types.StreamSettings{Width: 1280, Height: 720, FrameRate: 15, Quality: types.QualityHigh}},
+		{"larger than the screen", types.StreamSettings{Width: 3840, Height: 2400, FrameRate: 60, Quality: "ultra"},
+			types.StreamSettings{Width: 1728, Height: 1080, FrameRate: MaxFrameRate, Quality: types.QualityMedium}},
+		{"tiny", types.StreamSettings{Width: 100, Height: 50, FrameRate: 5, Quality: types.QualityLow},
+			types.StreamSettings{Width: minWidth, Height: minHeight, FrameRate: 5, Quality: types.QualityLow}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Fit(tc.req, 1920, 1080); got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	low := Bitrate(types.StreamSettings{Width: 1280, Height: 720, FrameRate: 10, Quality: types.QualityLow})
+	high := Bitrate(types.StreamSettings{Width: 1280, Height: 720, FrameRate: 10, Quality: types.QualityHigh})
+	if low <= 0 || high <= low {
+		t.Errorf("bitrates of the low and high tier: got %d and %d, want 0 < low < high", low, high)
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	settings := types.StreamSettings{Width: 1280, Height: 720, FrameRate: 20, Quality: types.QualityMedium}
+	tier := Bitrate(settings)
+	for _, tc := range []struct {
+		name               string
+		settings           types.StreamSettings
+		estimate, min, max int
+		bitrate, frameRate int
+	}{
+		{"above the tier", settings, 5_000_000, 0, 0, tier, 20},
+		{"below the tier", settings, 1_000_000, 0, 0, 1_000_000, 20},
+		{"quarter of the tier", settings, tier / 4, 0, 0, tier / 4, 10},
+		{"below the minimum", settings, 10_000, 0, 0, MinBitrate, 4},
+		{"configured bounds", settings, 100_000, 500_000, 800_000, 500_000, 20},
+		{"configured maximum", settings, 2_000_000, 0, 800_000, 800_000, 20},
+		{"minimum frame rate", types.StreamSettings{Width: 1920, Height: 1080, FrameRate: 10, Quality: types.QualityHigh},
+			0, 0, 0, MinBitrate, MinFrameRate},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bitrate, frameRate := Adapt(tc.settings, tc.estimate, tc.min, tc.max)
+			if bitrate != tc.bitrate || frameRate != tc.frameRate {
+				t.Errorf("got %d bit/s at %d fps, want %d bit/s at %d fps", bitrate, frameRate, tc.bitrate, tc.frameRate)
+			}
+		})
+	}
+}
+
+func TestTap(t *testing.T) {
+	var tap Tap
+	tap.Write([]byte{1}) // Nothing is set yet
+
+	var got [][]byte
+	tap.Set(func(sample []byte) { got = append(got, sample) })
+	tap.Write([]byte{2})
+	tap.Set(nil)
+	tap.Write([]byte{3})
+
+	if len(got) != 1 || got[0][0] != 2 {
+		t.Errorf("got samples %v, want only the one written while the callback was set", got)
+	}
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"image"
+	"sync"
+	"sync/atomic"
+)
+
+// Frame buffers in a ring: the one the decoder writes, the latest one waiting for the window
+// and the one the window shows
+const ringFrames = 3
+
+// Passes the decoded frames from the decoder to the window without allocating once the buffers
+// have the size of the video. The decoder copies each frame once into a buffer of the ring and
+// publishes it. Only the latest published frame waits for the window, so a window which draws
+// slower than the decoder decodes drops frames instead of stalling the decoder.
+// The frames are RGBA which the window draws without converting them. Decoded video is opaque,
+// so its NRGBA pixels are the same
+type FrameRing struct {
+	mu     sync.Mutex
+	free   []*image.RGBA
+	latest *image.RGBA // Published and not taken yet
+	shown  *image.RGBA // Taken by the window and returned to the ring on the next take
+
+	ready   chan struct{}
+	dropped int64 // Accessed atomically
+}
+
+func NewFrameRing() *FrameRing {
+	return &FrameRing{
+		free:  make([]*image.RGBA, 0, ringFrames),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// Returns a buffer for the next frame with the given size. The buffer is allocated anew only
+// if the ones of the ring are too small. It has to be published or released
+func (r *FrameRing) Acquire(width, height int) *image.RGBA {
+	r.mu.Lock()
+	var f *image.RGBA
+	if n := len(r.free); n > 0 {
+		f, r.free = r.free[n-1], r.free[:n-1]
+	}
+	r.mu.Unlock()
+
+	size := width * height * 4
+	if f == nil || cap(f.Pix) < size {
+		f = &image.RGBA{Pix: make([]byte, size)}
+	}
+	f.Pix = f.Pix[:size]
+	f.Stride = width * 4
+	f.Rect = image.Rect(0, 0, width, height)
+	return f
+}
+
+// Returns an acquired buffer which was not published to the ring
+func (r *FrameRing) Release(f *image.RGBA) {
+	r.mu.Lock()
+	r.put(f)
+	r.mu.Unlock()
+}
+
+// Makes the frame the latest one. The previous latest frame is dropped if the window did not
+// take it yet. Never blocks
+func (r *FrameRing) Publish(f *image.RGBA) {
+	r.mu.Lock()
+	if r.latest != nil {
+		r.put(r.latest)
+		atomic.AddInt64(&r.dropped, 1)
+	}
+	r.latest = f
+	r.mu.Unlock()
+
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Receives when a frame was published since the last take
+func (r *FrameRing) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// Returns the latest published frame or nil if there is none since the last take. The frame
+// stays untouched until the next take which returns it to the ring, so the window has to be
+// done drawing it by then
+func (r *FrameRing) Take() *image.RGBA {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.latest
+	if f == nil {
+		return nil
+	}
+	r.latest = nil
+	if r.shown != nil {
+		r.put(r.shown)
+	}
+	r.shown = f
+	return f
+}
+
+// Returns the number of frames which were replaced before the window took them
+func (r *FrameRing) Dropped() int64 {
+	return atomic.LoadInt64(&r.dropped)
+}
+
+// Keeps the buffer for reuse. Buffers beyond the ones the ring needs are left to the garbage
+// collector
+func (r *FrameRing) put(f *image.RGBA) {
+	if len(r.free) < cap(r.free) {
+		r.free = append(r.free, f)
+	}
+}
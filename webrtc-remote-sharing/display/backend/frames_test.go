@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"image"
+	"sync"
+	"testing"
+)
+
+func TestFrameRing(t *testing.T) {
+	r := NewFrameRing()
+	if f := r.Take(); f != nil {
+		t.Fatal("took a frame of an empty ring")
+	}
+
+	// The window was too slow for the first two frames
+	for i := 0; i < 3; i++ {
+		f := r.Acquire(4, 2)
+		f.Pix[0] = byte(i)
+		r.Publish(f)
+	}
+	select {
+	case <-r.Ready():
+	default:
+		t.Error("ring not ready after publishing")
+	}
+	if f := r.Take(); f == nil || f.Pix[0] != 2 {
+		t.Fatalf("took %v, want the latest frame", f)
+	}
+	if f := r.Take(); f != nil {
+		t.Error("took the same frame twice")
+	}
+	if got := r.Dropped(); got != 2 {
+		t.Errorf("dropped %d frames, want 2", got)
+	}
+
+	// The frame the window shows is not handed out again until the next take
+	shown := r.Take()
+	for i := 0; i < 10; i++ {
+		f := r.Acquire(4, 2)
+		if f == shown {
+			t.Fatal("acquired the frame the window shows")
+		}
+		r.Publish(f)
+	}
+
+	// Smaller frames reuse the buffers, larger ones replace them
+	r.Take()
+	small := r.Acquire(2, 2)
+	if small.Bounds() != image.Rect(0, 0, 2, 2) || small.Stride != 8 || len(small.Pix) != 16 || cap(small.Pix) != 32 {
+		t.Errorf("got %v with stride %d and %d of %d bytes, want a reused 2x2 frame",
+			small.Bounds(), small.Stride, len(small.Pix), cap(small.Pix))
+	}
+	r.Release(small)
+	if large := r.Acquire(8, 8); len(large.Pix) != 256 {
+		t.Errorf("got %d bytes for an 8x8 frame, want 256", len(large.Pix))
+	}
+}
+
+func TestFrameRingAllocs(t *testing.T) {
+	r := NewFrameRing()
+	cycle := func() {
+		r.Publish(r.Acquire(64, 64))
+		r.Publish(r.Acquire(64, 64))
+		r.Take()
+	}
+	cycle()
+	if n := testing.AllocsPerRun(100, cycle); n != 0 {
+		t.Errorf("got %.1f allocations per cycle, want 0", n)
+	}
+}
+
+// A fast decoder and a slow window. Every frame the window takes has to be complete
+func TestFrameRingConcurrent(t *testing.T) {
+	r := NewFrameRing()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			f := r.Acquire(32, 32)
+			for j := range f.Pix {
+				f.Pix[j] = byte(i)
+			}
+			r.Publish(f)
+		}
+	}()
+
+	taken := 0
+	for {
+		select {
+		case <-r.Ready():
+			f := r.Take()
+			if f == nil {
+				continue
+			}
+			taken++
+			for _, b := range f.Pix {
+				if b != f.Pix[0] {
+					t.Fatalf("frame %d was overwritten while the window showed it", f.Pix[0])
+				}
+			}
+		case <-done:
+			wg.Wait()
+			if taken == 0 || r.Dropped() == 0 {
+				t.Errorf("took %d frames and dropped %d, want both", taken, r.Dropped())
+			}
+			return
+		}
+	}
+}
+
+// Copies a decoded frame of the size into the ring and takes it like the window
+func benchmarkFrameRing(b *testing.B, width, height int) {
+	r := NewFrameRing()
+	decoded := make([]byte, width*height*4)
+	b.SetBytes(int64(len(decoded)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := r.Acquire(width, height)
+		copy(f.Pix, decoded)
+		r.Publish(f)
+		r.Take()
+	}
+}
+
+// The previous frame path which allocated an image for every frame
+func benchmarkFrameAlloc(b *testing.B, width, height int) {
+	decoded := make([]byte, width*height*4)
+	frames := make(chan *image.NRGBA, 1)
+	b.SetBytes(int64(len(decoded)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		copy(img.Pix, decoded)
+		frames <- img
+		<-frames
+	}
+}
+
+func BenchmarkFrameRing1080p(b *testing.B)  { benchmarkFrameRing(b, 1920, 1080) }
+func BenchmarkFrameRing4K(b *testing.B)     { benchmarkFrameRing(b, 3840, 2160) }
+func BenchmarkFrameAlloc1080p(b *testing.B) { benchmarkFrameAlloc(b, 1920, 1080) }
+func BenchmarkFrameAlloc4K(b *testing.B)    { benchmarkFrameAlloc(b, 3840, 2160) }
@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/remygo/internal/types"
+)
+
+// Counts and drops the received frames instead of decoding them
+type NullDecoder struct {
+	frames int64 // Accessed atomically
+
+	mu            sync.Mutex
+	width, height int
+}
+
+func (d *NullDecoder) Codecs() []string {
+	return anyCodec
+}
+
+func (d *NullDecoder) Start(width, height int, codecName string, frames *FrameRing) error {
+	return d.Resize(width, height)
+}
+
+func (d *NullDecoder) Resize(width, height int) error {
+	d.mu.Lock()
+	d.width, d.height = width, height
+	d.mu.Unlock()
+	return nil
+}
+
+// Returns the size of the last start or resize
+func (d *NullDecoder) Size() (width, height int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.width, d.height
+}
+
+func (d *NullDecoder) Stop() error {
+	return nil
+}
+
+func (d *NullDecoder) Push(frame []byte, pts time.Duration) {
+	atomic.AddInt64(&d.frames, 1)
+}
+
+// Returns the number of frames pushed so far
+func (d *NullDecoder) Frames() int64 {
+	return atomic.LoadInt64(&d.frames)
+}
+
+// Window without a display. It drops the frames, never produces input and keeps the title,
+// overlay and clipboard so that tests can check them
+type NullWindow struct {
+	events chan *types.RemoteEvent
+	closed chan struct{}
+	once   sync.Once
+
+	mu        sync.Mutex
+	title     string
+	overlay   string
+	clipboard string
+}
+
+func NewNullWindow() *NullWindow {
+	return &NullWindow{
+		events: make(chan *types.RemoteEvent),
+		closed: make(chan struct{}),
+	}
+}
+
+// Drops frames until Close is called. The event queue is closed afterwards like the one of the
+// Gio window so that readers of the input stop
+func (w *NullWindow) Loop(frames *FrameRing) error {
+	defer close(w.events)
+	for {
+		select {
+		case <-frames.Ready():
+			frames.Take()
+		case <-w.closed:
+			return nil
+		}
+	}
+}
+
+func (w *NullWindow) Close() {
+	w.once.Do(func() { close(w.closed) })
+}
+
+func (w *NullWindow) SetTitle(title string) {
+	w.mu.Lock()
+	w.title = title
+	w.mu.Unlock()
+}
+
+func (w *NullWindow) SetOverlay(text string) {
+	w.mu.Lock()
+	w.overlay = text
+	w.mu.Unlock()
+}
+
+func (w *NullWindow) Title() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.title
+}
+
+func (w *NullWindow) Overlay() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.overlay
+}
+
+func (w *NullWindow) WriteClipboard(text string) {
+	w.mu.Lock()
+	w.clipboard = text
+	w.mu.Unlock()
+}
+
+// Returns the text the host wrote into the clipboard
+func (w *NullWindow) ClipboardText() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.clipboard
+}
+
+func (w *NullWindow) GetEventQueue() chan *types.RemoteEvent {
+	return w.events
+}
@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"github.com/remygo/internal/types"
+)
+
+// Frame rates of the capture. Screen content rarely needs more than the maximum
+const (
+	DefaultFrameRate = 10
+	MaxFrameRate     = 30
+)
+
+// Lower bounds of the capture when the bandwidth drops. Below the bitrate the video is not
+// readable anymore at any frame rate
+const (
+	MinBitrate   = 150_000
+	MinFrameRate = 2
+)
+
+// Smallest size of the video. Smaller windows scale the video down themselves
+const (
+	minWidth  = 320
+	minHeight = 180
+)
+
+// Bits spent on every pixel of every frame in thousandths by quality tier
+var bitsPerPixel = map[string]int{
+	types.QualityLow:    40,
+	types.QualityMedium: 80,
+	types.QualityHigh:   150,
+}
+
+// Returns the settings the host applies for the requested ones. The size fits into the screen
+// of the host keeping the requested aspect ratio since upscaling only costs bandwidth. Both
+// dimensions are even for the I420 encoders. A missing size, frame rate or quality is
+// replaced by the screen size, the default frame rate and the medium tier
+func Fit(req types.StreamSettings, screenWidth, screenHeight int) types.StreamSettings {
+	s := req
+	if s.Width <= 0 || s.Height <= 0 {
+		s.Width, s.Height = screenWidth, screenHeight
+	}
+	if s.Width > screenWidth {
+		s.Width, s.Height = screenWidth, s.Height*screenWidth/s.Width
+	}
+	if s.Height > screenHeight {
+		s.Width, s.Height = s.Width*screenHeight/s.Height, screenHeight
+	}
+	if s.Width < minWidth {
+		s.Width = minWidth
+	}
+	if s.Height < minHeight {
+		s.Height = minHeight
+	}
+	s.Width, s.Height = s.Width&^1, s.Height&^1
+
+	switch {
+	case s.FrameRate <= 0:
+		s.FrameRate = DefaultFrameRate
+	case s.FrameRate > MaxFrameRate:
+		s.FrameRate = MaxFrameRate
+	}
+	if _, ok := bitsPerPixel[s.Quality]; !ok {
+		s.Quality = types.QualityMedium
+	}
+	return s
+}
+
+// Returns the target bitrate of the encoder in bits per second
+func Bitrate(s types.StreamSettings) int {
+	bpp, ok := bitsPerPixel[s.Quality]
+	if !ok {
+		bpp = bitsPerPixel[types.QualityMedium]
+	}
+	return s.Width * s.Height * s.FrameRate * bpp / 1000
+}
+
+// Returns the bitrate and frame rate which follow the estimated bandwidth in bits per second.
+// The bitrate stays between the bounds of which zero ones are the minimum and the bitrate of
+// the quality tier. Below half of the upper bound the frame rate drops with the bitrate so
+// that every frame keeps enough bits to stay readable
+func Adapt(s types.StreamSettings, estimate, minBitrate, maxBitrate int) (bitrate, frameRate int) {
+	if minBitrate <= 0 {
+		minBitrate = MinBitrate
+	}
+	if maxBitrate <= 0 {
+		maxBitrate = Bitrate(s)
+	}
+	if maxBitrate < minBitrate {
+		maxBitrate = minBitrate
+	}
+
+	bitrate = estimate
+	switch {
+	case bitrate < minBitrate:
+		bitrate = minBitrate
+	case bitrate > maxBitrate:
+		bitrate = maxBitrate
+	}
+
+	frameRate = s.FrameRate
+	if bitrate < maxBitrate/2 {
+		frameRate = s.FrameRate * bitrate * 2 / maxBitrate
+	}
+	if frameRate < MinFrameRate {
+		frameRate = MinFrameRate
+	}
+	if frameRate > s.FrameRate {
+		frameRate = s.FrameRate
+	}
+	return bitrate, frameRate
+}
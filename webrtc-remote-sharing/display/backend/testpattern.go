@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/remygo/internal/types"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// Size of the frames of the test pattern
+const testPatternSize = 2048
+
+// Writes a numbered synthetic frame to the track at a fixed rate instead of capturing the
+// screen. The frames are not decodable video but exercise the media path without GStreamer
+type TestPattern struct {
+	onActivity func()
+	stop       chan struct{}
+	done       chan struct{}
+	rate       chan int // Frame rates of reconfigurations of the running pattern
+	keyFrame   int32    // Set atomically when the next frame is a key frame
+
+	mu       sync.Mutex
+	settings types.StreamSettings
+}
+
+// Sets a callback which is invoked for every frame since the pattern changes constantly
+func (t *TestPattern) OnActivity(f func()) {
+	t.onActivity = f
+}
+
+// The frames are not real video so every codec with a payloader works
+func (t *TestPattern) Codecs() []string {
+	return anyCodec
+}
+
+func (t *TestPattern) Start(settings types.StreamSettings, codecName string, track *webrtc.TrackLocalStaticSample) error {
+	if t.stop != nil {
+		return errors.New("test pattern already started")
+	}
+	log.Printf("[TEST] Starting %s test pattern at %dx%d %d fps", codecName, settings.Width, settings.Height, settings.FrameRate)
+
+	t.setSettings(settings)
+	t.stop, t.done, t.rate = make(chan struct{}), make(chan struct{}), make(chan int, 1)
+	go t.run(track, settings.FrameRate)
+	return nil
+}
+
+// Changes the frame rate of the running pattern. The size and quality are only kept since
+// the frames are not real video
+func (t *TestPattern) Reconfigure(settings types.StreamSettings) error {
+	if t.stop == nil {
+		return errors.New("test pattern not started")
+	}
+	log.Printf("[TEST] Reconfiguring test pattern to %dx%d %d fps", settings.Width, settings.Height, settings.FrameRate)
+
+	t.setSettings(settings)
+	t.setRate(settings.FrameRate)
+	return nil
+}
+
+// Changes the frame rate of the running pattern. The bitrate is only logged since the frames
+// have a fixed size
+func (t *TestPattern) Adapt(bitrate, frameRate int) error {
+	if t.stop == nil {
+		return errors.New("test pattern not started")
+	}
+	log.Printf("[TEST] Adapting test pattern to %d fps for %d bit/s", frameRate, bitrate)
+
+	t.setRate(frameRate)
+	return nil
+}
+
+// Makes the next frame start like an H.264 IDR slice
+func (t *TestPattern) RequestKeyFrame() error {
+	atomic.StoreInt32(&t.keyFrame, 1)
+	return nil
+}
+
+// Only the latest rate matters if the pattern has not picked up the previous one
+func (t *TestPattern) setRate(rate int) {
+	select {
+	case <-t.rate:
+	default:
+	}
+	t.rate <- rate
+}
+
+// Returns the settings of the last start or reconfiguration
+func (t *TestPattern) Settings() types.StreamSettings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.settings
+}
+
+func (t *TestPattern) setSettings(settings types.StreamSettings) {
+	t.mu.Lock()
+	t.settings = settings
+	t.mu.Unlock()
+}
+
+func (t *TestPattern) Stop() error {
+	if t.stop == nil {
+		return nil
+	}
+	log.Println("[TEST] Stopping test pattern")
+	close(t.stop)
+	<-t.done
+	t.stop = nil
+	return nil
+}
+
+func (t *TestPattern) run(track *webrtc.TrackLocalStaticSample, rate int) {
+	defer close(t.done)
+
+	interval := frameInterval(rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	frame := make([]byte, testPatternSize)
+	for n := uint32(0); ; n++ {
+		select {
+		case <-t.stop:
+			return
+		case rate := <-t.rate:
+			interval = frameInterval(rate)
+			ticker.Reset(interval)
+			continue
+		case <-ticker.C:
+		}
+
+		// Starts like an H.264 slice since the H.264 payloader drops some NAL unit types
+		frame[0] = 0x41
+		if atomic.CompareAndSwapInt32(&t.keyFrame, 1, 0) {
+			frame[0] = 0x65
+		}
+		binary.BigEndian.PutUint32(frame[1:], n)
+		for i := 5; i < len(frame); i++ {
+			frame[i] = byte(i + int(n))
+		}
+		if err := track.WriteSample(media.Sample{Data: frame, Duration: interval}); err != nil {
+			log.Printf("[ERR] Writing test pattern: %v", err)
+		}
+		if t.onActivity != nil {
+			t.onActivity()
+		}
+	}
+}
+
+func frameInterval(rate int) time.Duration {
+	if rate <= 0 {
+		rate = DefaultFrameRate
+	}
+	return time.Second / time.Duration(rate)
+}
@@ -0,0 +1,152 @@
+package capture
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/remygo/display/backend"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/tinyzimmer/go-gst/gst"
+	"github.com/tinyzimmer/go-gst/gst/app"
+)
+
+// Sound source of the tests which needs neither a sound card nor a sound server
+const AudioTestSource = "test"
+
+// Duration of the Opus frames. Samples without a duration are assumed to have it
+const opusFrameDuration = 20 * time.Millisecond
+
+// Captures the sound the host plays or a microphone and encodes it with Opus
+type GstAudioCapture struct {
+	Source     string // Device of the sound server, AudioTestSource or empty for the default output or input
+	Microphone bool   // Captures an input for the voice chat
+	Muted      bool   // Set by SetMuted before the start too
+	pipeline   *gst.Pipeline
+	track      *webrtc.TrackLocalStaticSample
+	tap        backend.Tap
+}
+
+// Returns the element capturing the sound of the source. PulseAudio and PipeWire, which
+// serves the PulseAudio clients too, offer a monitor of every output. Windows records the
+// output in loopback mode. Microphones are the default input without a device
+func audioSource(name string, microphone bool) string {
+	switch {
+	case name == AudioTestSource:
+		return "audiotestsrc is-live=true wave=ticks"
+	case name != "":
+		return fmt.Sprintf("pulsesrc device=%q", name)
+	case runtime.GOOS == "windows" && microphone:
+		return "wasapisrc low-latency=true"
+	case runtime.GOOS == "windows":
+		return "wasapisrc loopback=true low-latency=true"
+	case microphone:
+		return "pulsesrc"
+	default:
+		return "pulsesrc device=@DEFAULT_MONITOR@"
+	}
+}
+
+// Settings of the Opus encoder. Voice needs a lower bitrate and sends almost nothing while
+// the microphone is muted or silent
+func opusEncoder(microphone bool) string {
+	if microphone {
+		return "opusenc audio-type=voice bitrate=32000 dtx=true"
+	}
+	return "opusenc bitrate=64000"
+}
+
+// The volume element mutes without stopping the stream, so the encoder keeps sending
+// frames of silence and the remote keeps its timing
+func (g *GstAudioCapture) createPipeline() (*gst.Pipeline, error) {
+	gst.Init(nil)
+
+	pipelineStr := fmt.Sprintf("%s ! queue ! audioconvert ! audioresample ! audio/x-raw,rate=48000,channels=2"+
+		" ! volume name=volume mute=%t ! %s frame-size=%d ! appsink name=appsink sync=false",
+		audioSource(g.Source, g.Microphone), g.Muted, opusEncoder(g.Microphone), opusFrameDuration/time.Millisecond)
+
+	log.Printf("[GST] Creating audio pipeline: %s", pipelineStr)
+
+	pipeline, err := gst.NewPipelineFromString(pipelineStr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing audio pipeline: %w", err)
+	}
+	return pipeline, nil
+}
+
+func (g *GstAudioCapture) Start(track *webrtc.TrackLocalStaticSample) error {
+	pipeline, err := g.createPipeline()
+	if err != nil {
+		return err
+	}
+	g.pipeline = pipeline
+	g.track = track
+
+	log.Println("[GST] Starting audio pipeline")
+
+	pipeline.GetBus().AddWatch(func(msg *gst.Message) bool {
+		switch msg.Type() {
+		case gst.MessageEOS:
+			_ = g.pipeline.BlockSetState(gst.StateNull)
+		case gst.MessageError:
+			log.Printf("[ERR] Audio capture: %v", msg.ParseError())
+		}
+		return true
+	})
+	appsink, err := pipeline.GetElementByName("appsink")
+	if err != nil {
+		return fmt.Errorf("finding appsink in audio pipeline: %w", err)
+	}
+	app.SinkFromElement(appsink).SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(appSink *app.Sink) gst.FlowReturn {
+			sample := appSink.PullSample()
+			if sample == nil {
+				return gst.FlowEOS
+			}
+			buffer := sample.GetBuffer()
+			if buffer == nil {
+				return gst.FlowError
+			}
+			duration := buffer.Duration()
+			if duration <= 0 {
+				duration = opusFrameDuration
+			}
+			data := buffer.Bytes()
+			if err := g.track.WriteSample(media.Sample{Data: data, Duration: duration}); err != nil {
+				return gst.FlowError
+			}
+			g.tap.Write(data)
+			return gst.FlowOK
+		}})
+
+	return pipeline.SetState(gst.StatePlaying)
+}
+
+// Sets a callback which is invoked with every Opus frame written to the track
+func (g *GstAudioCapture) OnSample(f func(sample []byte)) {
+	g.tap.Set(f)
+}
+
+// Mutes or unmutes the captured sound while running
+func (g *GstAudioCapture) SetMuted(muted bool) error {
+	g.Muted = muted
+	if g.pipeline == nil {
+		return nil
+	}
+	volume, err := g.pipeline.GetElementByName("volume")
+	if err != nil {
+		return err
+	}
+	return volume.SetProperty("mute", muted)
+}
+
+func (g *GstAudioCapture) Stop() error {
+	log.Println("[GST] Stopping audio pipeline")
+	if g.pipeline == nil {
+		return nil
+	}
+	return g.pipeline.SetState(gst.StateNull)
+}
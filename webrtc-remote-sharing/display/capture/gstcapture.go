@@ -1,59 +1,179 @@
 package capture
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/remygo/display/backend"
+	"github.com/remygo/display/capture/source"
+	"github.com/remygo/internal/types"
+
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/tinyzimmer/go-glib/glib"
 	"github.com/tinyzimmer/go-gst/gst"
 	"github.com/tinyzimmer/go-gst/gst/app"
 )
 
+// Encoded delta frames of an unchanged screen are only a few bytes. Anything
+// larger is counted as a frame change for the idle timeout of the session
+const frameChangeThreshold = 1024
+
+// Encoders by codec in the order they are tried. The first word is the name of the element
+// which has to be installed. AV1 is parsed into the temporal units the pion payloader expects
+var encoders = []struct {
+	codec    string
+	elements []string
+}{
+	{"video/h264", []string{"x264enc speed-preset=ultrafast tune=zerolatency psy-tune=film vbv-buf-capacity=50 key-int-max=60 subme=10"}},
+	{"video/vp8", []string{"vp8enc error-resilient=partitions keyframe-max-dist=10 auto-alt-ref=true cpu-used=5 deadline=1"}},
+	{"video/vp9", []string{"vp9enc error-resilient=default keyframe-max-dist=60 lag-in-frames=0 row-mt=true cpu-used=8 deadline=1"}},
+	{"video/av1", []string{
+		"svtav1enc preset=12",
+		"rav1enc speed-preset=10 low-latency=true",
+		"av1enc cpu-used=10 end-usage=cbr usage-profile=realtime",
+	}},
+}
+
+const av1Caps = " ! av1parse ! video/x-av1,stream-format=obu-stream,alignment=tu"
+
+// Bitrate property of each encoder and the bits per second of one unit of it
+var bitrateProperties = map[string]struct {
+	name string
+	unit int
+}{
+	"x264enc":   {"bitrate", 1000},
+	"vp8enc":    {"target-bitrate", 1},
+	"vp9enc":    {"target-bitrate", 1},
+	"svtav1enc": {"target-bitrate", 1000},
+	"rav1enc":   {"bitrate", 1},
+	"av1enc":    {"target-bitrate", 1000},
+}
+
 type GstCapture struct {
-	pipeline *gst.Pipeline
-	track    *webrtc.TrackLocalStaticSample
+	Source     string // Screen source. Empty detects it from the environment
+	stream     *source.Stream
+	pipeline   *gst.Pipeline // Set while the capture runs. Guarded by mu
+	track      *webrtc.TrackLocalStaticSample
+	onActivity func()
+	tap        backend.Tap
+
+	// The remote reconfigures the capture and the bandwidth estimate adapts it concurrently
+	// while the session may stop it
+	mu        sync.Mutex
+	settings  types.StreamSettings
+	frameRate int // Frame rate of the caps which is lower than the one of the settings if adapted
+}
+
+// Sets a callback which is invoked whenever the captured screen changes
+func (g *GstCapture) OnActivity(f func()) {
+	g.onActivity = f
 }
 
-func (g *GstCapture) createPipeline(width, height int, codecName string) *gst.Pipeline {
+// Returns the codecs of the installed encoders
+func (g *GstCapture) Codecs() []string {
 	gst.Init(nil)
 
-	pipelineSrc := fmt.Sprintf("gdiscreencapsrc do-timestamp=true cursor=true ! queue ! videoconvert ! "+
-		"queue ! videoscale ! video/x-raw,format=I420,framerate=10/1,width=%d,height=%d ! queue", width, height)
+	var codecs []string
+	for _, e := range encoders {
+		if encoder(e.elements) != "" {
+			codecs = append(codecs, e.codec)
+		}
+	}
+	return codecs
+}
+
+// Returns the first of the elements which is installed
+func encoder(elements []string) string {
+	for _, e := range elements {
+		if gst.Find(strings.Fields(e)[0]) != nil {
+			return e
+		}
+	}
+	return ""
+}
+
+// Caps of the raw frames which are fed to the encoder
+func rawCaps(settings types.StreamSettings) string {
+	return fmt.Sprintf("video/x-raw,format=I420,framerate=%d/1,width=%d,height=%d",
+		settings.FrameRate, settings.Width, settings.Height)
+}
+
+func (g *GstCapture) createPipeline(settings types.StreamSettings, codecName string) (*gst.Pipeline, error) {
+	gst.Init(nil)
 
-	pipelineStr := "appsink name=appsink sync=false qos=true drop=true"
+	var enc string
+	for _, e := range encoders {
+		if e.codec == strings.ToLower(codecName) {
+			enc = encoder(e.elements)
+		}
+	}
+	if enc == "" {
+		return nil, fmt.Errorf("no encoder for %s", codecName)
+	}
+	// Named so that the bitrate can be changed while running
+	fields := strings.Fields(enc)
+	enc = strings.Join(append([]string{fields[0], "name=encoder"}, fields[1:]...), " ")
+	if strings.EqualFold(codecName, webrtc.MimeTypeAV1) {
+		enc += av1Caps
+	}
 
-	switch strings.ToLower(codecName) {
-	case "video/h264":
-		pipelineStr = pipelineSrc + " ! x264enc speed-preset=ultrafast tune=zerolatency psy-tune=film vbv-buf-capacity=50 key-int-max=60 subme=10 ! queue ! " + pipelineStr
-	case "video/vp8":
-		pipelineStr = pipelineSrc + " ! vp8enc error-resilient=partitions keyframe-max-dist=10 auto-alt-ref=true cpu-used=5 deadline=1 ! queue ! " + pipelineStr
-	default:
-		log.Fatal("[GST] Unhandled codec " + codecName)
+	name, err := source.Resolve(g.Source, runtime.GOOS, os.Getenv)
+	if err != nil {
+		return nil, err
 	}
+	if name == source.PipeWire {
+		if g.stream, err = source.OpenStream(); err != nil {
+			return nil, fmt.Errorf("opening screen cast portal: %w", err)
+		}
+	}
+	element, err := source.Element(name, g.stream)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[GST] Capturing the %s screen source", name)
+
+	// The X11 and PipeWire sources produce frames only on damage so videorate keeps the rate
+	// constant. Setting the caps of the filter renegotiates the size and rate while running
+	pipelineSrc := fmt.Sprintf("%s ! queue ! videoconvert ! queue ! videoscale ! videorate ! "+
+		"capsfilter name=caps caps=\"%s\" ! queue", element, rawCaps(settings))
+
+	pipelineStr := pipelineSrc + " ! " + enc + " ! queue ! appsink name=appsink sync=false qos=true drop=true"
 
 	log.Printf("[GST] Creating pipeline: %s", pipelineStr)
 
 	pipeline, err := gst.NewPipelineFromString(pipelineStr)
 	if err != nil {
-		log.Fatal("[ERR] Cannot parse launch from pipeline string")
+		return nil, fmt.Errorf("parsing pipeline: %w", err)
 	}
-	return pipeline
+	return pipeline, nil
 }
 
-func (g *GstCapture) Start(width, height int, codecName string, track *webrtc.TrackLocalStaticSample) error {
-	pipeline := g.createPipeline(width, height, codecName)
-	g.pipeline = pipeline
+func (g *GstCapture) Start(settings types.StreamSettings, codecName string, track *webrtc.TrackLocalStaticSample) error {
+	pipeline, err := g.createPipeline(settings, codecName)
+	if err != nil {
+		g.closeStream()
+		return err
+	}
 	g.track = track
+	g.mu.Lock()
+	g.pipeline = pipeline
+	g.settings, g.frameRate = settings, settings.FrameRate
+	g.setBitrate(backend.Bitrate(settings))
+	g.mu.Unlock()
 
 	log.Println("[GST] Starting pipeline")
 
 	pipeline.GetBus().AddWatch(func(msg *gst.Message) bool {
 		switch msg.Type() {
 		case gst.MessageEOS:
-			_ = g.pipeline.BlockSetState(gst.StateNull)
+			_ = pipeline.BlockSetState(gst.StateNull)
 		case gst.MessageError:
 			err := msg.ParseError()
 			fmt.Println("[ERR]:", err.Error())
@@ -79,6 +199,10 @@ func (g *GstCapture) Start(width, height int, codecName string, track *webrtc.Tr
 
 			buf := buffer.Extract(0, buffer.GetSize())
 
+			if g.onActivity != nil && buffer.HasFlags(gst.BufferFlagDeltaUnit) && len(buf) > frameChangeThreshold {
+				g.onActivity()
+			}
+
 			g.writeToTrack(buf, buffer.Duration())
 
 			return gst.FlowOK
@@ -87,13 +211,142 @@ func (g *GstCapture) Start(width, height int, codecName string, track *webrtc.Tr
 	return pipeline.SetState(gst.StatePlaying)
 }
 
+// Changes the caps of the raw frames and the bitrate of the encoder. The encoder starts over
+// with a key frame of the new size so the session and the track are kept
+func (g *GstCapture) Reconfigure(settings types.StreamSettings) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pipeline == nil {
+		return errors.New("pipeline not started")
+	}
+	log.Printf("[GST] Reconfiguring capture to %dx%d at %d fps with %s quality",
+		settings.Width, settings.Height, settings.FrameRate, settings.Quality)
+
+	if err := g.setCaps(settings); err != nil {
+		return err
+	}
+	g.settings, g.frameRate = settings, settings.FrameRate
+	g.setBitrate(backend.Bitrate(settings))
+	return nil
+}
+
+// Sets the bitrate of the encoder and lowers the frame rate below the one of the settings
+// following the estimated bandwidth. The caps are only set again if the frame rate changed
+// since the encoder starts over with a key frame
+func (g *GstCapture) Adapt(bitrate, frameRate int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pipeline == nil {
+		return errors.New("pipeline not started")
+	}
+	if frameRate != g.frameRate {
+		log.Printf("[GST] Adapting capture to %d fps", frameRate)
+		settings := g.settings
+		settings.FrameRate = frameRate
+		if err := g.setCaps(settings); err != nil {
+			return err
+		}
+		g.frameRate = frameRate
+	}
+	g.setBitrate(bitrate)
+	return nil
+}
+
+// Asks the encoder for a key frame with a force key unit event sent upstream from the sink.
+// The headers are repeated so that a remote which missed them can decode the key frame
+func (g *GstCapture) RequestKeyFrame() error {
+	g.mu.Lock()
+	pipeline := g.pipeline
+	g.mu.Unlock()
+	if pipeline == nil {
+		return errors.New("pipeline not started")
+	}
+	sink, err := pipeline.GetElementByName("appsink")
+	if err != nil {
+		return err
+	}
+	event := gst.NewCustomEvent(gst.EventTypeCustomUpstream,
+		gst.NewStructureFromString("GstForceKeyUnit, all-headers=(boolean)true"))
+	if !sink.SendEvent(event) {
+		return errors.New("force key unit event not handled")
+	}
+	return nil
+}
+
+// Sets the caps of the raw frames which renegotiates the size and the rate while running
+func (g *GstCapture) setCaps(settings types.StreamSettings) error {
+	caps, err := g.pipeline.GetElementByName("caps")
+	if err != nil {
+		return err
+	}
+	if err := caps.SetProperty("caps", gst.NewCapsFromString(rawCaps(settings))); err != nil {
+		return fmt.Errorf("setting caps: %w", err)
+	}
+	return nil
+}
+
+// Sets the bitrate in bits per second on the encoder. Encoders without a known bitrate
+// property keep their default
+func (g *GstCapture) setBitrate(bitrate int) {
+	encoder, err := g.pipeline.GetElementByName("encoder")
+	if err != nil {
+		log.Printf("[WARN] Setting bitrate: %v", err)
+		return
+	}
+	prop, ok := bitrateProperties[encoder.GetFactory().GetName()]
+	if !ok {
+		return
+	}
+	value := bitrate / prop.unit
+	typ, err := encoder.GetPropertyType(prop.name)
+	if err != nil {
+		log.Printf("[WARN] Setting bitrate: %v", err)
+		return
+	}
+	// The property is an int or a uint depending on the encoder
+	if typ == glib.TYPE_UINT {
+		err = encoder.SetProperty(prop.name, uint(value))
+	} else {
+		err = encoder.SetProperty(prop.name, value)
+	}
+	if err != nil {
+		log.Printf("[WARN] Setting bitrate: %v", err)
+	}
+}
+
 func (g *GstCapture) Stop() error {
 	fmt.Println("[GST] Stopping pipeline")
-	return g.pipeline.SetState(gst.StateNull)
+	g.mu.Lock()
+	pipeline := g.pipeline
+	g.pipeline = nil
+	g.mu.Unlock()
+	if pipeline == nil {
+		return nil
+	}
+	err := pipeline.SetState(gst.StateNull)
+	g.closeStream()
+	return err
+}
+
+// Ends the portal session of the PipeWire source
+func (g *GstCapture) closeStream() {
+	if g.stream == nil {
+		return
+	}
+	if err := g.stream.Close(); err != nil {
+		log.Printf("[WARN] Closing screen cast session: %v", err)
+	}
+	g.stream = nil
 }
 
 func (g *GstCapture) writeToTrack(buffer []byte, bufferDuration time.Duration) {
 	if err := g.track.WriteSample(media.Sample{Data: buffer, Duration: bufferDuration}); err != nil {
 		panic(err)
 	}
+	g.tap.Write(buffer)
+}
+
+// Sets a callback which is invoked with every encoded frame written to the track
+func (g *GstCapture) OnSample(f func(sample []byte)) {
+	g.tap.Set(f)
 }
@@ -0,0 +1,188 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	portalDest       = "org.freedesktop.portal.Desktop"
+	portalPath       = "/org/freedesktop/portal/desktop"
+	screenCast       = "org.freedesktop.portal.ScreenCast"
+	portalTimeout    = 2 * time.Minute // The user picks the screen in a dialog of the portal
+	sourceMonitor    = uint32(1)
+	cursorEmbedded   = uint32(2)
+	responseCanceled = uint32(1)
+)
+
+var tokens uint32
+
+// PipeWire stream of a ScreenCast portal session. The session ends when the stream is closed
+type Stream struct {
+	FD      int    // PipeWire remote the stream is read from
+	Node    uint32 // PipeWire node of the shared monitor
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+}
+
+// Asks the ScreenCast portal for a monitor to share. The portal shows a dialog in which the
+// user picks the monitor, so this blocks until the user decided or the portal timed out
+func OpenStream() (*Stream, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), portalTimeout)
+	defer cancel()
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the session bus: %w", err)
+	}
+	s := &Stream{FD: -1, conn: conn}
+	if err := s.open(ctx); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Stream) open(ctx context.Context) error {
+	portal := s.conn.Object(portalDest, portalPath)
+
+	log.Println("[PORTAL] Creating screen cast session")
+	results, err := s.request(ctx, "CreateSession", map[string]dbus.Variant{
+		"session_handle_token": dbus.MakeVariant(token()),
+	})
+	if err != nil {
+		return err
+	}
+	handle, ok := results["session_handle"].Value().(string)
+	if !ok {
+		return errors.New("portal returned no session handle")
+	}
+	s.session = dbus.ObjectPath(handle)
+
+	options := map[string]dbus.Variant{
+		"types":    dbus.MakeVariant(sourceMonitor),
+		"multiple": dbus.MakeVariant(false),
+	}
+	if modes, err := portal.GetProperty(screenCast + ".AvailableCursorModes"); err == nil {
+		if m, ok := modes.Value().(uint32); ok && m&cursorEmbedded != 0 {
+			options["cursor_mode"] = dbus.MakeVariant(cursorEmbedded)
+		}
+	}
+	if _, err := s.request(ctx, "SelectSources", options); err != nil {
+		return err
+	}
+
+	log.Println("[PORTAL] Waiting for the user to pick a screen")
+	results, err = s.request(ctx, "Start", map[string]dbus.Variant{})
+	if err != nil {
+		return err
+	}
+	var streams []struct {
+		Node  uint32
+		Props map[string]dbus.Variant
+	}
+	if err := dbus.Store([]interface{}{results["streams"].Value()}, &streams); err != nil || len(streams) == 0 {
+		return fmt.Errorf("portal returned no stream: %v", err)
+	}
+	s.Node = streams[0].Node
+
+	var fd dbus.UnixFD
+	if err := portal.Call(screenCast+".OpenPipeWireRemote", 0, s.session, map[string]dbus.Variant{}).Store(&fd); err != nil {
+		return fmt.Errorf("opening pipewire remote: %w", err)
+	}
+	s.FD = int(fd)
+	log.Printf("[PORTAL] Sharing pipewire node %d", s.Node)
+	return nil
+}
+
+// Returns the arguments of a method of the ScreenCast portal which creates a request:
+//
+//	CreateSession(a{sv} options)
+//	SelectSources(o session_handle, a{sv} options)
+//	Start(o session_handle, s parent_window, a{sv} options)
+//
+// The dialog of Start has no parent window
+func requestArgs(method string, session dbus.ObjectPath, options map[string]dbus.Variant) []interface{} {
+	switch method {
+	case "CreateSession":
+		return []interface{}{options}
+	case "Start":
+		return []interface{}{session, "", options}
+	default:
+		return []interface{}{session, options}
+	}
+}
+
+// Calls a method of the ScreenCast portal and waits for the response of the request object
+// it creates. The handle token is added to the options of the method
+func (s *Stream) request(ctx context.Context, method string, options map[string]dbus.Variant) (map[string]dbus.Variant, error) {
+	// The path of the request is known in advance so that the response cannot be missed
+	t := token()
+	sender := strings.ReplaceAll(strings.TrimPrefix(s.conn.Names()[0], ":"), ".", "_")
+	path := dbus.ObjectPath(fmt.Sprintf("%s/request/%s/%s", portalPath, sender, t))
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface("org.freedesktop.portal.Request"),
+		dbus.WithMatchMember("Response"),
+	}
+	if err := s.conn.AddMatchSignal(match...); err != nil {
+		return nil, err
+	}
+	defer s.conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 1)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	options["handle_token"] = dbus.MakeVariant(t)
+	args := requestArgs(method, s.session, options)
+	if err := s.conn.Object(portalDest, portalPath).CallWithContext(ctx, screenCast+"."+method, 0, args...).Err; err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s: %w", method, ctx.Err())
+		case sig := <-signals:
+			if sig.Path != path || len(sig.Body) != 2 {
+				continue
+			}
+			response, _ := sig.Body[0].(uint32)
+			results, _ := sig.Body[1].(map[string]dbus.Variant)
+			switch response {
+			case 0:
+				return results, nil
+			case responseCanceled:
+				return nil, fmt.Errorf("%s: canceled by the user", method)
+			default:
+				return nil, fmt.Errorf("%s: portal responded with %d", method, response)
+			}
+		}
+	}
+}
+
+// Ends the portal session and closes the PipeWire remote
+func (s *Stream) Close() error {
+	var err error
+	if s.session != "" {
+		err = s.conn.Object(portalDest, s.session).Call("org.freedesktop.portal.Session.Close", 0).Err
+	}
+	if s.FD >= 0 {
+		os.NewFile(uintptr(s.FD), "pipewire").Close()
+	}
+	s.conn.Close()
+	return err
+}
+
+func token() string {
+	return fmt.Sprintf("remygo%d", atomic.AddUint32(&tokens, 1))
+}
@@ -0,0 +1,30 @@
+package source
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestRequestArgs(t *testing.T) {
+	const session = dbus.ObjectPath("/org/freedesktop/portal/desktop/session/1_42/remygo1")
+	options := map[string]dbus.Variant{"handle_token": dbus.MakeVariant("remygo2")}
+
+	// Signatures of the methods in the ScreenCast portal interface
+	for method, want := range map[string]string{
+		"CreateSession": "a{sv}",
+		"SelectSources": "oa{sv}",
+		"Start":         "osa{sv}",
+	} {
+		args := requestArgs(method, session, options)
+		if got := dbus.SignatureOf(args...).String(); got != want {
+			t.Errorf("%s called with %s, want %s", method, got, want)
+		}
+		if method != "CreateSession" && args[0] != session {
+			t.Errorf("%s called on session %v", method, args[0])
+		}
+		if last, ok := args[len(args)-1].(map[string]dbus.Variant); !ok || last["handle_token"] != options["handle_token"] {
+			t.Errorf("%s called without the options last: %v", method, args)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Screen sources of the GStreamer capture
+const (
+	GDI      = "gdi"      // gdiscreencapsrc on Windows
+	X11      = "x11"      // ximagesrc on X11 including Xvfb
+	PipeWire = "pipewire" // pipewiresrc with the stream of a ScreenCast portal session on Wayland
+	Test     = "test"     // videotestsrc
+)
+
+var (
+	ErrUnknown  = errors.New("unknown screen source")
+	ErrNoScreen = errors.New("no screen found. Set the capture source in the config to use the test source")
+)
+
+// Returns an error if the name is not a screen source. An empty name is valid and makes
+// Resolve detect the source
+func Check(name string) error {
+	switch name {
+	case "", GDI, X11, PipeWire, Test:
+		return nil
+	}
+	return fmt.Errorf("%q: %w", name, ErrUnknown)
+}
+
+// Returns the configured source or detects one if none is configured
+func Resolve(configured, goos string, getenv func(string) string) (string, error) {
+	if configured != "" {
+		return configured, Check(configured)
+	}
+	return Detect(goos, getenv)
+}
+
+// Picks the source for the platform and the display server of the session. Wayland is
+// checked first since XWayland also sets DISPLAY but only shows the X11 clients
+func Detect(goos string, getenv func(string) string) (string, error) {
+	switch {
+	case goos == "windows":
+		return GDI, nil
+	case getenv("WAYLAND_DISPLAY") != "" || getenv("XDG_SESSION_TYPE") == "wayland":
+		return PipeWire, nil
+	case getenv("DISPLAY") != "":
+		return X11, nil
+	}
+	return "", ErrNoScreen
+}
+
+// Returns the GStreamer element of the source. The stream is only used by the PipeWire source
+func Element(name string, stream *Stream) (string, error) {
+	switch name {
+	case GDI:
+		return "gdiscreencapsrc do-timestamp=true cursor=true", nil
+	case X11:
+		return "ximagesrc use-damage=false show-pointer=true do-timestamp=true", nil
+	case PipeWire:
+		if stream == nil {
+			return "", errors.New("pipewire source needs a portal stream")
+		}
+		return fmt.Sprintf("pipewiresrc fd=%d path=%d always-copy=true do-timestamp=true", stream.FD, stream.Node), nil
+	case Test:
+		return "videotestsrc is-live=true pattern=smpte do-timestamp=true", nil
+	}
+	return "", fmt.Errorf("%q: %w", name, ErrUnknown)
+}
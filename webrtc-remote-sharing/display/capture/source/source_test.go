@@ -0,0 +1,67 @@
+package source
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestDetect(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		goos string
+		env  map[string]string
+		want string
+		err  error
+	}{
+		{"windows", "windows", nil, GDI, nil},
+		{"xorg", "linux", map[string]string{"DISPLAY": ":0", "XDG_SESSION_TYPE": "x11"}, X11, nil},
+		{"xvfb", "linux", map[string]string{"DISPLAY": ":99"}, X11, nil},
+		{"wayland", "linux", map[string]string{"WAYLAND_DISPLAY": "wayland-0", "DISPLAY": ":0"}, PipeWire, nil},
+		{"wayland session", "linux", map[string]string{"XDG_SESSION_TYPE": "wayland"}, PipeWire, nil},
+		{"no screen", "linux", nil, "", ErrNoScreen},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Detect(tc.goos, env(tc.env))
+			if got != tc.want || !errors.Is(err, tc.err) {
+				t.Errorf("got %q, %v, want %q, %v", got, err, tc.want, tc.err)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	xorg := env(map[string]string{"DISPLAY": ":0"})
+	if got, err := Resolve("", "linux", xorg); got != X11 || err != nil {
+		t.Errorf("detected source: got %q, %v, want %q", got, err, X11)
+	}
+	// The config wins over the environment e.g. to use the test source on a desktop
+	if got, err := Resolve(Test, "linux", xorg); got != Test || err != nil {
+		t.Errorf("configured source: got %q, %v, want %q", got, err, Test)
+	}
+	if _, err := Resolve("vnc", "linux", xorg); !errors.Is(err, ErrUnknown) {
+		t.Errorf("unknown source: got %v, want %v", err, ErrUnknown)
+	}
+}
+
+func TestElement(t *testing.T) {
+	for _, name := range []string{GDI, X11, Test} {
+		if _, err := Element(name, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := Element(PipeWire, nil); err == nil {
+		t.Error("pipewire source without a stream: got no error")
+	}
+	got, err := Element(PipeWire, &Stream{FD: 7, Node: 42})
+	if err != nil || !strings.HasPrefix(got, "pipewiresrc fd=7 path=42 ") {
+		t.Errorf("pipewire source: got %q, %v", got, err)
+	}
+	if _, err := Element("vnc", nil); !errors.Is(err, ErrUnknown) {
+		t.Errorf("unknown source: got %v, want %v", err, ErrUnknown)
+	}
+}
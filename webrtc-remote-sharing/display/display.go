@@ -1,11 +1,10 @@
 package display
 
 import (
-	"image"
+	"fmt"
+	"time"
 
-	"github.com/remygo/display/capture"
-	"github.com/remygo/display/play"
-	"github.com/remygo/display/provider"
+	"github.com/remygo/display/backend"
 	"github.com/remygo/internal/types"
 	"github.com/remygo/internal/utils"
 
@@ -13,48 +12,93 @@ import (
 )
 
 type Capture struct {
-	Provider *capture.GstCapture
+	Provider backend.Capturer
 }
 
 type UI struct {
-	Window *provider.Window
-	frames <-chan *image.NRGBA
+	Window    backend.Window
+	frames    *backend.FrameRing
+	profile   string // Permission profile granted by the host
+	notice    string // Latest warning e.g. about the end of the session
+	sas       string // Short authentication string of the connection
+	recording bool   // The host records the session
 }
 
 type Playback struct {
-	Provider *play.GstPlayback
-	frames   chan<- *image.NRGBA
+	Provider backend.Decoder
+	frames   *backend.FrameRing
 }
 
-func NewCaptureProvider() *Capture {
-	return &Capture{
-		Provider: provider.GetCaptureProvider(),
+// Returns the capture of the registered backend with the given name. An empty name
+// selects the default backend
+func NewCaptureProvider(name string, opts backend.CaptureOptions) (*Capture, error) {
+	provider, err := backend.NewCapturer(name, opts)
+	if err != nil {
+		return nil, err
 	}
+	return &Capture{
+		Provider: provider,
+	}, nil
 }
 
-func NewPlaybackProvider() (playback *Playback, window *UI) {
-	frames := make(chan *image.NRGBA)
+// Returns the playback and the window of the registered backends with the given names.
+// Empty names select the default backends
+func NewPlaybackProvider(decoder, window string) (playback *Playback, ui *UI, err error) {
+	decoderProvider, err := backend.NewDecoder(decoder)
+	if err != nil {
+		return nil, nil, err
+	}
+	windowProvider, err := backend.NewWindow(window)
+	if err != nil {
+		return nil, nil, err
+	}
+	frames := backend.NewFrameRing()
 
 	playback = &Playback{
-		Provider: provider.GetPlaybackProvider(),
+		Provider: decoderProvider,
 		frames:   frames,
 	}
-	window = &UI{
-		Window: provider.GetWindowProvider(),
+	ui = &UI{
+		Window: windowProvider,
 		frames: frames,
 	}
 
 	return
 }
 
-func (c *Capture) Start(width, height int, codecName string, track *webrtc.TrackLocalStaticSample) error {
-	return c.Provider.Start(width, height, codecName, track)
+func (c *Capture) Start(settings types.StreamSettings, codecName string, track *webrtc.TrackLocalStaticSample) error {
+	return c.Provider.Start(settings, codecName, track)
+}
+
+// Changes the size, frame rate and quality of the running capture
+func (c *Capture) Reconfigure(settings types.StreamSettings) error {
+	return c.Provider.Reconfigure(settings)
+}
+
+// Makes the next frame of the running capture a key frame
+func (c *Capture) RequestKeyFrame() error {
+	return c.Provider.RequestKeyFrame()
+}
+
+// Changes the bitrate and frame rate of the running capture to the estimated bandwidth
+func (c *Capture) Adapt(bitrate, frameRate int) error {
+	return c.Provider.Adapt(bitrate, frameRate)
 }
 
 func (c *Capture) Stop() error {
 	return c.Provider.Stop()
 }
 
+// Returns the codecs the capture can encode
+func (c *Capture) Codecs() []string {
+	return c.Provider.Codecs()
+}
+
+// Sets a callback which is invoked whenever the captured screen changes
+func (c *Capture) OnActivity(f func()) {
+	c.Provider.OnActivity(f)
+}
+
 func (u *UI) Loop() error {
 	if err := u.Window.Loop(u.frames); err != nil {
 		return err
@@ -71,6 +115,63 @@ func (u *UI) Loop() error {
 // 	u.Window.Close()
 // }
 
+// Shows the permission profile granted by the host in the window title
+func (u *UI) ShowPermissions(profile string) {
+	u.profile = profile
+	u.updateTitle()
+}
+
+// Shows a warning from the host or the signaling server in the window title
+func (u *UI) ShowNotice(text string) {
+	u.notice = text
+	u.updateTitle()
+}
+
+// Shows the short authentication string in the window title so that the users can compare it
+func (u *UI) ShowSAS(sas string) {
+	u.sas = sas
+	u.updateTitle()
+}
+
+// Shows in the window title whether the host records the session
+func (u *UI) ShowRecording(recording bool) {
+	u.recording = recording
+	u.updateTitle()
+}
+
+// Shows or hides the overlay telling the user that the connection is being restored
+func (u *UI) ShowReconnecting(reconnecting bool) {
+	if reconnecting {
+		u.Window.SetOverlay("Reconnecting...")
+		return
+	}
+	u.Window.SetOverlay("")
+}
+
+// Writes the text the host copied into the clipboard of the remote if the window shares it
+func (u *UI) WriteClipboard(text string) {
+	if c, ok := u.Window.(backend.Clipboard); ok {
+		c.WriteClipboard(text)
+	}
+}
+
+func (u *UI) updateTitle() {
+	title := "Remote"
+	if u.recording {
+		title = "[Recording] " + title
+	}
+	if u.profile != "" {
+		title += fmt.Sprintf(" (%s)", u.profile)
+	}
+	if u.sas != "" {
+		title += fmt.Sprintf(" [code %s]", u.sas)
+	}
+	if u.notice != "" {
+		title += " - " + u.notice
+	}
+	u.Window.SetTitle(title)
+}
+
 func (u *UI) DispatchInputEvents(ev *types.RemoteEvent) {
 	u.Window.GetEventQueue() <- ev
 }
@@ -83,14 +184,33 @@ func Dimensions() (width, height int) {
 	return utils.GetDisplaySize()
 }
 
-func (d *Playback) HandleFrameBuffer(frame []byte) {
-	d.Provider.Push(frame)
+// Passes an encoded frame with its presentation time to the decoder
+func (d *Playback) HandleFrame(frame []byte, pts time.Duration) {
+	d.Provider.Push(frame, pts)
 }
 
-func (d *Playback) Start(width, height, payloadType int, codecName string) error {
-	return d.Provider.Start(width, height, payloadType, codecName, d.frames)
+func (d *Playback) Start(width, height int, codecName string) error {
+	return d.Provider.Start(width, height, codecName, d.frames)
 }
 
 func (d *Playback) Stop() error {
 	return d.Provider.Stop()
 }
+
+// Changes the size of the decoded frames of the running playback
+func (d *Playback) Resize(width, height int) error {
+	return d.Provider.Resize(width, height)
+}
+
+// Sets a callback which is invoked when the decoder needs a key frame. Decoders which do not
+// notice missing frames never invoke it
+func (d *Playback) OnKeyFrameNeeded(f func()) {
+	if r, ok := d.Provider.(backend.KeyFrameRequester); ok {
+		r.OnKeyFrameNeeded(f)
+	}
+}
+
+// Returns the codecs the playback can decode
+func (d *Playback) Codecs() []string {
+	return d.Provider.Codecs()
+}
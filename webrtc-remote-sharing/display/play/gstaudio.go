@@ -0,0 +1,113 @@
+package play
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tinyzimmer/go-gst/gst"
+	"github.com/tinyzimmer/go-gst/gst/app"
+)
+
+// Caps of the Opus frames of the jitter buffer
+const opusCaps = "audio/x-opus,channel-mapping-family=0,rate=48000,channels=2"
+
+// Decodes and plays the sound of the host or the voice of the other user
+type GstAudioPlayback struct {
+	pipeline *gst.Pipeline
+	src      *app.Source
+	muted    bool
+	volume   float64
+}
+
+func NewGstAudioPlayback() *GstAudioPlayback {
+	return &GstAudioPlayback{volume: 1}
+}
+
+// The decoder conceals lost frames. The sink plays the frames as they arrive like the video
+// is shown as it is decoded, which keeps sound and picture in step
+func (g *GstAudioPlayback) createPipeline() (*gst.Pipeline, error) {
+	gst.Init(nil)
+
+	pipelineStr := fmt.Sprintf("appsrc format=time is-live=true name=src caps=\"%s\" ! opusdec plc=true"+
+		" ! audioconvert ! audioresample ! volume name=volume mute=%t volume=%g ! autoaudiosink sync=false",
+		opusCaps, g.muted, g.volume)
+
+	log.Printf("[GST] Creating audio pipeline: %s", pipelineStr)
+
+	pipeline, err := gst.NewPipelineFromString(pipelineStr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing audio pipeline: %w", err)
+	}
+	return pipeline, nil
+}
+
+func (g *GstAudioPlayback) Start() error {
+	pipeline, err := g.createPipeline()
+	if err != nil {
+		return err
+	}
+	src, err := pipeline.GetElementByName("src")
+	if err != nil {
+		return fmt.Errorf("finding appsrc in audio pipeline: %w", err)
+	}
+	g.pipeline = pipeline
+	g.src = app.SrcFromElement(src)
+
+	log.Println("[GST] Starting audio pipeline")
+
+	pipeline.GetBus().AddWatch(func(msg *gst.Message) bool {
+		switch msg.Type() {
+		case gst.MessageEOS:
+			_ = g.pipeline.BlockSetState(gst.StateNull)
+		case gst.MessageError:
+			log.Printf("[ERR] Audio playback: %v", msg.ParseError())
+		}
+		return true
+	})
+	return pipeline.SetState(gst.StatePlaying)
+}
+
+// Mutes or unmutes the played sound while running
+func (g *GstAudioPlayback) SetMuted(muted bool) error {
+	g.muted = muted
+	if g.pipeline == nil {
+		return nil
+	}
+	volume, err := g.pipeline.GetElementByName("volume")
+	if err != nil {
+		return err
+	}
+	return volume.SetProperty("mute", muted)
+}
+
+// Changes the volume while running
+func (g *GstAudioPlayback) SetVolume(volume float64) error {
+	g.volume = volume
+	if g.pipeline == nil {
+		return nil
+	}
+	element, err := g.pipeline.GetElementByName("volume")
+	if err != nil {
+		return err
+	}
+	return element.SetProperty("volume", volume)
+}
+
+func (g *GstAudioPlayback) Stop() error {
+	log.Println("[GST] Stopping audio pipeline")
+	if g.pipeline == nil {
+		return nil
+	}
+	return g.pipeline.SetState(gst.StateNull)
+}
+
+// Pushes an Opus frame into the pipeline with its presentation time
+func (g *GstAudioPlayback) Push(frame []byte, pts time.Duration) {
+	if g.src == nil {
+		return
+	}
+	buf := gst.NewBufferFromBytes(frame)
+	buf.SetPresentationTimestamp(pts)
+	_ = g.src.PushBuffer(buf)
+}
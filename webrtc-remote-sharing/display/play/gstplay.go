@@ -1,60 +1,122 @@
 package play
 
 import (
+	"errors"
 	"fmt"
-	"image"
 	"log"
 	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/remygo/display/backend"
 
 	"github.com/tinyzimmer/go-gst/gst"
 	"github.com/tinyzimmer/go-gst/gst/app"
 )
 
 type GstPlayback struct {
-	pipeline *gst.Pipeline
+	pipeline   *gst.Pipeline
+	src        *app.Source // Looked up once when the pipeline starts
+	onKeyFrame func()
+}
+
+// Caps of the frames pushed into the pipeline, parsers and decoders by codec. The frames are
+// the ones of the jitter buffer. The first word of each decoder is the name of the element
+// which has to be installed and the first installed decoder is used
+var decoders = []struct {
+	codec, caps, parse string
+	decoders           []string
+}{
+	{"h264", "video/x-h264,stream-format=byte-stream,alignment=au", "h264parse", []string{"avdec_h264 output-corrupt=false"}},
+	{"vp8", "video/x-vp8", "", []string{"avdec_vp8"}},
+	{"vp9", "video/x-vp9", "", []string{"avdec_vp9"}},
+	{"av1", "video/x-av1,stream-format=obu-stream,alignment=tu", "av1parse", []string{"dav1ddec", "av1dec"}},
+}
+
+// Returns the first of the decoders which is installed if the parser is installed too
+func decoder(parse string, decoders []string) string {
+	if parse != "" && gst.Find(parse) == nil {
+		return ""
+	}
+	for _, d := range decoders {
+		if gst.Find(strings.Fields(d)[0]) != nil {
+			return d
+		}
+	}
+	return ""
 }
 
-func (g *GstPlayback) createPipeline(width, height, payloadType int, codecName string) *gst.Pipeline {
+// Returns the codecs of the installed parsers and decoders
+func (g *GstPlayback) Codecs() []string {
 	gst.Init(nil)
 
-	pipelineStr := "appsrc format=time is-live=true do-timestamp=true name=src ! application/x-rtp"
+	var codecs []string
+	for _, d := range decoders {
+		if decoder(d.parse, d.decoders) != "" {
+			codecs = append(codecs, "video/"+strings.ToUpper(d.codec))
+		}
+	}
+	return codecs
+}
 
-	switch strings.ToLower(codecName) {
-	case "vp8":
-		pipelineStr += fmt.Sprintf(", payload=%d,encoding-name=VP8 ! rtpvp8depay ! queue ! avdec_vp8  ! queue ! videoconvert"+
-			" ! queue ! videoscale ! video/x-raw,width=%d,height=%d,format=RGBA,pixel-aspect-ratio=1/1,framrate=10/1"+
-			" ! queue ! appsink name=sink sync=false", payloadType, width, height)
+// Caps of the decoded frames passed to the window. The filter is named so that Resize can
+// change the size of the frames while running
+func frameCaps(width, height int) string {
+	return fmt.Sprintf("video/x-raw,width=%d,height=%d,format=RGBA,pixel-aspect-ratio=1/1", width, height)
+}
 
-	case "opus":
-		pipelineStr += fmt.Sprintf(", payload=%d,encoding-name=OPUS ! rtpopusdepay ! decodebin ! autoaudiosink", payloadType)
+// The buffers carry the presentation times of the RTP timestamps. The sink does not sync to
+// the clock since the frames are shown as soon as they are decoded
+func (g *GstPlayback) createPipeline(width, height int, codecName string) (*gst.Pipeline, error) {
+	gst.Init(nil)
 
-	case "vp9":
-		pipelineStr += fmt.Sprintf(",payload=%d,encoding-name=VP9 ! rtpvp9depay ! avdec_vp9 ! queue"+
-			" ! videoconvert ! videoscale ! video/x-raw,width=%d,height=%d,format=RGBA,pixel-aspect-ratio=1/1,framrate=10/1"+
-			" ! queue ! appsink name=sink sync=false", payloadType, width, height)
+	codecName = strings.ToLower(codecName)
+	var caps, dec string
+	for _, d := range decoders {
+		if found := decoder(d.parse, d.decoders); d.codec == codecName && found != "" {
+			caps, dec = d.caps, found
+			if d.parse != "" {
+				dec = d.parse + " ! " + found
+			}
+		}
+	}
+	if dec == "" {
+		return nil, fmt.Errorf("no decoder for %s", codecName)
+	}
+	pipelineStr := fmt.Sprintf("appsrc format=time is-live=true name=src caps=\"%s\" ! %s", caps, dec)
 
-	case "h264":
-		pipelineStr += fmt.Sprintf(", payload=%d,encoding-name=H264,media=video,profile=high,clock-rate=90000 ! rtph264depay"+
-			" ! avdec_h264 output-corrupt=false ! queue ! videoconvert ! video/x-raw,format=AYUV ! gaussianblur sigma=-0.5"+
+	if codecName == "h264" {
+		pipelineStr += fmt.Sprintf(" ! queue ! videoconvert ! video/x-raw,format=AYUV ! gaussianblur sigma=-0.5"+
 			" ! queue ! videoconvert ! queue ! videoscale sharpen=1 sharpness=1.5 method=7 n-threads=6 add-borders=false"+
-			" ! video/x-raw,width=%d,height=%d,format=RGBA,pixel-aspect-ratio=1/1 ! queue"+
-			" ! appsink name=sink sync=false", payloadType, width, height)
-
-	default:
-		log.Fatal("Unhandled codec " + codecName)
+			" ! capsfilter name=caps caps=\"%s\" ! queue"+
+			" ! appsink name=sink sync=false", frameCaps(width, height))
+	} else {
+		pipelineStr += fmt.Sprintf(" ! queue ! videoconvert"+
+			" ! queue ! videoscale ! capsfilter name=caps caps=\"%s\""+
+			" ! queue ! appsink name=sink sync=false", frameCaps(width, height))
 	}
 
 	log.Printf("[GST] Creating pipeline: %s", pipelineStr)
 
 	pipeline, err := gst.NewPipelineFromString(pipelineStr)
 	if err != nil {
-		log.Fatal("[ERR] Cannot parse launch from pipeline string")
+		return nil, fmt.Errorf("parsing pipeline: %w", err)
 	}
-	return pipeline
+	return pipeline, nil
 }
 
-func (g *GstPlayback) Start(width, height, payloadType int, codecName string, imageChan chan<- *image.NRGBA) error {
-	g.pipeline = g.createPipeline(width, height, payloadType, codecName)
+func (g *GstPlayback) Start(width, height int, codecName string, frames *backend.FrameRing) error {
+	pipeline, err := g.createPipeline(width, height, codecName)
+	if err != nil {
+		return err
+	}
+	src, err := pipeline.GetElementByName("src")
+	if err != nil {
+		return fmt.Errorf("finding appsrc in pipeline: %w", err)
+	}
+	g.pipeline = pipeline
+	g.src = app.SrcFromElement(src)
+	g.watchKeyFrames()
 
 	log.Println("[GST] Starting pipeline")
 
@@ -65,6 +127,10 @@ func (g *GstPlayback) Start(width, height, payloadType int, codecName string, im
 		case gst.MessageError:
 			err := msg.ParseError()
 			fmt.Println("[ERR]:", err.Error())
+		case gst.MessageWarning:
+			// Decoders warn about frames they could not decode
+			log.Printf("[GST] Warning from %s: %s", msg.Source(), msg.ParseWarning().Error())
+			g.keyFrameNeeded()
 		}
 		return true
 	})
@@ -88,16 +154,16 @@ func (g *GstPlayback) Start(width, height, payloadType int, codecName string, im
 				return gst.FlowError
 			}
 
-			samples := buffer.Map(gst.MapRead).Bytes()
-			defer buffer.Unmap()
-
-			img := new(image.NRGBA)
-			img.Pix = samples
-			img.Stride = width * 4
-			img.Rect = image.Rect(0, 0, width, height)
-
-			imageChan <- img
+			// Frames queued before a resize still have the previous size. The mapped memory
+			// belongs to GStreamer, so the frame is copied into the ring before it is unmapped.
+			// It is read in place since Bytes of the map would copy it into a new slice first
+			w, h := frameSize(sample, width, height)
+			img := frames.Acquire(w, h)
+			mapped := buffer.Map(gst.MapRead)
+			copy(img.Pix, unsafe.Slice((*byte)(mapped.Data()), mapped.Size()))
+			buffer.Unmap()
 
+			frames.Publish(img)
 			return gst.FlowOK
 		},
 	})
@@ -105,6 +171,63 @@ func (g *GstPlayback) Start(width, height, payloadType int, codecName string, im
 	return g.pipeline.SetState(gst.StatePlaying)
 }
 
+// Sets a callback which is invoked when the parser or the decoder lost track of the video and
+// needs a key frame
+func (g *GstPlayback) OnKeyFrameNeeded(f func()) {
+	g.onKeyFrame = f
+}
+
+func (g *GstPlayback) keyFrameNeeded() {
+	if g.onKeyFrame != nil {
+		g.onKeyFrame()
+	}
+}
+
+// Catches the force key unit events of the parser and the decoder at the source where they
+// would otherwise be dropped. Lost packets are noticed by the jitter buffer before
+func (g *GstPlayback) watchKeyFrames() {
+	g.src.GetStaticPad("src").AddProbe(gst.PadProbeTypeEventUpstream, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if event := info.GetEvent(); event != nil && event.HasName("GstForceKeyUnit") {
+			g.keyFrameNeeded()
+		}
+		return gst.PadProbeOK
+	})
+}
+
+// Changes the size of the decoded frames. videoscale picks up the new caps of the filter
+func (g *GstPlayback) Resize(width, height int) error {
+	if g.pipeline == nil {
+		return errors.New("pipeline not started")
+	}
+	log.Printf("[GST] Resizing playback to %dx%d", width, height)
+
+	caps, err := g.pipeline.GetElementByName("caps")
+	if err != nil {
+		return err
+	}
+	return caps.SetProperty("caps", gst.NewCapsFromString(frameCaps(width, height)))
+}
+
+// Returns the size of the frame of the sample or the given size if its caps have none
+func frameSize(sample *gst.Sample, width, height int) (int, int) {
+	caps := sample.GetCaps()
+	if caps == nil || caps.GetSize() == 0 {
+		return width, height
+	}
+	s := caps.GetStructureAt(0)
+	w, errW := s.GetValue("width")
+	h, errH := s.GetValue("height")
+	if errW != nil || errH != nil {
+		return width, height
+	}
+	wi, okW := w.(int)
+	hi, okH := h.(int)
+	if !okW || !okH {
+		return width, height
+	}
+	return wi, hi
+}
+
 func (g *GstPlayback) Stop() error {
 	log.Println("[GST] Stopping pipeline")
 
@@ -115,13 +238,12 @@ func (g *GstPlayback) Stop() error {
 	return g.pipeline.SetState(gst.StateNull)
 }
 
-func (g *GstPlayback) Push(buffer []byte) {
-	src, err := g.pipeline.GetElementByName("src")
-	if err != nil {
-		log.Fatal("[ERR] Cannot find appsrc in pipeline")
+// Pushes an encoded frame into the pipeline with its presentation time
+func (g *GstPlayback) Push(frame []byte, pts time.Duration) {
+	if g.src == nil {
+		return
 	}
-	gbuf := gst.NewBufferFromBytes(buffer)
-
-	appSrc := app.SrcFromElement(src)
-	_ = appSrc.PushBuffer(gbuf)
+	buf := gst.NewBufferFromBytes(frame)
+	buf.SetPresentationTimestamp(pts)
+	_ = g.src.PushBuffer(buf)
 }
@@ -1,28 +1,23 @@
 package provider
 
 import (
+	"github.com/remygo/display/backend"
 	"github.com/remygo/display/capture"
 	"github.com/remygo/display/play"
 	"github.com/remygo/internal/types"
 	"github.com/remygo/internal/utils"
 )
 
-// type PlayBackProvider interface {
-// 	Start(width, height, payloadType int, codecName string) error
-// 	Stop() error
-// 	Push(buffer []byte)
-// }
-
-func GetPlaybackProvider() *play.GstPlayback {
-	return &play.GstPlayback{}
-}
-
-func GetCaptureProvider() *capture.GstCapture {
-	return &capture.GstCapture{}
-}
-
-func GetWindowProvider() *Window {
-	return newWindow(utils.GetDisplaySize())
+// Registers the GStreamer pipelines and the Gio window as the default backends
+func init() {
+	backend.RegisterCapturer(backend.DefaultCapturer, func(opts backend.CaptureOptions) backend.Capturer {
+		return &capture.GstCapture{Source: opts.Source}
+	})
+	backend.RegisterDecoder(backend.DefaultDecoder, func() backend.Decoder { return &play.GstPlayback{} })
+	backend.RegisterAudio(backend.DefaultAudio, func(opts backend.AudioOptions) backend.AudioCapturer {
+		return &capture.GstAudioCapture{Source: opts.Source, Microphone: opts.Microphone}
+	}, func() backend.AudioPlayer { return play.NewGstAudioPlayback() })
+	backend.RegisterWindow(backend.DefaultWindow, func() backend.Window { return newWindow(utils.GetDisplaySize()) })
 }
 
 func (w *Window) GetEventQueue() chan *types.RemoteEvent {
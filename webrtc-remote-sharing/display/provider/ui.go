@@ -4,11 +4,16 @@ import (
 	"image"
 	"image/color"
 	"log"
+	"sync"
 
+	"github.com/remygo/display/backend"
 	"github.com/remygo/internal/events"
 	"github.com/remygo/internal/types"
+	"github.com/remygo/internal/utils"
 
 	"gioui.org/app"
+	"gioui.org/font/gofont"
+	"gioui.org/io/clipboard"
 	"gioui.org/io/event"
 	"gioui.org/io/key"
 	"gioui.org/io/pointer"
@@ -19,6 +24,7 @@ import (
 	"gioui.org/op/paint"
 	"gioui.org/unit"
 	"gioui.org/widget"
+	"gioui.org/widget/material"
 )
 
 var done = make(chan struct{})
@@ -28,6 +34,35 @@ type Window struct {
 	window      *app.Window
 	eventQueue  chan *types.RemoteEvent
 	handlerChan chan event.Event
+	mu          sync.Mutex
+	overlay     string // Text drawn over the video e.g. while reconnecting. Empty hides the overlay
+	onResize    func(width, height int)
+	size        image.Point // Size of the last frame of the window in pixels
+
+	// Clipboard shared with the host. The text is the last one sent to or written by the host
+	clipboard      string
+	readClipboard  bool    // Reads the clipboard in the next frame
+	writeClipboard *string // Written into the clipboard in the next frame
+}
+
+// Sets a callback which is invoked with the size of the window in pixels whenever it changes
+func (w *Window) OnResize(f func(width, height int)) {
+	w.mu.Lock()
+	w.onResize = f
+	w.mu.Unlock()
+}
+
+func (w *Window) resized(size image.Point) {
+	if size == w.size {
+		return
+	}
+	w.size = size
+	w.mu.Lock()
+	f := w.onResize
+	w.mu.Unlock()
+	if f != nil {
+		f(size.X, size.Y)
+	}
 }
 
 func (w *Window) Close() {
@@ -48,6 +83,69 @@ func newWindow(width, height int) (w *Window) {
 	return
 }
 
+func (w *Window) SetTitle(title string) {
+	w.window.Option(app.Title(title))
+}
+
+// Writes the text the host copied into the clipboard in the next frame
+func (w *Window) WriteClipboard(text string) {
+	w.mu.Lock()
+	w.clipboard = text
+	w.writeClipboard = &text
+	w.mu.Unlock()
+	w.window.Invalidate()
+}
+
+// Adds the pending clipboard operations to the frame
+func (w *Window) layoutClipboard(gtx layout.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.writeClipboard != nil {
+		clipboard.WriteOp{Text: *w.writeClipboard}.Add(gtx.Ops)
+		w.writeClipboard = nil
+	}
+	if w.readClipboard {
+		clipboard.ReadOp{Tag: w.window}.Add(gtx.Ops)
+		w.readClipboard = false
+	}
+}
+
+// Returns the clipboard event for the text if it is not the one the host already has
+func (w *Window) clipboardEvent(text string) *types.RemoteEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if text == "" || text == w.clipboard {
+		return nil
+	}
+	w.clipboard = text
+	return &types.RemoteEvent{Type: "clipboard", Event: utils.MarshalEvent(&types.Clipboard{Text: text})}
+}
+
+// Draws the text over the last video frame. An empty text removes the overlay
+func (w *Window) SetOverlay(text string) {
+	w.mu.Lock()
+	w.overlay = text
+	w.mu.Unlock()
+	w.window.Invalidate()
+}
+
+// Dims the video and centers the overlay text on it
+func (w *Window) layoutOverlay(gtx layout.Context, th *material.Theme) {
+	w.mu.Lock()
+	text := w.overlay
+	w.mu.Unlock()
+	if text == "" {
+		return
+	}
+
+	paint.FillShape(gtx.Ops, color.NRGBA{A: 160}, clip.Rect{Max: gtx.Constraints.Max}.Op())
+	layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		label := material.H5(th, text)
+		label.Color = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		return label.Layout(gtx)
+	})
+}
+
 // Handle UI events here based on the event type
 func (w *Window) handleEvents() {
 	defer close(w.eventQueue)
@@ -56,6 +154,17 @@ func (w *Window) handleEvents() {
 		switch ev := ev.(type) {
 		case key.FocusEvent:
 			log.Printf("[GIO] Focus: %v", ev.Focus)
+			// The user may have copied something outside of the window
+			if ev.Focus {
+				w.mu.Lock()
+				w.readClipboard = true
+				w.mu.Unlock()
+				w.window.Invalidate()
+			}
+		case clipboard.Event:
+			if e := w.clipboardEvent(ev.Text); e != nil {
+				w.eventQueue <- e
+			}
 		case key.Event:
 			e, err := events.RemoteEvent(&ev)
 			if err != nil {
@@ -78,15 +187,15 @@ func (w *Window) handleEvents() {
 }
 
 // windowLoop is responsible for handling the ui event loop
-func (w *Window) Loop(frameQueue <-chan *image.NRGBA) error {
+func (w *Window) Loop(frames *backend.FrameRing) error {
 	var err error
 
 	// Operation buffer
 	var ops op.Ops
 	var gtx layout.Context
 
-	// Image buffer, reused on every frame
-	var videoFrame *image.NRGBA
+	// Theme of the overlay text
+	th := material.NewTheme(gofont.Collection())
 
 	// Image widget
 	var img widget.Image
@@ -113,6 +222,7 @@ outer:
 			case system.FrameEvent:
 				gtx = layout.NewContext(&ops, e)
 				cts = gtx.Constraints
+				w.resized(e.Size)
 
 				// Set the area for receiving pointer events
 				// in our case, the entire window
@@ -143,18 +253,19 @@ outer:
 				// Draw the image
 				paint.Fill(gtx.Ops, color.NRGBA{A: 255})
 				img.Layout(gtx)
+				w.layoutOverlay(gtx, th)
+				w.layoutClipboard(gtx)
 				e.Frame(gtx.Ops)
 			}
 
-		case frame, ok := <-frameQueue:
-			if !ok {
-				log.Println("[GIO] Frame queue closed")
-				break outer
+		case <-frames.Ready():
+			// Frames published while the window was busy were dropped by the ring. The frame
+			// taken before goes back to the ring, which is safe since e.Frame returns once the
+			// GPU has its copy
+			if frame := frames.Take(); frame != nil {
+				img.Src = paint.NewImageOp(frame)
+				w.window.Invalidate()
 			}
-
-			videoFrame = frame
-			img.Src = paint.NewImageOp(videoFrame)
-			w.window.Invalidate()
 		case _, ok := <-done:
 			if !ok {
 				log.Println("Done channel blocked")
@@ -8,6 +8,28 @@ const (
 	SetToken
 	RenewToken
 	SessionStarted
+	SetUnattended
+	SetPermissions
+	ShowSAS
+	SessionEnded
+	SessionState
+	ShowWarning
+	SetMuted
+	SetMicrophone
+	SetTalking
+	SetVoiceVolume
+	SetVoiceMuted
+	StartRecording
+	StopRecording
+	AnswerRecording
+	RecordingRequest
+	RecordingState
+	PeerRecordingState
+	SendFile
+	FileTransferred
+	AskApproval
+	CancelApproval
+	AnswerApproval
 )
 
 type Event struct {
@@ -15,3 +37,36 @@ type Event struct {
 	Payload interface{}
 	Error   error
 }
+
+// Payload of the JoinSession event
+type JoinRequest struct {
+	Token    string
+	Password string
+}
+
+// Payload of the AskApproval event
+type ApprovalRequest struct {
+	UserID string
+	OrgID  string
+}
+
+// Payload of the RecordingState event
+type RecordingInfo struct {
+	Recording bool
+	File      string // File of the recording once it started or stopped
+}
+
+// Settings of the config the controls start with
+type Settings struct {
+	Profile     string // Permission profile granted to remotes without a rule of their own
+	Muted       bool   // Sound of the host muted
+	Microphone  bool   // Microphone on without holding the talk button
+	VoiceMuted  bool
+	VoiceVolume int // Percent
+}
+
+// Payload of the SetToken and RenewToken events
+type TokenInfo struct {
+	Token    string
+	Password string // Empty in unattended mode
+}
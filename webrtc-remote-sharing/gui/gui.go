@@ -53,7 +53,7 @@ type GUI struct {
 	// cancelFunc context.CancelFunc
 }
 
-func NewGUI(w *app.Window) *GUI {
+func NewGUI(w *app.Window, settings uievents.Settings) *GUI {
 	g := &GUI{
 		w:            w,
 		router:       page.NewRouter(),
@@ -68,7 +68,7 @@ func NewGUI(w *app.Window) *GUI {
 	}
 
 	g.router.Add(0, login.New(&g.router, g.EventsTX, loginFunc, g.redraw))
-	g.router.Add(1, landing.New(&g.router, g.EventsTX))
+	g.router.Add(1, landing.New(&g.router, g.EventsTX, settings))
 
 	return g
 }
@@ -128,14 +128,47 @@ func (g *GUI) Loop() error {
 			log.Println("[INFO] Received event: ", ev.Payload)
 			switch ev.Type {
 			case uievents.SetToken:
-				log.Println("[INFO] Received set token event: ", ev.Payload)
-				if token, ok := ev.Payload.(string); ok {
-					g.router.SetToken(token)
+				log.Println("[INFO] Received set token event")
+				if info, ok := ev.Payload.(uievents.TokenInfo); ok {
+					g.router.SetToken(info.Token, info.Password)
 				}
 			case uievents.RenewToken:
-				log.Println("[INFO] Received renew token event: ", ev.Payload)
-				if token, ok := ev.Payload.(string); ok {
-					g.router.SetToken(token)
+				log.Println("[INFO] Received renew token event")
+				if info, ok := ev.Payload.(uievents.TokenInfo); ok {
+					g.router.SetToken(info.Token, info.Password)
+					g.router.SetVerification("")
+				}
+			case uievents.ShowSAS:
+				if sas, ok := ev.Payload.(string); ok {
+					g.router.SetVerification(sas)
+				}
+			case uievents.SessionState:
+				if status, ok := ev.Payload.(string); ok {
+					g.router.SetStatus(status)
+				}
+			case uievents.ShowWarning:
+				if warning, ok := ev.Payload.(string); ok {
+					g.router.SetWarning(warning)
+				}
+			case uievents.RecordingState:
+				if info, ok := ev.Payload.(uievents.RecordingInfo); ok {
+					g.router.SetRecording(info.Recording, info.File)
+				}
+			case uievents.PeerRecordingState:
+				if recording, ok := ev.Payload.(bool); ok {
+					g.router.SetPeerRecording(recording)
+				}
+			case uievents.RecordingRequest:
+				g.router.AskRecordingConsent()
+			case uievents.AskApproval:
+				if req, ok := ev.Payload.(uievents.ApprovalRequest); ok {
+					g.router.AskApproval(req.UserID)
+				}
+			case uievents.CancelApproval:
+				g.router.CancelApproval()
+			case uievents.FileTransferred:
+				if file, ok := ev.Payload.(string); ok {
+					g.router.SetTransferred(file)
 				}
 			case uievents.SessionStarted:
 				log.Println("[INFO] Received session started event: ", ev.Payload)
@@ -144,7 +177,16 @@ func (g *GUI) Loop() error {
 				log.Printf("[GUI] Prev state: %s Current state: %s", g.PrevState.String(), g.CurrentState.String())
 
 				g.router.DisableJoinButton(true)
+				g.router.ShowSessionControls(true)
+			case uievents.SessionEnded:
+				g.PrevState = g.CurrentState
+				g.CurrentState = LandingPage
+				log.Printf("[GUI] Prev state: %s Current state: %s", g.PrevState.String(), g.CurrentState.String())
+
+				g.router.DisableJoinButton(false)
+				g.router.ShowSessionControls(false)
 			}
+			g.w.Invalidate()
 		}
 	}
 }
@@ -2,12 +2,16 @@ package landing
 
 import (
 	"fmt"
+	"image/color"
+	"math"
 
 	uievents "github.com/remygo/gui/events"
 	page "github.com/remygo/gui/pages"
+	"github.com/remygo/internal/types"
 
 	"gioui.org/io/clipboard"
 	"gioui.org/layout"
+	"gioui.org/op"
 	"gioui.org/text"
 	"gioui.org/unit"
 	"gioui.org/widget"
@@ -89,6 +93,14 @@ func (re *RichEditor) Layout(gtx C, th *material.Theme, label string) D {
 	)
 }
 
+// Profiles the host user picks from when approving a join request, from the strictest
+var approvalProfiles = [...]struct{ profile, label string }{
+	{types.ProfileViewOnly, "View only"},
+	{types.ProfileControl, "Control"},
+	{types.ProfileClipboard, "Clipboard"},
+	{types.ProfileFileTransfer, "File Transfer"},
+}
+
 type Page struct {
 	*page.Router
 	hostToken, hostPwd     RichEditor
@@ -96,8 +108,42 @@ type Page struct {
 	joinBtn                widget.Clickable
 	joinBtnDisabled        bool
 	unattendedCheck        widget.Bool
+	muteCheck              widget.Bool // Mutes the sound of the host on either side of a session
+	profile                widget.Enum // Permission profile granted to remotes joining this host
 	promptPwd              bool
+	sas                    string // Short authentication string of the current session
+	status                 string // State of the session as reported by the application
+	warning                string // Last warning of the application e.g. a skipped message
 	eventsTX               chan<- uievents.Event
+
+	// Voice chat controls shown during a session
+	inSession  bool
+	talkBtn    widget.Clickable // Turns the microphone on while it is held
+	talking    bool
+	micCheck   widget.Bool  // Microphone on without holding the talk button
+	voiceMute  widget.Bool  // Mutes the voice of the other user
+	volume     widget.Float // Volume of the voice of the other user
+	sentVolume float32
+
+	// Recording controls shown during a session
+	recordBtn     widget.Clickable // Asks the other user to record or stops the recording
+	recording     bool             // Recording or waiting for the consent of the other user
+	recordingFile string           // File of the current or last recording
+	peerRecording bool             // The other user records the session
+	consent       bool             // The other user waits for consent to record
+	allowBtn      widget.Clickable
+	declineBtn    widget.Clickable
+
+	// Prompt asking the host user to approve a join request and pick the profile of the remote
+	approving   bool
+	approveUser string
+	approveBtns [len(approvalProfiles)]widget.Clickable
+	denyBtn     widget.Clickable
+
+	// File transfer controls shown during a session
+	filePath    widget.Editor // Path of the file sent to the host
+	sendFileBtn widget.Clickable
+	transferred string // Last file sent to or received by the host
 }
 
 func (p *Page) IsInfoSet() bool {
@@ -109,11 +155,56 @@ func (p *Page) SetTokenInfo(token, pwd string) {
 	p.hostPwd.SetText(pwd)
 }
 
+func (p *Page) SetVerification(sas string) {
+	p.sas = sas
+}
+
+func (p *Page) SetStatus(status string) {
+	p.status = status
+}
+
+func (p *Page) SetWarning(warning string) {
+	p.warning = warning
+}
+
+func (p *Page) ShowSessionControls(show bool) {
+	p.inSession = show
+	p.recording, p.peerRecording, p.consent = false, false, false
+}
+
+func (p *Page) SetRecording(recording bool, file string) {
+	p.recording = recording
+	if file != "" {
+		p.recordingFile = file
+	}
+}
+
+func (p *Page) SetPeerRecording(recording bool) {
+	p.peerRecording = recording
+	p.consent = false
+}
+
+func (p *Page) AskRecordingConsent() {
+	p.consent = true
+}
+
+func (p *Page) AskApproval(user string) {
+	p.approving, p.approveUser = true, user
+}
+
+func (p *Page) CancelApproval() {
+	p.approving = false
+}
+
+func (p *Page) SetTransferred(file string) {
+	p.transferred = file
+}
+
 func (p *Page) Disable(b bool) {
 	p.joinBtnDisabled = b
 }
 
-func New(router *page.Router, joinSignal chan<- uievents.Event) *Page {
+func New(router *page.Router, joinSignal chan<- uievents.Event, settings uievents.Settings) *Page {
 	p := Page{Router: router, promptPwd: false, eventsTX: joinSignal}
 
 	// Assign each of the text fields a unique tag
@@ -123,6 +214,14 @@ func New(router *page.Router, joinSignal chan<- uievents.Event) *Page {
 	p.remoteToken = RichEditor{tag: 2}
 	p.remotePwd = RichEditor{tag: 3}
 
+	p.profile.Value = settings.Profile
+	p.muteCheck.Value = settings.Muted
+	p.micCheck.Value = settings.Microphone
+	p.voiceMute.Value = settings.VoiceMuted
+	p.volume.Value = float32(settings.VoiceVolume) / 100
+	p.sentVolume = p.volume.Value
+	p.filePath.SingleLine = true
+
 	return &p
 }
 
@@ -145,7 +244,80 @@ func (p *Page) Layout(gtx C, th *material.Theme) D {
 		} else if p.remoteToken.Len() < 4 {
 			p.remoteToken.SetError("Invalid token")
 		}
-		p.eventsTX <- uievents.Event{Type: uievents.JoinSession, Payload: p.remoteToken.Text()}
+		p.eventsTX <- uievents.Event{Type: uievents.JoinSession,
+			Payload: uievents.JoinRequest{Token: p.remoteToken.Text(), Password: p.remotePwd.Text()}}
+	}
+
+	if p.unattendedCheck.Changed() {
+		p.eventsTX <- uievents.Event{Type: uievents.SetUnattended, Payload: p.unattendedCheck.Value}
+	}
+
+	if p.muteCheck.Changed() {
+		p.eventsTX <- uievents.Event{Type: uievents.SetMuted, Payload: p.muteCheck.Value}
+	}
+
+	if p.micCheck.Changed() {
+		p.eventsTX <- uievents.Event{Type: uievents.SetMicrophone, Payload: p.micCheck.Value}
+	}
+
+	// Talking lasts as long as the button is pressed, so the frames keep coming until it is
+	// released
+	if pressed := p.talkBtn.Pressed(); pressed != p.talking {
+		p.talking = pressed
+		p.eventsTX <- uievents.Event{Type: uievents.SetTalking, Payload: pressed}
+	}
+	if p.talking {
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+
+	if p.voiceMute.Changed() {
+		p.eventsTX <- uievents.Event{Type: uievents.SetVoiceMuted, Payload: p.voiceMute.Value}
+	}
+
+	if p.recordBtn.Clicked() {
+		if p.recording {
+			p.eventsTX <- uievents.Event{Type: uievents.StopRecording}
+		} else {
+			p.eventsTX <- uievents.Event{Type: uievents.StartRecording}
+		}
+		p.recording = !p.recording
+	}
+	for _, answer := range []struct {
+		btn   *widget.Clickable
+		allow bool
+	}{{&p.allowBtn, true}, {&p.declineBtn, false}} {
+		if answer.btn.Clicked() {
+			p.consent = false
+			p.eventsTX <- uievents.Event{Type: uievents.AnswerRecording, Payload: answer.allow}
+		}
+	}
+
+	if p.approving {
+		for i := range p.approveBtns {
+			if p.approveBtns[i].Clicked() {
+				p.approving = false
+				p.eventsTX <- uievents.Event{Type: uievents.AnswerApproval, Payload: approvalProfiles[i].profile}
+			}
+		}
+		if p.denyBtn.Clicked() {
+			p.approving = false
+			p.eventsTX <- uievents.Event{Type: uievents.AnswerApproval, Payload: ""}
+		}
+	}
+
+	if p.sendFileBtn.Clicked() && p.filePath.Text() != "" {
+		p.eventsTX <- uievents.Event{Type: uievents.SendFile, Payload: p.filePath.Text()}
+		p.filePath.SetText("")
+	}
+
+	// The volume is sent once the slider is let go
+	if !p.volume.Dragging() && p.volume.Value != p.sentVolume {
+		p.sentVolume = p.volume.Value
+		p.eventsTX <- uievents.Event{Type: uievents.SetVoiceVolume, Payload: int(math.Round(float64(p.volume.Value) * 100))}
+	}
+
+	if p.profile.Changed() {
+		p.eventsTX <- uievents.Event{Type: uievents.SetPermissions, Payload: p.profile.Value}
 	}
 
 	for _, e := range p.remoteToken.Events() {
@@ -185,6 +357,74 @@ func (p *Page) Layout(gtx C, th *material.Theme) D {
 				return p.hostPwd.Layout(gtx, th, "Your Session Password")
 			})
 		}),
+		layout.Rigid(func(gtx C) D {
+			if p.sas == "" {
+				return D{}
+			}
+			margin.Left = unit.Dp(150)
+			return margin.Layout(gtx, func(gtx C) D {
+				return material.Body1(th, "Verification code: "+p.sas+". It must match the other user's code").Layout(gtx)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			if p.status == "" {
+				return D{}
+			}
+			margin.Left = unit.Dp(150)
+			return margin.Layout(gtx, func(gtx C) D {
+				return material.Caption(th, "Status: "+p.status).Layout(gtx)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			if p.warning == "" {
+				return D{}
+			}
+			margin.Left = unit.Dp(150)
+			return margin.Layout(gtx, func(gtx C) D {
+				return material.Caption(th, "Warning: "+p.warning).Layout(gtx)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			if !p.approving {
+				return D{}
+			}
+			margin.Left, margin.Right = unit.Dp(150), unit.Dp(150)
+			return margin.Layout(gtx, func(gtx C) D {
+				return p.layoutApproval(gtx, th)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			if !p.inSession {
+				return D{}
+			}
+			margin.Left, margin.Right = unit.Dp(150), unit.Dp(150)
+			return margin.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(material.Button(th, &p.talkBtn, "Hold to Talk").Layout),
+					layout.Rigid(material.CheckBox(th, &p.micCheck, "Microphone On").Layout),
+					layout.Rigid(material.CheckBox(th, &p.voiceMute, "Mute Voice").Layout),
+					layout.Flexed(1, material.Slider(th, &p.volume, 0.01, 1).Layout),
+				)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			if !p.inSession {
+				return D{}
+			}
+			margin.Left, margin.Right = unit.Dp(150), unit.Dp(150)
+			return margin.Layout(gtx, func(gtx C) D {
+				return p.layoutRecording(gtx, th)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			if !p.inSession {
+				return D{}
+			}
+			margin.Left, margin.Right = unit.Dp(150), unit.Dp(150)
+			return margin.Layout(gtx, func(gtx C) D {
+				return p.layoutTransfer(gtx, th)
+			})
+		}),
 		layout.Rigid(func(gtx C) D {
 			return layout.Spacer{Height: unit.Dp(40)}.Layout(gtx)
 		}),
@@ -221,6 +461,23 @@ func (p *Page) Layout(gtx C, th *material.Theme) D {
 				return material.CheckBox(th, &p.unattendedCheck, "Unattended Access").Layout(gtx)
 			})
 		}),
+		layout.Rigid(func(gtx C) D {
+			margin.Left, margin.Right = unit.Dp(180), unit.Dp(170)
+			return margin.Layout(gtx, func(gtx C) D {
+				return material.CheckBox(th, &p.muteCheck, "Mute Sound").Layout(gtx)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			margin.Left, margin.Right = unit.Dp(150), unit.Dp(150)
+			return margin.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(material.RadioButton(th, &p.profile, types.ProfileViewOnly, "View only").Layout),
+					layout.Rigid(material.RadioButton(th, &p.profile, types.ProfileControl, "Control").Layout),
+					layout.Rigid(material.RadioButton(th, &p.profile, types.ProfileClipboard, "Clipboard").Layout),
+					layout.Rigid(material.RadioButton(th, &p.profile, types.ProfileFileTransfer, "File Transfer").Layout),
+				)
+			})
+		}),
 		layout.Rigid(func(gtx C) D {
 			return heightSpacer(gtx, 10)
 		}),
@@ -236,3 +493,78 @@ func (p *Page) Layout(gtx C, th *material.Theme) D {
 		}),
 	)
 }
+
+// Lays out the record button, the file of the recording, the indicator of a recording of the
+// other user and the prompt asking for consent to it
+func (p *Page) layoutRecording(gtx C, th *material.Theme) D {
+	label := "Record"
+	if p.recording {
+		label = "Stop Recording"
+	}
+	rows := []layout.FlexChild{
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(material.Button(th, &p.recordBtn, label).Layout),
+				layout.Rigid(func(gtx C) D {
+					if p.recordingFile == "" {
+						return D{}
+					}
+					return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, material.Caption(th, p.recordingFile).Layout)
+				}),
+			)
+		}),
+	}
+	if p.peerRecording {
+		rows = append(rows, layout.Rigid(func(gtx C) D {
+			indicator := material.Body1(th, "● The other user is recording this session")
+			indicator.Color = color.NRGBA{R: 0xD0, A: 0xFF}
+			return indicator.Layout(gtx)
+		}))
+	}
+	if p.consent {
+		rows = append(rows, layout.Rigid(func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(material.Body1(th, "The other user asks to record this session ").Layout),
+				layout.Rigid(material.Button(th, &p.allowBtn, "Allow").Layout),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+				layout.Rigid(material.Button(th, &p.declineBtn, "Decline").Layout),
+			)
+		}))
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, rows...)
+}
+
+// Lays out the prompt of a join request with a button for each profile the remote may be
+// granted and one denying the request
+func (p *Page) layoutApproval(gtx C, th *material.Theme) D {
+	user := "A remote"
+	if p.approveUser != "" {
+		user = "User " + p.approveUser
+	}
+	buttons := []layout.FlexChild{
+		layout.Rigid(material.Body1(th, user+" asks to join. Allow ").Layout),
+	}
+	for i := range approvalProfiles {
+		buttons = append(buttons,
+			layout.Rigid(material.Button(th, &p.approveBtns[i], approvalProfiles[i].label).Layout),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+		)
+	}
+	buttons = append(buttons, layout.Rigid(material.Button(th, &p.denyBtn, "Deny").Layout))
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx, buttons...)
+}
+
+// Lays out the path of a file to send to the host, the send button and the last transferred file
+func (p *Page) layoutTransfer(gtx C, th *material.Theme) D {
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		layout.Flexed(1, material.Editor(th, &p.filePath, "File to send").Layout),
+		layout.Rigid(layout.Spacer{Width: unit.Dp(10)}.Layout),
+		layout.Rigid(material.Button(th, &p.sendFileBtn, "Send File").Layout),
+		layout.Rigid(func(gtx C) D {
+			if p.transferred == "" {
+				return D{}
+			}
+			return layout.Inset{Left: unit.Dp(10)}.Layout(gtx, material.Caption(th, "Transferred "+p.transferred).Layout)
+		}),
+	)
+}
@@ -20,10 +20,41 @@ type Setter interface {
 	SetTokenInfo(token, pwd string)
 }
 
+type Verifier interface {
+	SetVerification(sas string)
+}
+
+type StatusSetter interface {
+	SetStatus(status string)
+}
+
+type Warner interface {
+	SetWarning(warning string)
+}
+
 type ButtonDisabler interface {
 	Disable(bool)
 }
 
+type SessionControls interface {
+	ShowSessionControls(bool)
+}
+
+type RecordingControls interface {
+	SetRecording(recording bool, file string)
+	SetPeerRecording(recording bool)
+	AskRecordingConsent()
+}
+
+type JoinApprover interface {
+	AskApproval(user string)
+	CancelApproval()
+}
+
+type FileTransfers interface {
+	SetTransferred(file string)
+}
+
 type Page interface {
 	Layout(gtx layout.Context, th *material.Theme) layout.Dimensions
 }
@@ -56,10 +87,13 @@ func (r *Router) TabSwitch() {
 	}
 }
 
-func (r *Router) SetToken(token string) {
+func (r *Router) SetToken(token, password string) {
 	log.Printf("[INFO] Setting token. Current page: %v, Token: %s\n", r.current, token)
 	if pg, ok := r.pages[r.current].(Setter); ok {
-		pg.SetTokenInfo(token, "session password not yet implemented")
+		if password == "" {
+			password = "not required in unattended mode"
+		}
+		pg.SetTokenInfo(token, password)
 		// if !pg.IsInfoSet() {
 		// 	log.Println("[INFO] Valid page")
 		// 	pg.SetTokenInfo(token, "session password not yet implemented")
@@ -67,6 +101,33 @@ func (r *Router) SetToken(token string) {
 	}
 }
 
+// Shows the short authentication string of the current session
+func (r *Router) SetVerification(sas string) {
+	if pg, ok := r.pages[r.current].(Verifier); ok {
+		pg.SetVerification(sas)
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement Verifier", r.current)
+}
+
+// Shows the state of the session on the current page
+func (r *Router) SetStatus(status string) {
+	if pg, ok := r.pages[r.current].(StatusSetter); ok {
+		pg.SetStatus(status)
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement StatusSetter", r.current)
+}
+
+// Shows a warning on the current page
+func (r *Router) SetWarning(warning string) {
+	if pg, ok := r.pages[r.current].(Warner); ok {
+		pg.SetWarning(warning)
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement Warner", r.current)
+}
+
 func (r *Router) DisableJoinButton(disable bool) {
 	if pg, ok := r.pages[r.current].(ButtonDisabler); ok {
 		pg.Disable(disable)
@@ -75,6 +136,69 @@ func (r *Router) DisableJoinButton(disable bool) {
 	log.Printf("[WARN] Current page %d does not implement ButtonDisabler", r.current)
 }
 
+// Shows the controls of the current session like the voice chat on the current page
+func (r *Router) ShowSessionControls(show bool) {
+	if pg, ok := r.pages[r.current].(SessionControls); ok {
+		pg.ShowSessionControls(show)
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement SessionControls", r.current)
+}
+
+// Shows whether this user records the session and the file of the recording
+func (r *Router) SetRecording(recording bool, file string) {
+	if pg, ok := r.pages[r.current].(RecordingControls); ok {
+		pg.SetRecording(recording, file)
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement RecordingControls", r.current)
+}
+
+// Shows whether the other user records the session
+func (r *Router) SetPeerRecording(recording bool) {
+	if pg, ok := r.pages[r.current].(RecordingControls); ok {
+		pg.SetPeerRecording(recording)
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement RecordingControls", r.current)
+}
+
+// Asks the user for consent to a recording of the other user
+func (r *Router) AskRecordingConsent() {
+	if pg, ok := r.pages[r.current].(RecordingControls); ok {
+		pg.AskRecordingConsent()
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement RecordingControls", r.current)
+}
+
+// Asks the host user to approve the join request of the user and pick its permission profile
+func (r *Router) AskApproval(user string) {
+	if pg, ok := r.pages[r.current].(JoinApprover); ok {
+		pg.AskApproval(user)
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement JoinApprover", r.current)
+}
+
+// Hides the prompt of a join request which was not answered in time
+func (r *Router) CancelApproval() {
+	if pg, ok := r.pages[r.current].(JoinApprover); ok {
+		pg.CancelApproval()
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement JoinApprover", r.current)
+}
+
+// Shows the last file sent to or received by the host
+func (r *Router) SetTransferred(file string) {
+	if pg, ok := r.pages[r.current].(FileTransfers); ok {
+		pg.SetTransferred(file)
+		return
+	}
+	log.Printf("[WARN] Current page %d does not implement FileTransfers", r.current)
+}
+
 func (r *Router) SwitchTo(tag interface{}) {
 	_, ok := r.pages[tag]
 	if !ok {
@@ -25,13 +25,18 @@ var (
 )
 
 // Deserializes event messages arriving on the host datachannel
-// into local events for robotgo to consume
-func ParseEvent(payload []byte) {
+// into local events for robotgo to consume. Events which are not
+// covered by the granted permissions are dropped
+func ParseEvent(payload []byte, granted types.Permission) {
 	event := types.RemoteEvent{}
 	if err := json.Unmarshal(payload, &event); err != nil {
 		log.Println("Failed to parse event", err)
 		return
 	}
+	if !granted.Allows(event.Type) {
+		log.Printf("[WARN] Dropping %s event. Not allowed by permission profile %s", event.Type, granted)
+		return
+	}
 	switch event.Type {
 	case "move":
 		ev := types.MouseMove{}
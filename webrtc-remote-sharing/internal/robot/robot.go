@@ -90,3 +90,13 @@ func KeyEvent(event *types.KeyEvent) {
 		// robotgo.KeyToggle(event.Key, "up", event.Modifiers)
 	}
 }
+
+// Returns the text in the clipboard
+func Clipboard() (string, error) {
+	return robotgo.ReadAll()
+}
+
+// Replaces the text in the clipboard
+func SetClipboard(text string) error {
+	return robotgo.WriteAll(text)
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/json"
+	"fmt"
 )
 
 type RemoteEvent struct {
@@ -37,3 +38,138 @@ type KeyEvent struct {
 	Action    string   `json:"action"`
 	Modifiers []string `json:"modifiers"`
 }
+
+// Set of capabilities the host grants to the remote peer for a session
+type Permission uint8
+
+const (
+	PermControl      Permission = 1 << iota // Mouse and keyboard input
+	PermClipboard                           // Clipboard synchronization
+	PermFileTransfer                        // Files sent by the remote to the host
+)
+
+// Names of the permission profiles. Profiles are cumulative i.e. each one
+// includes the permissions of the profiles listed before it
+const (
+	ProfileViewOnly     = "view-only"
+	ProfileControl      = "control"
+	ProfileClipboard    = "clipboard"
+	ProfileFileTransfer = "file-transfer"
+)
+
+var profiles = map[string]Permission{
+	ProfileViewOnly:     0,
+	ProfileControl:      PermControl,
+	ProfileClipboard:    PermControl | PermClipboard,
+	ProfileFileTransfer: PermControl | PermClipboard | PermFileTransfer,
+}
+
+// Permission needed by each type of event arriving on the host datachannel
+var requiredPermission = map[string]Permission{
+	"move":      PermControl,
+	"click":     PermControl,
+	"scroll":    PermControl,
+	"drag":      PermControl,
+	"key":       PermControl,
+	"stream":    PermControl, // Changes the capture of the host
+	"record":    PermControl, // Asks the host for consent to record. Answers need no permission
+	"clipboard": PermClipboard,
+	"file":      PermFileTransfer,
+}
+
+// Sent by the host over the datachannel to tell the remote which profile was granted
+type PermissionGrant struct {
+	Profile string `json:"profile"`
+}
+
+// Sent over the datachannel by either peer when the text in its clipboard changed
+type Clipboard struct {
+	Text string `json:"text"`
+}
+
+// Sent by the remote over the datachannel to send a file to the host. The parts of the file
+// follow each other in order, the first one names the file and the last one is done
+type FileChunk struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Offset int64  `json:"offset"`
+	Data   []byte `json:"data,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+// Sent by the host over the datachannel once it stored a file of the remote or gave up on it
+type FileResult struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"` // Name the host stored the file under
+	Error string `json:"error,omitempty"`
+}
+
+// Sent by the host over the datachannel to warn the remote e.g. before the session ends
+type Notice struct {
+	Text string `json:"text"`
+}
+
+// Sent by the host once the datachannel is open. The id is claimed by the host, so the remote
+// only logs it and pins the host's DTLS fingerprint under the token it dialed
+type Identity struct {
+	DeviceID string `json:"deviceID"`
+}
+
+// Sent over the datachannel by either peer to ask the other one for consent to record the
+// session, to answer and to tell it when the recording started and stopped
+type Recording struct {
+	Action string `json:"action"`
+}
+
+// Actions of the recording messages
+const (
+	RecordingRequest = "request"
+	RecordingAccept  = "accept"
+	RecordingDecline = "decline"
+	RecordingStarted = "started"
+	RecordingStopped = "stopped"
+)
+
+// Quality tiers of the video. Higher tiers spend more bits on every pixel
+const (
+	QualityLow    = "low"
+	QualityMedium = "medium"
+	QualityHigh   = "high"
+)
+
+// Sent by the remote over the datachannel to ask for the size, frame rate and quality of the
+// video. The host answers with the settings it applied which may be smaller than the requested
+type StreamSettings struct {
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	FrameRate int    `json:"frameRate"`
+	Quality   string `json:"quality"`
+}
+
+// Returns the permissions of the named profile
+func ParseProfile(name string) (Permission, error) {
+	if p, ok := profiles[name]; ok {
+		return p, nil
+	}
+	return 0, fmt.Errorf("unknown permission profile %q", name)
+}
+
+// Reports whether an event of the given type may be applied on the host
+func (p Permission) Allows(eventType string) bool {
+	required, ok := requiredPermission[eventType]
+	if !ok {
+		return false
+	}
+	return p&required == required
+}
+
+// Returns the name of the profile matching the permissions
+func (p Permission) String() string {
+	for name, perm := range profiles {
+		if perm == p {
+			return name
+		}
+	}
+	return fmt.Sprintf("custom(%d)", uint8(p))
+}
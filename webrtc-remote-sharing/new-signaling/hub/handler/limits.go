@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/remygo/pkg/message"
+	"github.com/remygo/pkg/policy"
+)
+
+// Interval at which the limits of active sessions are checked
+const limitCheckInterval = time.Second
+
+// Runs in a goroutine for every session with limits. Warns the peers before the
+// session ends and terminates it once a limit is reached
+func (m *Manager) watchSession(r *Room, tracker *policy.Tracker) {
+	ticker := time.NewTicker(limitCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if done := m.checkSession(context.TODO(), r, tracker, m.now()); done {
+			return
+		}
+	}
+}
+
+// Returns true once the session has ended and no further checks are needed
+func (m *Manager) checkSession(ctx context.Context, r *Room, tracker *policy.Tracker, now time.Time) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	// The session ended through another path e.g. a peer left
+	if r.tracker != tracker {
+		return true
+	}
+
+	ev, ok := tracker.Check(now)
+	if !ok {
+		return false
+	}
+	if !ev.Expired {
+		log.Printf("[HUB] Room %s: %s", r.id, ev)
+		warning, err := message.NewInfo(message.Warning, ev.String())
+		if err != nil {
+			log.Printf("[HUB] Error creating warning. %v", err)
+			return false
+		}
+		for _, peer := range r.peers {
+			if err := peer.send(ctx, warning); err != nil {
+				log.Printf("[HUB] Error sending warning to peer %s. %v", peer.id, err)
+			}
+		}
+		return false
+	}
+
+	log.Printf("[HUB] Room %s: %s. Terminating session", r.id, ev)
+	if err := m.terminateSession(ctx, r); err != nil {
+		log.Printf("[HUB] Error terminating session %s. %v", r.id, err)
+	}
+	return true
+}
+
+// Ends the session in the room on behalf of the signaling server. Both peers are told to
+// terminate and receive new session tokens as if they had left on their own
+func (m *Manager) terminateSession(ctx context.Context, r *Room) error {
+	host, err := r.getHost()
+	if err != nil {
+		return err
+	}
+	var remotes []*Peer
+	for _, peer := range r.peers {
+		if peer.id != host.id {
+			remotes = append(remotes, peer)
+		}
+	}
+
+	terminate, err := message.NewCommand(message.TerminateSession)
+	if err != nil {
+		return err
+	}
+	if err = host.send(ctx, terminate); err != nil {
+		return fmt.Errorf("error sending terminate session command to host %s. %v", host.id, err)
+	}
+	// Sends the terminate command to the remotes, empties the room and renews the host token
+	if err = host.sessionCleanup(ctx); err != nil {
+		return err
+	}
+	for _, remote := range remotes {
+		remote.renewSessionToken(ctx)
+	}
+	return nil
+}
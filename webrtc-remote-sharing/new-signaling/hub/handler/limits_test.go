@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/remygo/pkg/message"
+	"github.com/remygo/pkg/policy"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// Clock of the manager which only moves when the test advances it
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// Connects a peer to the manager over a websocket and returns the messages the peer receives
+func connectPeer(t *testing.T, m *Manager, id string) (*Peer, <-chan message.Message) {
+	conns := make(chan *websocket.Conn)
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- c
+		<-done
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(done) })
+
+	client, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan message.Message, 16)
+	go func() {
+		defer close(received)
+		for {
+			var msg message.Message
+			if err := wsjson.Read(context.Background(), client, &msg); err != nil {
+				return
+			}
+			received <- msg
+		}
+	}()
+
+	conn := <-conns
+	t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+
+	return newPeer(id, conn, m), received
+}
+
+// Starts a session of the host and the remote in the manager which is tracked against the limits
+func startSession(t *testing.T, limits policy.Limits, clock *fakeClock) (m *Manager, r *Room, host, remote *Peer, hostMsgs, remoteMsgs <-chan message.Message) {
+	m = NewManager(nil, &policy.Config{})
+	m.now = clock.Now
+
+	host, hostMsgs = connectPeer(t, m, "host")
+	remote, remoteMsgs = connectPeer(t, m, "remote")
+	host.sessionToken, remote.sessionToken = "host-token", "remote-token"
+	m.sessions[host.sessionToken], m.sessions[remote.sessionToken] = host, remote
+	m.rooms[remote.sessionToken] = newRoom(remote.sessionToken)
+
+	r = newRoom(host.sessionToken)
+	m.rooms[host.sessionToken] = r
+	host.joinSession(host.sessionToken)
+	remote.joinSession(host.sessionToken)
+	r.addPeer(host)
+	r.addPeer(remote)
+	r.tracker = policy.NewTracker(limits, m.now())
+
+	return m, r, host, remote, hostMsgs, remoteMsgs
+}
+
+// Checks that the peer received the messages in order and nothing else
+func expectMessages(t *testing.T, peer string, received <-chan message.Message, want []string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case msg := <-received:
+			payload, err := message.Decode(&msg)
+			if err != nil {
+				t.Fatalf("%s received undecodable message: %v", peer, err)
+			}
+			if payload.String() != w {
+				t.Fatalf("%s received %s, want %s", peer, payload, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s received nothing, want %s", peer, w)
+		}
+	}
+	select {
+	case msg := <-received:
+		payload, _ := message.Decode(&msg)
+		t.Fatalf("%s received unexpected %s", peer, payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCheckSession(t *testing.T) {
+	type step struct {
+		at           time.Duration // Time since the start of the session
+		activity     bool          // The host reports activity before the check
+		done         bool          // The check ends the session
+		host, remote []string      // Messages the peers receive
+	}
+	terminated := []string{"TerminateSession", "Renew"}
+
+	tests := []struct {
+		name   string
+		limits policy.Limits
+		steps  []step
+	}{
+		{
+			name:   "time limit",
+			limits: policy.Limits{MaxDuration: policy.Duration(time.Hour), WarnBefore: policy.Duration(5 * time.Minute)},
+			steps: []step{
+				{at: 30 * time.Minute},
+				{at: 55 * time.Minute, host: []string{"Warning"}, remote: []string{"Warning"}},
+				{at: 56 * time.Minute},
+				{at: time.Hour, done: true, host: terminated, remote: terminated},
+			},
+		},
+		{
+			name:   "idle timeout",
+			limits: policy.Limits{IdleTimeout: policy.Duration(10 * time.Minute)},
+			steps: []step{
+				{at: 9*time.Minute + 30*time.Second, host: []string{"Warning"}, remote: []string{"Warning"}},
+				{at: 15 * time.Minute, activity: true},
+				{at: 24*time.Minute + 30*time.Second, host: []string{"Warning"}, remote: []string{"Warning"}},
+				{at: 25 * time.Minute, done: true, host: terminated, remote: terminated},
+			},
+		},
+		{
+			name:   "activity keeps idle session",
+			limits: policy.Limits{MaxDuration: policy.Duration(time.Hour), IdleTimeout: policy.Duration(10 * time.Minute)},
+			steps: []step{
+				{at: 8 * time.Minute, activity: true},
+				{at: 16 * time.Minute, activity: true},
+				{at: 24 * time.Minute, activity: true},
+				{at: 59 * time.Minute, activity: true, host: []string{"Warning"}, remote: []string{"Warning"}},
+				{at: time.Hour, done: true, host: terminated, remote: terminated},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			clock := &fakeClock{now: start}
+			m, r, host, remote, hostMsgs, remoteMsgs := startSession(t, tt.limits, clock)
+			tracker := r.tracker
+
+			for _, s := range tt.steps {
+				clock.now = start.Add(s.at)
+				if s.activity {
+					activity, err := message.NewInfo(message.Activity, "")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := host.handleInfo(context.Background(), activity); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if done := m.checkSession(context.Background(), r, tracker, m.now()); done != s.done {
+					t.Fatalf("at %s: done %v, want %v", s.at, done, s.done)
+				}
+				expectMessages(t, "host", hostMsgs, s.host)
+				expectMessages(t, "remote", remoteMsgs, s.remote)
+			}
+
+			// Both peers left the room and the watcher of the session stops
+			if host.inRoom() || remote.inRoom() || len(r.peers) != 0 || r.tracker != nil {
+				t.Fatalf("session not terminated: host %q, remote %q, room %v", host.status, remote.status, r.peers)
+			}
+			if done := m.checkSession(context.Background(), r, tracker, m.now()); !done {
+				t.Fatal("check of the terminated session did not stop the watcher")
+			}
+		})
+	}
+}
@@ -2,13 +2,15 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/remygo/pkg/auth"
 	"github.com/remygo/pkg/message"
+	"github.com/remygo/pkg/policy"
 
 	"nhooyr.io/websocket"
 )
@@ -28,17 +30,21 @@ type Manager struct {
 	sessions    map[string]*Peer
 	apiCallChan chan APICall
 	requests    map[RequestID]*JoinRequest
+	policy      *policy.Config   // Session time limits and idle timeouts
+	now         func() time.Time // Clock the session limits are tracked by
 	// recvChan chan *message.Message
 	mux sync.RWMutex
 }
 
-func NewManager(apiChan chan APICall) *Manager {
+func NewManager(apiChan chan APICall, pol *policy.Config) *Manager {
 	return &Manager{
 		peers:       make(map[string]*Peer),
 		rooms:       make(map[string]*Room),
 		sessions:    make(map[string]*Peer),
 		requests:    make(map[RequestID]*JoinRequest),
 		apiCallChan: apiChan,
+		policy:      pol,
+		now:         time.Now,
 		// recvChan: make(chan *message.Message),
 		mux: sync.RWMutex{},
 	}
@@ -112,8 +118,8 @@ func (p *Peer) handleSession(ctx context.Context, msg *message.Message) error {
 	p.m.mux.Lock()
 	defer p.m.mux.Unlock()
 
-	var sessionMessage *message.SessionMessage
-	if err := json.Unmarshal(msg.Data, &sessionMessage); err != nil {
+	sessionMessage, err := message.DecodeSession(msg)
+	if err != nil {
 		return fmt.Errorf("[HUB] Failed to parse session message: %v", err)
 	}
 	sessionToken := sessionMessage.Token
@@ -138,11 +144,26 @@ func (p *Peer) handleSession(ctx context.Context, msg *message.Message) error {
 						remote.joinSession(sessionToken)
 						room.addPeer(remote)
 
-						remote.send(ctx, message.NewInfo(message.Ack, fmt.Sprintf("Session Join Request %s ALLOWED", sessionToken)))
-						remote.send(ctx, message.NewCommand(message.InitiateSession))
+						ack, err := message.NewAck(fmt.Sprintf("Session Join Request %s ALLOWED", sessionToken), p.unattended)
+						if err != nil {
+							return fmt.Errorf("[HUB] Error creating ack. %v", err)
+						}
+						initiate, err := message.NewCommand(message.InitiateSession)
+						if err != nil {
+							return fmt.Errorf("[HUB] Error creating initiate session command. %v", err)
+						}
+						remote.send(ctx, ack)
+						remote.send(ctx, initiate)
 
 						delete(p.m.requests, RequestID(sessionToken))
 
+						// Limits of the remote's organization apply too so that e.g. contractor access expires
+						if limits := p.m.policy.For(p.orgID, remote.orgID); limits.Enabled() {
+							log.Printf("[HUB] Session %s is limited to %+v", sessionToken, limits)
+							room.tracker = policy.NewTracker(limits, p.m.now())
+							go p.m.watchSession(room, room.tracker)
+						}
+
 						// p.m.apiCallChan <- APICall{Type: JoinSession, UserID: p.id, DeviceID: p.deviceID, SessionToken: sessionToken}
 						return nil
 					}
@@ -154,10 +175,9 @@ func (p *Peer) handleSession(ctx context.Context, msg *message.Message) error {
 		case message.Deny:
 			if !p.inRoom() {
 				if req, ok := p.m.requests[RequestID(sessionToken)]; ok {
-					if recipient, ok := p.m.sessions[req.Sender]; ok {
-						recipient.send(ctx, message.NewInfo(message.Error, fmt.Sprintf("Session Join Request %s Denied", sessionToken)))
+					if recipient, ok := p.m.peers[req.Sender]; ok {
 						delete(p.m.requests, RequestID(sessionToken))
-						return nil
+						return recipient.sendError(ctx, fmt.Sprintf("Session Join Request %s Denied", sessionToken))
 					}
 					return fmt.Errorf("[HUB] Unable to fetch peer %s", req.Sender)
 				}
@@ -195,9 +215,7 @@ func (p *Peer) handleSession(ctx context.Context, msg *message.Message) error {
 				if host.inRoom() {
 					log.Printf("\n\n[HUB] Host %s peer is already in a session. Terminating peer %s\n\n", host.id, p.id)
 
-					errorMsg := message.NewInfo(message.Error, "Peer already in room")
-					p.send(ctx, errorMsg)
-					return nil
+					return p.sendError(ctx, "Peer already in room")
 				}
 
 				// Check pending requests. If it's a new request, add it to the pending requests
@@ -209,8 +227,15 @@ func (p *Peer) handleSession(ctx context.Context, msg *message.Message) error {
 						Status:    "pending",
 					}
 					log.Printf("[HUB] Peer %s sent session join request to peer %s: %s\n", p.id, host.id, sessionToken)
-					// Send the request to the host peer
-					joinRequest := message.NewSession(message.JoinRequest, sessionToken, nil)
+					// Send the request to the host peer annotated with the requesting user so that
+					// the host can apply its access rules and permission profiles. The ids are the
+					// ones the peer registered with, they are not authenticated
+					joinRequest, err := message.NewJoinRequest(sessionToken, p.userID, p.orgID,
+						sessionMessage.Share, sessionMessage.Device)
+					if err != nil {
+						delete(p.m.requests, RequestID(sessionToken))
+						return fmt.Errorf("[HUB] Error creating join request. %v", err)
+					}
 					host.send(ctx, joinRequest)
 
 					return nil
@@ -245,9 +270,39 @@ func (p *Peer) handleSession(ctx context.Context, msg *message.Message) error {
 				// p.m.apiCallChan <- APICall{Type: JoinSession, UserID: p.id, DeviceID: p.deviceID, SessionToken: sessionToken}
 			}
 		} else {
-			errorMsg := message.NewInfo(message.Error, "Invalid session token")
-			p.send(ctx, errorMsg)
+			return p.sendError(ctx, "Invalid session token")
+		}
+	case message.JoinChallenge:
+		// The host answers the password exchange of a pending request. The hub only relays the
+		// shares, it cannot learn the password from them
+		req, ok := p.m.requests[RequestID(sessionToken)]
+		if !ok || req.Recipient != p.id {
+			return fmt.Errorf("[HUB] No pending join request %s for peer %s", sessionToken, p.id)
+		}
+		remote, ok := p.m.peers[req.Sender]
+		if !ok {
+			return fmt.Errorf("[HUB] Unable to fetch peer %s", req.Sender)
 		}
+		challenge, err := message.NewJoinChallenge(sessionToken, sessionMessage.Share, sessionMessage.Proof)
+		if err != nil {
+			return fmt.Errorf("[HUB] Error creating join challenge. %v", err)
+		}
+		remote.send(ctx, challenge)
+	case message.JoinConfirm:
+		// The remote confirms the password exchange to the host of its pending request
+		req, ok := p.m.requests[RequestID(sessionToken)]
+		if !ok || req.Sender != p.id {
+			return fmt.Errorf("[HUB] No pending join request %s from peer %s", sessionToken, p.id)
+		}
+		host, ok := p.m.peers[req.Recipient]
+		if !ok {
+			return fmt.Errorf("[HUB] Unable to fetch peer %s", req.Recipient)
+		}
+		confirm, err := message.NewJoinConfirm(sessionToken, sessionMessage.Proof)
+		if err != nil {
+			return fmt.Errorf("[HUB] Error creating join confirmation. %v", err)
+		}
+		host.send(ctx, confirm)
 	case message.Leave:
 		// if sessionToken == "" {
 		// 	return fmt.Errorf("[HUB] No room specified in session message")
@@ -259,6 +314,16 @@ func (p *Peer) handleSession(ctx context.Context, msg *message.Message) error {
 					return fmt.Errorf("error removing peer %s from joined session %s. %v", p.id, p.status, err)
 				}
 				p.renewSessionToken(ctx)
+			} else {
+				// The host ends the session itself e.g. when its own limits are reached
+				log.Printf("[HUB] Host peer %s is ending the session\n", p.id)
+				r, err := p.getOwnRoom()
+				if err != nil {
+					return fmt.Errorf("error fetching host session room %s. %v", p.sessionToken, err)
+				}
+				if err = p.m.terminateSession(ctx, r); err != nil {
+					return fmt.Errorf("error ending session %s. %v", r.id, err)
+				}
 			}
 		}
 
@@ -276,15 +341,48 @@ func (p *Peer) handleInfo(ctx context.Context, msg *message.Message) error {
 	p.m.mux.Lock()
 	defer p.m.mux.Unlock()
 
-	var tokenMsg message.InfoMessage
-	if err := json.Unmarshal([]byte(msg.Data), &tokenMsg); err != nil {
-		log.Panicf("[ERR] Unmarshalling info message. %v", err)
+	tokenMsg, err := message.DecodeInfo(msg)
+	if err != nil {
+		return fmt.Errorf("[HUB] Failed to parse info message: %v", err)
 	}
 	switch tokenMsg.Type {
 	case message.Register:
 		// Store the user & device ids for logging events with the rest api
 		p.userID = tokenMsg.UserID
 		p.deviceID = tokenMsg.DeviceID
+		p.orgID = tokenMsg.OrgID
+
+		if p.inRoom() {
+			return fmt.Errorf("[HUB] Peer %s tried to register while in session %s", p.id, p.status)
+		}
+
+		// Unattended hosts must supply their access id since it is what remotes connect to. The id
+		// is derived from a key the host proves, so no other device can register under it. Other
+		// peers are assigned a token
+		if tokenMsg.Unattended {
+			if tokenMsg.Data == "" {
+				return p.sendError(ctx, "Unattended access requires an access id")
+			}
+			if err := auth.VerifyAccessID(tokenMsg.Data, tokenMsg.Key, tokenMsg.Signature); err != nil {
+				log.Printf("[HUB] Peer %s claimed access id %s: %v", p.id, tokenMsg.Data, err)
+				return p.sendError(ctx, fmt.Sprintf("Access id %s is bound to another device", tokenMsg.Data))
+			}
+		} else if tokenMsg.Data != "" {
+			log.Printf("[HUB] Peer %s supplied session token %s without unattended access. Ignoring it", p.id, tokenMsg.Data)
+			tokenMsg.Data = ""
+		}
+		// Refuse tokens that are registered by another peer
+		if other, ok := p.m.sessions[tokenMsg.Data]; ok && other.id != p.id {
+			return p.sendError(ctx, fmt.Sprintf("Session token %s already in use", tokenMsg.Data))
+		}
+
+		// Peer is registering again e.g. after switching unattended access on or off
+		if p.sessionToken != "" {
+			log.Printf("[HUB] Peer %s is registering again. Dropping session token %s", p.id, p.sessionToken)
+			delete(p.m.rooms, p.sessionToken)
+			delete(p.m.sessions, p.sessionToken)
+		}
+		p.unattended = tokenMsg.Unattended
 
 		if tokenMsg.Data == "" {
 			p.sessionToken = newSessionToken()
@@ -300,13 +398,27 @@ func (p *Peer) handleInfo(ctx context.Context, msg *message.Message) error {
 		p.m.rooms[p.sessionToken] = newRoom(p.sessionToken)
 
 		// Send the peer their assigned session token
-		tokenMsg := message.NewInfo(message.Token, p.sessionToken)
+		tokenMsg, err := message.NewInfo(message.Token, p.sessionToken)
+		if err != nil {
+			return fmt.Errorf("[HUB] Error creating token message. %v", err)
+		}
 		fmt.Printf("\n\n[HUB] -> Peer %s: Session: %s\nMSG:%#+v\n", p.id, p.sessionToken, tokenMsg)
 		if err := p.send(ctx, tokenMsg); err != nil {
-			log.Panicf("[ERR] sending message to socket: %q", err)
+			return fmt.Errorf("[ERR] sending message to socket: %q", err)
 		}
 
 		// p.m.apiCallChan <- APICall{Type: CreateSession, UserID: p.userID, DeviceID: p.deviceID, SessionToken: p.sessionToken}
+	case message.Activity:
+		// Input or frame changes on the host reset the idle timeout of the session
+		if p.inRoom() {
+			r, err := p.getJoinedRoom()
+			if err != nil {
+				return fmt.Errorf("[HUB] Error fetching room for activity report. %v", err)
+			}
+			if r.tracker != nil {
+				r.tracker.Touch(p.m.now())
+			}
+		}
 	}
 
 	return nil
@@ -356,6 +468,13 @@ func (m *Manager) registerPeer(conn *websocket.Conn, pid string) (*Peer, error)
 }
 
 func (p *Peer) renewSessionToken(ctx context.Context) {
+	// Unattended hosts keep their access id so that they can be reached again with the same token
+	if p.unattended {
+		log.Printf("[HUB] Peer %s is unattended. Keeping access id %s", p.id, p.sessionToken)
+		p.sendRenew(p.sessionToken)
+		return
+	}
+
 	log.Println("[HUB] Renewing session token for peer", p.id)
 	newToken := newSessionToken()
 
@@ -367,7 +486,19 @@ func (p *Peer) renewSessionToken(ctx context.Context) {
 	log.Printf("[HUB] Updated sessions record. Old token: %s -> New token: %s", p.sessionToken, newToken)
 	p.sessionToken = newToken
 
-	p.send(context.Background(), message.NewInfo(message.Renew, newToken))
+	p.sendRenew(newToken)
+}
+
+// Sends the renewed session token to the peer
+func (p *Peer) sendRenew(token string) {
+	renew, err := message.NewInfo(message.Renew, token)
+	if err != nil {
+		log.Printf("[HUB] Error creating renew message for peer %s. %v", p.id, err)
+		return
+	}
+	if err := p.send(context.Background(), renew); err != nil {
+		log.Printf("[HUB] Error sending renew message to peer %s. %v", p.id, err)
+	}
 }
 
 func (p *Peer) sessionCleanup(ctx context.Context) error {
@@ -379,11 +510,18 @@ func (p *Peer) sessionCleanup(ctx context.Context) error {
 			return fmt.Errorf("error fetching peer's %s room %s. %v", p.id, p.sessionToken, err)
 		}
 		log.Printf("[HUB] Peer %s is the session host. Removing other peer from the room\n", p.id)
+		// Stops the limit watcher of the session
+		r.tracker = nil
+
 		for _, recipient := range r.peers {
 			// Send terminate session command to all peers in the session except the host peer
 			if recipient.id != p.id {
 				log.Printf("[HUB] Removing peer %s from the room", recipient.id)
-				if err = recipient.send(ctx, message.NewCommand(message.TerminateSession)); err != nil {
+				terminate, err := message.NewCommand(message.TerminateSession)
+				if err != nil {
+					return err
+				}
+				if err = recipient.send(ctx, terminate); err != nil {
 					// // TODO: Error sending a message to a peer should terminate their websocket connection through ctx.Cancel
 					return fmt.Errorf("error sending terminate session command to peer %s. %v", recipient.id, err)
 				}
@@ -421,9 +559,13 @@ func (p *Peer) sessionCleanup(ctx context.Context) error {
 	log.Printf("[HUB] Remote peer %s is leaving the room. Informing host to terminate session", p.id)
 	host, err := r.getHost()
 	if err != nil {
-		log.Panicf("[HUB] Error fetching host peer from room %s. %v", r.id, err)
+		return fmt.Errorf("error fetching host peer from room %s. %v", r.id, err)
+	}
+	terminate, err := message.NewCommand(message.TerminateSession)
+	if err != nil {
+		return err
 	}
-	host.send(ctx, message.NewCommand(message.TerminateSession))
+	host.send(ctx, terminate)
 	defer host.sessionCleanup(ctx)
 
 	// End session device
@@ -468,36 +610,10 @@ func (p *Peer) removePeer(ctx context.Context) error {
 
 // Log the message received from a peer
 func logMessage(msg *message.Message) {
-	switch msg.Type {
-	case message.Signal:
-		var signal message.SignalMessage
-		if err := json.Unmarshal(msg.Data, &signal); err != nil {
-			log.Printf("[ERR] Unmarshalling websocket message: %v", err)
-			return
-		}
-		log.Printf("[HUB] Peer message from %s. Type: %s", msg.From, signal.String())
-	case message.Session:
-		var session message.SessionMessage
-		if err := json.Unmarshal(msg.Data, &session); err != nil {
-			log.Printf("[ERR] Unmarshalling websocket message: %v", err)
-			return
-		}
-		log.Printf("[HUB] Peer message from %s. Type: %s", msg.From, session.String())
-	case message.Command:
-		var command message.CommandMessage
-		if err := json.Unmarshal(msg.Data, &command); err != nil {
-			log.Printf("[ERR] Unmarshalling websocket message: %v", err)
-			return
-		}
-		log.Printf("[HUB] Peer message from %s. Type: %s", msg.From, command.String())
-	case message.Info:
-		var info message.InfoMessage
-		if err := json.Unmarshal(msg.Data, &info); err != nil {
-			log.Printf("[ERR] Unmarshalling websocket message: %v", err)
-			return
-		}
-		log.Printf("[HUB] Peer message from %s. Type: %s", msg.From, info.String())
-	default:
-		log.Printf("[HUB] Unknown message from %s: %v", msg.From, msg.Type)
+	payload, err := message.Decode(msg)
+	if err != nil {
+		log.Printf("[HUB] Unknown message from %s: %v", msg.From, err)
+		return
 	}
+	log.Printf("[HUB] Peer message from %s. Type: %s", msg.From, payload.String())
 }
@@ -25,9 +25,11 @@ type Peer struct {
 	// recvCh       chan *message.Message // Channel for the peer to pass on messages to the hub
 	// sendCh       chan *message.Message // Channel for the hub to pass messages to for writing to socket
 	// stopCh chan struct{} // Channel to signal that peer's serveWs() should return
-	m        *Manager // Pointer to the manager
-	userID   string
-	deviceID string
+	m          *Manager // Pointer to the manager
+	userID     string
+	deviceID   string
+	orgID      string
+	unattended bool // Peer registered with a stable access id which is kept across sessions
 }
 
 func newPeer(id string, conn *websocket.Conn, m *Manager) *Peer {
@@ -83,6 +85,15 @@ func (p *Peer) send(ctx context.Context, msg *message.Message) error {
 	return wsjson.Write(ctx, p.conn, msg)
 }
 
+// Sends an 'Error' info message with the given text to the peer
+func (p *Peer) sendError(ctx context.Context, text string) error {
+	msg, err := message.NewInfo(message.Error, text)
+	if err != nil {
+		return err
+	}
+	return p.send(ctx, msg)
+}
+
 // Returns true if the peer status is not an empty string i.e. peer is in a session
 func (p *Peer) inRoom() bool {
 	return p.status != ""
@@ -2,11 +2,14 @@ package handler
 
 import (
 	"fmt"
+
+	"github.com/remygo/pkg/policy"
 )
 
 type Room struct {
-	id    string  // Room id with the same value of the peer session token
-	peers []*Peer // Slice of other peers that have joined the room
+	id      string          // Room id with the same value of the peer session token
+	peers   []*Peer         // Slice of other peers that have joined the room
+	tracker *policy.Tracker // Tracks the session against its limits. Nil when no session is active or no limits apply
 }
 
 // Create a new room with the peer's session token and add the peer to the room
@@ -4,14 +4,15 @@ import (
 	"net/http"
 
 	"github.com/remygo/new-signaling/hub/handler"
+	"github.com/remygo/pkg/policy"
 )
 
 type Hub struct {
 	manager *handler.Manager
 }
 
-func New(apiChan chan handler.APICall) *Hub {
-	return &Hub{handler.NewManager(apiChan)}
+func New(apiChan chan handler.APICall, pol *policy.Config) *Hub {
+	return &Hub{handler.NewManager(apiChan, pol)}
 }
 
 func (h *Hub) Serve(w http.ResponseWriter, r *http.Request) {
@@ -7,10 +7,12 @@ import (
 
 	"github.com/remygo/new-signaling/hub"
 	"github.com/remygo/new-signaling/hub/handler"
+	"github.com/remygo/pkg/policy"
 )
 
 var (
-	addr = flag.String("addr", ":8765", "http service address")
+	addr       = flag.String("addr", ":8765", "http service address")
+	policyPath = flag.String("policy", "", "path to a JSON file with session time limits and idle timeouts")
 )
 
 const apiChanBuffer = 1024
@@ -18,8 +20,13 @@ const apiChanBuffer = 1024
 func main() {
 	flag.Parse()
 
+	pol, err := policy.Load(*policyPath)
+	if err != nil {
+		log.Fatalf("[ERR] Loading session policy: %v", err)
+	}
+
 	apiChan := make(chan handler.APICall, apiChanBuffer)
-	h := hub.New(apiChan)
+	h := hub.New(apiChan, pol)
 	go h.StartAPIService(apiChan)
 
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
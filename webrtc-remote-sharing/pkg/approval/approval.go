@@ -0,0 +1,122 @@
+package approval
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/remygo/internal/types"
+)
+
+// Join request as seen by an approver. The ids are set by the signaling server
+type Request struct {
+	Token      string
+	UserID     string
+	OrgID      string
+	Unattended bool // The host runs unattended, so nobody may be in front of it
+}
+
+// Answer of an approver. An approved request may be granted another permission profile than
+// the one the config picks for the user
+type Decision struct {
+	Approved bool
+	Profile  string // Permission profile granted to the remote. Empty keeps the one of the config
+}
+
+// Decides join requests in place of a user in front of the host. It is asked after
+// the request has been authenticated with the session or access password
+type Approver interface {
+	Approve(ctx context.Context, req Request) (Decision, error)
+}
+
+// Approves requests from the listed user ids
+type AllowList []string
+
+func (a AllowList) Approve(_ context.Context, req Request) (Decision, error) {
+	if req.UserID == "" {
+		return Decision{}, nil
+	}
+	for _, id := range a {
+		if id == req.UserID {
+			return Decision{Approved: true}, nil
+		}
+	}
+	return Decision{}, nil
+}
+
+// How long an approval command may run before the request is denied
+const DefaultTimeout = 10 * time.Second
+
+// Runs an external program for every request. The request is passed in the REMYGO_TOKEN,
+// REMYGO_USER_ID and REMYGO_ORG_ID environment variables and an exit status of zero approves it.
+// The first line the program prints may name the permission profile granted to the remote
+type Command struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func (c Command) Approve(ctx context.Context, req Request) (Decision, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Path)
+	cmd.Env = append(os.Environ(),
+		"REMYGO_TOKEN="+req.Token,
+		"REMYGO_USER_ID="+req.UserID,
+		"REMYGO_ORG_ID="+req.OrgID,
+	)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		line, _ := bufio.NewReader(&out).ReadString('\n')
+		return Decision{Approved: true, Profile: strings.TrimSpace(line)}, nil
+	case ctx.Err() != nil:
+		return Decision{}, fmt.Errorf("approval command timed out after %s", timeout)
+	case errors.As(err, &exitErr):
+		return Decision{}, nil
+	default:
+		return Decision{}, fmt.Errorf("running approval command: %v", err)
+	}
+}
+
+// Approves a request only if every approver does. An empty list approves every request.
+// The remote is granted the strictest of the profiles the approvers picked
+type All []Approver
+
+func (all All) Approve(ctx context.Context, req Request) (Decision, error) {
+	decision := Decision{Approved: true}
+	var granted types.Permission
+	for _, a := range all {
+		d, err := a.Approve(ctx, req)
+		if err != nil || !d.Approved {
+			return Decision{}, err
+		}
+		if d.Profile == "" {
+			continue
+		}
+		perm, err := types.ParseProfile(d.Profile)
+		if err != nil {
+			return Decision{}, err
+		}
+		// Profiles are cumulative, so the strictest one has the fewest permissions
+		if decision.Profile == "" || perm&granted != granted {
+			decision.Profile, granted = d.Profile, perm
+		}
+	}
+	return decision, nil
+}
@@ -0,0 +1,56 @@
+package approval
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/remygo/internal/types"
+)
+
+func TestApprove(t *testing.T) {
+	dir := t.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	onlyAlice := script("alice.sh", `[ "$REMYGO_USER_ID" = "alice" ]`)
+	slow := script("slow.sh", "sleep 5")
+	viewOnly := script("view.sh", "echo "+types.ProfileViewOnly+"; echo "+types.ProfileControl)
+	clipboard := script("clipboard.sh", "echo "+types.ProfileClipboard)
+	unknown := script("unknown.sh", "echo admin")
+
+	approved := Decision{Approved: true}
+	tests := []struct {
+		name    string
+		a       Approver
+		user    string
+		want    Decision
+		wantErr bool
+	}{
+		{"allowed user", AllowList{"alice", "bob"}, "alice", approved, false},
+		{"unknown user", AllowList{"alice", "bob"}, "mallory", Decision{}, false},
+		{"anonymous user", AllowList{""}, "", Decision{}, false},
+		{"command approves", Command{Path: onlyAlice}, "alice", approved, false},
+		{"command denies", Command{Path: onlyAlice}, "bob", Decision{}, false},
+		{"command times out", Command{Path: slow, Timeout: 50 * time.Millisecond}, "alice", Decision{}, true},
+		{"missing command", Command{Path: filepath.Join(dir, "missing")}, "alice", Decision{}, true},
+		{"command picks profile", Command{Path: viewOnly}, "alice", Decision{Approved: true, Profile: types.ProfileViewOnly}, false},
+		{"all approve", All{AllowList{"alice"}, Command{Path: onlyAlice}}, "alice", approved, false},
+		{"one denies", All{AllowList{"bob"}, Command{Path: onlyAlice}}, "bob", Decision{}, false},
+		{"no approvers", All{}, "mallory", approved, false},
+		{"strictest profile", All{Command{Path: clipboard}, Command{Path: viewOnly}, AllowList{"alice"}}, "alice", Decision{Approved: true, Profile: types.ProfileViewOnly}, false},
+		{"unknown profile", All{Command{Path: unknown}}, "alice", Decision{}, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.a.Approve(context.Background(), Request{Token: "token", UserID: tt.user})
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: Approve() = %+v, %v, want %+v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
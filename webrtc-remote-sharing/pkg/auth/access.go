@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Access ids of unattended hosts are derived from the public key of a key pair the host keeps.
+// The host proves that it holds the key when it registers, so the signaling server binds the id
+// to the device which created it without having to remember who claimed it first
+
+// Signed by the host to claim its access id
+const accessContext = "remygo access id\x00"
+
+// Returned if a registration does not prove the key of the access id
+var ErrAccessID = errors.New("access id is not bound to the key")
+
+// Returns a new access key encoded for the config
+func NewAccessKey() (string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// Returns the access id of the key, its public key and the signature which claims the id
+func ClaimAccessID(accessKey string) (id, public, signature string, err error) {
+	der, err := base64.StdEncoding.DecodeString(accessKey)
+	if err != nil {
+		return "", "", "", fmt.Errorf("decoding access key: %v", err)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return "", "", "", fmt.Errorf("parsing access key: %v", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return "", "", "", fmt.Errorf("access key is a %T", parsed)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	id = accessID(pub)
+	digest := sha256.Sum256([]byte(accessContext + id))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", "", "", err
+	}
+	return id, base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// Checks that the access id belongs to the public key and that the signature was made with it
+func VerifyAccessID(id, public, signature string) error {
+	pub, err := base64.StdEncoding.DecodeString(public)
+	if err != nil || accessID(pub) != id {
+		return ErrAccessID
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrAccessID
+	}
+	parsed, err := x509.ParsePKIXPublicKey(pub)
+	if err != nil {
+		return ErrAccessID
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return ErrAccessID
+	}
+
+	digest := sha256.Sum256([]byte(accessContext + id))
+	if !ecdsa.VerifyASN1(key, digest[:], sig) {
+		return ErrAccessID
+	}
+	return nil
+}
+
+// Formats the first 16 bytes of the hash of the public key like a uuid so that access ids
+// look the same as before
+func accessID(pub []byte) string {
+	h := sha256.Sum256(pub)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
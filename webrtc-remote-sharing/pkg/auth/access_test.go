@@ -0,0 +1,42 @@
+package auth
+
+import "testing"
+
+func TestClaimAccessID(t *testing.T) {
+	key, err := NewAccessKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, public, signature, err := ClaimAccessID(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAccessID(id, public, signature); err != nil {
+		t.Fatalf("VerifyAccessID() = %v for the claim of the key", err)
+	}
+
+	// The id stays the same for the key while the signature is made anew
+	again, _, _, err := ClaimAccessID(key)
+	if err != nil || again != id {
+		t.Fatalf("claimed %q then %q, %v", id, again, err)
+	}
+
+	// Another device can neither sign for the id nor pass its own key off as the id's key
+	otherKey, err := NewAccessKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPublic, otherSignature, err := ClaimAccessID(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAccessID(id, otherPublic, otherSignature); err != ErrAccessID {
+		t.Errorf("VerifyAccessID() with another key = %v, want %v", err, ErrAccessID)
+	}
+	if err := VerifyAccessID(id, public, otherSignature); err != ErrAccessID {
+		t.Errorf("VerifyAccessID() with a signature of another key = %v, want %v", err, ErrAccessID)
+	}
+	if err := VerifyAccessID(id, "", ""); err != ErrAccessID {
+		t.Errorf("VerifyAccessID() without key = %v, want %v", err, ErrAccessID)
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// scrypt cost parameters. Deriving the secrets of a password takes roughly 100ms on a
+// desktop machine which is acceptable once per join request but slows down offline guessing
+// of a stolen verifier
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	keyLen  = 32 // Length of the shared key which seals the signaling
+)
+
+// Characters of generated session passwords. Similar looking characters are left out
+// since the password is read out or typed in by hand
+const passwordAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// Length of generated session passwords, which gives roughly 50 bits of entropy
+const passwordLen = 10
+
+// Returned by Open if the message was not sealed with the same key or was tampered with
+var ErrAuthentication = errors.New("message authentication failed")
+
+// Returns a random one-off session password
+func NewPassword() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(passwordAlphabet)))
+
+	for i := 0; i < passwordLen; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(passwordAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// Encrypts and authenticates plaintext with AES-GCM. The additional data is authenticated
+// but not sent, so the receiver has to supply the same values to Open
+func Seal(key, plaintext []byte, additional ...string) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, []byte(strings.Join(additional, "\x00"))), nil
+}
+
+// Decrypts a message created by Seal
+func Open(key, sealed []byte, additional ...string) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrAuthentication
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(strings.Join(additional, "\x00")))
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns a short authentication string derived from the DTLS fingerprints of both peers.
+// The order of the fingerprints does not matter so both peers arrive at the same string.
+// If the users read out matching strings, no one has swapped the certificates in between
+func SAS(fingerprints ...string) string {
+	sorted := make([]string, len(fingerprints))
+	for i, fp := range fingerprints {
+		sorted[i] = strings.ToLower(strings.TrimSpace(fp))
+	}
+	sort.Strings(sorted)
+
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\x00")))
+	code := binary.BigEndian.Uint32(sum[:4]) % 1000000
+
+	return fmt.Sprintf("%03d %03d", code/1000, code%1000)
+}
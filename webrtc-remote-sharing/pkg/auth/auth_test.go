@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	signalingKey := make([]byte, keyLen)
+	if _, err := rand.Read(signalingKey); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(signalingKey, []byte("v=0"), "Offer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := Open(signalingKey, sealed, "Offer")
+	if err != nil || !bytes.Equal(plaintext, []byte("v=0")) {
+		t.Fatalf("Open() = %q, %v", plaintext, err)
+	}
+	if _, err := Open(signalingKey, sealed, "Answer"); err != ErrAuthentication {
+		t.Errorf("Open() with different additional data = %v, want %v", err, ErrAuthentication)
+	}
+
+	otherKey := append([]byte{}, signalingKey...)
+	otherKey[0] ^= 1
+	if _, err := Open(otherKey, sealed, "Offer"); err != ErrAuthentication {
+		t.Errorf("Open() with key of another join request = %v, want %v", err, ErrAuthentication)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := Open(signalingKey, sealed, "Offer"); err != ErrAuthentication {
+		t.Errorf("Open() of tampered message = %v, want %v", err, ErrAuthentication)
+	}
+}
+
+func TestSAS(t *testing.T) {
+	host := "sha-256 AA:BB:CC"
+	remote := "sha-256 11:22:33"
+
+	if SAS(host, remote) != SAS(remote, host) {
+		t.Errorf("SAS depends on the order of the fingerprints")
+	}
+	if SAS(host, remote) == SAS(host, "sha-256 11:22:34") {
+		t.Errorf("SAS does not change with the fingerprint")
+	}
+	if got := SAS(host, remote); len(got) != 7 {
+		t.Errorf("SAS() = %q, want 6 digits in two groups", got)
+	}
+}
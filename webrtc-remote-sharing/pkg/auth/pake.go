@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Passwords are checked with SPAKE2+ (RFC 9383) over P-256. The remote proves that it knows
+// the password without sending anything a relay could guess the password from offline: each
+// guess takes a join request. The host keeps a verifier which lets it check the remote but
+// not join other hosts with the same password. Both sides end up with a shared key which
+// seals the signaling of the session
+
+// Context of the exchange. It binds the keys to this protocol
+const pakeContext = "remygo join"
+
+// Length of each of the two password secrets before they are reduced to scalars
+const pakeSecretLen = 40
+
+var (
+	pakeCurve = elliptic.P256()
+
+	// Points of RFC 9383 for P-256 whose discrete logarithms nobody knows
+	pakeMX, pakeMY = mustPoint("02886e2f97ace46e55ba9dd7242579f2993b64e16ef3dcab95afd497333d8fa12f")
+	pakeNX, pakeNY = mustPoint("03d8bbd6c639c62937b04d997f38c3770719c629d7014d49a24b4f98baa1292b49")
+)
+
+var (
+	// Returned if the other side of the exchange used another password or sent an invalid share
+	ErrPassword = errors.New("password could not be confirmed")
+
+	errShare    = errors.New("invalid share of the password exchange")
+	errVerifier = errors.New("invalid password verifier")
+)
+
+func mustPoint(compressed string) (*big.Int, *big.Int) {
+	b, err := hex.DecodeString(compressed)
+	if err != nil {
+		panic(err)
+	}
+	x, y := elliptic.UnmarshalCompressed(pakeCurve, b)
+	if x == nil {
+		panic("point not on curve")
+	}
+	return x, y
+}
+
+// Secrets a remote derives from the password to join a host
+type Credential struct {
+	w0, w1 *big.Int
+}
+
+// Stretches the password into the secrets of the exchange. The salt binds them to a session
+// token or access id so that the same password yields different secrets for different hosts
+func DeriveCredential(password, salt string) (*Credential, error) {
+	b, err := scrypt.Key([]byte(password), []byte(salt), scryptN, scryptR, scryptP, 2*pakeSecretLen)
+	if err != nil {
+		return nil, err
+	}
+	n := pakeCurve.Params().N
+	return &Credential{
+		w0: new(big.Int).Mod(new(big.Int).SetBytes(b[:pakeSecretLen]), n),
+		w1: new(big.Int).Mod(new(big.Int).SetBytes(b[pakeSecretLen:]), n),
+	}, nil
+}
+
+// Returns the verifier the host keeps instead of the password
+func (c *Credential) Verifier() *Verifier {
+	lx, ly := pakeCurve.ScalarBaseMult(c.w1.Bytes())
+	return &Verifier{w0: c.w0, lx: lx, ly: ly}
+}
+
+// What a host stores to check the password. Reading it is not enough to join other hosts
+// with the password but still allows guessing the password offline like any password hash
+type Verifier struct {
+	w0     *big.Int
+	lx, ly *big.Int
+}
+
+// Encodes the verifier for the config
+func (v *Verifier) String() string {
+	b := append(scalarBytes(v.w0), elliptic.Marshal(pakeCurve, v.lx, v.ly)...)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Decodes a verifier encoded with String
+func ParseVerifier(s string) (*Verifier, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) <= scalarLen() {
+		return nil, errVerifier
+	}
+	lx, ly := elliptic.Unmarshal(pakeCurve, b[scalarLen():])
+	if lx == nil {
+		return nil, errVerifier
+	}
+	return &Verifier{w0: new(big.Int).SetBytes(b[:scalarLen()]), lx: lx, ly: ly}, nil
+}
+
+// Side of the remote in the exchange. The share is sent with the join request
+type Prover struct {
+	cred   *Credential
+	ids    []string
+	x      *big.Int
+	xx, xy *big.Int
+}
+
+// Starts the exchange of a remote. The ids, e.g. the token and the user id, are bound into the
+// keys so both sides have to use the same
+func NewProver(cred *Credential, ids ...string) (*Prover, error) {
+	x, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	// X = x*G + w0*M
+	xx, xy := pakeCurve.ScalarBaseMult(x.Bytes())
+	mx, my := pakeCurve.ScalarMult(pakeMX, pakeMY, cred.w0.Bytes())
+	xx, xy = pakeCurve.Add(xx, xy, mx, my)
+
+	return &Prover{cred: cred, ids: ids, x: x, xx: xx, xy: xy}, nil
+}
+
+// Returns the share of the remote
+func (p *Prover) Share() string {
+	return encodePoint(p.xx, p.xy)
+}
+
+// Checks the answer of the host and returns the confirmation for the host and the shared key.
+// ErrPassword is returned if the host does not know the password
+func (p *Prover) Finish(share, confirm string) (string, []byte, error) {
+	yx, yy, err := decodePoint(share)
+	if err != nil {
+		return "", nil, err
+	}
+	// Y - w0*N is y*G for the host which knows w0
+	nx, ny := pakeCurve.ScalarMult(pakeNX, pakeNY, p.cred.w0.Bytes())
+	tx, ty := pakeCurve.Add(yx, yy, nx, new(big.Int).Sub(pakeCurve.Params().P, ny))
+	if tx.Sign() == 0 && ty.Sign() == 0 {
+		return "", nil, errShare
+	}
+	zx, zy := pakeCurve.ScalarMult(tx, ty, p.x.Bytes())
+	vx, vy := pakeCurve.ScalarMult(tx, ty, p.cred.w1.Bytes())
+
+	k, err := deriveKeys(p.ids, p.cred.w0, p.xx, p.xy, yx, yy, zx, zy, vx, vy)
+	if err != nil {
+		return "", nil, err
+	}
+	if !hmac.Equal([]byte(confirm), []byte(k.confirm(k.confirmV, p.xx, p.xy))) {
+		return "", nil, ErrPassword
+	}
+	return k.confirm(k.confirmP, yx, yy), k.shared, nil
+}
+
+// Side of the host in the exchange. It answers the share of the remote with its own share and
+// a confirmation and checks the confirmation of the remote
+type Challenge struct {
+	yx, yy *big.Int
+	keys   *pakeKeys
+	xx, xy *big.Int
+}
+
+// Answers the share of a join request with the verifier of the password. The ids have to be
+// the ones of the remote
+func NewChallenge(v *Verifier, share string, ids ...string) (*Challenge, error) {
+	xx, xy, err := decodePoint(share)
+	if err != nil {
+		return nil, err
+	}
+	y, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	// Y = y*G + w0*N
+	yx, yy := pakeCurve.ScalarBaseMult(y.Bytes())
+	nx, ny := pakeCurve.ScalarMult(pakeNX, pakeNY, v.w0.Bytes())
+	yx, yy = pakeCurve.Add(yx, yy, nx, ny)
+
+	// X - w0*M is x*G for the remote which knows w0
+	mx, my := pakeCurve.ScalarMult(pakeMX, pakeMY, v.w0.Bytes())
+	tx, ty := pakeCurve.Add(xx, xy, mx, new(big.Int).Sub(pakeCurve.Params().P, my))
+	if tx.Sign() == 0 && ty.Sign() == 0 {
+		return nil, errShare
+	}
+	zx, zy := pakeCurve.ScalarMult(tx, ty, y.Bytes())
+	vx, vy := pakeCurve.ScalarMult(v.lx, v.ly, y.Bytes())
+
+	k, err := deriveKeys(ids, v.w0, xx, xy, yx, yy, zx, zy, vx, vy)
+	if err != nil {
+		return nil, err
+	}
+	return &Challenge{yx: yx, yy: yy, keys: k, xx: xx, xy: xy}, nil
+}
+
+// Returns the share of the host
+func (c *Challenge) Share() string {
+	return encodePoint(c.yx, c.yy)
+}
+
+// Returns the confirmation of the host which proves the remote that the host knows the verifier
+func (c *Challenge) Confirm() string {
+	return c.keys.confirm(c.keys.confirmV, c.xx, c.xy)
+}
+
+// Checks the confirmation of the remote and returns the shared key. ErrPassword is returned if
+// the remote used another password
+func (c *Challenge) Check(confirm string) ([]byte, error) {
+	if !hmac.Equal([]byte(confirm), []byte(c.keys.confirm(c.keys.confirmP, c.yx, c.yy))) {
+		return nil, ErrPassword
+	}
+	return c.keys.shared, nil
+}
+
+type pakeKeys struct {
+	confirmP, confirmV []byte
+	shared             []byte
+}
+
+// Derives the keys from the transcript of the exchange
+func deriveKeys(ids []string, w0 *big.Int, points ...*big.Int) (*pakeKeys, error) {
+	h := sha256.New()
+	writeField := func(b []byte) {
+		var n [8]byte
+		binary.LittleEndian.PutUint64(n[:], uint64(len(b)))
+		h.Write(n[:])
+		h.Write(b)
+	}
+	writeField([]byte(pakeContext))
+	for _, id := range ids {
+		writeField([]byte(id))
+	}
+	writeField(elliptic.Marshal(pakeCurve, pakeMX, pakeMY))
+	writeField(elliptic.Marshal(pakeCurve, pakeNX, pakeNY))
+	for i := 0; i+1 < len(points); i += 2 {
+		writeField(elliptic.Marshal(pakeCurve, points[i], points[i+1]))
+	}
+	writeField(scalarBytes(w0))
+	main := h.Sum(nil)
+
+	confirmation := make([]byte, 2*sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, main, nil, []byte("ConfirmationKeys")), confirmation); err != nil {
+		return nil, err
+	}
+	shared := make([]byte, keyLen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, main, nil, []byte("SharedKey")), shared); err != nil {
+		return nil, err
+	}
+	return &pakeKeys{confirmP: confirmation[:sha256.Size], confirmV: confirmation[sha256.Size:], shared: shared}, nil
+}
+
+// Returns the base64 encoded MAC over the share of the other side
+func (k *pakeKeys) confirm(key []byte, x, y *big.Int) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(elliptic.Marshal(pakeCurve, x, y))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomScalar() (*big.Int, error) {
+	n := pakeCurve.Params().N
+	for {
+		k, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return nil, err
+		}
+		if k.Sign() != 0 {
+			return k, nil
+		}
+	}
+}
+
+func scalarLen() int {
+	return (pakeCurve.Params().BitSize + 7) / 8
+}
+
+func scalarBytes(k *big.Int) []byte {
+	return k.FillBytes(make([]byte, scalarLen()))
+}
+
+func encodePoint(x, y *big.Int) string {
+	return base64.StdEncoding.EncodeToString(elliptic.Marshal(pakeCurve, x, y))
+}
+
+// Decodes a share. Points which are not on the curve are rejected
+func decodePoint(s string) (*big.Int, *big.Int, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, nil, errShare
+	}
+	x, y := elliptic.Unmarshal(pakeCurve, b)
+	if x == nil {
+		return nil, nil, errShare
+	}
+	return x, y, nil
+}
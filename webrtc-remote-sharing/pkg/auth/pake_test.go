@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Runs the exchange of a remote with the password against a host with the verifier
+func exchange(t *testing.T, password string, v *Verifier) (hostKey, remoteKey []byte, hostErr, remoteErr error) {
+	cred, err := DeriveCredential(password, "access-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prover, err := NewProver(cred, "access-id", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := NewChallenge(v, prover.Share(), "access-id", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirm, remoteKey, remoteErr := prover.Finish(challenge.Share(), challenge.Confirm())
+	hostKey, hostErr = challenge.Check(confirm)
+	return hostKey, remoteKey, hostErr, remoteErr
+}
+
+func TestPasswordExchange(t *testing.T) {
+	cred, err := DeriveCredential("correct horse", "access-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The host only keeps the encoded verifier
+	v, err := ParseVerifier(cred.Verifier().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostKey, remoteKey, hostErr, remoteErr := exchange(t, "correct horse", v)
+	if hostErr != nil || remoteErr != nil {
+		t.Fatalf("exchange with the password: host %v, remote %v", hostErr, remoteErr)
+	}
+	if len(hostKey) != keyLen || !bytes.Equal(hostKey, remoteKey) {
+		t.Fatalf("shared keys differ: %x, %x", hostKey, remoteKey)
+	}
+
+	// Neither side confirms a wrong password
+	_, _, hostErr, remoteErr = exchange(t, "wrong horse", v)
+	if hostErr != ErrPassword || remoteErr != ErrPassword {
+		t.Fatalf("exchange with a wrong password: host %v, remote %v", hostErr, remoteErr)
+	}
+
+	// The keys are bound to the ids of the request
+	prover, _ := NewProver(cred, "access-id", "alice")
+	challenge, err := NewChallenge(v, prover.Share(), "access-id", "mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := prover.Finish(challenge.Share(), challenge.Confirm()); err != ErrPassword {
+		t.Fatalf("exchange with other ids: %v", err)
+	}
+}
+
+func TestInvalidShare(t *testing.T) {
+	cred, err := DeriveCredential("correct horse", "access-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, share := range []string{"", "not base64", "AAAA", cred.Verifier().String()} {
+		if _, err := NewChallenge(cred.Verifier(), share); err == nil {
+			t.Errorf("challenge accepted share %q", share)
+		}
+	}
+	if _, err := ParseVerifier("AAAA"); err == nil {
+		t.Error("parsed a truncated verifier")
+	}
+}
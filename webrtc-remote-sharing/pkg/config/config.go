@@ -0,0 +1,291 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/remygo/pkg/auth"
+	"github.com/remygo/pkg/policy"
+
+	"github.com/google/uuid"
+)
+
+const fileName = "config.json"
+
+// Persistent client configuration. It is stored as JSON at the path given with
+// the '-config' flag or in the user config directory if no path is provided
+type Config struct {
+	DeviceID    string        `json:"deviceID,omitempty"`    // Stable id of the device reported to the signaling server and to remotes
+	AccessID    string        `json:"accessID,omitempty"`    // Stable device bound id used as the session token in unattended mode
+	AccessIDKey string        `json:"accessIDKey,omitempty"` // Private key the access id is derived from. The host proves it to the signaling server
+	Unattended  Unattended    `json:"unattended"`
+	Permissions Permissions   `json:"permissions"`
+	Limits      policy.Limits `json:"limits"` // Limits the host enforces on its sessions in addition to the signaling server
+	Media       Media         `json:"media"`
+	Recording   Recording     `json:"recording"`
+	Upload      Upload        `json:"upload"`
+	Transfer    Transfer      `json:"transfer"`
+	path        string
+}
+
+// Names of the registered media backends. Empty names select the GStreamer pipelines and the
+// Gio window. The test pattern capture and the null decoder and window need neither
+type Media struct {
+	Capture string   `json:"capture,omitempty"` // "gstreamer" or "testpattern"
+	Decoder string   `json:"decoder,omitempty"` // "gstreamer" or "null"
+	Window  string   `json:"window,omitempty"`  // "gio" or "null"
+	Source  string   `json:"source,omitempty"`  // Screen source of the GStreamer capture: "gdi", "x11", "pipewire" or "test". Empty detects it
+	Codecs  []string `json:"codecs,omitempty"`  // Video codecs in the order of preference e.g. "video/VP9". Empty prefers H264, VP8, VP9 and AV1 in this order
+
+	// Asked for by the remote. The size of the video follows the window of the remote
+	FrameRate int    `json:"frameRate,omitempty"` // Frames per second up to 30. Empty is 10
+	Quality   string `json:"quality,omitempty"`   // "low", "medium" or "high". Empty is medium
+
+	// Bounds of the bitrate the host adapts to the estimated bandwidth in kbit/s
+	MinBitrate int `json:"minBitrate,omitempty"` // Empty is 150
+	MaxBitrate int `json:"maxBitrate,omitempty"` // Empty is the bitrate of the quality
+
+	Recovery Recovery `json:"recovery,omitempty"`
+	Audio    Audio    `json:"audio"`
+	Voice    Voice    `json:"voice"`
+}
+
+// Sound of the host streamed to the remote. The remote plays it if the host sends it
+type Audio struct {
+	Enabled bool   `json:"enabled"`           // Captures the sound of the host
+	Backend string `json:"backend,omitempty"` // "gstreamer" or "null"
+	Source  string `json:"source,omitempty"`  // Sound server device to capture or "test". Empty is the monitor of the default output
+	Muted   bool   `json:"muted,omitempty"`   // Starts muted on either side
+}
+
+// Voice chat between host and remote. The microphone is on while the talk button is held
+// unless it is always on. The audio backend captures and plays the voice
+type Voice struct {
+	AlwaysOn   bool   `json:"alwaysOn,omitempty"`
+	Source     string `json:"source,omitempty"`     // Sound server device of the microphone or "test". Empty is the default input
+	Volume     int    `json:"volume,omitempty"`     // Percent of the volume of the voice of the other user up to 100. Empty is 100
+	Muted      bool   `json:"muted,omitempty"`      // Mutes the voice of the other user
+	Unattended bool   `json:"unattended,omitempty"` // Uses the microphone on headless and unattended hosts too
+}
+
+// Recordings of sessions. Either user records once the other one consented
+type Recording struct {
+	Dir         string `json:"dir,omitempty"`         // Directory of the recordings. Empty is the recordings directory next to the config
+	AutoConsent bool   `json:"autoConsent,omitempty"` // Consents to recordings of the other user without asking e.g. on unattended hosts
+}
+
+// Uploads of the recordings and received files into a blob provider of the organization.
+// Interrupted uploads continue when the client starts again
+type Upload struct {
+	Provider string `json:"provider,omitempty"` // Id of the blob provider. Empty keeps the recordings on the device
+	PartSize int    `json:"partSize,omitempty"` // MiB uploaded at once, at least 5. Empty is 8
+}
+
+// Files the remote sends to the host if the host granted the file transfer
+type Transfer struct {
+	Dir     string `json:"dir,omitempty"`     // Directory of the received files. Empty is the transfers directory next to the config
+	MaxSize int    `json:"maxSize,omitempty"` // MiB accepted per file. Empty is 100
+}
+
+// Recovery of lost video packets. The zero value retransmits the lost packets on NACKs
+type Recovery struct {
+	DisableNACK  bool `json:"disableNack,omitempty"`
+	NACKBuffer   int  `json:"nackBuffer,omitempty"`   // Packets the host keeps for retransmission. A power of two up to 32768, empty is 1024
+	NACKInterval int  `json:"nackInterval,omitempty"` // Milliseconds between the NACKs of the remote for missing packets. Empty is 100
+	RTX          bool `json:"rtx,omitempty"`          // Retransmits on a separate repair stream
+	FECGroup     int  `json:"fecGroup,omitempty"`     // Packets protected by one FEC packet, up to 15. Empty sends no FEC
+}
+
+// Unattended access settings. Join requests which prove the access password or come from an
+// allowed device are accepted without prompting the host. The user ids of join requests are
+// not authenticated, so they only narrow down the allowed devices
+type Unattended struct {
+	Enabled        bool     `json:"enabled"`
+	AccessVerifier string   `json:"accessVerifier,omitempty"` // Verifier of the access password salted with the access id. It cannot prove the password
+	AllowedDevices []string `json:"allowedDevices,omitempty"` // DTLS fingerprints of the certificates of remote devices allowed without a password
+	AllowedUsers   []string `json:"allowedUsers,omitempty"`   // User ids the allowed devices are restricted to. Empty allows any user
+}
+
+// Permission profiles granted to remotes. The most specific rule wins i.e. a profile
+// configured for the user is used before the one configured for the organization
+type Permissions struct {
+	Default string            `json:"default,omitempty"` // Profile picked by the host for everyone else
+	Users   map[string]string `json:"users,omitempty"`   // Profile by user id
+	Orgs    map[string]string `json:"orgs,omitempty"`    // Profile by organization id
+}
+
+// Returns the default location of the config file
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "remygo", fileName)
+}
+
+// Reads the config from path. A missing file is not an error and results in an empty config
+// which will be created on the first call to Save
+func Load(path string) (*Config, error) {
+	if path == "" {
+		path = DefaultPath()
+	}
+	cfg := &Config{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("reading config %s: %v", path, err)
+	}
+	if err = json.Unmarshal(data, cfg); err != nil {
+		return cfg, fmt.Errorf("parsing config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Writes the config back to the path it was loaded from
+func (c *Config) Save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The file holds the verifier of the access password so keep it private to the user
+	return os.WriteFile(c.path, data, 0o600)
+}
+
+// Returns the directory of the config file. Other persistent state is stored alongside it
+func (c *Config) Dir() string {
+	return filepath.Dir(c.path)
+}
+
+// Returns the id of the device, generating and persisting one on first use. It is kept
+// apart from the access id since remotes learn it while the access id is the unattended token
+func (c *Config) EnsureDeviceID() (string, error) {
+	if c.DeviceID != "" {
+		return c.DeviceID, nil
+	}
+	c.DeviceID = uuid.New().String()
+
+	return c.DeviceID, c.Save()
+}
+
+// Returns the access id of the device, generating and persisting one with its key on first use
+func (c *Config) EnsureAccessID() (string, error) {
+	if c.AccessID != "" && c.AccessIDKey != "" {
+		return c.AccessID, nil
+	}
+	key, err := auth.NewAccessKey()
+	if err != nil {
+		return "", err
+	}
+	id, _, _, err := auth.ClaimAccessID(key)
+	if err != nil {
+		return "", err
+	}
+	c.AccessID, c.AccessIDKey = id, key
+
+	return c.AccessID, c.Save()
+}
+
+// Returns the access id with the public key and signature the signaling server checks it by
+func (c *Config) ClaimAccessID() (id, public, signature string, err error) {
+	if _, err := c.EnsureAccessID(); err != nil {
+		return "", "", "", err
+	}
+	return auth.ClaimAccessID(c.AccessIDKey)
+}
+
+// Stores the verifier of the unattended access password. The password itself is not kept
+func (c *Config) SetAccessPassword(password string) error {
+	accessID, err := c.EnsureAccessID()
+	if err != nil {
+		return err
+	}
+	cred, err := auth.DeriveCredential(password, accessID)
+	if err != nil {
+		return err
+	}
+	c.Unattended.AccessVerifier = cred.Verifier().String()
+
+	return c.Save()
+}
+
+// Returns the verifier of the access password or nil if no access password is set
+func (u *Unattended) Verifier() *auth.Verifier {
+	v, err := auth.ParseVerifier(u.AccessVerifier)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// Returns the volume of the voice of the other user in percent
+func (v *Voice) VolumePercent() int {
+	if v.Volume < 1 || v.Volume > 100 {
+		return 100
+	}
+	return v.Volume
+}
+
+// Reports whether the device may join without a password. The device proves its certificate
+// in the DTLS handshake, the user id is only compared since anyone can claim it
+func (u *Unattended) Allowed(fingerprint, userID string) bool {
+	if fingerprint == "" || !contains(u.AllowedDevices, strings.ToLower(fingerprint)) {
+		return false
+	}
+	return len(u.AllowedUsers) == 0 || userID != "" && contains(u.AllowedUsers, userID)
+}
+
+// Adds the DTLS fingerprint of a remote device to the allowlist
+func (c *Config) AllowDevice(fingerprint string) error {
+	fingerprint = strings.ToLower(strings.TrimSpace(fingerprint))
+	if !strings.HasPrefix(fingerprint, "sha-256 ") {
+		return fmt.Errorf("fingerprint %q is not of the form 'sha-256 ab:cd:...'", fingerprint)
+	}
+	if !contains(c.Unattended.AllowedDevices, fingerprint) {
+		c.Unattended.AllowedDevices = append(c.Unattended.AllowedDevices, fingerprint)
+	}
+	return c.Save()
+}
+
+// Removes the DTLS fingerprint of a remote device from the allowlist
+func (c *Config) RevokeDevice(fingerprint string) error {
+	fingerprint = strings.ToLower(strings.TrimSpace(fingerprint))
+	devices := c.Unattended.AllowedDevices[:0]
+	for _, d := range c.Unattended.AllowedDevices {
+		if d != fingerprint {
+			devices = append(devices, d)
+		}
+	}
+	c.Unattended.AllowedDevices = devices
+	return c.Save()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the name of the profile for the user, falling back to the organization
+// and then to the default profile. An empty string means no profile is configured
+func (p *Permissions) Profile(userID, orgID string) string {
+	if profile, ok := p.Users[userID]; ok && userID != "" {
+		return profile
+	}
+	if profile, ok := p.Orgs[orgID]; ok && orgID != "" {
+		return profile
+	}
+	return p.Default
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/remygo/pkg/auth"
+)
+
+func TestAllowedDevices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const device = "sha-256 ab:cd:ef"
+
+	if err = c.AllowDevice("SHA-256 AB:CD:EF"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AllowDevice("ab:cd"); err == nil {
+		t.Error("allowed a fingerprint without algorithm")
+	}
+	if !c.Unattended.Allowed(device, "") {
+		t.Error("allowed device denied")
+	}
+
+	// Claimed user ids alone never allow a device in
+	c.Unattended.AllowedUsers = []string{"alice"}
+	if c.Unattended.Allowed("", "alice") || c.Unattended.Allowed("sha-256 00:11", "alice") {
+		t.Error("allowed user on an unknown device")
+	}
+	if c.Unattended.Allowed(device, "mallory") || !c.Unattended.Allowed(device, "alice") {
+		t.Error("allowed users do not narrow the allowed devices down")
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Unattended.AllowedDevices) != 1 || loaded.Unattended.AllowedDevices[0] != device {
+		t.Fatalf("saved devices %v", loaded.Unattended.AllowedDevices)
+	}
+	if err = loaded.RevokeDevice(device); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Unattended.Allowed(device, "") {
+		t.Error("revoked device allowed")
+	}
+}
+
+func TestAccessPassword(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Unattended.Verifier() != nil {
+		t.Fatal("verifier without an access password")
+	}
+	if err = c.SetAccessPassword("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AccessID == "" || loaded.Unattended.Verifier() == nil {
+		t.Fatalf("saved access id %q and verifier %q", loaded.AccessID, loaded.Unattended.AccessVerifier)
+	}
+
+	// The access id is bound to the saved key
+	id, key, signature, err := loaded.ClaimAccessID()
+	if err != nil || id != c.AccessID {
+		t.Fatalf("claimed access id %q, %v, want %q", id, err, c.AccessID)
+	}
+	if err := auth.VerifyAccessID(id, key, signature); err != nil {
+		t.Fatal(err)
+	}
+}
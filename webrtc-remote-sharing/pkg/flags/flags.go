@@ -48,13 +48,17 @@ func validateCodec(cfg *app.Args) error {
 	// 	log.Println("[INFO] Host peer will choose the codec")
 	// 	return nil
 	// }
+	// The codec is negotiated with the other peer. The flag only makes it the preferred one
 	switch cfg.Codec {
+	case "":
 	case webrtc.MimeTypeH264:
-		log.Println("[INFO] Using H264 codec")
+		log.Println("[INFO] Preferring H264 codec")
 	case webrtc.MimeTypeVP8:
-		log.Println("[INFO] Using VP8 codec")
+		log.Println("[INFO] Preferring VP8 codec")
 	case webrtc.MimeTypeVP9:
-		log.Println("[INFO] Using VP9 codec")
+		log.Println("[INFO] Preferring VP9 codec")
+	case webrtc.MimeTypeAV1:
+		log.Println("[INFO] Preferring AV1 codec")
 	default:
 		return fmt.Errorf("[ERR] Unsupported codec: %s", cfg.Codec)
 	}
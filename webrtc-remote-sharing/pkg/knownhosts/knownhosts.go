@@ -0,0 +1,130 @@
+package knownhosts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outcome of verifying the fingerprint of a host
+type Result uint8
+
+const (
+	New     Result = iota // First connection to the host. Its fingerprint has been pinned
+	Match                 // The fingerprint matches the pinned one
+	Changed               // The fingerprint differs from the pinned one
+)
+
+func (r Result) String() string {
+	switch r {
+	case New:
+		return "New"
+	case Match:
+		return "Match"
+	case Changed:
+		return "Changed"
+	default:
+		return "Unsupported"
+	}
+}
+
+// Pinned DTLS fingerprint of a host
+type Host struct {
+	Fingerprint string    `json:"fingerprint"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+// Trust-on-first-use store of host fingerprints keyed by the access id the remote dialed. Ids the
+// host claims itself could be chosen freely by anyone in the middle to be treated as a first
+// connection. One-off tokens of attended hosts are never dialed twice and are not pinned. A
+// changed fingerprint is never pinned automatically. The entry has to be removed from the file
+// to trust the host again
+type Store struct {
+	Hosts map[string]Host `json:"hosts"`
+	path  string
+	mu    sync.Mutex
+}
+
+// Reads the store from path. A missing file results in an empty store
+func Load(path string) (*Store, error) {
+	s := &Store{Hosts: make(map[string]Host), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("reading known hosts %s: %v", path, err)
+	}
+	if err = json.Unmarshal(data, s); err != nil {
+		return s, fmt.Errorf("parsing known hosts %s: %v", path, err)
+	}
+	if s.Hosts == nil {
+		s.Hosts = make(map[string]Host)
+	}
+	return s, nil
+}
+
+// Checks the fingerprint presented by the host dialed by the token against the pinned one. The
+// fingerprint of a new host is pinned. The previously pinned host is returned if it changed
+func (s *Store) Verify(token, fingerprint string, now time.Time) (Result, Host, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host, ok := s.Hosts[token]
+	switch {
+	case !ok:
+		s.Hosts[token] = Host{Fingerprint: fingerprint, FirstSeen: now, LastSeen: now}
+		return New, Host{}, s.save()
+	case host.Fingerprint != fingerprint:
+		return Changed, host, nil
+	default:
+		host.LastSeen = now
+		s.Hosts[token] = host
+		return Match, host, s.save()
+	}
+}
+
+// Reports whether a fingerprint is pinned for the token
+func (s *Store) Known(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.Hosts[token]
+	return ok
+}
+
+// Removes the hosts which were seen once and not since before, e.g. one-off tokens pinned by
+// versions which pinned every token. Returns the number of removed hosts
+func (s *Store) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pruned int
+	for token, host := range s.Hosts {
+		if host.FirstSeen.Equal(host.LastSeen) && host.LastSeen.Before(before) {
+			delete(s.Hosts, token)
+			pruned++
+		}
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+	return pruned, s.save()
+}
+
+func (s *Store) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o600)
+}
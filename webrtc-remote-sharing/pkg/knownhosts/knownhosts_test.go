@@ -0,0 +1,127 @@
+package knownhosts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remygo", "known_hosts.json")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	later := first.Add(time.Hour)
+
+	result, _, err := s.Verify("access-id", "sha-256 aa", first)
+	if err != nil || result != New {
+		t.Fatalf("first connection: got %s, %v", result, err)
+	}
+	result, pinned, err := s.Verify("access-id", "sha-256 aa", later)
+	if err != nil || result != Match || !pinned.FirstSeen.Equal(first) {
+		t.Fatalf("same fingerprint: got %s, %+v, %v", result, pinned, err)
+	}
+
+	// A changed fingerprint keeps the pinned one
+	result, pinned, err = s.Verify("access-id", "sha-256 bb", later)
+	if err != nil || result != Changed || pinned.Fingerprint != "sha-256 aa" {
+		t.Fatalf("changed fingerprint: got %s, %+v, %v", result, pinned, err)
+	}
+	if result, _, _ = s.Verify("access-id", "sha-256 bb", later); result != Changed {
+		t.Fatalf("changed fingerprint was pinned: got %s", result)
+	}
+
+	// Tokens are pinned apart
+	if result, _, _ = s.Verify("other-id", "sha-256 bb", later); result != New {
+		t.Fatalf("other token: got %s", result)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts.json")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if _, _, err = s.Verify("access-id", "sha-256 aa", now); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode %v, want 0600", info.Mode().Perm())
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, ok := loaded.Hosts["access-id"]
+	if !ok || host.Fingerprint != "sha-256 aa" || !host.LastSeen.Equal(now) {
+		t.Fatalf("loaded %+v", loaded.Hosts)
+	}
+	if result, _, _ := loaded.Verify("access-id", "sha-256 bb", now); result != Changed {
+		t.Fatalf("changed fingerprint after reload: got %s", result)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts.json")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cutoff := first.AddDate(0, 0, 30)
+
+	tests := []struct {
+		token  string
+		seen   []time.Time
+		pruned bool
+	}{
+		{"one-off", []time.Time{first}, true},
+		{"access-id", []time.Time{first, first.Add(time.Hour)}, false},
+		{"recent", []time.Time{cutoff.Add(time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		for _, seen := range tt.seen {
+			if _, _, err = s.Verify(tt.token, "sha-256 aa", seen); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if n, err := s.Prune(cutoff); err != nil || n != 1 {
+		t.Fatalf("Prune() = %d, %v, want 1", n, err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if loaded.Known(tt.token) == tt.pruned {
+			t.Errorf("%s: known %v after pruning", tt.token, loaded.Known(tt.token))
+		}
+	}
+}
+
+func TestLoadMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err == nil {
+		t.Fatal("loaded malformed file")
+	}
+	// The store stays usable
+	if result, _, _ := s.Verify("access-id", "sha-256 aa", time.Now()); result != New {
+		t.Fatalf("got %s", result)
+	}
+}
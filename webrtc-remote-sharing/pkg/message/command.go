@@ -2,7 +2,6 @@ package message
 
 import (
 	"encoding/json"
-	"log"
 )
 
 type commandType uint8
@@ -17,12 +16,12 @@ type CommandMessage struct {
 	// Token string `json:"token"`
 }
 
-func NewCommand(t commandType) *Message {
+func NewCommand(t commandType) (*Message, error) {
 	cmd, err := json.Marshal(&CommandMessage{Type: t})
 	if err != nil {
-		log.Panicf("error marshalling message. %v", err)
+		return nil, &EncodeError{Type: Command, Err: err}
 	}
-	return &Message{Type: Command, Data: cmd}
+	return &Message{Type: Command, Data: cmd}, nil
 }
 
 func (c CommandMessage) String() string {
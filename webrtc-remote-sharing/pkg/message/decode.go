@@ -0,0 +1,92 @@
+package message
+
+import (
+	"encoding/json"
+)
+
+// Payload of a websocket message i.e. one of *SessionMessage, *SignalMessage,
+// *CommandMessage or *InfoMessage
+type Payload interface {
+	String() string
+}
+
+// Decodes the data of the message into the payload its type calls for. Messages of an
+// unknown type or variant and malformed data are reported with a *DecodeError
+func Decode(m *Message) (Payload, error) {
+	var (
+		payload Payload
+		valid   func() bool
+	)
+	switch m.Type {
+	case Session:
+		msg := &SessionMessage{}
+		payload, valid = msg, func() bool { return msg.Type <= JoinConfirm }
+	case Signal:
+		msg := &SignalMessage{}
+		payload, valid = msg, func() bool { return msg.Type <= Answer }
+	case Command:
+		msg := &CommandMessage{}
+		payload, valid = msg, func() bool { return msg.Type <= TerminateSession }
+	case Info:
+		msg := &InfoMessage{}
+		payload, valid = msg, func() bool { return msg.Type <= Warning }
+	default:
+		return nil, &DecodeError{Type: m.Type, Err: ErrUnsupported}
+	}
+
+	if err := json.Unmarshal(m.Data, payload); err != nil {
+		return nil, &DecodeError{Type: m.Type, Err: err}
+	}
+	if !valid() {
+		return nil, &DecodeError{Type: m.Type, Err: ErrUnsupported}
+	}
+	return payload, nil
+}
+
+// Decodes a 'session' message
+func DecodeSession(m *Message) (*SessionMessage, error) {
+	if m.Type != Session {
+		return nil, &DecodeError{Type: m.Type, Err: ErrWrongType}
+	}
+	payload, err := Decode(m)
+	if err != nil {
+		return nil, err
+	}
+	return payload.(*SessionMessage), nil
+}
+
+// Decodes a 'signal' message
+func DecodeSignal(m *Message) (*SignalMessage, error) {
+	if m.Type != Signal {
+		return nil, &DecodeError{Type: m.Type, Err: ErrWrongType}
+	}
+	payload, err := Decode(m)
+	if err != nil {
+		return nil, err
+	}
+	return payload.(*SignalMessage), nil
+}
+
+// Decodes a 'command' message
+func DecodeCommand(m *Message) (*CommandMessage, error) {
+	if m.Type != Command {
+		return nil, &DecodeError{Type: m.Type, Err: ErrWrongType}
+	}
+	payload, err := Decode(m)
+	if err != nil {
+		return nil, err
+	}
+	return payload.(*CommandMessage), nil
+}
+
+// Decodes an 'info' message
+func DecodeInfo(m *Message) (*InfoMessage, error) {
+	if m.Type != Info {
+		return nil, &DecodeError{Type: m.Type, Err: ErrWrongType}
+	}
+	payload, err := Decode(m)
+	if err != nil {
+		return nil, err
+	}
+	return payload.(*InfoMessage), nil
+}
@@ -0,0 +1,69 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	m, err := NewJoinRequest("token", "user", "org", "share", "sha-256 ab:cd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := Decode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, ok := payload.(*SessionMessage)
+	if !ok {
+		t.Fatalf("got payload %T, want *SessionMessage", payload)
+	}
+	if session.Type != JoinRequest || session.Token != "token" || session.Share != "share" || session.Device != "sha-256 ab:cd" {
+		t.Errorf("got %+v", session)
+	}
+
+	m, err = NewJoinConfirm("token", "proof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session, err := DecodeSession(m); err != nil || session.Type != JoinConfirm || session.Proof != "proof" {
+		t.Errorf("got %+v, %v", session, err)
+	}
+
+	if _, err := DecodeSignal(m); !errors.Is(err, ErrWrongType) {
+		t.Errorf("decoding a session message as signal: got %v, want %v", err, ErrWrongType)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		err  error
+	}{
+		{"unknown type", Message{Type: 42, Data: json.RawMessage(`{}`)}, ErrUnsupported},
+		{"unknown variant", Message{Type: Signal, Data: json.RawMessage(`{"event":9}`)}, ErrUnsupported},
+		{"malformed data", Message{Type: Info, Data: json.RawMessage(`{"event":"token"}`)}, nil},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(&tt.msg)
+
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("%s: got %v, want a *DecodeError", tt.name, err)
+			continue
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestNewInfoRegisterArgs(t *testing.T) {
+	if _, err := NewInfo(Register, ""); !errors.Is(err, ErrRegisterArgs) {
+		t.Errorf("got %v, want %v", err, ErrRegisterArgs)
+	}
+}
@@ -0,0 +1,40 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnsupported  = errors.New("unsupported message type")
+	ErrWrongType    = errors.New("message is of a different type")
+	ErrRegisterArgs = errors.New("register message requires a userID and deviceID")
+)
+
+// Returned when a payload cannot be encoded into a message
+type EncodeError struct {
+	Type Type
+	Err  error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("encoding %s message: %v", e.Type, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when a message cannot be decoded into its payload
+type DecodeError struct {
+	Type Type
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %s message: %v", e.Type, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -2,7 +2,6 @@ package message
 
 import (
 	"encoding/json"
-	"log"
 )
 
 type infoType uint8
@@ -13,35 +12,67 @@ const (
 	Renew
 	Ack
 	Error
+	Activity // Host reports input or frame changes so the signaling server can apply idle timeouts
+	Warning  // Signaling server warns the peers before ending a session
 )
 
 type InfoMessage struct {
-	Type     infoType `json:"event"`
-	Data     string   `json:"data"`
-	UserID   string   `json:"userID,omitempty"`
-	DeviceID string   `json:"deviceID,omitempty"`
+	Type       infoType `json:"event"`
+	Data       string   `json:"data"`
+	UserID     string   `json:"userID,omitempty"`
+	DeviceID   string   `json:"deviceID,omitempty"`
+	OrgID      string   `json:"orgID,omitempty"`
+	Unattended bool     `json:"unattended,omitempty"` // Register with a stable access id which survives token renewal
+	Key        string   `json:"key,omitempty"`        // Public key the access id of an unattended host is derived from
+	Signature  string   `json:"signature,omitempty"`  // Signature of the access id with the key
 }
 
 // Returns a new message of the 'Info' type. Info messages are used to communicate
 // auxiliary information to and from the signaling server. In case of the 'Register'
-// message, the first argument is the userID and the second argument is the deviceID.
-func NewInfo(t infoType, data string, args ...string) *Message {
+// message, the first argument is the userID, the second argument is the deviceID and
+// the optional third argument is the organization id of the user.
+func NewInfo(t infoType, data string, args ...string) (*Message, error) {
 	if t == Register {
-		if len(args) < 2 || len(args) > 2 {
-			log.Panicf("register message requires a userID and deviceID")
+		if len(args) < 2 || len(args) > 3 {
+			return nil, &EncodeError{Type: Info, Err: ErrRegisterArgs}
 		}
-		registerMsg, err := json.Marshal(&InfoMessage{Type: t, Data: data, UserID: args[0], DeviceID: args[1]})
+		var orgID string
+		if len(args) == 3 {
+			orgID = args[2]
+		}
+		registerMsg, err := json.Marshal(&InfoMessage{Type: t, Data: data, UserID: args[0], DeviceID: args[1], OrgID: orgID})
 		if err != nil {
-			log.Panicf("error marshalling info message. %v", err)
+			return nil, &EncodeError{Type: Info, Err: err}
 		}
-		return &Message{Type: Info, Data: registerMsg}
+		return &Message{Type: Info, Data: registerMsg}, nil
 	}
 
 	msg, err := json.Marshal(&InfoMessage{Type: t, Data: data})
 	if err != nil {
-		log.Panicf("error marshalling message. %v", err)
+		return nil, &EncodeError{Type: Info, Err: err}
+	}
+	return &Message{Type: Info, Data: msg}, nil
+}
+
+// Returns a 'Register' message for a host in unattended mode. The access id is used as
+// the session token and is kept by the signaling server when the session ends
+func NewUnattendedRegister(accessID, key, signature, userID, deviceID, orgID string) (*Message, error) {
+	msg, err := json.Marshal(&InfoMessage{Type: Register, Data: accessID, UserID: userID, DeviceID: deviceID,
+		OrgID: orgID, Unattended: true, Key: key, Signature: signature})
+	if err != nil {
+		return nil, &EncodeError{Type: Info, Err: err}
+	}
+	return &Message{Type: Info, Data: msg}, nil
+}
+
+// Returns the acknowledgement of an allowed join request. It tells the remote whether it dialed
+// the access id of an unattended host, which is the only token worth remembering the host by
+func NewAck(data string, unattended bool) (*Message, error) {
+	msg, err := json.Marshal(&InfoMessage{Type: Ack, Data: data, Unattended: unattended})
+	if err != nil {
+		return nil, &EncodeError{Type: Info, Err: err}
 	}
-	return &Message{Type: Info, Data: msg}
+	return &Message{Type: Info, Data: msg}, nil
 }
 
 func (i InfoMessage) String() string {
@@ -56,6 +87,10 @@ func (i InfoMessage) String() string {
 		return "Ack"
 	case Renew:
 		return "Renew"
+	case Activity:
+		return "Activity"
+	case Warning:
+		return "Warning"
 	default:
 		return Unsupported
 	}
@@ -73,6 +108,10 @@ func (i infoType) String() string {
 		return "Error"
 	case Renew:
 		return "Renew"
+	case Activity:
+		return "Activity"
+	case Warning:
+		return "Warning"
 	default:
 		return Unsupported
 	}
@@ -2,7 +2,6 @@ package message
 
 import (
 	"encoding/json"
-	"log"
 )
 
 type sessionType uint8
@@ -19,6 +18,8 @@ const (
 	JoinRequest sessionType = iota
 	JoinResponse
 	Leave
+	JoinChallenge // Answer of the host to the password exchange of a join request
+	JoinConfirm   // Confirmation of the password exchange by the remote
 )
 
 // Underlying message type for the 'session' websocket message
@@ -26,39 +27,80 @@ type SessionMessage struct {
 	Type     sessionType `json:"event"` // Type of session message
 	Token    string      `json:"token"` // Token of the session
 	Response JoinAnswer  `json:"response,omitempty"`
+	UserID   string      `json:"userID,omitempty"` // Id of the user requesting to join. Set by the signaling server as the user claims it
+	OrgID    string      `json:"orgID,omitempty"`  // Organization of the user requesting to join. Set by the signaling server
+	Share    string      `json:"share,omitempty"`  // Share of the password exchange of the join request or challenge
+	Proof    string      `json:"proof,omitempty"`  // Confirmation of the password exchange
+	Device   string      `json:"device,omitempty"` // DTLS fingerprint of the device certificate of the remote
 }
 
 // Returns a new 'session' message wrapped in a Message struct
-func NewSession(t sessionType, token string, response *string) *Message {
+func NewSession(t sessionType, token string, response *string) (*Message, error) {
 	if response != nil {
 		switch *response {
 		case "allow":
 			msg, err := json.Marshal(&SessionMessage{Type: t, Token: token, Response: Allow})
 			if err != nil {
-				log.Panicf("error marshalling message. %v", err)
+				return nil, &EncodeError{Type: Session, Err: err}
 			}
-			return &Message{Type: Session, Data: msg}
+			return &Message{Type: Session, Data: msg}, nil
 		case "deny":
 			msg, err := json.Marshal(&SessionMessage{Type: t, Token: token, Response: Deny})
 			if err != nil {
-				log.Panicf("error marshalling message. %v", err)
+				return nil, &EncodeError{Type: Session, Err: err}
 			}
-			return &Message{Type: Session, Data: msg}
+			return &Message{Type: Session, Data: msg}, nil
 		}
 	}
 	msg, err := json.Marshal(&SessionMessage{Type: t, Token: token})
 	if err != nil {
-		log.Panicf("error marshalling message. %v", err)
+		return nil, &EncodeError{Type: Session, Err: err}
 	}
-	return &Message{Type: Session, Data: msg}
+	return &Message{Type: Session, Data: msg}, nil
+}
+
+// Returns a new 'join request' session message. The share starts the exchange which proves the
+// session or access password. It may be empty for devices on the allowlist of an unattended
+// host, which prove their certificate in the DTLS handshake instead
+func NewJoinRequest(token, userID, orgID, share, device string) (*Message, error) {
+	msg, err := json.Marshal(&SessionMessage{Type: JoinRequest, Token: token, UserID: userID, OrgID: orgID,
+		Share: share, Device: device})
+	if err != nil {
+		return nil, &EncodeError{Type: Session, Err: err}
+	}
+	return &Message{Type: Session, Data: msg}, nil
+}
+
+// Returns a new 'join challenge' session message with the share and confirmation of the host
+func NewJoinChallenge(token, share, proof string) (*Message, error) {
+	msg, err := json.Marshal(&SessionMessage{Type: JoinChallenge, Token: token, Share: share, Proof: proof})
+	if err != nil {
+		return nil, &EncodeError{Type: Session, Err: err}
+	}
+	return &Message{Type: Session, Data: msg}, nil
+}
+
+// Returns a new 'join confirm' session message. An empty proof gives up on the request
+func NewJoinConfirm(token, proof string) (*Message, error) {
+	msg, err := json.Marshal(&SessionMessage{Type: JoinConfirm, Token: token, Proof: proof})
+	if err != nil {
+		return nil, &EncodeError{Type: Session, Err: err}
+	}
+	return &Message{Type: Session, Data: msg}, nil
 }
 
 func (s SessionMessage) String() string {
 	switch s.Type {
 	case JoinRequest:
 		return "Join Request"
+	case JoinResponse:
+		return "Join Response"
 	case Leave:
 		return "Leave"
+	case JoinChallenge:
+		return "Join Challenge"
+	case JoinConfirm:
+		return "Join Confirm"
 	default:
 		return Unsupported
 	}
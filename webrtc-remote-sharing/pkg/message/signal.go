@@ -19,28 +19,35 @@ const (
 
 // Underlying message type for the 'signal' websocket message
 type SignalMessage struct {
-	Type signalType `json:"event"` // Type of signal message
-	Data []byte     `json:"data"`  // Payload of the underlying 'signal' message sum types
+	Type   signalType `json:"event"`            // Type of signal message
+	Data   []byte     `json:"data"`             // Payload of the underlying 'signal' message sum types
+	Sealed bool       `json:"sealed,omitempty"` // Whether the payload is encrypted with the key shared by the peers
 }
 
 // Returns a new 'signal' message wrapped in a Message struct
-func NewSignal(t signalType, data []byte) *Message {
+func NewSignal(t signalType, data []byte) (*Message, error) {
 	msg, err := json.Marshal(&SignalMessage{Type: t, Data: data})
 	if err != nil {
-		log.Panicf("error marshalling message. %v", err)
+		return nil, &EncodeError{Type: Signal, Err: err}
 	}
-	return &Message{Type: Signal, Data: msg}
+	return &Message{Type: Signal, Data: msg}, nil
 }
 
-// Unmarshals the message into a webrtc.ICECandidate
+// Returns a new 'signal' message with a payload sealed by the peer. The signaling
+// server relays it like any other signal message but cannot read or alter it
+func NewSealedSignal(t signalType, sealed []byte) (*Message, error) {
+	msg, err := json.Marshal(&SignalMessage{Type: t, Data: sealed, Sealed: true})
+	if err != nil {
+		return nil, &EncodeError{Type: Signal, Err: err}
+	}
+	return &Message{Type: Signal, Data: msg}, nil
+}
+
+// Unmarshals the message into a webrtc.ICECandidate. An empty candidate marks the end of candidates
 func (msg *SignalMessage) IntoICE(candidate *webrtc.ICECandidateInit) error {
 	if err := json.Unmarshal([]byte(msg.Data), candidate); err != nil {
 		return fmt.Errorf("could not unmarshal: %v", err)
 	}
-
-	if candidate.Candidate == "" {
-		log.Printf("[WARN] Empty ICECandidate: %v", candidate)
-	}
 	return nil
 }
 
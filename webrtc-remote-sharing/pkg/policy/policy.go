@@ -0,0 +1,233 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Wraps time.Duration so that limits can be written as "90m" or "2h" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"90m\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Limits applied to a session. A zero value disables the corresponding limit
+type Limits struct {
+	MaxDuration Duration `json:"maxDuration,omitempty"` // Maximum length of a session
+	IdleTimeout Duration `json:"idleTimeout,omitempty"` // Session ends after no input and no frame changes for this long
+	WarnBefore  Duration `json:"warnBefore,omitempty"`  // How long before the end both peers are warned
+}
+
+// Default warning period if limits are set without one
+const defaultWarnBefore = Duration(time.Minute)
+
+// Returns the stricter of the two limits for each field
+func (l Limits) Merge(o Limits) Limits {
+	return Limits{
+		MaxDuration: stricter(l.MaxDuration, o.MaxDuration),
+		IdleTimeout: stricter(l.IdleTimeout, o.IdleTimeout),
+		WarnBefore:  longer(l.WarnBefore, o.WarnBefore),
+	}
+}
+
+// Reports whether any limit is set
+func (l Limits) Enabled() bool {
+	return l.MaxDuration > 0 || l.IdleTimeout > 0
+}
+
+func stricter(a, b Duration) Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func longer(a, b Duration) Duration {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+// Session policy of the signaling server. Organizations can override the defaults
+type Config struct {
+	Limits
+	Orgs map[string]Limits `json:"orgs,omitempty"` // Limits by organization id
+}
+
+// Reads the policy from a JSON file. An empty path results in a policy without limits
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy %s: %v", path, err)
+	}
+	if err = json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Returns the limits for a session between users of the given organizations. Each
+// organization's settings replace the defaults they set and the strictest of them applies
+func (c *Config) For(orgIDs ...string) Limits {
+	var limits Limits
+	matched := false
+
+	for _, id := range orgIDs {
+		if org, ok := c.Orgs[id]; ok && id != "" {
+			limits = limits.Merge(c.override(org))
+			matched = true
+		}
+	}
+	if !matched {
+		return c.Limits
+	}
+	return limits
+}
+
+// Returns the defaults with the limits the organization sets in their place
+func (c *Config) override(org Limits) Limits {
+	limits := c.Limits
+	if org.MaxDuration != 0 {
+		limits.MaxDuration = org.MaxDuration
+	}
+	if org.IdleTimeout != 0 {
+		limits.IdleTimeout = org.IdleTimeout
+	}
+	if org.WarnBefore != 0 {
+		limits.WarnBefore = org.WarnBefore
+	}
+	return limits
+}
+
+// Why a session is about to end
+type Reason uint8
+
+const (
+	TimeLimit Reason = iota
+	Idle
+)
+
+func (r Reason) String() string {
+	switch r {
+	case TimeLimit:
+		return "time limit"
+	case Idle:
+		return "inactivity"
+	default:
+		return "Unsupported"
+	}
+}
+
+// Returned by Tracker.Check when a warning is due or the session has to end
+type Event struct {
+	Reason    Reason
+	Remaining time.Duration
+	Expired   bool
+}
+
+func (e Event) String() string {
+	if e.Expired {
+		return fmt.Sprintf("Session ended due to %s", e.Reason)
+	}
+	return fmt.Sprintf("Session will end in %s due to %s", e.Remaining.Round(time.Second), e.Reason)
+}
+
+// Tracks the age and the last activity of a session against its limits.
+// Safe for concurrent use since activity is reported from media callbacks
+type Tracker struct {
+	limits       Limits
+	start        time.Time
+	lastActivity time.Time
+	warned       map[Reason]bool
+	mu           sync.Mutex
+}
+
+func NewTracker(limits Limits, now time.Time) *Tracker {
+	if limits.Enabled() && limits.WarnBefore == 0 {
+		limits.WarnBefore = defaultWarnBefore
+	}
+	return &Tracker{
+		limits:       limits,
+		start:        now,
+		lastActivity: now,
+		warned:       make(map[Reason]bool),
+	}
+}
+
+// Records input or a frame change
+func (t *Tracker) Touch(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastActivity = now
+	// Activity after an idle warning rearms it for the next idle period
+	t.warned[Idle] = false
+}
+
+// Returns the time of the last recorded activity
+func (t *Tracker) LastActivity() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.lastActivity
+}
+
+// Returns an event if the session has expired or a warning is due. Each warning is only
+// returned once. Expiry takes precedence and the earliest deadline is reported first
+func (t *Tracker) Check(now time.Time) (Event, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var events []Event
+	if t.limits.MaxDuration > 0 {
+		events = append(events, Event{Reason: TimeLimit,
+			Remaining: t.start.Add(time.Duration(t.limits.MaxDuration)).Sub(now)})
+	}
+	if t.limits.IdleTimeout > 0 {
+		events = append(events, Event{Reason: Idle,
+			Remaining: t.lastActivity.Add(time.Duration(t.limits.IdleTimeout)).Sub(now)})
+	}
+
+	var due *Event
+	for i := range events {
+		ev := &events[i]
+		if ev.Remaining <= 0 {
+			ev.Expired, ev.Remaining = true, 0
+			return *ev, true
+		}
+		if ev.Remaining <= time.Duration(t.limits.WarnBefore) && !t.warned[ev.Reason] {
+			if due == nil || ev.Remaining < due.Remaining {
+				due = ev
+			}
+		}
+	}
+	if due == nil {
+		return Event{}, false
+	}
+	t.warned[due.Reason] = true
+
+	return *due, true
+}
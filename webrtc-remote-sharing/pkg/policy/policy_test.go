@@ -0,0 +1,68 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestConfigFor(t *testing.T) {
+	var cfg Config
+	data := `{"maxDuration":"2h","idleTimeout":"15m","orgs":{"contractors":{"maxDuration":"30m"},"partners":{"idleTimeout":"5m"},"support":{"maxDuration":"4h","warnBefore":"5m"}}}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		orgs []string
+		want Limits
+	}{
+		{nil, Limits{MaxDuration: Duration(2 * time.Hour), IdleTimeout: Duration(15 * time.Minute)}},
+		{[]string{"staff", ""}, Limits{MaxDuration: Duration(2 * time.Hour), IdleTimeout: Duration(15 * time.Minute)}},
+		{[]string{"contractors"}, Limits{MaxDuration: Duration(30 * time.Minute), IdleTimeout: Duration(15 * time.Minute)}},
+		{[]string{"staff", "contractors"}, Limits{MaxDuration: Duration(30 * time.Minute), IdleTimeout: Duration(15 * time.Minute)}},
+		{[]string{"partners", "contractors"}, Limits{MaxDuration: Duration(30 * time.Minute), IdleTimeout: Duration(5 * time.Minute)}},
+		{[]string{"support"}, Limits{MaxDuration: Duration(4 * time.Hour), IdleTimeout: Duration(15 * time.Minute), WarnBefore: Duration(5 * time.Minute)}},
+		{[]string{"support", "contractors"}, Limits{MaxDuration: Duration(30 * time.Minute), IdleTimeout: Duration(15 * time.Minute), WarnBefore: Duration(5 * time.Minute)}},
+	}
+	for _, tt := range tests {
+		if got := cfg.For(tt.orgs...); got != tt.want {
+			t.Errorf("For(%v) = %+v, want %+v", tt.orgs, got, tt.want)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	start := time.Now()
+	tr := NewTracker(Limits{MaxDuration: Duration(time.Hour), IdleTimeout: Duration(10 * time.Minute)}, start)
+
+	if ev, ok := tr.Check(start.Add(5 * time.Minute)); ok {
+		t.Fatalf("unexpected event %v", ev)
+	}
+
+	// Idle warning is due one minute before the idle timeout and only reported once
+	ev, ok := tr.Check(start.Add(9*time.Minute + 30*time.Second))
+	if !ok || ev.Reason != Idle || ev.Expired {
+		t.Fatalf("expected idle warning, got %v %v", ev, ok)
+	}
+	if ev, ok := tr.Check(start.Add(9*time.Minute + 40*time.Second)); ok {
+		t.Fatalf("warning repeated: %v", ev)
+	}
+
+	// Activity pushes the idle deadline back and rearms the warning
+	tr.Touch(start.Add(9*time.Minute + 50*time.Second))
+	if ev, ok := tr.Check(start.Add(15 * time.Minute)); ok {
+		t.Fatalf("unexpected event after activity %v", ev)
+	}
+	if ev, ok := tr.Check(start.Add(19 * time.Minute)); !ok || ev.Reason != Idle {
+		t.Fatalf("expected second idle warning, got %v %v", ev, ok)
+	}
+
+	tr.Touch(start.Add(55 * time.Minute))
+	if ev, ok := tr.Check(start.Add(59 * time.Minute)); !ok || ev.Reason != TimeLimit || ev.Expired {
+		t.Fatalf("expected time limit warning, got %v %v", ev, ok)
+	}
+	if ev, ok := tr.Check(start.Add(time.Hour)); !ok || !ev.Expired || ev.Reason != TimeLimit {
+		t.Fatalf("expected time limit expiry, got %v %v", ev, ok)
+	}
+}
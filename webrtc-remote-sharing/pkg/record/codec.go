@@ -0,0 +1,299 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Video codecs the recorder can store and the ids of their Matroska codecs
+var codecIDs = map[string]string{
+	"vp8":  "V_VP8",
+	"vp9":  "V_VP9",
+	"av1":  "V_AV1",
+	"h264": "V_MPEG4/ISO/AVC",
+}
+
+// Returns the lower case name of the codec of a mime type like "video/VP8"
+func codecName(mimeType string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.ToLower(mimeType), "video/"))
+}
+
+// Returns the file extension for recordings of the codec. WebM only allows VP8, VP9 and AV1,
+// so H.264 is stored in a Matroska file
+func Extension(mimeType string) string {
+	if codecName(mimeType) == "h264" {
+		return ".mkv"
+	}
+	return ".webm"
+}
+
+// Head of the Ogg Opus stream which Matroska takes as the private data of the Opus track.
+// Two channels at 48 kHz with the pre-skip recommended by the Opus specification
+var opusHead = []byte{
+	'O', 'p', 'u', 's', 'H', 'e', 'a', 'd',
+	1,          // Version
+	2,          // Channels
+	0x38, 0x01, // Pre-skip of 312 samples, little endian
+	0x80, 0xBB, 0, 0, // Input sample rate of 48000, little endian
+	0, 0, // Output gain
+	0, // Channel mapping family
+}
+
+var errNoParameterSets = errors.New("key frame without SPS and PPS")
+
+// Reports whether a frame of the codec can be decoded without the frames before it
+func isKeyFrame(codec string, frame []byte) bool {
+	if len(frame) == 0 {
+		return false
+	}
+	switch codec {
+	case "vp8":
+		// The inverted key frame flag is the lowest bit of the frame tag
+		return frame[0]&0x01 == 0
+	case "vp9":
+		return vp9KeyFrame(frame)
+	case "av1":
+		// Encoders repeat the sequence header with every key frame
+		return findOBU(frame, obuSequenceHeader) != nil
+	case "h264":
+		for _, nalu := range splitAnnexB(frame) {
+			if len(nalu) > 0 && nalu[0]&0x1F == naluIDR {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reads the frame type of the uncompressed header of a VP9 frame
+func vp9KeyFrame(frame []byte) bool {
+	r := bitReader{data: frame}
+	if r.read(2) != 2 { // Frame marker
+		return false
+	}
+	profile := r.read(1) | r.read(1)<<1
+	if profile == 3 {
+		r.read(1) // Reserved
+	}
+	if r.read(1) == 1 { // Show existing frame
+		return false
+	}
+	return r.read(1) == 0 && !r.overrun
+}
+
+// Returns the private data of the video track taken from a key frame and the frame as it
+// is stored in the blocks of the track
+func codecPrivate(codec string, frame []byte) (private, block []byte, err error) {
+	switch codec {
+	case "h264":
+		return avcConfig(frame)
+	case "av1":
+		return av1Config(frame), stripTemporalDelimiters(frame), nil
+	}
+	return nil, frame, nil
+}
+
+// Converts a frame for the blocks of the codec
+func blockData(codec string, frame []byte) []byte {
+	switch codec {
+	case "h264":
+		return lengthPrefixed(frame)
+	case "av1":
+		return stripTemporalDelimiters(frame)
+	}
+	return frame
+}
+
+// NAL unit types of H.264
+const (
+	naluIDR = 5
+	naluSPS = 7
+	naluPPS = 8
+	naluAUD = 9
+)
+
+// Splits a frame in Annex B byte stream format into its NAL units
+func splitAnnexB(frame []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	for i := 0; i+2 < len(frame); i++ {
+		if frame[i] != 0 || frame[i+1] != 0 || frame[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			nalus = append(nalus, bytes.TrimRight(frame[start:i], "\x00"))
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 && start < len(frame) {
+		nalus = append(nalus, frame[start:])
+	}
+	return nalus
+}
+
+// Converts a frame from Annex B into NAL units prefixed with their 4 byte length. Access unit
+// delimiters are dropped since Matroska blocks already delimit the frames
+func lengthPrefixed(frame []byte) []byte {
+	out := make([]byte, 0, len(frame)+16)
+	for _, nalu := range splitAnnexB(frame) {
+		if len(nalu) == 0 || nalu[0]&0x1F == naluAUD {
+			continue
+		}
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(nalu)))
+		out = append(append(out, size[:]...), nalu...)
+	}
+	return out
+}
+
+// Builds the AVC decoder configuration record from the parameter sets of a key frame
+func avcConfig(frame []byte) (private, block []byte, err error) {
+	var sps, pps []byte
+	for _, nalu := range splitAnnexB(frame) {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch nalu[0] & 0x1F {
+		case naluSPS:
+			sps = nalu
+		case naluPPS:
+			pps = nalu
+		}
+	}
+	if len(sps) < 4 || pps == nil {
+		return nil, nil, errNoParameterSets
+	}
+	private = []byte{1, sps[1], sps[2], sps[3], 0xFF, 0xE1} // 4 byte lengths and one SPS
+	private = append(private, byte(len(sps)>>8), byte(len(sps)))
+	private = append(private, sps...)
+	private = append(private, 1, byte(len(pps)>>8), byte(len(pps)))
+	private = append(private, pps...)
+	return private, lengthPrefixed(frame), nil
+}
+
+// OBU types of AV1
+const (
+	obuSequenceHeader    = 1
+	obuTemporalDelimiter = 2
+)
+
+// Calls f with the type and the whole of each OBU of a temporal unit. The OBUs have size
+// fields, which is how the jitter buffer and the encoders pass them on
+func walkOBUs(frame []byte, f func(typ byte, obu, payload []byte)) {
+	for len(frame) > 0 {
+		header := frame[0]
+		n := 1
+		if header&0x04 != 0 { // Extension
+			n++
+		}
+		if header&0x02 == 0 || n > len(frame) { // No size field, the OBU fills the rest
+			f(header>>3&0x0F, frame, frame[min(n, len(frame)):])
+			return
+		}
+		size, l := leb128(frame[n:])
+		if l == 0 || uint64(len(frame)-n-l) < size {
+			return
+		}
+		end := n + l + int(size)
+		f(header>>3&0x0F, frame[:end], frame[n+l:end])
+		frame = frame[end:]
+	}
+}
+
+// Returns the first OBU of the type including its header or nil
+func findOBU(frame []byte, typ byte) (obu []byte) {
+	walkOBUs(frame, func(t byte, o, _ []byte) {
+		if t == typ && obu == nil {
+			obu = o
+		}
+	})
+	return obu
+}
+
+// Drops the temporal delimiters, which Matroska leaves out of the blocks
+func stripTemporalDelimiters(frame []byte) []byte {
+	out := make([]byte, 0, len(frame))
+	walkOBUs(frame, func(t byte, obu, _ []byte) {
+		if t != obuTemporalDelimiter {
+			out = append(out, obu...)
+		}
+	})
+	return out
+}
+
+// Builds the AV1 codec configuration record from the sequence header of a key frame. The
+// video of the capture is 8 bit 4:2:0, only the profile and the level are read
+func av1Config(frame []byte) []byte {
+	private := []byte{0x81, 0, 0x0C, 0}
+	found := false
+	walkOBUs(frame, func(t byte, obu, payload []byte) {
+		if t != obuSequenceHeader || found {
+			return
+		}
+		found = true
+		profile, level := av1Level(payload)
+		private[1] = profile<<5 | level
+		private = append(private, obu...)
+	})
+	return private
+}
+
+// Reads the profile and the level of the first operating point of a sequence header
+func av1Level(header []byte) (profile, level byte) {
+	r := bitReader{data: header}
+	profile = byte(r.read(3))
+	r.read(1)           // Still picture
+	if r.read(1) == 1 { // Reduced still picture header
+		return profile, byte(r.read(5))
+	}
+	if r.read(1) == 1 { // Timing info, which WebRTC encoders leave out
+		return profile, 0
+	}
+	r.read(1) // Initial display delay present
+	r.read(5) // Operating points minus one
+	r.read(12)
+	return profile, byte(r.read(5))
+}
+
+// Reads an unsigned LEB128 value and returns it with its length or a length of 0
+func leb128(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 8; i++ {
+		v |= uint64(b[i]&0x7F) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Reads bits most significant first. Reading past the end returns zeros
+type bitReader struct {
+	data    []byte
+	pos     int
+	overrun bool
+}
+
+func (r *bitReader) read(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if r.pos/8 >= len(r.data) {
+			r.overrun = true
+			continue
+		}
+		v |= uint32(r.data[r.pos/8]>>(7-r.pos%8)) & 1
+		r.pos++
+	}
+	return v
+}
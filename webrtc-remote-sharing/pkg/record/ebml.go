@@ -0,0 +1,113 @@
+package record
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Element ids of the Matroska elements written by the recorder. The ids include their
+// length marker bits, so they are written as they are
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+
+	idSegment        = 0x18538067
+	idInfo           = 0x1549A966
+	idTimestampScale = 0x2AD7B1
+	idDuration       = 0x4489
+	idMuxingApp      = 0x4D80
+	idWritingApp     = 0x5741
+
+	idTracks            = 0x1654AE6B
+	idTrackEntry        = 0xAE
+	idTrackNumber       = 0xD7
+	idTrackUID          = 0x73C5
+	idTrackType         = 0x83
+	idFlagLacing        = 0x9C
+	idCodecID           = 0x86
+	idCodecPrivate      = 0x63A2
+	idCodecDelay        = 0x56AA
+	idSeekPreRoll       = 0x56BB
+	idVideo             = 0xE0
+	idPixelWidth        = 0xB0
+	idPixelHeight       = 0xBA
+	idAudio             = 0xE1
+	idSamplingFrequency = 0xB5
+	idChannels          = 0x9F
+
+	idCluster     = 0x1F43B675
+	idTimestamp   = 0xE7
+	idSimpleBlock = 0xA3
+)
+
+// Size of the segment written before its end is known. It is patched when the recorder is
+// closed. 0x01 followed by all ones would mean an unknown size
+var unknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// Appends the id of an element
+func appendID(b []byte, id uint32) []byte {
+	switch {
+	case id > 0xFFFFFF:
+		return append(b, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	case id > 0xFFFF:
+		return append(b, byte(id>>16), byte(id>>8), byte(id))
+	case id > 0xFF:
+		return append(b, byte(id>>8), byte(id))
+	}
+	return append(b, byte(id))
+}
+
+// Appends a size as a variable length integer of as few bytes as possible
+func appendSize(b []byte, size uint64) []byte {
+	n := 1
+	// All ones in the value bits are reserved for unknown sizes
+	for size >= 1<<(7*n)-1 {
+		n++
+	}
+	b = append(b, make([]byte, n)...)
+	encodeSize(b[len(b)-n:], size)
+	return b
+}
+
+// Encodes a size into all bytes of dst
+func encodeSize(dst []byte, size uint64) {
+	n := len(dst)
+	for i := n - 1; i >= 0; i-- {
+		dst[i] = byte(size)
+		size >>= 8
+	}
+	dst[0] |= 0x80 >> (n - 1)
+}
+
+// Appends an element with the given payload
+func appendElement(b []byte, id uint32, payload []byte) []byte {
+	b = appendID(b, id)
+	b = appendSize(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+func appendUint(b []byte, id uint32, v uint64) []byte {
+	n := 1
+	for n < 8 && v>>(8*n) != 0 {
+		n++
+	}
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, v)
+	return appendElement(b, id, payload[8-n:])
+}
+
+func appendFloat(b []byte, id uint32, v float64) []byte {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, math.Float64bits(v))
+	return appendElement(b, id, payload)
+}
+
+func appendString(b []byte, id uint32, s string) []byte {
+	return appendElement(b, id, []byte(s))
+}
@@ -0,0 +1,315 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Track numbers of the recording
+const (
+	videoTrack = 1
+	audioTrack = 2
+)
+
+const (
+	// A cluster starts at each key frame or when its blocks would be further apart than this
+	maxClusterDuration = 30 * time.Second
+	// Opus decoders need this much audio before a seek point to converge
+	opusSeekPreRoll = 80 * time.Millisecond
+)
+
+var ErrUnsupportedCodec = errors.New("codec cannot be recorded")
+
+// Settings of a recording
+type Options struct {
+	Codec  string // Mime type of the video e.g. "video/VP8"
+	Width  int    // Size of the video when the recording starts
+	Height int
+	Audio  bool // Adds an Opus track for the sound
+}
+
+// Writes the encoded frames of a session into a WebM or Matroska file without decoding them.
+// Nothing is written before the first video key frame. Each track is placed on the timeline
+// by the time its first frame arrives, later frames follow their presentation times, so the
+// audio stays in sync with the video as long as both arrive with the same delay. The frames
+// may be written from different goroutines
+type Recorder struct {
+	w     io.WriteSeeker
+	close func() error
+	opts  Options
+	codec string
+	now   func() time.Time
+
+	mu      sync.Mutex
+	start   time.Time // Arrival of the first key frame, the start of the timeline
+	started bool
+	video   trackClock
+	audio   trackClock
+	err     error // First write error, after which frames are dropped
+
+	segmentSize int64 // Offsets of the sizes patched on close
+	segmentData int64
+	durationPos int64
+	duration    time.Duration
+
+	cluster      []byte // Blocks of the open cluster
+	clusterStart time.Duration
+	clusterOpen  bool
+}
+
+// Maps the presentation times of a track onto the timeline of the recording
+type trackClock struct {
+	anchored bool
+	offset   time.Duration // Time of the first frame on the timeline minus its presentation time
+	last     time.Duration
+}
+
+func (c *trackClock) at(pts time.Duration, arrival time.Duration) time.Duration {
+	if !c.anchored {
+		c.anchored = true
+		c.offset = arrival - pts
+	}
+	t := c.offset + pts
+	// Blocks of a track never go back in time, e.g. when the capture restarts
+	if t < c.last {
+		t = c.last
+	}
+	c.last = t
+	return t
+}
+
+// Creates the file at path and records into it
+func Create(path string, opts Options) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r, err := New(f, opts)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	r.close = f.Close
+	return r, nil
+}
+
+// Records into w. The sizes in the header are patched when the recorder is closed
+func New(w io.WriteSeeker, opts Options) (*Recorder, error) {
+	codec := codecName(opts.Codec)
+	if _, ok := codecIDs[codec]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCodec, opts.Codec)
+	}
+	return &Recorder{w: w, opts: opts, codec: codec, now: time.Now}, nil
+}
+
+// Writes an encoded video frame. Frames before the first key frame are dropped
+func (r *Recorder) WriteVideo(frame []byte, pts time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	key := isKeyFrame(r.codec, frame)
+	if !r.started {
+		if !key {
+			return nil
+		}
+		private, block, err := codecPrivate(r.codec, frame)
+		if err != nil {
+			// The next key frame may carry the parameter sets
+			return nil
+		}
+		if r.err = r.writeHeader(private); r.err != nil {
+			return r.err
+		}
+		r.start, r.started = r.now(), true
+		return r.writeBlock(videoTrack, r.video.at(pts, 0), block, true)
+	}
+	t := r.video.at(pts, r.now().Sub(r.start))
+	return r.writeBlock(videoTrack, t, blockData(r.codec, frame), key)
+}
+
+// Writes an Opus frame. Frames before the first video key frame are dropped
+func (r *Recorder) WriteAudio(frame []byte, pts time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	if !r.started || !r.opts.Audio {
+		return nil
+	}
+	t := r.audio.at(pts, r.now().Sub(r.start))
+	return r.writeBlock(audioTrack, t, frame, true)
+}
+
+// Returns how much of the session has been recorded
+func (r *Recorder) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.duration
+}
+
+// Writes the last cluster and the sizes of the header, then closes the file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.err
+	if err == nil && r.started {
+		err = r.finish()
+	}
+	r.err = errors.New("recorder closed")
+	if r.close != nil {
+		if closeErr := r.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (r *Recorder) finish() error {
+	if err := r.flushCluster(); err != nil {
+		return err
+	}
+	end, err := r.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	size := make([]byte, len(unknownSize))
+	encodeSize(size, uint64(end-r.segmentData))
+	if err = r.writeAt(r.segmentSize, size); err != nil {
+		return err
+	}
+	duration := appendFloat(nil, idDuration, float64(r.duration/time.Millisecond))
+	if err = r.writeAt(r.durationPos, duration); err != nil {
+		return err
+	}
+	_, err = r.w.Seek(end, io.SeekStart)
+	return err
+}
+
+func (r *Recorder) writeAt(offset int64, b []byte) error {
+	if _, err := r.w.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := r.w.Write(b)
+	return err
+}
+
+// Writes the EBML header, the segment info and the tracks
+func (r *Recorder) writeHeader(private []byte) error {
+	docType := "webm"
+	if r.codec == "h264" {
+		docType = "matroska"
+	}
+	var ebml []byte
+	ebml = appendUint(ebml, idEBMLVersion, 1)
+	ebml = appendUint(ebml, idEBMLReadVersion, 1)
+	ebml = appendUint(ebml, idEBMLMaxIDLength, 4)
+	ebml = appendUint(ebml, idEBMLMaxSizeLength, 8)
+	ebml = appendString(ebml, idDocType, docType)
+	ebml = appendUint(ebml, idDocTypeVersion, 4)
+	ebml = appendUint(ebml, idDocTypeReadVersion, 2)
+	header := appendElement(nil, idEBML, ebml)
+
+	header = appendID(header, idSegment)
+	r.segmentSize = int64(len(header))
+	header = append(header, unknownSize...)
+	r.segmentData = int64(len(header))
+
+	// The duration is written as a placeholder of the final size
+	var info []byte
+	info = appendUint(info, idTimestampScale, uint64(time.Millisecond))
+	info = appendString(info, idMuxingApp, "remygo")
+	info = appendString(info, idWritingApp, "remygo")
+	durationPos := len(info)
+	info = appendFloat(info, idDuration, 0)
+	header = appendID(header, idInfo)
+	header = appendSize(header, uint64(len(info)))
+	r.durationPos = int64(len(header) + durationPos)
+	header = append(header, info...)
+
+	var video []byte
+	video = appendUint(video, idPixelWidth, uint64(r.opts.Width))
+	video = appendUint(video, idPixelHeight, uint64(r.opts.Height))
+	var track []byte
+	track = appendUint(track, idTrackNumber, videoTrack)
+	track = appendUint(track, idTrackUID, videoTrack)
+	track = appendUint(track, idTrackType, 1)
+	track = appendUint(track, idFlagLacing, 0)
+	track = appendString(track, idCodecID, codecIDs[r.codec])
+	if private != nil {
+		track = appendElement(track, idCodecPrivate, private)
+	}
+	track = appendElement(track, idVideo, video)
+	tracks := appendElement(nil, idTrackEntry, track)
+
+	if r.opts.Audio {
+		var audio []byte
+		audio = appendFloat(audio, idSamplingFrequency, 48000)
+		audio = appendUint(audio, idChannels, 2)
+		track = nil
+		track = appendUint(track, idTrackNumber, audioTrack)
+		track = appendUint(track, idTrackUID, audioTrack)
+		track = appendUint(track, idTrackType, 2)
+		track = appendUint(track, idFlagLacing, 0)
+		track = appendString(track, idCodecID, "A_OPUS")
+		track = appendElement(track, idCodecPrivate, opusHead)
+		track = appendUint(track, idCodecDelay, uint64(312*time.Second/48000))
+		track = appendUint(track, idSeekPreRoll, uint64(opusSeekPreRoll))
+		track = appendElement(track, idAudio, audio)
+		tracks = appendElement(tracks, idTrackEntry, track)
+	}
+	header = appendElement(header, idTracks, tracks)
+
+	_, err := r.w.Write(header)
+	return err
+}
+
+// Adds a block to the open cluster. Video key frames start a new cluster so that players can
+// seek to them, which the timecodes of the blocks relative to the cluster require anyway
+func (r *Recorder) writeBlock(track uint64, t time.Duration, data []byte, key bool) error {
+	rel := (t - r.clusterStart) / time.Millisecond
+	if !r.clusterOpen || (track == videoTrack && key) || rel < -1<<15 || rel >= 1<<15 ||
+		t-r.clusterStart >= maxClusterDuration {
+		if r.err = r.flushCluster(); r.err != nil {
+			return r.err
+		}
+		r.clusterStart, r.clusterOpen = t, true
+		r.cluster = appendUint(r.cluster[:0], idTimestamp, uint64(t/time.Millisecond))
+		rel = 0
+	}
+
+	var flags byte
+	if key {
+		flags = 0x80
+	}
+	r.cluster = appendID(r.cluster, idSimpleBlock)
+	r.cluster = appendSize(r.cluster, uint64(4+len(data)))
+	r.cluster = append(r.cluster, 0x80|byte(track), byte(uint16(rel)>>8), byte(rel), flags)
+	r.cluster = append(r.cluster, data...)
+
+	if t > r.duration {
+		r.duration = t
+	}
+	return nil
+}
+
+func (r *Recorder) flushCluster() error {
+	if !r.clusterOpen {
+		return nil
+	}
+	r.clusterOpen = false
+	_, err := r.w.Write(appendElement(nil, idCluster, r.cluster))
+	return err
+}
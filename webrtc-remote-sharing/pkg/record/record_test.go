@@ -0,0 +1,331 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Element of a parsed recording
+type element struct {
+	id       uint32
+	data     []byte
+	children []element
+}
+
+// Elements which contain other elements
+var masters = map[uint32]bool{
+	idEBML: true, idSegment: true, idInfo: true, idTracks: true, idTrackEntry: true,
+	idVideo: true, idAudio: true, idCluster: true,
+}
+
+func readVint(b []byte) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for b[0]&(0x80>>(n-1)) == 0 {
+		n++
+	}
+	if n > len(b) {
+		return 0, 0
+	}
+	v := uint64(b[0] & (0xFF >> n))
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+func parse(t *testing.T, b []byte) []element {
+	t.Helper()
+	var elems []element
+	for len(b) > 0 {
+		_, idLen := readVint(b)
+		if idLen == 0 {
+			t.Fatalf("invalid id at % x", b[:min(8, len(b))])
+		}
+		var id uint32
+		for _, c := range b[:idLen] {
+			id = id<<8 | uint32(c)
+		}
+		size, sizeLen := readVint(b[idLen:])
+		if sizeLen == 0 || uint64(len(b)-idLen-sizeLen) < size {
+			t.Fatalf("invalid size of element %x", id)
+		}
+		data := b[idLen+sizeLen : idLen+sizeLen+int(size)]
+		e := element{id: id, data: data}
+		if masters[id] {
+			e.children = parse(t, data)
+		}
+		elems = append(elems, e)
+		b = b[idLen+sizeLen+int(size):]
+	}
+	return elems
+}
+
+func find(elems []element, id uint32) []element {
+	var found []element
+	for _, e := range elems {
+		if e.id == id {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func one(t *testing.T, elems []element, id uint32) element {
+	t.Helper()
+	found := find(elems, id)
+	if len(found) != 1 {
+		t.Fatalf("got %d elements %x, want 1", len(found), id)
+	}
+	return found[0]
+}
+
+// Records the frames with a clock which advances by the presentation times
+func record(t *testing.T, opts Options, write func(r *Recorder, clock *time.Time)) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session"+Extension(opts.Codec))
+	r, err := Create(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Unix(1000, 0)
+	r.now = func() time.Time { return clock }
+	write(r, &clock)
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+type block struct {
+	track int
+	time  time.Duration
+	key   bool
+	data  []byte
+}
+
+func blocks(t *testing.T, segment []element) []block {
+	t.Helper()
+	var got []block
+	for _, cluster := range find(segment, idCluster) {
+		start := time.Duration(readUint(one(t, cluster.children, idTimestamp).data)) * time.Millisecond
+		for _, b := range find(cluster.children, idSimpleBlock) {
+			rel := time.Duration(int16(binary.BigEndian.Uint16(b.data[1:3]))) * time.Millisecond
+			got = append(got, block{int(b.data[0] & 0x7F), start + rel, b.data[3]&0x80 != 0, b.data[4:]})
+		}
+	}
+	return got
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func TestRecordVP8WithAudio(t *testing.T) {
+	data := record(t, Options{Codec: "video/VP8", Width: 640, Height: 480, Audio: true}, func(r *Recorder, clock *time.Time) {
+		// Frames before the first key frame cannot be decoded and are dropped
+		mustWrite(t, r.WriteAudio([]byte{0xf8}, 0))
+		mustWrite(t, r.WriteVideo([]byte{0x01, 0xAA}, 0))
+
+		for i := 0; i < 3; i++ {
+			pts := time.Duration(i) * 100 * time.Millisecond
+			*clock = time.Unix(1000, 0).Add(pts)
+			frame := []byte{0x01, byte(i)}
+			if i == 0 {
+				frame[0] = 0x00
+			}
+			mustWrite(t, r.WriteVideo(frame, time.Second+pts))
+			mustWrite(t, r.WriteAudio([]byte{0xf8, byte(i)}, 5*time.Second+pts))
+		}
+		// The second key frame starts a new cluster
+		*clock = time.Unix(1000, 0).Add(300 * time.Millisecond)
+		mustWrite(t, r.WriteVideo([]byte{0x00, 3}, time.Second+300*time.Millisecond))
+	})
+
+	elems := parse(t, data)
+	if len(elems) != 2 {
+		t.Fatalf("got %d top level elements, want the EBML header and the segment", len(elems))
+	}
+	if docType := one(t, elems[0].children, idDocType).data; string(docType) != "webm" {
+		t.Errorf("doc type %q, want webm", docType)
+	}
+
+	segment := elems[1].children
+	info := one(t, segment, idInfo).children
+	duration := math.Float64frombits(binary.BigEndian.Uint64(one(t, info, idDuration).data))
+	if duration != 300 {
+		t.Errorf("duration %v ms, want 300", duration)
+	}
+
+	tracks := find(one(t, segment, idTracks).children, idTrackEntry)
+	if len(tracks) != 2 {
+		t.Fatalf("got %d tracks, want video and audio", len(tracks))
+	}
+	if id := one(t, tracks[0].children, idCodecID).data; string(id) != "V_VP8" {
+		t.Errorf("video codec %q", id)
+	}
+	if id := one(t, tracks[1].children, idCodecID).data; string(id) != "A_OPUS" {
+		t.Errorf("audio codec %q", id)
+	}
+	if private := one(t, tracks[1].children, idCodecPrivate).data; !bytes.HasPrefix(private, []byte("OpusHead")) {
+		t.Errorf("audio codec private % x", private)
+	}
+
+	if clusters := find(segment, idCluster); len(clusters) != 2 {
+		t.Errorf("got %d clusters, want one per key frame", len(clusters))
+	}
+	want := []block{
+		{1, 0, true, []byte{0x00, 0}},
+		{2, 0, true, []byte{0xf8, 0}},
+		{1, 100 * time.Millisecond, false, []byte{0x01, 1}},
+		{2, 100 * time.Millisecond, true, []byte{0xf8, 1}},
+		{1, 200 * time.Millisecond, false, []byte{0x01, 2}},
+		{2, 200 * time.Millisecond, true, []byte{0xf8, 2}},
+		{1, 300 * time.Millisecond, true, []byte{0x00, 3}},
+	}
+	got := blocks(t, segment)
+	if len(got) != len(want) {
+		t.Fatalf("got %d blocks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].track != want[i].track || got[i].time != want[i].time || got[i].key != want[i].key ||
+			!bytes.Equal(got[i].data, want[i].data) {
+			t.Errorf("block %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRecordH264(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xC0, 0x1F, 0x11}
+	pps := []byte{0x68, 0xCE, 0x3C}
+	idr := []byte{0x65, 0x88, 0x84}
+	annexB := func(nalus ...[]byte) []byte {
+		var b []byte
+		for _, n := range nalus {
+			b = append(append(b, 0, 0, 0, 1), n...)
+		}
+		return b
+	}
+
+	data := record(t, Options{Codec: "video/H264", Width: 1280, Height: 720}, func(r *Recorder, clock *time.Time) {
+		mustWrite(t, r.WriteVideo(annexB([]byte{0x09, 0xF0}, sps, pps, idr), 0))
+		*clock = clock.Add(40 * time.Millisecond)
+		mustWrite(t, r.WriteVideo(annexB([]byte{0x41, 0x9A}), 40*time.Millisecond))
+	})
+
+	elems := parse(t, data)
+	if docType := one(t, elems[0].children, idDocType).data; string(docType) != "matroska" {
+		t.Errorf("doc type %q, want matroska", docType)
+	}
+	segment := elems[1].children
+	tracks := find(one(t, segment, idTracks).children, idTrackEntry)
+	if len(tracks) != 1 {
+		t.Fatalf("got %d tracks, want only video", len(tracks))
+	}
+	avcC := one(t, tracks[0].children, idCodecPrivate).data
+	wantAvcC := append([]byte{1, 0x42, 0xC0, 0x1F, 0xFF, 0xE1, 0, 5}, sps...)
+	wantAvcC = append(append(wantAvcC, 1, 0, 3), pps...)
+	if !bytes.Equal(avcC, wantAvcC) {
+		t.Errorf("avcC % x, want % x", avcC, wantAvcC)
+	}
+
+	got := blocks(t, segment)
+	if len(got) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(got))
+	}
+	// The access unit delimiter is dropped and the NAL units are length prefixed
+	want := []byte{0, 0, 0, 5}
+	want = append(append(want, sps...), 0, 0, 0, 3)
+	want = append(append(want, pps...), 0, 0, 0, 3)
+	want = append(want, idr...)
+	if !bytes.Equal(got[0].data, want) || !got[0].key {
+		t.Errorf("key frame % x, want % x", got[0].data, want)
+	}
+	if !bytes.Equal(got[1].data, []byte{0, 0, 0, 2, 0x41, 0x9A}) || got[1].key || got[1].time != 40*time.Millisecond {
+		t.Errorf("second frame %+v", got[1])
+	}
+}
+
+func TestKeyFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec string
+		frame []byte
+		key   bool
+	}{
+		{"VP8 key frame", "vp8", []byte{0x50, 0x42}, true},
+		{"VP8 inter frame", "vp8", []byte{0x51, 0x42}, false},
+		{"VP9 key frame", "vp9", []byte{0x82, 0x49}, true},
+		{"VP9 inter frame", "vp9", []byte{0x86, 0x00}, false},
+		{"VP9 shown existing frame", "vp9", []byte{0x88}, false},
+		{"AV1 sequence header", "av1", []byte{0x12, 0x00, 0x0A, 0x02, 0x00, 0x00, 0x32, 0x01, 0x10}, true},
+		{"AV1 frame", "av1", []byte{0x12, 0x00, 0x32, 0x01, 0x10}, false},
+		{"H264 IDR", "h264", []byte{0, 0, 1, 0x65, 0x88}, true},
+		{"H264 slice", "h264", []byte{0, 0, 0, 1, 0x41, 0x9A}, false},
+		{"Empty", "vp8", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key := isKeyFrame(tt.codec, tt.frame); key != tt.key {
+				t.Errorf("isKeyFrame = %v, want %v", key, tt.key)
+			}
+		})
+	}
+}
+
+func TestAV1Config(t *testing.T) {
+	// Temporal delimiter, sequence header of profile 0 at level 8 and a frame
+	seq := []byte{0x0A, 0x04, 0x00, 0x00, 0x00, 0x40}
+	frame := append([]byte{0x12, 0x00}, seq...)
+	frame = append(frame, 0x32, 0x01, 0x10)
+
+	private, block, err := codecPrivate("av1", frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte{0x81, 0x08, 0x0C, 0x00}, seq...); !bytes.Equal(private, want) {
+		t.Errorf("av1C % x, want % x", private, want)
+	}
+	if want := append(append([]byte{}, seq...), 0x32, 0x01, 0x10); !bytes.Equal(block, want) {
+		t.Errorf("block % x, want % x without the temporal delimiter", block, want)
+	}
+}
+
+func TestUnsupportedCodec(t *testing.T) {
+	if _, err := New(nil, Options{Codec: "video/H265"}); !errors.Is(err, ErrUnsupportedCodec) {
+		t.Errorf("got %v, want ErrUnsupportedCodec", err)
+	}
+}
+
+func TestSize(t *testing.T) {
+	for _, size := range []uint64{0, 126, 127, 16382, 16383, 1 << 30} {
+		b := appendSize(nil, size)
+		got, n := readVint(b)
+		if got != size || n != len(b) {
+			t.Errorf("size %d encoded as % x decodes to %d", size, b, got)
+		}
+	}
+}
+
+func mustWrite(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,53 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/remygo/swagger"
+)
+
+// State of the files registered with the API. Their progress tells when they are complete
+const stateUploading = "uploading"
+
+// Registry of the files of a user kept by the API server. The files are stored in the blob
+// provider with the given id
+type APIRegistry struct {
+	Files      *swagger.UserFileApiService
+	UserID     string
+	ProviderID string
+}
+
+// Adds the file to the files of the user. The API does not answer the id of a new file, so
+// it is looked up by the url of its object, which is unique
+func (r *APIRegistry) Register(ctx context.Context, f File) (string, error) {
+	_, err := r.Files.Create(ctx, r.UserID, swagger.AddUserFile{
+		Name:           f.Name,
+		State:          stateUploading,
+		Size:           float32(f.Size),
+		Md5:            f.MD5,
+		BlobUri:        f.URI,
+		SessionId:      f.SessionID,
+		BlobProviderId: r.ProviderID,
+	})
+	if err != nil {
+		return "", err
+	}
+	files, _, err := r.Files.GetFiles(ctx)
+	if err != nil {
+		return "", err
+	}
+	// The latest file of the url is the one just created
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].BlobUri == f.URI && files[i].Id != "" {
+			return files[i].Id, nil
+		}
+	}
+	return "", fmt.Errorf("registered file %s not found", f.URI)
+}
+
+// Reports the bytes uploaded and the url of the object once it is complete
+func (r *APIRegistry) Update(ctx context.Context, id string, uploaded int64, uri string) error {
+	_, _, err := r.Files.Update(ctx, id, swagger.UpdateFile{BytesUploaded: float32(uploaded), DownloadUri: uri})
+	return err
+}
@@ -0,0 +1,240 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/remygo/pkg/upload/sigv4"
+)
+
+// Region requests are signed for unless the host of the provider names another one. Most
+// S3 compatible stores accept any region
+const defaultRegion = "us-east-1"
+
+// Error returned by the blob store
+type Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("blob store answered %d", e.Status)
+	}
+	return fmt.Sprintf("blob store answered %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Reports whether the store does not know the multipart upload e.g. since it expired
+func isNoSuchUpload(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == "NoSuchUpload"
+}
+
+// Uploaded part of a multipart upload
+type Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+	Size   int64  `xml:"Size"`
+}
+
+// Client of the multipart uploads of an S3 compatible blob store. The url of the provider
+// names the bucket in its path e.g. http://minio:9000/recordings
+type S3 struct {
+	Endpoint    *url.URL
+	Credentials sigv4.Credentials
+	Client      *http.Client
+	now         func() time.Time
+}
+
+// Returns a client of the store at the url of a blob provider
+func NewS3(endpoint, accessKey, secretKey string) (*S3, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing url of blob provider: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("url of blob provider %q does not name a bucket", endpoint)
+	}
+	return &S3{
+		Endpoint:    u,
+		Credentials: sigv4.Credentials{AccessKey: accessKey, SecretKey: secretKey, Region: region(u.Hostname())},
+		Client:      http.DefaultClient,
+		now:         time.Now,
+	}, nil
+}
+
+// Returns the region of AWS hosts like s3.eu-central-1.amazonaws.com
+func region(host string) string {
+	labels := strings.Split(host, ".")
+	if len(labels) >= 4 && strings.HasSuffix(host, ".amazonaws.com") {
+		for i, l := range labels[:len(labels)-2] {
+			if (l == "s3" || strings.HasPrefix(l, "s3-")) && i+1 < len(labels)-2 {
+				return labels[i+1]
+			}
+		}
+	}
+	return defaultRegion
+}
+
+// Returns the url of the object
+func (s *S3) ObjectURL(key string) string {
+	u := *s.Endpoint
+	u.Path = path.Join(u.Path, key)
+	return u.String()
+}
+
+// Starts a multipart upload of the object and returns its id
+func (s *S3) CreateUpload(ctx context.Context, key string) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil, &result); err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("no upload id for %s", key)
+	}
+	return result.UploadID, nil
+}
+
+// Uploads a part of the object. Parts are numbered from 1
+func (s *S3) UploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (Part, error) {
+	sum := md5.Sum(data)
+	header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+
+	resp, err := s.send(ctx, http.MethodPut, key, query, data, header)
+	if err != nil {
+		return Part{}, err
+	}
+	resp.Body.Close()
+	return Part{Number: number, ETag: resp.Header.Get("ETag"), Size: int64(len(data))}, nil
+}
+
+// Returns the parts the store received in the order of their numbers
+func (s *S3) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	var parts []Part
+	marker := ""
+	for {
+		var result struct {
+			Parts       []Part `xml:"Part"`
+			IsTruncated bool   `xml:"IsTruncated"`
+			NextMarker  string `xml:"NextPartNumberMarker"`
+		}
+		query := url.Values{"uploadId": {uploadID}}
+		if marker != "" {
+			query.Set("part-number-marker", marker)
+		}
+		if err := s.do(ctx, http.MethodGet, key, query, nil, nil, &result); err != nil {
+			return nil, err
+		}
+		parts = append(parts, result.Parts...)
+		if !result.IsTruncated || result.NextMarker == "" {
+			break
+		}
+		marker = result.NextMarker
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+// Joins the parts into the object
+func (s *S3) CompleteUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	type part struct {
+		Number int    `xml:"PartNumber"`
+		ETag   string `xml:"ETag"`
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for _, p := range parts {
+		complete.Parts = append(complete.Parts, part{p.Number, p.ETag})
+	}
+	body, err := xml.Marshal(&complete)
+	if err != nil {
+		return err
+	}
+	// The store may fail while joining the parts after it answered 200, the body tells
+	var result struct {
+		XMLName xml.Name
+		Error
+	}
+	if err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, nil, &result); err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		result.Error.Status = http.StatusOK
+		return &result.Error
+	}
+	return nil
+}
+
+// Discards the upload and the parts received so far
+func (s *S3) AbortUpload(ctx context.Context, key, uploadID string) error {
+	return s.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, nil)
+}
+
+// Sends a request and decodes the XML of the response into result if it is not nil
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body []byte, header http.Header, result interface{}) error {
+	resp, err := s.send(ctx, method, key, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err = xml.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("decoding answer of blob store: %w", err)
+	}
+	return nil
+}
+
+// Sends a signed request and returns the response if the store succeeded
+func (s *S3) send(ctx context.Context, method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	u := *s.Endpoint
+	u.Path = path.Join(u.Path, key)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	sigv4.Sign(req, sigv4.PayloadHash(body), s.Credentials, s.now())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		e := &Error{Status: resp.StatusCode}
+		if data, err := io.ReadAll(resp.Body); err == nil {
+			_ = xml.Unmarshal(data, e)
+		}
+		return nil, e
+	}
+	return resp, nil
+}
@@ -0,0 +1,257 @@
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/remygo/pkg/upload/sigv4"
+)
+
+// Bucket the server stores the objects in
+const Bucket = "remygo"
+
+// In-process stand-in of an S3 compatible blob store for tests. It checks the signatures of
+// the requests and keeps the multipart uploads and the objects of a single bucket in memory
+type Server struct {
+	*httptest.Server
+	credentials sigv4.Credentials
+
+	mu          sync.Mutex
+	nextID      int
+	uploads     map[string]*multipart
+	objects     map[string][]byte
+	failures    map[int]int // Part number to the number of uploads of it still to fail
+	partUploads map[int]int // Part number to the number of times it was received
+}
+
+type multipart struct {
+	key   string
+	parts map[int][]byte
+}
+
+// Starts a server which accepts the keys. Close it when done
+func NewServer(accessKey, secretKey string) *Server {
+	s := &Server{
+		credentials: sigv4.Credentials{AccessKey: accessKey, SecretKey: secretKey, Region: "us-east-1"},
+		uploads:     map[string]*multipart{},
+		objects:     map[string][]byte{},
+		failures:    map[int]int{},
+		partUploads: map[int]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Returns the url of the bucket as blob providers name it
+func (s *Server) Endpoint() string {
+	return s.URL + "/" + Bucket
+}
+
+// Fails the next uploads of the part with an internal error
+func (s *Server) FailPart(number, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[number] = times
+}
+
+// Returns how often the part was received, failed attempts included
+func (s *Server) PartUploads(number int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.partUploads[number]
+}
+
+// Drops the unfinished uploads as stores do when they expire
+func (s *Server) ExpireUploads() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads = map[string]*multipart{}
+}
+
+// Returns the content of a completed object
+func (s *Server) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	return data, ok
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if err = sigv4.Verify(r, s.credentials); err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") != sigv4.PayloadHash(body) {
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash does not match")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+Bucket+"/")
+	if key == r.URL.Path || key == "" {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "unknown bucket")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query()
+	_, create := query["uploads"]
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && create:
+		s.nextID++
+		id := fmt.Sprintf("upload-%d", s.nextID)
+		s.uploads[id] = &multipart{key: key, parts: map[int][]byte{}}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadID string `xml:"UploadId"`
+		}{Bucket: Bucket, Key: key, UploadID: id})
+	case r.Method == http.MethodGet && uploadID == "":
+		data, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "unknown key")
+			return
+		}
+		w.Write(data)
+	default:
+		upload, ok := s.uploads[uploadID]
+		if !ok || upload.key != key {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", "unknown upload")
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			s.uploadPart(w, r, upload, body)
+		case http.MethodGet:
+			s.listParts(w, upload)
+		case http.MethodPost:
+			s.complete(w, uploadID, upload, body)
+		case http.MethodDelete:
+			delete(s.uploads, uploadID)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+		}
+	}
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, upload *multipart, body []byte) {
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 || number > 10000 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+	s.partUploads[number]++
+	if s.failures[number] > 0 {
+		s.failures[number]--
+		writeError(w, http.StatusInternalServerError, "InternalError", "failing as asked")
+		return
+	}
+	sum := md5.Sum(body)
+	if md5Header := r.Header.Get("Content-MD5"); md5Header != "" && md5Header != base64.StdEncoding.EncodeToString(sum[:]) {
+		writeError(w, http.StatusBadRequest, "BadDigest", "content md5 does not match")
+		return
+	}
+	upload.parts[number] = body
+	w.Header().Set("ETag", etag(body))
+}
+
+func (s *Server) listParts(w http.ResponseWriter, upload *multipart) {
+	type part struct {
+		PartNumber int
+		ETag       string
+		Size       int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListPartsResult"`
+		IsTruncated bool
+		Parts       []part `xml:"Part"`
+	}{}
+	for number, data := range upload.parts {
+		result.Parts = append(result.Parts, part{number, etag(data), len(data)})
+	}
+	sort.Slice(result.Parts, func(i, j int) bool { return result.Parts[i].PartNumber < result.Parts[j].PartNumber })
+	writeXML(w, result)
+}
+
+// Joins the parts of the upload. Like S3 it answers errors found while joining with 200
+func (s *Server) complete(w http.ResponseWriter, uploadID string, upload *multipart, body []byte) {
+	var request struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "no parts to complete")
+		return
+	}
+	var object []byte
+	for i, p := range request.Parts {
+		data, ok := upload.parts[p.PartNumber]
+		if !ok || p.ETag != etag(data) {
+			writeXML(w, struct {
+				XMLName xml.Name `xml:"Error"`
+				Code    string
+				Message string
+			}{Code: "InvalidPart", Message: fmt.Sprintf("part %d is not uploaded", p.PartNumber)})
+			return
+		}
+		if i > 0 && p.PartNumber <= request.Parts[i-1].PartNumber {
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder", "parts are not in order")
+			return
+		}
+		object = append(object, data...)
+	}
+	s.objects[upload.key] = object
+	delete(s.uploads, uploadID)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: Bucket, Key: upload.key, ETag: etag(object)})
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(append([]byte(xml.Header), data...))
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	data, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write(append([]byte(xml.Header), data...))
+}
@@ -0,0 +1,183 @@
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Parts of AWS Signature Version 4 as S3 compatible blob stores take it
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	service    = "s3"
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+)
+
+// Hash of an empty payload e.g. of GET and DELETE requests
+var EmptyPayload = PayloadHash(nil)
+
+var ErrSignature = errors.New("signature does not match")
+
+// Keys of the blob provider and the region its requests are signed for
+type Credentials struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+}
+
+// Returns the hex encoded SHA-256 of the payload which is signed in place of the body
+func PayloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sets the date, the payload hash and the authorization header of the request. The host and
+// all headers the request has of those which S3 wants signed are signed
+func Sign(req *http.Request, payloadHash string, c Credentials, now time.Time) {
+	amzDate := now.UTC().Format(timeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := signedHeaders(req)
+	sig := signature(req, headers, payloadHash, c, amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, c.AccessKey, scope(amzDate, c.Region), strings.Join(headers, ";"), sig))
+}
+
+// Checks the signature of a received request against the secret key of the access key it was
+// signed with. The payload hash is taken from the request and has to be checked by the caller
+func Verify(req *http.Request, c Credentials) error {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, algorithm+" ") {
+		return fmt.Errorf("%w: unsupported authorization", ErrSignature)
+	}
+	fields := map[string]string{}
+	for _, f := range strings.Split(strings.TrimPrefix(auth, algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	amzDate := req.Header.Get("X-Amz-Date")
+	if fields["Credential"] != c.AccessKey+"/"+scope(amzDate, c.Region) {
+		return fmt.Errorf("%w: unknown credential %q", ErrSignature, fields["Credential"])
+	}
+	headers := strings.Split(fields["SignedHeaders"], ";")
+	want := signature(req, headers, req.Header.Get("X-Amz-Content-Sha256"), c, amzDate)
+	if !hmac.Equal([]byte(fields["Signature"]), []byte(want)) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Returns the lower case names of the headers to sign in order
+func signedHeaders(req *http.Request) []string {
+	headers := []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-md5" || name == "content-type" || name == "range" {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+func scope(amzDate, region string) string {
+	date := amzDate
+	if len(date) > len(dateFormat) {
+		date = date[:len(dateFormat)]
+	}
+	return date + "/" + region + "/" + service + "/aws4_request"
+}
+
+func signature(req *http.Request, headers []string, payloadHash string, c Credentials, amzDate string) string {
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Header.Get(name)
+		if name == "host" {
+			// Servers receive the host header in the Host field of the request
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		encode(path, false),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		strings.Join(headers, ";"),
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		algorithm,
+		amzDate,
+		scope(amzDate, c.Region),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), []byte(scope(amzDate, c.Region)[:len(dateFormat)]))
+	key = hmacSHA256(key, []byte(c.Region))
+	key = hmacSHA256(key, []byte(service))
+	key = hmacSHA256(key, []byte("aws4_request"))
+	return hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+}
+
+// Sorts the parameters of the query by name, parameters without a value get an empty one
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []string
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, encode(name, true)+"="+encode(v, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// Percent encodes everything but the unreserved characters. Slashes of paths stay as they are
+func encode(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,361 @@
+package upload
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Size of the parts the files are uploaded in. S3 wants at least 5 MiB but for the last one
+	DefaultPartSize = 8 << 20
+	MinPartSize     = 5 << 20
+
+	// Attempts of a part before the upload is left to be resumed later
+	partAttempts = 3
+	retryDelay   = time.Second
+
+	journalExt = ".json"
+)
+
+var ErrUploading = errors.New("the file is already being uploaded")
+
+// File as the registry of the uploaded files knows it
+type File struct {
+	Name      string
+	Size      int64
+	MD5       string // Hex encoded digest of the content
+	URI       string // Url of the object in the blob store
+	SessionID string // Session the file was recorded or transferred in. Empty for none
+}
+
+// Keeps track of the uploaded files and the progress of their uploads
+type Registry interface {
+	// Adds a file before its upload starts and returns its id
+	Register(ctx context.Context, f File) (string, error)
+	// Reports the bytes uploaded so far. The url is set once the upload completed
+	Update(ctx context.Context, id string, uploaded int64, uri string) error
+}
+
+// Uploads files into a blob store in parts. The state of each upload is kept in a journal
+// file, so uploads interrupted e.g. by a lost connection or a restart continue after the
+// last part the store received
+type Uploader struct {
+	Store    *S3
+	Files    Registry
+	Dir      string // Directory of the journals
+	PartSize int64
+
+	retryDelay time.Duration
+	mu         sync.Mutex
+	active     map[string]bool // Paths being uploaded
+}
+
+// State of an upload which survives restarts
+type journal struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"`
+	MD5       string    `json:"md5,omitempty"`
+	Key       string    `json:"key"`
+	SessionID string    `json:"sessionID,omitempty"`
+	UploadID  string    `json:"uploadID"`
+	FileID    string    `json:"fileID,omitempty"`
+	PartSize  int64     `json:"partSize"`
+}
+
+// Returns an uploader which keeps its journals in dir
+func New(store *S3, files Registry, dir string) *Uploader {
+	return &Uploader{Store: store, Files: files, Dir: dir, PartSize: DefaultPartSize, retryDelay: retryDelay}
+}
+
+// Uploads the file and links it to the session. An upload of the file which was interrupted
+// before continues unless the file changed since
+func (u *Uploader) Upload(ctx context.Context, filePath, sessionID string) error {
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	if u.active[filePath] {
+		u.mu.Unlock()
+		return ErrUploading
+	}
+	if u.active == nil {
+		u.active = map[string]bool{}
+	}
+	u.active[filePath] = true
+	u.mu.Unlock()
+
+	defer func() {
+		u.mu.Lock()
+		delete(u.active, filePath)
+		u.mu.Unlock()
+	}()
+
+	err = u.upload(ctx, filePath, sessionID)
+	if isNoSuchUpload(err) {
+		// The store dropped the parts e.g. since the upload expired, so it starts over
+		if j, loadErr := u.load(filePath); loadErr == nil {
+			j.UploadID = ""
+			if err = u.save(j); err != nil {
+				return err
+			}
+		}
+		err = u.upload(ctx, filePath, sessionID)
+	}
+	return err
+}
+
+// Journals the upload of the file without starting it. Resume uploads it later e.g. when the
+// application starts again
+func (u *Uploader) Queue(filePath, sessionID string) error {
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err = u.load(filePath); errors.Is(err, os.ErrNotExist) {
+		_, err = u.start(filePath, info, sessionID)
+	}
+	return err
+}
+
+// Continues the uploads whose journals are left in the directory. Journals of files which
+// no longer exist are dropped with their uploads
+func (u *Uploader) Resume(ctx context.Context) error {
+	entries, err := os.ReadDir(u.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != journalExt {
+			continue
+		}
+		j, err := readJournal(filepath.Join(u.Dir, e.Name()))
+		if err == nil {
+			if _, statErr := os.Stat(j.Path); errors.Is(statErr, os.ErrNotExist) {
+				u.drop(ctx, j)
+				continue
+			}
+			err = u.Upload(ctx, j.Path, j.SessionID)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("resuming upload %s: %w", e.Name(), err)
+		}
+	}
+	return firstErr
+}
+
+func (u *Uploader) upload(ctx context.Context, filePath, sessionID string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	j, err := u.load(filePath)
+	if err == nil && (j.Size != info.Size() || !j.ModTime.Equal(info.ModTime())) {
+		// The parts uploaded so far are of another content
+		u.drop(ctx, j)
+		err = os.ErrNotExist
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		j, err = u.start(filePath, info, sessionID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if j.MD5 == "" {
+		h := md5.New()
+		if _, err = io.Copy(h, io.NewSectionReader(f, 0, j.Size)); err != nil {
+			return err
+		}
+		j.MD5 = hex.EncodeToString(h.Sum(nil))
+		if err = u.save(j); err != nil {
+			return err
+		}
+	}
+
+	if j.UploadID == "" {
+		if j.UploadID, err = u.Store.CreateUpload(ctx, j.Key); err != nil {
+			return fmt.Errorf("starting upload: %w", err)
+		}
+		if err = u.save(j); err != nil {
+			return err
+		}
+	}
+	if j.FileID == "" {
+		file := File{Name: filepath.Base(j.Path), Size: j.Size, MD5: j.MD5, URI: u.Store.ObjectURL(j.Key), SessionID: j.SessionID}
+		if j.FileID, err = u.Files.Register(ctx, file); err != nil {
+			return fmt.Errorf("registering file: %w", err)
+		}
+		if err = u.save(j); err != nil {
+			return err
+		}
+	}
+
+	// The store knows which parts arrived, the journal may lag behind it
+	parts, err := u.Store.ListParts(ctx, j.Key, j.UploadID)
+	if err != nil {
+		return fmt.Errorf("listing uploaded parts: %w", err)
+	}
+	parts = contiguous(parts, j.PartSize, j.Size)
+	var uploaded int64
+	for _, p := range parts {
+		uploaded += p.Size
+	}
+
+	buf := make([]byte, j.PartSize)
+	for number := len(parts) + 1; uploaded < j.Size || number == 1; number++ {
+		n, err := f.ReadAt(buf, uploaded)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		part, err := u.uploadPart(ctx, j, number, buf[:n])
+		if err != nil {
+			return fmt.Errorf("uploading part %d: %w", number, err)
+		}
+		parts = append(parts, part)
+		uploaded += int64(n)
+		if err = u.Files.Update(ctx, j.FileID, uploaded, ""); err != nil {
+			return fmt.Errorf("reporting progress: %w", err)
+		}
+	}
+
+	if err = u.Store.CompleteUpload(ctx, j.Key, j.UploadID, parts); err != nil {
+		return fmt.Errorf("completing upload: %w", err)
+	}
+	if err = u.Files.Update(ctx, j.FileID, j.Size, u.Store.ObjectURL(j.Key)); err != nil {
+		return fmt.Errorf("reporting completed upload: %w", err)
+	}
+	return os.Remove(u.journalPath(j.Path))
+}
+
+// Creates the journal of a new upload of the file. The digest is taken when the upload starts
+func (u *Uploader) start(filePath string, info os.FileInfo, sessionID string) (*journal, error) {
+	partSize := u.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	j := &journal{
+		Path:      filePath,
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		Key:       objectKey(sessionID, filepath.Base(filePath)),
+		SessionID: sessionID,
+		PartSize:  partSize,
+	}
+	if err := os.MkdirAll(u.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	return j, u.save(j)
+}
+
+// Uploads a part and retries it with growing delays when it fails. A store which lost the
+// upload is not retried
+func (u *Uploader) uploadPart(ctx context.Context, j *journal, number int, data []byte) (Part, error) {
+	delay := u.retryDelay
+	for attempt := 1; ; attempt++ {
+		part, err := u.Store.UploadPart(ctx, j.Key, j.UploadID, number, data)
+		if err == nil || attempt == partAttempts || isNoSuchUpload(err) {
+			return part, err
+		}
+		select {
+		case <-ctx.Done():
+			return Part{}, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// Aborts the upload of the journal and removes it
+func (u *Uploader) drop(ctx context.Context, j *journal) {
+	if j.UploadID != "" {
+		// Stores discard unfinished uploads after a while anyway
+		_ = u.Store.AbortUpload(ctx, j.Key, j.UploadID)
+	}
+	_ = os.Remove(u.journalPath(j.Path))
+}
+
+// Returns the parts from the first one up to the first gap. Parts which were uploaded with
+// another size are of no use either, but for the last part of the file
+func contiguous(parts []Part, partSize, size int64) []Part {
+	for i, p := range parts {
+		last := int64(i)*partSize+p.Size == size
+		if p.Number != i+1 || p.Size != partSize && !last {
+			return parts[:i]
+		}
+		if last {
+			return parts[:i+1]
+		}
+	}
+	return parts
+}
+
+// Returns the key of the object of the file. Files of a session share its prefix
+func objectKey(sessionID, name string) string {
+	if sessionID == "" {
+		return path.Join("files", name)
+	}
+	return path.Join("sessions", strings.ReplaceAll(sessionID, "/", "_"), name)
+}
+
+// Names the journal of a file by the hash of its path
+func (u *Uploader) journalPath(filePath string) string {
+	sum := sha256.Sum256([]byte(filePath))
+	return filepath.Join(u.Dir, hex.EncodeToString(sum[:8])+journalExt)
+}
+
+func (u *Uploader) load(filePath string) (*journal, error) {
+	return readJournal(u.journalPath(filePath))
+}
+
+func readJournal(name string) (*journal, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{}
+	if err = json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("parsing journal %s: %w", name, err)
+	}
+	return j, nil
+}
+
+// Writes the journal to a temporary file first, so a crash leaves the last one intact
+func (u *Uploader) save(j *journal) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := u.journalPath(j.Path)
+	if err = os.WriteFile(name+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
@@ -0,0 +1,292 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/remygo/pkg/upload/s3test"
+	"github.com/remygo/swagger"
+)
+
+const (
+	accessKey = "AKIDREMYGO"
+	secretKey = "secret"
+	partSize  = 1 << 10
+)
+
+// Registry which remembers the files and their progress
+type memRegistry struct {
+	mu       sync.Mutex
+	files    []File
+	progress map[string][]int64
+	uris     map[string]string
+}
+
+func (r *memRegistry) Register(ctx context.Context, f File) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, f)
+	return f.Name, nil
+}
+
+func (r *memRegistry) Update(ctx context.Context, id string, uploaded int64, uri string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress == nil {
+		r.progress, r.uris = map[string][]int64{}, map[string]string{}
+	}
+	r.progress[id] = append(r.progress[id], uploaded)
+	if uri != "" {
+		r.uris[id] = uri
+	}
+	return nil
+}
+
+func newUploader(t *testing.T, endpoint, secret string) (*Uploader, *memRegistry) {
+	store, err := NewS3(endpoint, accessKey, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := &memRegistry{}
+	u := New(store, files, filepath.Join(t.TempDir(), "uploads"))
+	u.PartSize = partSize
+	u.retryDelay = time.Millisecond
+	return u, files
+}
+
+func writeFile(t *testing.T, name string, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUpload(t *testing.T) {
+	server := s3test.NewServer(accessKey, secretKey)
+	defer server.Close()
+	u, files := newUploader(t, server.Endpoint(), secretKey)
+
+	name := filepath.Join(t.TempDir(), "session.webm")
+	data := writeFile(t, name, 2*partSize+100)
+	server.FailPart(2, 2)
+
+	if err := u.Upload(context.Background(), name, "token-1"); err != nil {
+		t.Fatal(err)
+	}
+	object, ok := server.Object("sessions/token-1/session.webm")
+	if !ok || !bytes.Equal(object, data) {
+		t.Fatalf("object missing or different, found %v", ok)
+	}
+	if len(files.files) != 1 || files.files[0].SessionID != "token-1" || files.files[0].Size != int64(len(data)) {
+		t.Fatalf("registered %+v", files.files)
+	}
+	want := []int64{partSize, 2 * partSize, int64(len(data)), int64(len(data))}
+	if got := files.progress["session.webm"]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("progress %v, want %v", got, want)
+	}
+	if uri := files.uris["session.webm"]; uri != server.Endpoint()+"/sessions/token-1/session.webm" {
+		t.Fatalf("uri %q", uri)
+	}
+	if n := server.PartUploads(2); n != 3 {
+		t.Fatalf("part 2 sent %d times, want 3", n)
+	}
+	if entries, _ := os.ReadDir(u.Dir); len(entries) != 0 {
+		t.Fatalf("%d journals left", len(entries))
+	}
+}
+
+func TestResume(t *testing.T) {
+	server := s3test.NewServer(accessKey, secretKey)
+	defer server.Close()
+	u, files := newUploader(t, server.Endpoint(), secretKey)
+
+	name := filepath.Join(t.TempDir(), "file.bin")
+	data := writeFile(t, name, 3*partSize)
+	server.FailPart(2, partAttempts)
+	if err := u.Upload(context.Background(), name, ""); err == nil {
+		t.Fatal("upload succeeded despite failing part")
+	}
+
+	// A restarted uploader finds the journal and continues after the first part
+	resumed := New(u.Store, files, u.Dir)
+	resumed.PartSize = 2 * partSize
+	if err := resumed.Resume(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if object, _ := server.Object("files/file.bin"); !bytes.Equal(object, data) {
+		t.Fatal("object differs")
+	}
+	if n := server.PartUploads(1); n != 1 {
+		t.Fatalf("part 1 sent %d times, want 1", n)
+	}
+	if len(files.files) != 1 {
+		t.Fatalf("file registered %d times", len(files.files))
+	}
+}
+
+func TestQueue(t *testing.T) {
+	server := s3test.NewServer(accessKey, secretKey)
+	defer server.Close()
+	u, files := newUploader(t, server.Endpoint(), secretKey)
+
+	name := filepath.Join(t.TempDir(), "session.webm")
+	data := writeFile(t, name, partSize/2)
+	if err := u.Queue(name, "token-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(files.files) != 0 {
+		t.Fatal("queued file registered before its upload")
+	}
+	if err := u.Resume(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if object, _ := server.Object("sessions/token-1/session.webm"); !bytes.Equal(object, data) {
+		t.Fatal("object differs")
+	}
+	if len(files.files) != 1 || files.files[0].MD5 == "" {
+		t.Fatalf("registered %+v", files.files)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	server := s3test.NewServer(accessKey, secretKey)
+	defer server.Close()
+	u, files := newUploader(t, server.Endpoint(), secretKey)
+	ctx := context.Background()
+
+	name := filepath.Join(t.TempDir(), "file.bin")
+	writeFile(t, name, 2*partSize)
+	server.FailPart(2, partAttempts)
+	if err := u.Upload(ctx, name, ""); err == nil {
+		t.Fatal("upload succeeded despite failing part")
+	}
+
+	// The store lost the upload, so it starts over
+	server.ExpireUploads()
+	if err := u.Upload(ctx, name, ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.PartUploads(1); n != 2 {
+		t.Fatalf("part 1 sent %d times, want 2", n)
+	}
+
+	// A changed file is uploaded anew
+	server.FailPart(2, partAttempts)
+	data := writeFile(t, name, 2*partSize+1)
+	os.Chtimes(name, time.Now(), time.Now().Add(time.Second))
+	if err := u.Upload(ctx, name, ""); err == nil {
+		t.Fatal("upload succeeded despite failing part")
+	}
+	data = writeFile(t, name, 2*partSize+2)
+	os.Chtimes(name, time.Now(), time.Now().Add(2*time.Second))
+	if err := u.Upload(ctx, name, ""); err != nil {
+		t.Fatal(err)
+	}
+	if object, _ := server.Object("files/file.bin"); !bytes.Equal(object, data) {
+		t.Fatal("object differs")
+	}
+	if len(files.files) != 3 {
+		t.Fatalf("file registered %d times, want 3", len(files.files))
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+	server := s3test.NewServer(accessKey, secretKey)
+	defer server.Close()
+	u, _ := newUploader(t, server.Endpoint(), secretKey)
+
+	name := filepath.Join(t.TempDir(), "empty")
+	writeFile(t, name, 0)
+	if err := u.Upload(context.Background(), name, ""); err != nil {
+		t.Fatal(err)
+	}
+	if object, ok := server.Object("files/empty"); !ok || len(object) != 0 {
+		t.Fatalf("object %v %d", ok, len(object))
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	server := s3test.NewServer(accessKey, secretKey)
+	defer server.Close()
+	u, _ := newUploader(t, server.Endpoint(), "wrong")
+
+	name := filepath.Join(t.TempDir(), "file.bin")
+	writeFile(t, name, 10)
+	err := u.Upload(context.Background(), name, "")
+	var storeErr *Error
+	if !errors.As(err, &storeErr) || storeErr.Code != "SignatureDoesNotMatch" {
+		t.Fatalf("upload with wrong secret: %v", err)
+	}
+}
+
+func TestNewS3(t *testing.T) {
+	for endpoint, region := range map[string]string{
+		"https://s3.eu-central-1.amazonaws.com/bucket": "eu-central-1",
+		"https://bucket.s3.us-west-2.amazonaws.com/b":  "us-west-2",
+		"http://minio:9000/bucket":                     defaultRegion,
+	} {
+		s, err := NewS3(endpoint, accessKey, secretKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Credentials.Region != region {
+			t.Errorf("region of %s is %s, want %s", endpoint, s.Credentials.Region, region)
+		}
+	}
+	if _, err := NewS3("http://minio:9000/", accessKey, secretKey); err == nil {
+		t.Error("accepted url without bucket")
+	}
+}
+
+func TestAPIRegistry(t *testing.T) {
+	var created swagger.AddUserFile
+	var updated swagger.UpdateFile
+	mux := http.NewServeMux()
+	mux.HandleFunc("/org/user/u1/file", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&created)
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/org/user/file/all", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]swagger.UserFile{{Id: "f1", BlobUri: "other"}, {Id: "f2", BlobUri: created.BlobUri}})
+	})
+	mux.HandleFunc("/org/user/file/f2", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&updated)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[1]"))
+	})
+	api := httptest.NewServer(mux)
+	defer api.Close()
+
+	conf := swagger.NewConfiguration()
+	conf.BasePath = api.URL
+	registry := &APIRegistry{Files: swagger.NewAPIClient(conf).UserFileApi, UserID: "u1", ProviderID: "p1"}
+
+	ctx := context.Background()
+	id, err := registry.Register(ctx, File{Name: "a.webm", Size: 42, MD5: "d41d", URI: "http://blob/a.webm", SessionID: "s1"})
+	if err != nil || id != "f2" {
+		t.Fatalf("registered as %q: %v", id, err)
+	}
+	if created.SessionId != "s1" || created.BlobProviderId != "p1" || created.Size != 42 || created.State != stateUploading {
+		t.Fatalf("created %+v", created)
+	}
+	if err = registry.Update(ctx, id, 42, "http://blob/a.webm"); err != nil {
+		t.Fatal(err)
+	}
+	if updated.BytesUploaded != 42 || updated.DownloadUri != "http://blob/a.webm" {
+		t.Fatalf("updated %+v", updated)
+	}
+}
@@ -10,11 +10,11 @@
 package swagger
 
 type AddUserFile struct {
-	Ame string `json:"ame"`
-	Tate string `json:"tate"`
-	Ize float32 `json:"ize"`
-	D5 string `json:"d5"`
-	LobUri string `json:"lobUri"`
-	EssionId float32 `json:"essionId"`
-	BlobProviderId float32 `json:"BlobProviderId"`
+	Name           string  `json:"name"`
+	State          string  `json:"state"`
+	Size           float32 `json:"size"`
+	Md5            string  `json:"md5"`
+	BlobUri        string  `json:"blobUri"`
+	SessionId      string  `json:"SessionId"`
+	BlobProviderId string  `json:"BlobProviderId"`
 }